@@ -29,7 +29,6 @@ import (
 
 	"github.com/uber/cadence/common"
 	"github.com/uber/cadence/common/membership"
-	"github.com/uber/cadence/common/service"
 )
 
 func TestPeerResolver(t *testing.T) {
@@ -37,31 +36,31 @@ func TestPeerResolver(t *testing.T) {
 	controller := gomock.NewController(t)
 	serviceResolver := membership.NewMockResolver(controller)
 	serviceResolver.EXPECT().Lookup(
-		service.History, string(rune(common.DomainIDToHistoryShard("domainID", numShards)))).Return(
+		membership.ServiceHistory, string(rune(common.DomainIDToHistoryShard("domainID", numShards)))).Return(
 		membership.NewDetailedHostInfo(
 			"domainHost:123",
 			"domainHost_123",
 			membership.PortMap{membership.PortTchannel: 1234}),
 		nil)
-	serviceResolver.EXPECT().Lookup(service.History, string(rune(common.WorkflowIDToHistoryShard("workflowID", numShards)))).Return(
+	serviceResolver.EXPECT().Lookup(membership.ServiceHistory, string(rune(common.WorkflowIDToHistoryShard("workflowID", numShards)))).Return(
 		membership.NewDetailedHostInfo(
 			"workflowHost:123",
 			"workflow",
 			membership.PortMap{membership.PortTchannel: 1235, membership.PortGRPC: 1666}), nil)
 
-	serviceResolver.EXPECT().Lookup(service.History, string(rune(99))).Return(
+	serviceResolver.EXPECT().Lookup(membership.ServiceHistory, string(rune(99))).Return(
 		membership.NewDetailedHostInfo(
 			"shardHost:123",
 			"shard_123",
 			membership.PortMap{membership.PortTchannel: 1235}),
 		nil)
 
-	serviceResolver.EXPECT().LookupByAddress(service.History, "invalid address").Return(
+	serviceResolver.EXPECT().LookupByAddress(membership.ServiceHistory, "invalid address").Return(
 		membership.HostInfo{},
 		errors.New("host not found"),
 	)
 
-	serviceResolver.EXPECT().Lookup(service.History, string(rune(11))).Return(membership.HostInfo{}, assert.AnError)
+	serviceResolver.EXPECT().Lookup(membership.ServiceHistory, string(rune(11))).Return(membership.HostInfo{}, assert.AnError)
 
 	r := NewPeerResolver(numShards, serviceResolver, membership.PortTchannel)
 
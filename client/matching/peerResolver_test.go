@@ -27,7 +27,6 @@ import (
 	"github.com/stretchr/testify/assert"
 
 	"github.com/uber/cadence/common/membership"
-	"github.com/uber/cadence/common/service"
 )
 
 func TestPeerResolver(t *testing.T) {
@@ -49,22 +48,22 @@ func TestPeerResolver(t *testing.T) {
 			membership.PortGRPC:     1245,
 		},
 	)
-	serviceResolver.EXPECT().Lookup(service.Matching, "taskListA").Return(
+	serviceResolver.EXPECT().Lookup(membership.ServiceMatching, "taskListA").Return(
 		host1,
 		nil)
-	serviceResolver.EXPECT().Lookup(service.Matching, "invalid").Return(membership.HostInfo{}, assert.AnError)
-	serviceResolver.EXPECT().LookupByAddress(service.Matching, "invalid address").Return(membership.HostInfo{}, assert.AnError)
+	serviceResolver.EXPECT().Lookup(membership.ServiceMatching, "invalid").Return(membership.HostInfo{}, assert.AnError)
+	serviceResolver.EXPECT().LookupByAddress(membership.ServiceMatching, "invalid address").Return(membership.HostInfo{}, assert.AnError)
 
-	serviceResolver.EXPECT().Members(service.Matching).Return([]membership.HostInfo{
+	serviceResolver.EXPECT().Members(membership.ServiceMatching).Return([]membership.HostInfo{
 		host1,
 		host2,
 	}, nil)
 
-	serviceResolver.EXPECT().LookupByAddress(service.Matching, "tasklistHost2:1235").Return(
+	serviceResolver.EXPECT().LookupByAddress(membership.ServiceMatching, "tasklistHost2:1235").Return(
 		host2,
 		nil,
 	).AnyTimes()
-	serviceResolver.EXPECT().LookupByAddress(service.Matching, "tasklistHost:1234").Return(
+	serviceResolver.EXPECT().LookupByAddress(membership.ServiceMatching, "tasklistHost:1234").Return(
 		host1,
 		nil,
 	).AnyTimes()
@@ -45,6 +45,7 @@ import (
 	"github.com/uber/cadence/common/membership"
 	"github.com/uber/cadence/common/messaging/kafka"
 	"github.com/uber/cadence/common/metrics"
+	"github.com/uber/cadence/common/peerprovider/dnsprovider"
 	"github.com/uber/cadence/common/peerprovider/ringpopprovider"
 	"github.com/uber/cadence/common/resource"
 	"github.com/uber/cadence/common/rpc"
@@ -164,24 +165,54 @@ func (s *server) startService() common.Daemon {
 	rpcFactory := rpc.NewFactory(params.Logger, rpcParams)
 	params.RPCFactory = rpcFactory
 
-	peerProvider, err := ringpopprovider.New(
-		params.Name,
-		&s.cfg.Ringpop,
-		rpcFactory.GetChannel(),
-		membership.PortMap{
-			membership.PortGRPC:     svcCfg.RPC.GRPCPort,
-			membership.PortTchannel: svcCfg.RPC.Port,
-		},
-		params.Logger,
-	)
+	params.MetricsClient = metrics.NewClient(params.MetricScope, service.GetMetricsServiceIdx(params.Name, params.Logger))
 
-	if err != nil {
-		log.Fatalf("ringpop provider failed: %v", err)
+	portMap := svcCfg.RPC.PortMap()
+	var peerProvider membership.PeerProvider
+	if s.cfg.DNSMembership != nil {
+		// dns members are keyed by ip:port whatever the record type, as is the tchannel host port
+		selfAddress := rpcFactory.GetChannel().PeerInfo().HostPort
+		peerProvider, err = dnsprovider.New(
+			s.cfg.DNSMembership,
+			membership.NewDetailedHostInfo(selfAddress, selfAddress, portMap),
+			params.MetricsClient,
+			params.Logger,
+		)
+		if err != nil {
+			log.Fatalf("dns peer provider failed: %v", err)
+		}
+	} else {
+		peerProvider, err = ringpopprovider.New(
+			params.Name,
+			&s.cfg.Ringpop,
+			rpcFactory.GetChannel(),
+			portMap,
+			params.MetricsClient,
+			params.Logger,
+		)
+		if err != nil {
+			log.Fatalf("ringpop provider failed: %v", err)
+		}
+	}
+	if s.cfg.TLSIdentityMembership {
+		// rpc tls is only served on the grpc port, so certificates are read from there
+		tlsConfig, err := svcCfg.RPC.TLS.ToTLSConfig()
+		if err != nil {
+			log.Fatalf("error creating tls config for membership identities: %v", err)
+		}
+		certs, err := membership.NewTLSCertificateSource(tlsConfig, membership.PortGRPC)
+		if err != nil {
+			log.Fatalf("membership identities from tls need rpc tls enabled: %v", err)
+		}
+		peerProvider = membership.NewTLSIdentityPeerProvider(peerProvider, certs, params.Logger)
 	}
 
 	params.MembershipResolver, err = membership.NewResolver(
 		peerProvider,
+		params.MetricsClient,
 		params.Logger,
+		membership.WithReadiness(s.cfg.RingReadiness),
+		membership.WithShardCounts(map[membership.Service]int{membership.ServiceHistory: s.cfg.Persistence.NumHistoryShards}),
 	)
 	if err != nil {
 		log.Fatalf("error creating membership monitor: %v", err)
@@ -190,8 +221,6 @@ func (s *server) startService() common.Daemon {
 
 	params.ClusterRedirectionPolicy = s.cfg.ClusterGroupMetadata.ClusterRedirectionPolicy
 
-	params.MetricsClient = metrics.NewClient(params.MetricScope, service.GetMetricsServiceIdx(params.Name, params.Logger))
-
 	params.ClusterMetadata = cluster.NewMetadata(
 		clusterGroupMetadata.FailoverVersionIncrement,
 		clusterGroupMetadata.PrimaryClusterName,
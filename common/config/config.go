@@ -32,6 +32,8 @@ import (
 
 	"github.com/uber/cadence/common/dynamicconfig"
 	c "github.com/uber/cadence/common/dynamicconfig/configstore/config"
+	"github.com/uber/cadence/common/membership"
+	"github.com/uber/cadence/common/peerprovider/dnsprovider"
 	"github.com/uber/cadence/common/peerprovider/ringpopprovider"
 	"github.com/uber/cadence/common/service"
 )
@@ -41,6 +43,14 @@ type (
 	Config struct {
 		// Ringpop is the ringpop related configuration
 		Ringpop ringpopprovider.Config `yaml:"ringpop"`
+		// DNSMembership is the DNS based membership configuration, ringpop is used when it is not set
+		DNSMembership *dnsprovider.Config `yaml:"dnsMembership"`
+		// TLSIdentityMembership makes ring members take their identity from a subject alternative name of the
+		// certificate they serve on their gRPC port, read with the rpc tls config of the service, which must be enabled
+		TLSIdentityMembership bool `yaml:"tlsIdentityMembership"`
+		// RingReadiness is the minimum number of members of service rings keyed by service name,
+		// frontend reports warming up until they are reached, or for up to 5 minutes after its warmup duration
+		RingReadiness map[membership.Service]int `yaml:"ringReadiness"`
 		// Persistence contains the configuration for cadence datastores
 		Persistence Persistence `yaml:"persistence"`
 		// Log is the logging config
@@ -642,6 +652,11 @@ func (c *Config) String() string {
 	return string(out)
 }
 
+// PortMap returns the ports the service advertises to other members of its ring
+func (r *RPC) PortMap() membership.PortMap {
+	return membership.NewPortMap(r.Port, r.GRPCPort)
+}
+
 func (c *Config) GetServiceConfig(serviceName string) (Service, error) {
 	shortName := service.ShortName(serviceName)
 	serviceConfig, ok := c.Services[shortName]
@@ -28,6 +28,7 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"github.com/uber/cadence/common"
+	"github.com/uber/cadence/common/membership"
 	"github.com/uber/cadence/common/service"
 )
 
@@ -38,6 +39,17 @@ func TestToString(t *testing.T) {
 	assert.NotEmpty(t, cfg.String())
 }
 
+func TestRPCPortMap(t *testing.T) {
+	var cfg Config
+	require.NoError(t, Load("", "../../config", "", &cfg))
+	frontend, err := cfg.GetServiceConfig(service.Frontend)
+	require.NoError(t, err)
+	assert.Equal(t, membership.PortMap{membership.PortTchannel: 7933, membership.PortGRPC: 7833}, frontend.RPC.PortMap())
+
+	frontend.RPC.GRPCPort = 0
+	assert.Equal(t, membership.PortMap{membership.PortTchannel: 7933}, frontend.RPC.PortMap())
+}
+
 func TestFillingDefaultSQLEncodingDecodingTypes(t *testing.T) {
 	cfg := &Config{
 		Persistence: Persistence{
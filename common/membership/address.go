@@ -0,0 +1,74 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2017-2020 Uber Technologies Inc.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package membership
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// parseAddr splits host:port address into its parts. ip is nil if host is not an IP literal.
+// The zone of a link-local IPv6 literal is kept in host, ip is the address without the zone.
+// If only the port is invalid, host and ip are still returned along with the error,
+// so callers that tolerate invalid addresses can use whatever could be parsed.
+func parseAddr(addr string) (host string, ip net.IP, port uint16, err error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", nil, 0, fmt.Errorf("invalid host address %q: %w", addr, err)
+	}
+	ip = parseIP(host)
+	if host == "" {
+		return host, ip, 0, fmt.Errorf("invalid host address %q: empty host", addr)
+	}
+	if portStr == "" {
+		return host, ip, 0, fmt.Errorf("invalid host address %q: empty port", addr)
+	}
+	number, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return host, ip, 0, fmt.Errorf("invalid host address %q: port %q: %w", addr, portStr, err)
+	}
+	if number == 0 {
+		return host, ip, 0, fmt.Errorf("invalid host address %q: port 0", addr)
+	}
+	return host, ip, uint16(number), nil
+}
+
+// parseIP parses an IP literal, IPv6 literals may have a zone, e.g. fe80::1%eth0. It returns nil if host is not an IP.
+func parseIP(host string) net.IP {
+	addr, zone := splitZone(host)
+	ip := net.ParseIP(addr)
+	if zone != "" && (ip == nil || ip.To4() != nil) {
+		return nil
+	}
+	return ip
+}
+
+// splitZone splits host into the address and the IPv6 zone, zone is empty if host has none
+func splitZone(host string) (addr, zone string) {
+	if i := strings.LastIndexByte(host, '%'); i >= 0 {
+		return host[:i], host[i+1:]
+	}
+	return host, ""
+}
@@ -0,0 +1,94 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2017-2020 Uber Technologies Inc.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+//go:build go1.18
+// +build go1.18
+
+package membership
+
+import (
+	"net"
+	"strconv"
+	"testing"
+)
+
+func FuzzParseAddr(f *testing.F) {
+	for _, addr := range []string{
+		"127.0.0.1:7933",
+		"[::1]:7933",
+		"[fe80::1%eth0]:7933",
+		"[fe80::1%25eth0]:7933",
+		"worker-1.svc:7933",
+		"127.0.0.1:7933\n",
+		"127.0.0.1:7933,127.0.0.2:7933",
+		"127.0.0.1:7933:",
+		"127.0.0.1:",
+		":7933",
+		"127.0.0.1",
+		"[::1]",
+		"::1:7933",
+		"[[::1]]:7933",
+		"127.0.0.1:-1",
+		"127.0.0.1:+7933",
+		"127.0.0.1:0x1ef5",
+		"127.0.0.1:00000",
+		"",
+	} {
+		f.Add(addr)
+	}
+
+	f.Fuzz(func(t *testing.T, addr string) {
+		host, ip, port, err := parseAddr(addr)
+		if err != nil {
+			if port != 0 {
+				t.Fatalf("port %d returned along with error for %q", port, addr)
+			}
+			// constructors tolerate invalid addresses and rely on them not to panic
+			hi := NewHostInfo(addr)
+			_, _ = hi.Belongs(addr)
+			return
+		}
+		if host == "" {
+			t.Fatalf("empty host for %q", addr)
+		}
+		if ip == nil && net.ParseIP(host) != nil {
+			t.Fatalf("ip literal %q was not parsed", host)
+		}
+		if addr, _ := splitZone(host); ip != nil && !ip.Equal(net.ParseIP(addr)) {
+			t.Fatalf("ip %v does not match host %q", ip, host)
+		}
+
+		joined := net.JoinHostPort(host, strconv.Itoa(int(port)))
+		host2, ip2, port2, err := parseAddr(joined)
+		if err != nil {
+			t.Fatalf("rejected %q rebuilt from valid %q: %v", joined, addr, err)
+		}
+		if host2 != host || !ip2.Equal(ip) || port2 != port {
+			t.Fatalf("%q parsed differently from %q", joined, addr)
+		}
+
+		belongs, err := NewHostInfo(addr).Belongs(joined)
+		if err != nil || !belongs {
+			t.Fatalf("%q does not belong to host %q: %v", joined, addr, err)
+		}
+	})
+}
@@ -0,0 +1,73 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2017-2020 Uber Technologies Inc.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package membership
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseAddr(t *testing.T) {
+	tests := []struct {
+		addr    string
+		host    string
+		ip      string
+		port    uint16
+		wantErr bool
+	}{
+		{addr: "127.0.0.1:7933", host: "127.0.0.1", ip: "127.0.0.1", port: 7933},
+		{addr: "[::1]:7933", host: "::1", ip: "::1", port: 7933},
+		{addr: "[0:0:0:0:0:0:0:1]:7933", host: "0:0:0:0:0:0:0:1", ip: "::1", port: 7933},
+		{addr: "worker-1.svc:7933", host: "worker-1.svc", port: 7933},
+		{addr: "[fe80::1%eth0]:7933", host: "fe80::1%eth0", ip: "fe80::1", port: 7933},
+		{addr: "[fe80:0::1%eth0]:7933", host: "fe80:0::1%eth0", ip: "fe80::1", port: 7933},
+		{addr: "[127.0.0.1%eth0]:7933", host: "127.0.0.1%eth0", port: 7933},
+		{addr: "127.0.0.1:", host: "127.0.0.1", ip: "127.0.0.1", wantErr: true},
+		{addr: "127.0.0.1:http", host: "127.0.0.1", ip: "127.0.0.1", wantErr: true},
+		{addr: "127.0.0.1:70000", host: "127.0.0.1", ip: "127.0.0.1", wantErr: true},
+		{addr: "127.0.0.1:0", host: "127.0.0.1", ip: "127.0.0.1", wantErr: true},
+		{addr: ":7933", wantErr: true},
+		{addr: "127.0.0.1", wantErr: true},
+		{addr: "127.0.0.1:7933junk:", wantErr: true},
+		{addr: "[::1", wantErr: true},
+		{addr: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			host, ip, port, err := parseAddr(tt.addr)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.host, host)
+			if tt.ip == "" {
+				assert.Nil(t, ip)
+			} else {
+				assert.Equal(t, tt.ip, ip.String())
+			}
+			assert.Equal(t, tt.port, port)
+		})
+	}
+}
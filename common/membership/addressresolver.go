@@ -0,0 +1,173 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2017-2020 Uber Technologies Inc.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+//go:generate mockgen -package $GOPACKAGE -source $GOFILE -destination addressresolver_mock.go -self_package github.com/uber/cadence/common/membership
+
+package membership
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/uber/cadence/common/clock"
+)
+
+const (
+	defaultLookupTimeout    = time.Second
+	defaultNegativeCacheTTL = time.Second * 5
+	maxNegativeCacheEntries = 1024
+)
+
+type (
+	// AddressResolver resolves host names into IP addresses.
+	// It is used whenever membership needs to compare host names with IPs.
+	AddressResolver interface {
+		LookupIP(ctx context.Context, host string) ([]net.IP, error)
+	}
+
+	netResolver struct {
+		resolver *net.Resolver
+	}
+
+	negativeCachingResolver struct {
+		resolver   AddressResolver
+		ttl        time.Duration
+		timeSource clock.TimeSource
+
+		mu       sync.Mutex
+		failures map[string]*list.Element // of *failedLookup by host
+		order    *list.List               // oldest failures first, they also expire first as all share the ttl
+	}
+
+	failedLookup struct {
+		host    string
+		err     error
+		expires time.Time
+	}
+)
+
+// DefaultAddressResolver is used to resolve host names when no other resolver is provided.
+// It uses net.DefaultResolver and remembers failed lookups for a few seconds.
+var DefaultAddressResolver = NewNegativeCachingResolver(
+	NewNetAddressResolver(net.DefaultResolver),
+	defaultNegativeCacheTTL,
+	clock.NewRealTimeSource(),
+)
+
+// NewNetAddressResolver returns AddressResolver backed by resolver
+func NewNetAddressResolver(resolver *net.Resolver) AddressResolver {
+	return &netResolver{resolver: resolver}
+}
+
+// NewNegativeCachingResolver wraps resolver so that failed lookups are remembered for ttl
+// and are not retried until then. Successful lookups are not cached, neither are lookups which were
+// cancelled, timed out or failed with a temporary DNS error, since retrying them may succeed.
+// Up to maxNegativeCacheEntries failures are remembered, the oldest ones are forgotten first.
+func NewNegativeCachingResolver(resolver AddressResolver, ttl time.Duration, timeSource clock.TimeSource) AddressResolver {
+	return &negativeCachingResolver{
+		resolver:   resolver,
+		ttl:        ttl,
+		timeSource: timeSource,
+		failures:   make(map[string]*list.Element),
+		order:      list.New(),
+	}
+}
+
+func (r *netResolver) LookupIP(ctx context.Context, host string) ([]net.IP, error) {
+	return r.resolver.LookupIP(ctx, "ip", host)
+}
+
+func (r *negativeCachingResolver) LookupIP(ctx context.Context, host string) ([]net.IP, error) {
+	now := r.timeSource.Now()
+
+	if err := r.cachedFailure(host, now); err != nil {
+		return nil, err
+	}
+
+	ips, err := r.resolver.LookupIP(ctx, host)
+	if err != nil {
+		if isPermanentLookupError(err) {
+			r.cacheFailure(host, err, now)
+		}
+		return nil, err
+	}
+	return ips, nil
+}
+
+// cachedFailure returns the error of the last lookup of host if it failed less than ttl ago, nil otherwise
+func (r *negativeCachingResolver) cachedFailure(host string, now time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	element, ok := r.failures[host]
+	if !ok {
+		return nil
+	}
+	failure := element.Value.(*failedLookup)
+	if !now.Before(failure.expires) {
+		return nil
+	}
+	return failure.err
+}
+
+// cacheFailure remembers the failed lookup of host, dropping expired failures
+// and the oldest ones beyond maxNegativeCacheEntries
+func (r *negativeCachingResolver) cacheFailure(host string, err error, now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if element, ok := r.failures[host]; ok {
+		r.order.Remove(element)
+	}
+	r.failures[host] = r.order.PushBack(&failedLookup{host: host, err: err, expires: now.Add(r.ttl)})
+	for oldest := r.order.Front(); oldest != nil; oldest = r.order.Front() {
+		failure := oldest.Value.(*failedLookup)
+		if now.Before(failure.expires) && r.order.Len() <= maxNegativeCacheEntries {
+			break
+		}
+		r.order.Remove(oldest)
+		delete(r.failures, failure.host)
+	}
+}
+
+// isPermanentLookupError returns false for errors which don't tell anything about the host,
+// e.g. a lookup running out of time or a DNS server failing
+func isPermanentLookupError(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && (dnsErr.IsTimeout || dnsErr.IsTemporary) {
+		return false
+	}
+	return true
+}
+
+// lookupHost returns IPs of host without calling resolver if host is an IP literal, possibly with an IPv6 zone
+func lookupHost(ctx context.Context, resolver AddressResolver, host string) ([]net.IP, error) {
+	if ip := parseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+	return resolver.LookupIP(ctx, host)
+}
@@ -0,0 +1,141 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2017-2020 Uber Technologies Inc.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package membership
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/uber/cadence/common/clock"
+)
+
+func TestNegativeCachingResolver(t *testing.T) {
+	fake := &fakeAddressResolver{hosts: map[string][]net.IP{
+		"worker-1.svc": {net.ParseIP("10.0.0.1")},
+	}}
+	timeSource := clock.NewEventTimeSource().Update(time.Unix(0, 0))
+	resolver := NewNegativeCachingResolver(fake, time.Second, timeSource)
+
+	ips, err := resolver.LookupIP(context.Background(), "worker-1.svc")
+	assert.NoError(t, err)
+	assert.Equal(t, []net.IP{net.ParseIP("10.0.0.1")}, ips)
+	_, err = resolver.LookupIP(context.Background(), "worker-1.svc")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, fake.calls, "successful lookups are not cached")
+
+	_, err = resolver.LookupIP(context.Background(), "unknown.svc")
+	assert.Error(t, err)
+	_, err = resolver.LookupIP(context.Background(), "unknown.svc")
+	assert.Error(t, err)
+	assert.Equal(t, 3, fake.calls, "failed lookup is cached")
+
+	timeSource.Update(time.Unix(1, 0))
+	_, err = resolver.LookupIP(context.Background(), "unknown.svc")
+	assert.Error(t, err)
+	assert.Equal(t, 4, fake.calls, "failed lookup is retried after ttl")
+}
+
+func TestNegativeCachingResolverRetriesTransientErrors(t *testing.T) {
+	tests := []error{
+		context.Canceled,
+		context.DeadlineExceeded,
+		fmt.Errorf("lookup: %w", context.DeadlineExceeded),
+		&net.DNSError{Err: "i/o timeout", Name: "worker-1.svc", IsTimeout: true},
+		&net.DNSError{Err: "server misbehaving", Name: "worker-1.svc", IsTemporary: true},
+	}
+	for _, lookupErr := range tests {
+		t.Run(lookupErr.Error(), func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			fake := NewMockAddressResolver(ctrl)
+			fake.EXPECT().LookupIP(gomock.Any(), "worker-1.svc").Return(nil, lookupErr).Times(2)
+			resolver := NewNegativeCachingResolver(fake, time.Second, clock.NewEventTimeSource())
+
+			for i := 0; i < 2; i++ {
+				_, err := resolver.LookupIP(context.Background(), "worker-1.svc")
+				assert.Equal(t, lookupErr, err)
+			}
+		})
+	}
+
+	ctrl := gomock.NewController(t)
+	fake := NewMockAddressResolver(ctrl)
+	notFound := &net.DNSError{Err: "no such host", Name: "unknown.svc", IsNotFound: true}
+	fake.EXPECT().LookupIP(gomock.Any(), "unknown.svc").Return(nil, notFound).Times(1)
+	resolver := NewNegativeCachingResolver(fake, time.Second, clock.NewEventTimeSource())
+	for i := 0; i < 2; i++ {
+		_, err := resolver.LookupIP(context.Background(), "unknown.svc")
+		assert.Equal(t, notFound, err, "unknown hosts are cached")
+	}
+}
+
+func TestNegativeCachingResolverIsBounded(t *testing.T) {
+	fake := &fakeAddressResolver{}
+	timeSource := clock.NewEventTimeSource().Update(time.Unix(0, 0))
+	resolver := NewNegativeCachingResolver(fake, time.Minute, timeSource).(*negativeCachingResolver)
+
+	for i := 0; i < 3*maxNegativeCacheEntries; i++ {
+		_, err := resolver.LookupIP(context.Background(), fmt.Sprintf("unknown-%d.svc", i))
+		assert.Error(t, err)
+	}
+	assert.Len(t, resolver.failures, maxNegativeCacheEntries, "failures within the ttl are bounded")
+	assert.Equal(t, maxNegativeCacheEntries, resolver.order.Len())
+
+	calls := fake.calls
+	_, err := resolver.LookupIP(context.Background(), fmt.Sprintf("unknown-%d.svc", 3*maxNegativeCacheEntries-1))
+	assert.Error(t, err)
+	assert.Equal(t, calls, fake.calls, "the latest failures are kept")
+	_, err = resolver.LookupIP(context.Background(), "unknown-0.svc")
+	assert.Error(t, err)
+	assert.Equal(t, calls+1, fake.calls, "the oldest failures are evicted")
+}
+
+func TestLookupHostSkipsResolverForIPLiterals(t *testing.T) {
+	tests := []struct {
+		host string
+		ip   net.IP
+	}{
+		{host: "10.0.0.1", ip: net.ParseIP("10.0.0.1")},
+		{host: "fd00::1", ip: net.ParseIP("fd00::1")},
+		{host: "fe80::1%eth0", ip: net.ParseIP("fe80::1")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			fake := &fakeAddressResolver{}
+			ips, err := lookupHost(context.Background(), fake, tt.host)
+			assert.NoError(t, err)
+			assert.Equal(t, []net.IP{tt.ip}, ips)
+			assert.Zero(t, fake.calls)
+		})
+	}
+
+	fake := &fakeAddressResolver{}
+	_, err := lookupHost(context.Background(), fake, "10.0.0.1%eth0")
+	assert.Error(t, err, "IPv4 literals can't have a zone, they are looked up as names")
+	assert.Equal(t, 1, fake.calls)
+}
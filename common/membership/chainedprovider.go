@@ -0,0 +1,180 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2017-2020 Uber Technologies Inc.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package membership
+
+import (
+	"errors"
+	"sync/atomic"
+
+	"go.uber.org/multierr"
+
+	"github.com/uber/cadence/common"
+	"github.com/uber/cadence/common/log"
+	"github.com/uber/cadence/common/log/tag"
+)
+
+var errNoPeerProviders = errors.New("chained peer provider has no providers")
+
+// ChainedPeerProvider combines several peer providers, for example static seeds and DNS discovery
+// while migrating between discovery mechanisms.
+//
+// Providers are started in order and queried in order: WhoAmI returns the answer of the first provider
+// which succeeds, so a failing provider fails over to the next one. GetMembers merges the members of all
+// providers which succeed. A host reported by several providers is listed once, hosts are deduplicated by
+// address and the first provider reporting an address wins, since a ring keeps a single member per address.
+// If providers describe the host of an address differently, e.g. with other identity or ports, a warning is logged.
+// Calls fail only when every provider fails.
+type ChainedPeerProvider struct {
+	status    int32
+	providers []PeerProvider
+	logger    log.Logger
+}
+
+var _ PeerProvider = (*ChainedPeerProvider)(nil)
+
+// NewChainedPeerProvider returns a provider chaining given providers, in order of preference
+func NewChainedPeerProvider(logger log.Logger, providers ...PeerProvider) *ChainedPeerProvider {
+	return &ChainedPeerProvider{
+		status:    common.DaemonStatusInitialized,
+		providers: append([]PeerProvider(nil), providers...),
+		logger:    logger,
+	}
+}
+
+// Start starts all providers in order
+func (p *ChainedPeerProvider) Start() {
+	if !atomic.CompareAndSwapInt32(&p.status, common.DaemonStatusInitialized, common.DaemonStatusStarted) {
+		return
+	}
+	for _, provider := range p.providers {
+		provider.Start()
+	}
+}
+
+// Stop stops all providers in reverse order
+func (p *ChainedPeerProvider) Stop() {
+	if !atomic.CompareAndSwapInt32(&p.status, common.DaemonStatusStarted, common.DaemonStatusStopped) {
+		return
+	}
+	for i := len(p.providers) - 1; i >= 0; i-- {
+		p.providers[i].Stop()
+	}
+}
+
+// GetMembers returns the deduplicated union of the members reported by all providers
+func (p *ChainedPeerProvider) GetMembers(service string) ([]HostInfo, error) {
+	var (
+		members []HostInfo
+		seen    = make(map[string]HostInfo)
+		errs    error
+		ok      bool
+	)
+	for i, provider := range p.providers {
+		hosts, err := provider.GetMembers(service)
+		if err != nil {
+			p.logger.Warn("peer provider failed to list members", tag.Service(service), tag.Number(int64(i)), tag.Error(err))
+			errs = multierr.Append(errs, err)
+			continue
+		}
+		ok = true
+		for _, host := range hosts {
+			if first, dup := seen[host.GetAddress()]; dup {
+				if first.Key() != host.Key() {
+					p.logger.Warn("peer providers report different hosts for the same address, keeping the first one",
+						tag.Service(service), tag.Address(host.GetAddress()), tag.Number(int64(i)))
+				}
+				continue
+			}
+			seen[host.GetAddress()] = host
+			members = append(members, host)
+		}
+	}
+	if !ok {
+		return nil, allFailed(errs)
+	}
+	return members, nil
+}
+
+// WhoAmI returns the host reported by the first provider which succeeds
+func (p *ChainedPeerProvider) WhoAmI() (HostInfo, error) {
+	var errs error
+	for _, provider := range p.providers {
+		self, err := provider.WhoAmI()
+		if err == nil {
+			return self, nil
+		}
+		errs = multierr.Append(errs, err)
+	}
+	return HostInfo{}, allFailed(errs)
+}
+
+// SelfEvict evicts this host from all providers
+func (p *ChainedPeerProvider) SelfEvict() error {
+	return p.forEach(PeerProvider.SelfEvict)
+}
+
+// Drain drains this host in all providers supporting it
+func (p *ChainedPeerProvider) Drain(service string) error {
+	return p.forEach(func(provider PeerProvider) error {
+		return provider.Drain(service)
+	})
+}
+
+// Undrain reverts Drain in all providers supporting it
+func (p *ChainedPeerProvider) Undrain(service string) error {
+	return p.forEach(func(provider PeerProvider) error {
+		return provider.Undrain(service)
+	})
+}
+
+// Subscribe subscribes the channel to membership changes of all providers
+func (p *ChainedPeerProvider) Subscribe(name string, notifyChannel chan<- *ChangedEvent) error {
+	return p.forEach(func(provider PeerProvider) error {
+		return provider.Subscribe(name, notifyChannel)
+	})
+}
+
+// forEach calls fn on every provider, it fails only if fn failed for all of them
+func (p *ChainedPeerProvider) forEach(fn func(PeerProvider) error) error {
+	var errs error
+	ok := false
+	for _, provider := range p.providers {
+		if err := fn(provider); err != nil {
+			errs = multierr.Append(errs, err)
+			continue
+		}
+		ok = true
+	}
+	if !ok {
+		return allFailed(errs)
+	}
+	return nil
+}
+
+// allFailed returns the errors of all providers, or an error if there were no providers
+func allFailed(errs error) error {
+	if errs == nil {
+		return errNoPeerProviders
+	}
+	return errs
+}
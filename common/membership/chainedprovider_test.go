@@ -0,0 +1,117 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2017-2020 Uber Technologies Inc.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package membership
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/uber/cadence/common/log"
+)
+
+func TestChainedPeerProviderFailsOver(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	self := NewDetailedHostInfo("127.0.0.1:7933", "self", PortMap{PortGRPC: 7833})
+	other := NewDetailedHostInfo("127.0.0.2:7933", "other", PortMap{PortGRPC: 7833})
+
+	failing := NewMockPeerProvider(ctrl)
+	failing.EXPECT().Start()
+	failing.EXPECT().Stop()
+	failing.EXPECT().WhoAmI().Return(HostInfo{}, errors.New("not bootstrapped"))
+	failing.EXPECT().GetMembers("test-worker").Return(nil, errors.New("not bootstrapped"))
+	failing.EXPECT().Drain("test-worker").Return(errors.New("not bootstrapped"))
+
+	static := NewStaticPeerProvider(self, map[string][]HostInfo{"test-worker": {self, other}})
+	provider := NewChainedPeerProvider(log.NewNoop(), failing, static)
+	provider.Start()
+	defer provider.Stop()
+
+	host, err := provider.WhoAmI()
+	require.NoError(t, err)
+	assert.Equal(t, self, host)
+
+	members, err := provider.GetMembers("test-worker")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"self", "other"}, identities(members))
+
+	assert.NoError(t, provider.Drain("test-worker"), "drain succeeds if any provider supports it")
+}
+
+func TestChainedPeerProviderMergesMembers(t *testing.T) {
+	self := NewDetailedHostInfo("127.0.0.1:7933", "self", PortMap{PortGRPC: 7833})
+	other := NewDetailedHostInfo("127.0.0.2:7933", "other", PortMap{PortGRPC: 7833})
+	third := NewDetailedHostInfo("127.0.0.3:7933", "third", PortMap{PortGRPC: 7833})
+	reported := NewDetailedHostInfo("127.0.0.3:7933", "third", PortMap{PortGRPC: 7834})
+
+	seeds := NewStaticPeerProvider(self, map[string][]HostInfo{"test-worker": {self, other}})
+	dns := NewStaticPeerProvider(other, map[string][]HostInfo{"test-worker": {other, third, reported}})
+	provider := NewChainedPeerProvider(log.NewNoop(), seeds, dns)
+
+	host, err := provider.WhoAmI()
+	require.NoError(t, err)
+	assert.Equal(t, self, host, "first provider wins")
+
+	members, err := provider.GetMembers("test-worker")
+	require.NoError(t, err)
+	assert.Equal(t, []HostInfo{self, other, third}, members, "an address is listed once, as reported first")
+}
+
+func TestChainedPeerProviderKeepsFirstHostOfConflictingAddress(t *testing.T) {
+	seeded := NewDetailedHostInfo("127.0.0.1:7933", "seeded", PortMap{PortGRPC: 7833})
+	discovered := NewDetailedHostInfo("127.0.0.1:7933", "discovered", PortMap{PortGRPC: 7834})
+
+	seeds := NewStaticPeerProvider(seeded, map[string][]HostInfo{"test-worker": {seeded}})
+	dns := NewStaticPeerProvider(discovered, map[string][]HostInfo{"test-worker": {discovered}})
+	members, err := NewChainedPeerProvider(log.NewNoop(), seeds, dns).GetMembers("test-worker")
+	require.NoError(t, err)
+	assert.Equal(t, []HostInfo{seeded}, members)
+
+	members, err = NewChainedPeerProvider(log.NewNoop(), dns, seeds).GetMembers("test-worker")
+	require.NoError(t, err)
+	assert.Equal(t, []HostInfo{discovered}, members, "the first provider wins")
+}
+
+func TestChainedPeerProviderAllFail(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	first := NewMockPeerProvider(ctrl)
+	second := NewMockPeerProvider(ctrl)
+	for _, p := range []*MockPeerProvider{first, second} {
+		p.EXPECT().WhoAmI().Return(HostInfo{}, errors.New("down"))
+		p.EXPECT().GetMembers("test-worker").Return(nil, errors.New("down"))
+		p.EXPECT().Subscribe("test", gomock.Any()).Return(errors.New("down"))
+	}
+	provider := NewChainedPeerProvider(log.NewNoop(), first, second)
+
+	_, err := provider.WhoAmI()
+	assert.Error(t, err)
+	_, err = provider.GetMembers("test-worker")
+	assert.Error(t, err)
+	assert.Error(t, provider.Subscribe("test", make(chan *ChangedEvent)))
+
+	_, err = NewChainedPeerProvider(log.NewNoop()).WhoAmI()
+	assert.Equal(t, errNoPeerProviders, err)
+}
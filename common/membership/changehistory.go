@@ -0,0 +1,101 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2017-2020 Uber Technologies Inc.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package membership
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const defaultChangeHistorySize = 100
+
+type (
+	// TimestampedChange is a membership change of a service ring along with the time it was applied
+	TimestampedChange struct {
+		Service string
+		Time    time.Time
+		Event   ChangedEvent
+	}
+
+	// changeHistory keeps the most recent membership changes of all rings.
+	// Changes are rare, so every record copies the buffer, and reads load it without locking.
+	changeHistory struct {
+		sync.Mutex
+		size    int
+		changes atomic.Value // []TimestampedChange, oldest first, never modified once stored
+	}
+)
+
+// WithChangeHistory returns a setter for the number of membership changes kept for RecentChanges,
+// defaults to 100. Zero or a negative size disables the history.
+func WithChangeHistory(size int) ResolverOption {
+	return func(rpo *MultiringResolver) {
+		rpo.changeHistorySize = size
+	}
+}
+
+func newChangeHistory(size int) *changeHistory {
+	if size <= 0 {
+		return nil
+	}
+	h := &changeHistory{size: size}
+	h.changes.Store([]TimestampedChange(nil))
+	return h
+}
+
+// record appends a change, dropping the oldest one when the history is full
+func (h *changeHistory) record(service string, event *ChangedEvent) {
+	if h == nil {
+		return
+	}
+	h.Lock()
+	defer h.Unlock()
+	old := h.changes.Load().([]TimestampedChange)
+	if len(old) == h.size {
+		old = old[1:]
+	}
+	changes := make([]TimestampedChange, len(old), len(old)+1)
+	copy(changes, old)
+	changes = append(changes, TimestampedChange{Service: service, Time: time.Now(), Event: *event})
+	h.changes.Store(changes)
+}
+
+// recent returns up to limit most recent changes oldest first, all of them when limit is not positive
+func (h *changeHistory) recent(limit int) []TimestampedChange {
+	if h == nil {
+		return nil
+	}
+	changes := h.changes.Load().([]TimestampedChange)
+	if limit > 0 && limit < len(changes) {
+		changes = changes[len(changes)-limit:]
+	}
+	res := make([]TimestampedChange, len(changes))
+	copy(res, changes)
+	return res
+}
+
+// RecentChanges returns up to limit most recent membership changes of all service rings, oldest first
+func (rpo *MultiringResolver) RecentChanges(limit int) []TimestampedChange {
+	return rpo.changes.recent(limit)
+}
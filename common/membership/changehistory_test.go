@@ -0,0 +1,72 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2017-2020 Uber Technologies Inc.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package membership
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/uber/cadence/common/log"
+	"github.com/uber/cadence/common/metrics"
+)
+
+func TestRecentChangesRetainsOnlyTheMostRecent(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	pp := NewMockPeerProvider(ctrl)
+	var calls []*gomock.Call
+	for i := 1; i <= 5; i++ {
+		calls = append(calls, pp.EXPECT().GetMembers("test-worker").Return([]HostInfo{NewHostInfo(fmt.Sprintf("127.0.0.%d:7933", i))}, nil))
+	}
+	gomock.InOrder(calls...)
+	r := NewMultiringResolver(testServices, pp, metrics.NewNoopMetricsClient(), log.NewNoop(), WithChangeHistory(3))
+	hr, err := r.getRing("test-worker")
+	require.NoError(t, err)
+
+	assert.Empty(t, r.RecentChanges(0))
+	for i := 0; i < 5; i++ {
+		require.NoError(t, hr.refreshMembers())
+	}
+
+	changes := r.RecentChanges(0)
+	require.Len(t, changes, 3)
+	for i, change := range changes {
+		assert.Equal(t, "test-worker", change.Service)
+		require.Len(t, change.Event.HostsAdded, 1)
+		assert.Equal(t, fmt.Sprintf("127.0.0.%d:7933", i+3), change.Event.HostsAdded[0].GetAddress(), "oldest changes are dropped")
+		if i > 0 {
+			assert.False(t, change.Time.Before(changes[i-1].Time))
+		}
+	}
+
+	latest := r.RecentChanges(1)
+	require.Len(t, latest, 1)
+	assert.Equal(t, changes[2], latest[0])
+	assert.Len(t, r.RecentChanges(10), 3)
+
+	disabled := NewMultiringResolver(testServices, pp, metrics.NewNoopMetricsClient(), log.NewNoop(), WithChangeHistory(0))
+	assert.Nil(t, disabled.RecentChanges(0))
+}
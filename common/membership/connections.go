@@ -0,0 +1,122 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2017-2020 Uber Technologies Inc.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package membership
+
+import (
+	"sync"
+)
+
+type (
+	// PeerConnectionManager opens and closes connections to ring members as lookups and membership require.
+	// Calls are made synchronously from lookups and ring refreshes, so they must not block, e.g. dial in the background.
+	PeerConnectionManager interface {
+		// Connect is called the first time a member of the service ring is returned by a lookup
+		Connect(service string, host HostInfo)
+		// Disconnect is called once a connected member left the service ring, or the ring is stopped
+		Disconnect(service string, host HostInfo)
+	}
+
+	// peerConnections tracks members of a ring which were connected to by addresses
+	peerConnections struct {
+		sync.Mutex
+		manager PeerConnectionManager
+		active  map[string]HostInfo
+	}
+)
+
+// WithPeerConnectionManager returns a setter which makes rings connect to members lazily, on their first lookup,
+// instead of to every member upfront. Connections are closed when members leave the ring, so the number of open
+// connections follows the members which are actually routed to, see ActivePeerConnections.
+func WithPeerConnectionManager(manager PeerConnectionManager) ResolverOption {
+	return func(rpo *MultiringResolver) {
+		rpo.connectionManager = manager
+	}
+}
+
+func newPeerConnections(manager PeerConnectionManager) *peerConnections {
+	return &peerConnections{
+		manager: manager,
+		active:  make(map[string]HostInfo),
+	}
+}
+
+// connect connects to the hosts which are not connected yet
+func (c *peerConnections) connect(service string, hosts []HostInfo) {
+	if c == nil {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+	for _, host := range hosts {
+		if _, ok := c.active[host.GetAddress()]; ok {
+			continue
+		}
+		c.active[host.GetAddress()] = host
+		c.manager.Connect(service, host)
+	}
+}
+
+// disconnect closes connections to the hosts which are connected
+func (c *peerConnections) disconnect(service string, hosts []HostInfo) {
+	if c == nil {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+	for _, host := range hosts {
+		connected, ok := c.active[host.GetAddress()]
+		if !ok {
+			continue
+		}
+		delete(c.active, host.GetAddress())
+		c.manager.Disconnect(service, connected)
+	}
+}
+
+// disconnectAll closes all connections
+func (c *peerConnections) disconnectAll(service string) {
+	if c == nil {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+	for addr, host := range c.active {
+		delete(c.active, addr)
+		c.manager.Disconnect(service, host)
+	}
+}
+
+// count returns the number of connected hosts
+func (c *peerConnections) count() int {
+	if c == nil {
+		return 0
+	}
+	c.Lock()
+	defer c.Unlock()
+	return len(c.active)
+}
+
+// ActivePeerConnections returns the number of members connected to
+func (r *ring) ActivePeerConnections() int {
+	return r.connections.count()
+}
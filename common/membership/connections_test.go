@@ -0,0 +1,220 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2017-2020 Uber Technologies Inc.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package membership
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/uber/cadence/common/log"
+	"github.com/uber/cadence/common/metrics"
+)
+
+// recordingConnectionManager records connection changes as "+address" and "-address"
+type recordingConnectionManager struct {
+	sync.Mutex
+	calls []string
+}
+
+func (m *recordingConnectionManager) Connect(service string, host HostInfo) {
+	m.Lock()
+	defer m.Unlock()
+	m.calls = append(m.calls, "+"+host.GetAddress())
+}
+
+func (m *recordingConnectionManager) Disconnect(service string, host HostInfo) {
+	m.Lock()
+	defer m.Unlock()
+	m.calls = append(m.calls, "-"+host.GetAddress())
+}
+
+func (m *recordingConnectionManager) drain() []string {
+	m.Lock()
+	defer m.Unlock()
+	calls := m.calls
+	m.calls = nil
+	return calls
+}
+
+func TestPeerConnectionsFollowLookupsAndMemberChurn(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	pp := NewMockPeerProvider(ctrl)
+	a, b, c := NewHostInfo("127.0.0.1:7933"), NewHostInfo("127.0.0.2:7933"), NewHostInfo("127.0.0.3:7933")
+	gomock.InOrder(
+		pp.EXPECT().GetMembers("test-worker").Return([]HostInfo{a, b}, nil),
+		pp.EXPECT().GetMembers("test-worker").Return([]HostInfo{a, b, c}, nil),
+		pp.EXPECT().GetMembers("test-worker").Return([]HostInfo{c}, nil),
+	)
+	manager := &recordingConnectionManager{}
+	r := NewMultiringResolver(testServices, pp, metrics.NewNoopMetricsClient(), log.NewNoop(), WithPeerConnectionManager(manager))
+	hr, err := r.getRing("test-worker")
+	require.NoError(t, err)
+
+	require.NoError(t, hr.refreshMembers())
+	assert.Empty(t, manager.drain(), "members are not connected upfront")
+	assert.Zero(t, r.ActivePeerConnections())
+
+	owner, err := r.Lookup("test-worker", "key")
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		_, err = r.Lookup("test-worker", "key")
+		require.NoError(t, err)
+	}
+	assert.Equal(t, []string{"+" + owner.GetAddress()}, manager.drain(), "the owner is connected once on the first lookup")
+	assert.Equal(t, 1, r.ActivePeerConnections())
+
+	require.NoError(t, hr.refreshMembers())
+	assert.Empty(t, manager.drain(), "joining members are not connected")
+
+	require.NoError(t, hr.refreshMembers())
+	assert.Equal(t, []string{"-" + owner.GetAddress()}, manager.drain(), "only the connected member which left is disconnected")
+	assert.Zero(t, r.ActivePeerConnections())
+
+	owners, err := r.LookupN("test-worker", "key", 2)
+	require.NoError(t, err)
+	require.Len(t, owners, 1)
+	assert.Equal(t, c.GetAddress(), owners[0].GetAddress())
+	assert.Equal(t, []string{"+" + c.GetAddress()}, manager.drain())
+
+	pp.EXPECT().Subscribe(gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+	pp.EXPECT().GetMembers(gomock.Any()).Return([]HostInfo{c}, nil).AnyTimes()
+	pp.EXPECT().Start().AnyTimes()
+	pp.EXPECT().Stop().AnyTimes()
+	r.Start()
+	r.Stop()
+	assert.Equal(t, []string{"-" + c.GetAddress()}, manager.drain(), "stopped rings close their connections")
+}
+
+func TestCachedAndPinnedLookupsAreConnected(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	pp := NewMockPeerProvider(ctrl)
+	a, b := NewHostInfo("127.0.0.1:7933"), NewHostInfo("127.0.0.2:7933")
+	outsider := NewHostInfo("127.0.0.9:7933")
+	pp.EXPECT().GetMembers("test-worker").Return([]HostInfo{a, b}, nil)
+	manager := &recordingConnectionManager{}
+	r := NewMultiringResolver(testServices, pp, metrics.NewNoopMetricsClient(), log.NewNoop(),
+		WithPeerConnectionManager(manager), WithLookupCache(10))
+	hr, err := r.getRing("test-worker")
+	require.NoError(t, err)
+	require.NoError(t, hr.refreshMembers())
+
+	hr.PinKey("pinned", b)
+	_, err = hr.Lookup("pinned")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"+" + b.GetAddress()}, manager.drain(), "pinned members are connected")
+
+	hr.PinKey("outsider", outsider)
+	_, err = hr.Lookup("outsider")
+	require.NoError(t, err)
+	assert.Empty(t, manager.drain(), "hosts which are not members are never disconnected, so they are not connected")
+
+	owner, err := hr.Lookup("key")
+	require.NoError(t, err)
+	manager.drain()
+	hr.connections.disconnectAll("test-worker")
+	assert.NotEmpty(t, manager.drain())
+	cached, err := hr.Lookup("key")
+	require.NoError(t, err)
+	assert.Equal(t, owner.GetAddress(), cached.GetAddress())
+	assert.Equal(t, []string{"+" + owner.GetAddress()}, manager.drain(), "cache hits connect their owner")
+}
+
+func TestCachedAndPinnedLookupsAreConnectedDuringRefresh(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	pp := NewMockPeerProvider(ctrl)
+	a, b, c := NewHostInfo("127.0.0.1:7933"), NewHostInfo("127.0.0.2:7933"), NewHostInfo("127.0.0.3:7933")
+	memberSets := [][]HostInfo{{a, b}, {b, c}, {a, c}, {a, b, c}}
+	var (
+		mu      sync.Mutex
+		current []HostInfo
+		calls   int
+	)
+	pp.EXPECT().GetMembers("test-worker").DoAndReturn(func(service string) ([]HostInfo, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		current = memberSets[calls%len(memberSets)]
+		calls++
+		return current, nil
+	}).AnyTimes()
+	manager := &recordingConnectionManager{}
+	r := NewMultiringResolver(testServices, pp, metrics.NewNoopMetricsClient(), log.NewNoop(),
+		WithPeerConnectionManager(manager), WithLookupCache(10))
+	hr, err := r.getRing("test-worker")
+	require.NoError(t, err)
+	require.NoError(t, hr.refreshMembers())
+	hr.PinKey("pinned", b)
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				for _, key := range []string{"key-1", "key-2", "pinned"} {
+					_, _ = hr.Lookup(key)
+				}
+			}
+		}()
+	}
+	for i := 0; i < 200; i++ {
+		require.NoError(t, hr.refreshMembers())
+	}
+	close(stop)
+	wg.Wait()
+
+	connected := make(map[string]bool)
+	for _, call := range manager.drain() {
+		connected[call[1:]] = call[0] == '+'
+	}
+	mu.Lock()
+	members := make(map[string]bool)
+	for _, member := range current {
+		members[member.GetAddress()] = true
+	}
+	mu.Unlock()
+	for addr, ok := range connected {
+		if ok {
+			assert.True(t, members[addr], "%v is connected but left the ring", addr)
+		}
+	}
+	for _, key := range []string{"key-1", "key-2", "key-1", "key-2", "pinned"} {
+		host, err := hr.Lookup(key)
+		require.NoError(t, err)
+		for _, call := range manager.drain() {
+			connected[call[1:]] = call[0] == '+'
+		}
+		if members[host.GetAddress()] {
+			assert.True(t, connected[host.GetAddress()], "%v is returned by a lookup of %v but not connected", host, key)
+		}
+	}
+}
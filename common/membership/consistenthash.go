@@ -0,0 +1,182 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2017-2020 Uber Technologies Inc.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package membership
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/dgryski/go-farm"
+)
+
+// keyBuffers holds buffers which keys are copied to for hashing, the hash function is called indirectly,
+// so converting the key to bytes on the stack is not possible
+var keyBuffers = sync.Pool{New: func() interface{} {
+	buf := make([]byte, 0, 64)
+	return &buf
+}}
+
+// HashRing is a consistent hash ring of member addresses. It is immutable once built, so it is safe for concurrent lookups.
+// Points are kept in a slice sorted by hash, ties are broken by member address and then by replica index,
+// which is the order of ringpop hashring, so both place keys on the same members.
+type HashRing struct {
+	points   []replicaPoint
+	servers  []string // sorted unique member addresses
+	owners   int      // number of servers with points, members with weight 0 own no keys
+	replicas int
+	hash     func([]byte) uint32
+	version  uint64 // set by the service ring, see RingVersion
+}
+
+// HashRingOption sets optional details of HashRing
+type HashRingOption func(*HashRing)
+
+// WithHashFunc replaces farmhash fingerprint, which places keys and replica points on the ring, only the lower
+// 32 bits of the hash are used. Every key and member moves to a different position, so changing the hash function
+// of a running cluster reshuffles ownership of all keys and every host of a service has to use the same function.
+func WithHashFunc(hash func([]byte) uint64) HashRingOption {
+	return func(r *HashRing) {
+		r.hash = func(b []byte) uint32 { return uint32(hash(b)) }
+	}
+}
+
+type replicaPoint struct {
+	hash    uint32
+	address string
+	index   int
+}
+
+func (p replicaPoint) less(other replicaPoint) bool {
+	if p.hash != other.hash {
+		return p.hash < other.hash
+	}
+	if p.address != other.address {
+		return p.address < other.address
+	}
+	return p.index < other.index
+}
+
+// NewHashRing places members on a hashring with replicaPoints virtual nodes per unit of member weight.
+// More virtual nodes smooth key distribution, which matters for rings of a few hosts,
+// but every member then takes replicaPoints hashes to compute and entries to keep on every ring rebuild,
+// so memory and rebuild CPU grow linearly and lookups logarithmically with the replica count.
+// Members with duplicate addresses are added once, members with weight 0 are listed in Servers but own no keys.
+func NewHashRing(members []HostInfo, replicaPoints int, opts ...HashRingOption) *HashRing {
+	ring := &HashRing{
+		points:   make([]replicaPoint, 0, len(members)*replicaPoints),
+		servers:  make([]string, 0, len(members)),
+		replicas: replicaPoints,
+		hash:     farm.Fingerprint32,
+	}
+	for _, opt := range opts {
+		opt(ring)
+	}
+	added := make(map[string]struct{}, len(members))
+	for _, member := range members {
+		addr := member.GetAddress()
+		if _, ok := added[addr]; ok {
+			continue
+		}
+		added[addr] = struct{}{}
+		ring.servers = append(ring.servers, addr)
+		points := ring.memberPoints(member)
+		if len(points) > 0 {
+			ring.owners++
+		}
+		for i, hash := range points {
+			ring.points = append(ring.points, replicaPoint{hash: hash, address: addr, index: i})
+		}
+	}
+	sort.Strings(ring.servers)
+	sort.Slice(ring.points, func(i, j int) bool { return ring.points[i].less(ring.points[j]) })
+	return ring
+}
+
+// Lookup returns the address of the member owning the key, false if the ring is empty
+func (r *HashRing) Lookup(key string) (string, bool) {
+	addrs := r.LookupN(key, 1)
+	if len(addrs) == 0 {
+		return "", false
+	}
+	return addrs[0], true
+}
+
+// LookupN returns up to n distinct member addresses owning the key, in ring order
+func (r *HashRing) LookupN(key string, n int) []string {
+	if n > r.owners {
+		n = r.owners
+	}
+	if n < 1 {
+		return nil
+	}
+
+	start := r.search(r.hashKey(key))
+	addrs := make([]string, 0, n)
+	for i := 0; i < len(r.points) && len(addrs) < n; i++ {
+		addr := r.points[(start+i)%len(r.points)].address
+		if !containsAddress(addrs, addr) {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
+// hashKey returns the position of the key on the ring without allocating
+func (r *HashRing) hashKey(key string) uint32 {
+	buf := keyBuffers.Get().(*[]byte)
+	*buf = append((*buf)[:0], key...)
+	hash := r.hash(*buf)
+	keyBuffers.Put(buf)
+	return hash
+}
+
+// search returns the index of the first point at or after hash, which is len(r.points) past the last point
+func (r *HashRing) search(hash uint32) int {
+	return sort.Search(len(r.points), func(i int) bool { return r.points[i].hash >= hash })
+}
+
+// ServerCount returns the number of members on the ring
+func (r *HashRing) ServerCount() int {
+	return len(r.servers)
+}
+
+// Servers returns addresses of all members on the ring, sorted
+func (r *HashRing) Servers() []string {
+	servers := make([]string, len(r.servers))
+	copy(servers, r.servers)
+	return servers
+}
+
+// memberPoints returns positions of the member on the ring
+func (r *HashRing) memberPoints(member HostInfo) []uint32 {
+	return ringPoints(member, r.replicas, r.hash)
+}
+
+func containsAddress(addrs []string, addr string) bool {
+	for _, a := range addrs {
+		if a == addr {
+			return true
+		}
+	}
+	return false
+}
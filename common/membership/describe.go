@@ -0,0 +1,179 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2017-2020 Uber Technologies Inc.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package membership
+
+import (
+	"hash/fnv"
+	"math"
+	"sort"
+)
+
+type (
+	// RingDescription is a point in time view of a service ring, suitable for JSON serialization
+	RingDescription struct {
+		Service string              `json:"service"`
+		Digest  uint64              `json:"digest"`
+		Members []MemberDescription `json:"members"`
+	}
+
+	// MemberDescription describes a ring member and the parts of the key hash space it owns.
+	// Shards are set for rings of services configured with WithShardCounts.
+	MemberDescription struct {
+		Address  string       `json:"address"`
+		Identity string       `json:"identity"`
+		Status   string       `json:"status"`
+		Weight   int          `json:"weight"`
+		Drained  bool         `json:"drained"`
+		Ranges   []HashRange  `json:"ranges"`
+		Shards   []ShardRange `json:"shards,omitempty"`
+	}
+
+	// HashRange is an inclusive range of key hashes
+	HashRange struct {
+		Start uint32 `json:"start"`
+		End   uint32 `json:"end"`
+	}
+
+	// ShardRange is an inclusive range of shard IDs
+	ShardRange struct {
+		Start int `json:"start"`
+		End   int `json:"end"`
+	}
+)
+
+// WithShardCounts returns a setter for the number of shards of the given services, e.g. the number of history shards.
+// Rings of these services are described with the shards owned by each member, placed as LookupShardRange places them.
+func WithShardCounts(numShards map[Service]int) ResolverOption {
+	return func(rpo *MultiringResolver) {
+		rpo.shardCounts = make(map[string]int, len(numShards))
+		for service, count := range numShards {
+			rpo.shardCounts[string(service)] = count
+		}
+	}
+}
+
+// describe returns members of the ring sorted by address, along with the hash ranges they own
+func (r *ring) describe() RingDescription {
+	r.members.RLock()
+	defer r.members.RUnlock()
+
+	ranges := ownedRanges(ownedPoints(r.members.keys))
+	shards := r.ownedShardsLocked()
+	desc := RingDescription{
+		Service: r.service,
+		Members: make([]MemberDescription, 0, len(r.members.keys)),
+	}
+	members := make([]HostInfo, 0, len(r.members.keys))
+	for addr, member := range r.members.keys {
+		members = append(members, member)
+		desc.Members = append(desc.Members, MemberDescription{
+			Address:  addr,
+			Identity: member.Identity(),
+			Status:   member.GetStatus().String(),
+			Weight:   member.GetWeight(),
+			Drained:  member.IsDrained(),
+			Ranges:   append([]HashRange{}, ranges[addr]...),
+			Shards:   shards[addr],
+		})
+	}
+	sort.Slice(desc.Members, func(i, j int) bool { return desc.Members[i].Address < desc.Members[j].Address })
+	desc.Digest = memberDigest(members)
+	return desc
+}
+
+// ownedShardsLocked returns the shard IDs owned by each member as ranges sorted by ID, members must be locked for reading.
+// It is empty if the shard count of the ring is not set or no member can own shards.
+func (r *ring) ownedShardsLocked() map[string][]ShardRange {
+	res := make(map[string][]ShardRange)
+	if r.numShards <= 0 {
+		return res
+	}
+	owners, _, err := r.shardOwnersLocked(r.ring(), 0, r.numShards)
+	if err != nil {
+		return res
+	}
+	for shardID := 0; shardID < r.numShards; shardID++ {
+		addr := owners[shardID].GetAddress()
+		ranges := res[addr]
+		if n := len(ranges); n > 0 && ranges[n-1].End+1 == shardID {
+			ranges[n-1].End = shardID
+			continue
+		}
+		res[addr] = append(ranges, ShardRange{Start: shardID, End: shardID})
+	}
+	return res
+}
+
+// digest returns the digest of the current members of the ring
+func (r *ring) digest() uint64 {
+	r.members.RLock()
+	defer r.members.RUnlock()
+	members := make([]HostInfo, 0, len(r.members.keys))
+	for _, member := range r.members.keys {
+		members = append(members, member)
+	}
+	return memberDigest(members)
+}
+
+// memberDigest hashes the keys of members in sorted order, so it doesn't depend on the order members were listed in.
+// Hosts seeing the same members of a ring compute the same digest, a mismatch means their views disagree.
+func memberDigest(members []HostInfo) uint64 {
+	keys := make([]string, 0, len(members))
+	for _, member := range members {
+		keys = append(keys, member.Key())
+	}
+	sort.Strings(keys)
+
+	h := fnv.New64a()
+	for _, key := range keys {
+		h.Write([]byte(key))
+	}
+	return h.Sum64()
+}
+
+// ownedRanges splits the hash space into ranges (previous point, point] owned by the point owner,
+// adjacent ranges of the same owner are merged. The range wrapping around the end of the hash space is split in two.
+func ownedRanges(o ringOwners) map[string][]HashRange {
+	res := make(map[string][]HashRange)
+	if len(o.hashes) == 0 {
+		return res
+	}
+	add := func(owner string, start, end uint32) {
+		ranges := res[owner]
+		if n := len(ranges); n > 0 && ranges[n-1].End+1 == start {
+			ranges[n-1].End = end
+			return
+		}
+		res[owner] = append(ranges, HashRange{Start: start, End: end})
+	}
+
+	first := o.owners[o.hashes[0]]
+	add(first, 0, o.hashes[0])
+	for i := 1; i < len(o.hashes); i++ {
+		add(o.owners[o.hashes[i]], o.hashes[i-1]+1, o.hashes[i])
+	}
+	if last := o.hashes[len(o.hashes)-1]; last != math.MaxUint32 {
+		add(first, last+1, math.MaxUint32)
+	}
+	return res
+}
@@ -0,0 +1,200 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2017-2020 Uber Technologies Inc.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package membership
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/dgryski/go-farm"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/uber/cadence/common/log"
+	"github.com/uber/cadence/common/metrics"
+)
+
+func TestDescribeRingsMatchesLookups(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	pp := NewMockPeerProvider(ctrl)
+	a := NewMultiringResolver(testServices, pp, metrics.NewNoopMetricsClient(), log.NewNoop())
+
+	hosts := []HostInfo{
+		NewDetailedHostInfo("10.0.0.1:7933", "host-a", nil).WithStatus(StatusAlive),
+		NewDetailedHostInfo("10.0.0.2:7933", "host-b", nil),
+		NewDetailedHostInfo("10.0.0.3:7933", "host-c", nil).WithLabel(LabelDrained, "true"),
+	}
+	pp.EXPECT().GetMembers("test-worker").Return(hosts, nil).Times(1)
+	pp.EXPECT().GetMembers("test-services").Return(nil, nil).Times(1)
+	for _, service := range testServices {
+		r, err := a.getRing(Service(service))
+		require.NoError(t, err)
+		require.NoError(t, r.refresh())
+	}
+
+	rings := a.DescribeRings()
+	require.Len(t, rings, 2)
+	assert.Equal(t, "test-services", rings[0].Service)
+	assert.Empty(t, rings[0].Members)
+
+	worker := rings[1]
+	require.Equal(t, []string{"host-a", "host-b", "host-c"}, []string{
+		worker.Members[0].Identity, worker.Members[1].Identity, worker.Members[2].Identity,
+	})
+	assert.Equal(t, "alive", worker.Members[0].Status)
+	assert.Equal(t, 1, worker.Members[1].Weight)
+	assert.True(t, worker.Members[2].Drained)
+	assert.Empty(t, worker.Members[2].Ranges, "drained members don't own keys")
+
+	// ranges of all members cover the hash space exactly once
+	var covered uint64
+	for _, m := range worker.Members {
+		for _, r := range m.Ranges {
+			assert.LessOrEqual(t, r.Start, r.End)
+			covered += uint64(r.End-r.Start) + 1
+		}
+	}
+	assert.Equal(t, uint64(math.MaxUint32)+1, covered)
+
+	for i := 0; i < 200; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		owner, err := a.Lookup("test-worker", key)
+		require.NoError(t, err)
+		assert.Equal(t, owner.GetAddress(), rangeOwner(worker, farm.Fingerprint32([]byte(key))), key)
+	}
+
+	data, err := json.Marshal(rings)
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), `"identity":"host-a"`)
+}
+
+func TestDescribeRingsMatchesShardLookups(t *testing.T) {
+	hosts := []HostInfo{
+		NewHostInfo("10.0.0.1:7933"),
+		NewHostInfo("10.0.0.2:7933"),
+		NewHostInfo("10.0.0.3:7933").WithLabel(LabelDrained, "true"),
+	}
+	provider := NewStaticPeerProvider(hosts[0], map[string][]HostInfo{"test-worker": hosts})
+	const numShards = 64
+	a := NewMultiringResolver(testServices, provider, metrics.NewNoopMetricsClient(), log.NewNoop(),
+		WithShardCounts(map[Service]int{"test-worker": numShards}))
+	for _, service := range testServices {
+		r, err := a.getRing(Service(service))
+		require.NoError(t, err)
+		require.NoError(t, r.refresh())
+	}
+
+	rings := a.DescribeRings()
+	require.Len(t, rings, 2)
+	assert.Empty(t, rings[0].Members, "services without a shard count describe no shards")
+
+	owners := make(map[int]string)
+	for _, m := range rings[1].Members {
+		for _, r := range m.Shards {
+			require.LessOrEqual(t, r.Start, r.End)
+			for shardID := r.Start; shardID <= r.End; shardID++ {
+				_, ok := owners[shardID]
+				assert.False(t, ok, "shard %v is described twice", shardID)
+				owners[shardID] = m.Address
+			}
+		}
+	}
+	assert.Empty(t, rings[1].Members[2].Shards, "drained members don't own shards")
+	require.Len(t, owners, numShards)
+	for shardID := 0; shardID < numShards; shardID++ {
+		owner, err := a.LookupShard("test-worker", shardID)
+		require.NoError(t, err)
+		assert.Equal(t, owner.GetAddress(), owners[shardID], "shard %v", shardID)
+	}
+}
+
+func TestMemberDigestIgnoresOrder(t *testing.T) {
+	a := NewDetailedHostInfo("10.0.0.1:7933", "host-a", PortMap{PortGRPC: 7833})
+	b := NewDetailedHostInfo("10.0.0.2:7933", "host-b", PortMap{PortGRPC: 7833})
+	c := NewDetailedHostInfo("10.0.0.3:7933", "host-c", PortMap{PortGRPC: 7833})
+
+	digest := memberDigest([]HostInfo{a, b, c})
+	assert.Equal(t, digest, memberDigest([]HostInfo{c, a, b}))
+	assert.Equal(t, digest, memberDigest([]HostInfo{b, c, a}))
+
+	assert.NotEqual(t, digest, memberDigest([]HostInfo{a, b}))
+	assert.NotEqual(t, digest, memberDigest([]HostInfo{a, b, c.WithPorts(PortMap{PortGRPC: 7834})}))
+	assert.Equal(t, memberDigest(nil), memberDigest([]HostInfo{}))
+}
+
+func TestConsistencyDigestMatchesAcrossResolvers(t *testing.T) {
+	hosts := []HostInfo{NewHostInfo("10.0.0.1:7933"), NewHostInfo("10.0.0.2:7933"), NewHostInfo("10.0.0.3:7933")}
+	reversed := []HostInfo{hosts[2], hosts[1], hosts[0]}
+
+	var digests []uint64
+	for _, members := range [][]HostInfo{hosts, reversed, hosts[:2]} {
+		provider := NewStaticPeerProvider(members[0], map[string][]HostInfo{"test-worker": members})
+		a := NewMultiringResolver(testServices, provider, metrics.NewNoopMetricsClient(), log.NewNoop())
+		r, err := a.getRing("test-worker")
+		require.NoError(t, err)
+		require.NoError(t, r.refresh())
+
+		digest, err := a.ConsistencyDigest("test-worker")
+		require.NoError(t, err)
+		assert.Equal(t, digest, a.DescribeRings()[1].Digest)
+		digests = append(digests, digest)
+	}
+	assert.Equal(t, digests[0], digests[1], "same members give the same digest")
+	assert.NotEqual(t, digests[0], digests[2], "diverged members give different digests")
+
+	_, err := NewMultiringResolver(testServices, nil, metrics.NewNoopMetricsClient(), log.NewNoop()).ConsistencyDigest("unknown")
+	assert.Error(t, err)
+}
+
+func TestOwnedRangesMergesAndWraps(t *testing.T) {
+	assert.Empty(t, ownedRanges(ringOwners{}))
+
+	o := ringOwners{
+		hashes: []uint32{10, 20, 30, math.MaxUint32},
+		owners: map[uint32]string{10: "a", 20: "a", 30: "b", math.MaxUint32: "a"},
+	}
+	assert.Equal(t, map[string][]HashRange{
+		"a": {{Start: 0, End: 20}, {Start: 31, End: math.MaxUint32}},
+		"b": {{Start: 21, End: 30}},
+	}, ownedRanges(o))
+
+	o = ringOwners{hashes: []uint32{10, 20}, owners: map[uint32]string{10: "a", 20: "b"}}
+	assert.Equal(t, map[string][]HashRange{
+		"a": {{Start: 0, End: 10}, {Start: 21, End: math.MaxUint32}},
+		"b": {{Start: 11, End: 20}},
+	}, ownedRanges(o))
+}
+
+func rangeOwner(desc RingDescription, hash uint32) string {
+	for _, m := range desc.Members {
+		for _, r := range m.Ranges {
+			if r.Start <= hash && hash <= r.End {
+				return m.Address
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,90 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2017-2020 Uber Technologies Inc.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package membership
+
+import (
+	"sync/atomic"
+)
+
+type (
+	// DomainRoutingPolicy restricts the members which own keys of a domain, e.g. to isolate a noisy tenant
+	// on dedicated hosts. It is consulted on every LookupForDomain, so implementations can be reloaded at runtime.
+	DomainRoutingPolicy interface {
+		// AllowedMembers returns the addresses of members of the service ring which may own keys of the domain,
+		// no addresses means that all members may
+		AllowedMembers(service Service, domain string) []string
+	}
+
+	// DomainRoutingRules is a DomainRoutingPolicy of fixed member addresses per service and domain,
+	// which can be replaced with Update without a restart
+	DomainRoutingRules struct {
+		rules atomic.Value // map[Service]map[string][]string by service and domain, never modified once stored
+	}
+)
+
+// WithDomainRoutingPolicy returns a setter for the policy LookupForDomain restricts owners of domain keys with
+func WithDomainRoutingPolicy(policy DomainRoutingPolicy) ResolverOption {
+	return func(rpo *MultiringResolver) {
+		rpo.domainRouting = policy
+	}
+}
+
+// NewDomainRoutingRules returns rules allowing members by address, keyed by service and then by domain
+func NewDomainRoutingRules(rules map[Service]map[string][]string) *DomainRoutingRules {
+	r := &DomainRoutingRules{}
+	r.Update(rules)
+	return r
+}
+
+// Update replaces all rules, lookups which are in flight may still use the previous ones
+func (r *DomainRoutingRules) Update(rules map[Service]map[string][]string) {
+	clone := make(map[Service]map[string][]string, len(rules))
+	for service, domains := range rules {
+		clone[service] = make(map[string][]string, len(domains))
+		for domain, addrs := range domains {
+			clone[service][domain] = append([]string(nil), addrs...)
+		}
+	}
+	r.rules.Store(clone)
+}
+
+// AllowedMembers returns the addresses the domain is restricted to in the service ring
+func (r *DomainRoutingRules) AllowedMembers(service Service, domain string) []string {
+	return r.rules.Load().(map[Service]map[string][]string)[service][domain]
+}
+
+// LookupForDomain is Lookup for a key of the domain, which is owned by one of the members the DomainRoutingPolicy
+// set with WithDomainRoutingPolicy allows. ErrInsufficientHosts is returned if none of them is in the ring,
+// keys of a restricted domain are never routed to the rest of the ring.
+func (rpo *MultiringResolver) LookupForDomain(service Service, domain, key string) (HostInfo, error) {
+	ring, err := rpo.getRing(service)
+	if err != nil {
+		return HostInfo{}, err
+	}
+	if rpo.domainRouting != nil {
+		if allowed := rpo.domainRouting.AllowedMembers(service, domain); len(allowed) > 0 {
+			return ring.LookupAmong(key, allowed)
+		}
+	}
+	return ring.Lookup(key)
+}
@@ -0,0 +1,101 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2017-2020 Uber Technologies Inc.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package membership
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/uber/cadence/common/log"
+	"github.com/uber/cadence/common/metrics"
+)
+
+func TestDomainRoutingPolicyRestrictsOwners(t *testing.T) {
+	var members []HostInfo
+	for i := 1; i <= 6; i++ {
+		members = append(members, NewHostInfo(fmt.Sprintf("10.0.0.%d:7934", i)))
+	}
+	provider := NewStaticPeerProvider(HostInfo{}, map[string][]HostInfo{"test-worker": members})
+	rules := NewDomainRoutingRules(map[Service]map[string][]string{
+		"test-worker": {"noisy-domain": {"10.0.0.2:7934", "10.0.0.5:7934"}},
+	})
+	r := NewMultiringResolver(testServices, provider, metrics.NewNoopMetricsClient(), log.NewNoop(), WithDomainRoutingPolicy(rules))
+	hr, err := r.getRing("test-worker")
+	require.NoError(t, err)
+	require.NoError(t, hr.refresh())
+
+	restricted := make(map[string]int)
+	for i := 0; i < 200; i++ {
+		key := fmt.Sprintf("workflow-%d", i)
+		owner, err := r.LookupForDomain("test-worker", "noisy-domain", key)
+		require.NoError(t, err)
+		restricted[owner.GetAddress()]++
+
+		expected, err := r.Lookup("test-worker", key)
+		require.NoError(t, err)
+		owner, err = r.LookupForDomain("test-worker", "other-domain", key)
+		require.NoError(t, err)
+		assert.Equal(t, expected.GetAddress(), owner.GetAddress(), "unrestricted domains use the full ring")
+	}
+	assert.Len(t, restricted, 2)
+	assert.NotZero(t, restricted["10.0.0.2:7934"])
+	assert.NotZero(t, restricted["10.0.0.5:7934"])
+
+	rules.Update(map[Service]map[string][]string{
+		"test-worker": {"noisy-domain": {"10.0.0.9:7934"}},
+	})
+	_, err = r.LookupForDomain("test-worker", "noisy-domain", "workflow-0")
+	assert.Equal(t, ErrInsufficientHosts, err, "restricted keys don't fall back to other members")
+
+	rules.Update(nil)
+	expected, err := r.Lookup("test-worker", "workflow-0")
+	require.NoError(t, err)
+	owner, err := r.LookupForDomain("test-worker", "noisy-domain", "workflow-0")
+	require.NoError(t, err)
+	assert.Equal(t, expected.GetAddress(), owner.GetAddress(), "reloaded rules lift the restriction")
+}
+
+func TestLookupAmongMatchesRingOfAllowedMembers(t *testing.T) {
+	var members []HostInfo
+	for i := 1; i <= 5; i++ {
+		members = append(members, NewHostInfo(fmt.Sprintf("10.0.0.%d:7934", i)))
+	}
+	provider := NewStaticPeerProvider(HostInfo{}, map[string][]HostInfo{"test-worker": members})
+	r := NewMultiringResolver(testServices, provider, metrics.NewNoopMetricsClient(), log.NewNoop())
+	hr, err := r.getRing("test-worker")
+	require.NoError(t, err)
+	require.NoError(t, hr.refresh())
+
+	subset := NewHashRing([]HostInfo{members[1], members[3]}, defaultReplicaPoints)
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("workflow-%d", i)
+		expected, ok := subset.Lookup(key)
+		require.True(t, ok)
+		owner, err := hr.LookupAmong(key, []string{members[1].GetAddress(), members[3].GetAddress()})
+		require.NoError(t, err)
+		assert.Equal(t, expected, owner.GetAddress(), key)
+	}
+}
@@ -21,17 +21,19 @@
 package membership
 
 import (
+	"context"
 	"fmt"
+	"math"
+	"net"
+	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
 
-	"github.com/dgryski/go-farm"
-	"github.com/uber/ringpop-go/hashring"
-
 	"github.com/uber/cadence/common"
 	"github.com/uber/cadence/common/log"
 	"github.com/uber/cadence/common/log/tag"
+	"github.com/uber/cadence/common/metrics"
 	"github.com/uber/cadence/common/types"
 )
 
@@ -40,12 +42,27 @@ import (
 // ErrInsufficientHosts is thrown when there are not enough hosts to serve the request
 var ErrInsufficientHosts = &types.InternalServiceError{Message: "Not enough hosts to serve the request"}
 
+// ErrNoMembers is thrown when the ring has no members at all, e.g. before it is loaded from the peer provider.
+// Like ErrInsufficientHosts it is a transient error, so requests should be retried with a backoff.
+var ErrNoMembers = &types.InternalServiceError{Message: "No hosts are members of the ring"}
+
+// ErrOnlyOwnerExcluded is thrown when the excluded host is the only owner of a key
+var ErrOnlyOwnerExcluded = &types.InternalServiceError{Message: "Excluded host is the only owner of the key"}
+
 const (
 	minRefreshInternal     = time.Second * 4
 	defaultRefreshInterval = time.Second * 10
-	replicaPoints          = 100
+	refreshDebounce        = time.Millisecond * 100
+	forceRefreshTimeout    = time.Second * 30
+	evictionPollInterval   = time.Millisecond * 50
+	zoneAwareCandidates    = 3
+	defaultReplicaPoints   = 100
+	basisPoints            = 10000
 )
 
+// protocolPorts are the ports with their own membership view, see LookupForPort
+var protocolPorts = []string{PortTchannel, PortGRPC}
+
 // PeerProvider is used to retrieve membership information from provider
 type PeerProvider interface {
 	common.Daemon
@@ -53,6 +70,10 @@ type PeerProvider interface {
 	GetMembers(service string) ([]HostInfo, error)
 	WhoAmI() (HostInfo, error)
 	SelfEvict() error
+	// Drain announces this host as drained in the ring of the service, it stays a member but stops owning keys
+	Drain(service string) error
+	// Undrain reverts Drain
+	Undrain(service string) error
 	Subscribe(name string, notifyChannel chan<- *ChangedEvent) error
 }
 
@@ -63,25 +84,66 @@ type ring struct {
 	refreshChan  chan *ChangedEvent
 	shutdownCh   chan struct{}
 	shutdownWG   sync.WaitGroup
+	scope        metrics.Scope
 	logger       log.Logger
+	ports        PortMap          // overrides ports of all members when set
+	replicas     int              // virtual nodes of every member on the hashring
+	numShards    int              // shards described by DescribeRings, 0 when the service is not sharded
+	health       *healthState     // tracks unhealthy members when health checks are enabled
+	load         *loadState       // tracks member load scores when load tracking is enabled
+	cache        *lookupCache     // caches Lookup results when enabled
+	connections  *peerConnections // tracks connected members when a connection manager is set
+	ringOptions  []HashRingOption
+	pins         keyPins        // keys routed to hosts set with PinKey
+	changes      *changeHistory // shared by the rings of a resolver, nil when disabled
+	warmup       *warmupHook    // shared by the rings of a resolver
+	self         *selfCache     // shared by the rings of a resolver, nil when the ring is used alone
+
+	lastRebalanceMoved int64    // basis points of the key space moved on the last change
+	drift              int64    // members which differed from the peer provider on the last reconciliation
+	drifted            []string // sorted addresses of these members, only used by the reconciliation loop
 
 	value atomic.Value // this stores the current hashring
 
+	// serializes refreshes of the refresh worker, Refresh and EvictSelf, so that the members read
+	// from the peer provider are stored in the order they were read
+	refreshing sync.Mutex
+
 	members struct {
 		sync.RWMutex
 		refreshed time.Time
-		keys      map[string]HostInfo // for mapping ip:port to HostInfo
+		keys      map[string]HostInfo  // for mapping ip:port to HostInfo
+		drained   int                  // number of drained members which are skipped by lookups
+		conflicts []IdentityConflict   // members sharing an identity, they collide on all ring points
+		portRings map[string]*HashRing // rings of members advertising each of protocolPorts
 	}
 
 	subscribers struct {
 		sync.RWMutex
-		keys map[string]chan<- *ChangedEvent
+		keys map[string]*subscription
+	}
+
+	shardWatchers struct {
+		sync.Mutex
+		list []*shardWatcher
+	}
+
+	keyWatchers struct {
+		sync.Mutex
+		set map[*keyWatcher]struct{}
+	}
+
+	memberIPs struct {
+		sync.Mutex
+		version uint64
+		ips     map[string][]net.IP // IPs of member hosts by member address, see lookupResolvedAddress
 	}
 }
 
 func newHashring(
 	service string,
 	provider PeerProvider,
+	metricsClient metrics.Client,
 	logger log.Logger,
 ) *ring {
 	hashring := &ring{
@@ -89,21 +151,20 @@ func newHashring(
 		service:      service,
 		peerProvider: provider,
 		shutdownCh:   make(chan struct{}),
+		scope:        metricsClient.Scope(metrics.HashringScope, metrics.HashringServiceTag(service)),
 		logger:       logger,
 		refreshChan:  make(chan *ChangedEvent),
+		replicas:     defaultReplicaPoints,
 	}
 
 	hashring.members.keys = make(map[string]HostInfo)
-	hashring.subscribers.keys = make(map[string]chan<- *ChangedEvent)
+	hashring.subscribers.keys = make(map[string]*subscription)
+	hashring.keyWatchers.set = make(map[*keyWatcher]struct{})
 
-	hashring.value.Store(emptyHashring())
+	hashring.value.Store(NewHashRing(nil, hashring.replicas, hashring.ringOptions...))
 	return hashring
 }
 
-func emptyHashring() *hashring.HashRing {
-	return hashring.New(farm.Fingerprint32, replicaPoints)
-}
-
 // Start starts the hashring
 func (r *ring) Start() {
 	if !atomic.CompareAndSwapInt32(
@@ -123,6 +184,16 @@ func (r *ring) Start() {
 
 	r.shutdownWG.Add(1)
 	go r.refreshRingWorker()
+
+	if r.health != nil {
+		r.shutdownWG.Add(1)
+		go r.healthCheckWorker()
+	}
+
+	if r.load != nil {
+		r.shutdownWG.Add(1)
+		go r.loadTrackingWorker()
+	}
 }
 
 // Stop stops the resolver
@@ -136,11 +207,15 @@ func (r *ring) Stop() {
 	}
 
 	r.peerProvider.Stop()
-	r.value.Store(emptyHashring())
+	r.storeRing(NewHashRing(nil, r.replicas, r.ringOptions...))
+	r.connections.disconnectAll(r.service)
 
 	r.subscribers.Lock()
 	defer r.subscribers.Unlock()
-	r.subscribers.keys = make(map[string]chan<- *ChangedEvent)
+	for _, sub := range r.subscribers.keys {
+		sub.stop()
+	}
+	r.subscribers.keys = make(map[string]*subscription)
 	close(r.shutdownCh)
 
 	if success := common.AwaitWaitGroup(&r.shutdownWG, time.Minute); !success {
@@ -152,21 +227,360 @@ func (r *ring) Stop() {
 func (r *ring) Lookup(
 	key string,
 ) (HostInfo, error) {
-	addr, found := r.ring().Lookup(key)
-	if !found {
-		select {
-		case r.refreshChan <- &ChangedEvent{}:
-		default:
+	start := time.Now()
+	defer func() { r.scope.RecordHistogramDuration(metrics.HashringLookupLatency, time.Since(start)) }()
+
+	if host, ok := r.pins.get(key); ok {
+		r.scope.IncCounter(metrics.HashringPinnedLookups)
+		r.connectMember(host)
+		return host, nil
+	}
+
+	if r.cache == nil {
+		var host HostInfo
+		if err := r.lookupOwner(key, nil, &host); err != nil {
+			return HostInfo{}, err
 		}
+		return host, nil
+	}
+
+	host, generation, ok := r.cache.get(key)
+	if ok {
+		r.scope.IncCounter(metrics.HashringLookupCacheHits)
+		r.connectMember(host)
+		return host, nil
+	}
+	r.scope.IncCounter(metrics.HashringLookupCacheMisses)
+	if err := r.lookupOwner(key, nil, &host); err != nil {
+		return HostInfo{}, err
+	}
+	if r.cache.put(key, host, generation) {
+		r.scope.IncCounter(metrics.HashringLookupCacheEvictions)
+	}
+	return host, nil
+}
+
+// LookupInto is Lookup writing the owner of the key into out. It doesn't allocate, unless the lookup cache
+// is enabled or a connection manager has to connect to the owner, so it suits per request routing.
+func (r *ring) LookupInto(key string, out *HostInfo) error {
+	if r.cache != nil {
+		host, err := r.Lookup(key)
+		if err != nil {
+			return err
+		}
+		*out = host
+		return nil
+	}
+
+	start := time.Now()
+	defer func() { r.scope.RecordHistogramDuration(metrics.HashringLookupLatency, time.Since(start)) }()
+
+	if host, ok := r.pins.get(key); ok {
+		r.scope.IncCounter(metrics.HashringPinnedLookups)
+		r.connectMember(host)
+		*out = host
+		return nil
+	}
+	return r.lookupOwner(key, nil, out)
+}
+
+// LookupAmong finds the host responsible for serving the given key on the ring of the allowed members only,
+// given by addresses. ErrInsufficientHosts is returned if none of them can own keys.
+func (r *ring) LookupAmong(key string, allowed []string) (HostInfo, error) {
+	start := time.Now()
+	defer func() { r.scope.RecordHistogramDuration(metrics.HashringLookupLatency, time.Since(start)) }()
+
+	if len(allowed) == 0 {
 		return HostInfo{}, ErrInsufficientHosts
 	}
+	var host HostInfo
+	if err := r.lookupOwner(key, allowed, &host); err != nil {
+		return HostInfo{}, err
+	}
+	return host, nil
+}
+
+// LookupWithVersion finds the host responsible for serving the given key along with the version of the ring
+// it was found on. Results are not cached, so that the owner always matches the version.
+func (r *ring) LookupWithVersion(key string) (HostInfo, uint64, error) {
+	start := time.Now()
+	defer func() { r.scope.RecordHistogramDuration(metrics.HashringLookupLatency, time.Since(start)) }()
+
 	r.members.RLock()
 	defer r.members.RUnlock()
-	host, ok := r.members.keys[addr]
+	ring := r.ring()
+	owners, err := r.lookupOwnersLocked(ring, key, 1)
+	if err != nil {
+		return HostInfo{}, 0, err
+	}
+	return owners[0], ring.version, nil
+}
+
+// Version returns the version of the current ring snapshot, it is incremented on every membership change
+func (r *ring) Version() uint64 {
+	return r.ring().version
+}
+
+// LookupForPort finds the host responsible for serving the given key among members advertising the port,
+// which is one of PortTchannel and PortGRPC. Members without the port are left out,
+// so that traffic of a protocol is not routed to hosts which don't serve it yet.
+func (r *ring) LookupForPort(key, port string) (HostInfo, error) {
+	start := time.Now()
+	defer func() { r.scope.RecordHistogramDuration(metrics.HashringLookupLatency, time.Since(start)) }()
+
+	r.members.RLock()
+	defer r.members.RUnlock()
+	ring, ok := r.members.portRings[port]
 	if !ok {
-		return HostInfo{}, fmt.Errorf("host not found in member keys, host: %q", addr)
+		if !isProtocolPort(port) {
+			return HostInfo{}, fmt.Errorf("no membership view for port %q", port)
+		}
+		return HostInfo{}, ErrNoMembers
+	}
+	owners, err := r.lookupOwnersLocked(ring, key, 1)
+	if err != nil {
+		return HostInfo{}, err
+	}
+	return owners[0], nil
+}
+
+func isProtocolPort(port string) bool {
+	for _, p := range protocolPorts {
+		if p == port {
+			return true
+		}
+	}
+	return false
+}
+
+// LookupN finds up to n distinct hosts responsible for serving the given key, in ring order.
+// If the ring has less than n members, all of them are returned.
+func (r *ring) LookupN(
+	key string,
+	n int,
+) ([]HostInfo, error) {
+	if n < 1 {
+		return nil, fmt.Errorf("invalid number of owners requested: %d", n)
+	}
+	start := time.Now()
+	defer func() { r.scope.RecordHistogramDuration(metrics.HashringLookupLatency, time.Since(start)) }()
+	return r.lookupOwners(key, n)
+}
+
+// lookupOwner writes the first host owning the key in ring order into out, skipping drained and unhealthy members,
+// and members not in allowed unless it is empty
+func (r *ring) lookupOwner(key string, allowed []string, out *HostInfo) error {
+	r.members.RLock()
+	defer r.members.RUnlock()
+	ring := r.ring() // loaded under the lock, so that a concurrent refresh cannot swap it before members are read
+	if err := r.ownerLocked(ring, key, allowed, out); err != nil {
+		return err
+	}
+	if r.connections != nil {
+		r.connections.connect(r.service, []HostInfo{*out})
+	}
+	return nil
+}
+
+// connectMember connects to host, which was not looked up on the ring, if it is still a member.
+// Members are locked, so that a concurrent refresh cannot disconnect the host before it is connected.
+func (r *ring) connectMember(host HostInfo) {
+	if r.connections == nil {
+		return
+	}
+	r.members.RLock()
+	defer r.members.RUnlock()
+	if member, ok := r.members.keys[host.GetAddress()]; ok {
+		r.connections.connect(r.service, []HostInfo{member})
+	}
+}
+
+// ownerLocked is ownersLocked for a single owner, it walks the ring points without collecting addresses.
+// When allowed is not empty, members with other addresses are skipped, which places the key as a ring
+// of the allowed members only would.
+func (r *ring) ownerLocked(ring *HashRing, key string, allowed []string, out *HostInfo) error {
+	if len(ring.points) == 0 {
+		return r.emptyRingError(ring)
+	}
+	start := ring.search(ring.hashKey(key))
+	for i := 0; i < len(ring.points); i++ {
+		addr := ring.points[(start+i)%len(ring.points)].address
+		host, ok := r.members.keys[addr]
+		if !ok {
+			return fmt.Errorf("host not found in member keys, host: %q", addr)
+		}
+		if len(allowed) > 0 && !containsAddress(allowed, addr) {
+			continue
+		}
+		if host.IsDrained() || r.health.isUnhealthy(addr) {
+			continue
+		}
+		*out = host
+		return nil
+	}
+	return ErrInsufficientHosts
+}
+
+// emptyRingError is the error of a lookup on a ring without points, ErrInsufficientHosts if it has members
+// which own no keys because all of them have weight 0, ErrNoMembers otherwise
+func (r *ring) emptyRingError(ring *HashRing) error {
+	if ring.ServerCount() > 0 {
+		return ErrInsufficientHosts
+	}
+	r.signalRefresh()
+	return ErrNoMembers
+}
+
+// lookupOwners returns up to n hosts owning the key in ring order, skipping drained and unhealthy members
+func (r *ring) lookupOwners(key string, n int) ([]HostInfo, error) {
+	r.members.RLock()
+	defer r.members.RUnlock()
+	ring := r.ring()
+	return r.lookupOwnersLocked(ring, key, n)
+}
+
+// LookupBatch finds the owner of every key, returned in the order of keys.
+// Members are locked once for the whole batch, so all keys are resolved against the same ring.
+func (r *ring) LookupBatch(keys []string) ([]HostInfo, error) {
+	start := time.Now()
+	defer func() { r.scope.RecordHistogramDuration(metrics.HashringLookupLatency, time.Since(start)) }()
+
+	r.members.RLock()
+	defer r.members.RUnlock()
+	ring := r.ring()
+
+	hosts := make([]HostInfo, len(keys))
+	for i, key := range keys {
+		if err := r.ownerLocked(ring, key, nil, &hosts[i]); err != nil {
+			return nil, err
+		}
+	}
+	r.connections.connect(r.service, hosts)
+	return hosts, nil
+}
+
+// lookupOwnersLocked is lookupOwners on the given ring, members must be locked for reading
+func (r *ring) lookupOwnersLocked(ring *HashRing, key string, n int) ([]HostInfo, error) {
+	hosts, err := r.ownersLocked(ring, key, n)
+	if err != nil {
+		return nil, err
+	}
+	r.connections.connect(r.service, hosts)
+	return hosts, nil
+}
+
+// ownersLocked is lookupOwnersLocked without connecting to the owners
+func (r *ring) ownersLocked(ring *HashRing, key string, n int) ([]HostInfo, error) {
+	addrs := ring.LookupN(key, n+r.members.drained+r.health.unhealthyCount())
+	if len(addrs) == 0 {
+		return nil, r.emptyRingError(ring)
+	}
+	hosts := make([]HostInfo, 0, n)
+	for _, addr := range addrs {
+		host, ok := r.members.keys[addr]
+		if !ok {
+			return nil, fmt.Errorf("host not found in member keys, host: %q", addr)
+		}
+		if host.IsDrained() || r.health.isUnhealthy(addr) {
+			continue
+		}
+		hosts = append(hosts, host)
+		if len(hosts) == n {
+			break
+		}
+	}
+	if len(hosts) == 0 {
+		return nil, ErrInsufficientHosts
+	}
+	return hosts, nil
+}
+
+// LookupZoneAware returns the first of the top zoneAwareCandidates owners of the key which is in localZone,
+// according to its LabelZone label. If none of them is, or localZone is empty, the primary owner is returned.
+func (r *ring) LookupZoneAware(key, localZone string) (HostInfo, error) {
+	owners, err := r.LookupN(key, zoneAwareCandidates)
+	if err != nil {
+		return HostInfo{}, err
+	}
+	if localZone != "" {
+		for _, owner := range owners {
+			if zone, ok := owner.Label(LabelZone); ok && zone == localZone {
+				return owner, nil
+			}
+		}
+	}
+	return owners[0], nil
+}
+
+// LookupNDiverse returns up to n owners of the key, walking the ring and taking the next owner whose labelKey
+// label value was not taken yet. Members missing the label share the empty value. When there are fewer distinct
+// values than n, the remaining owners are filled in ring order, so the result has as many hosts as LookupN.
+func (r *ring) LookupNDiverse(key string, n int, labelKey string) ([]HostInfo, error) {
+	if n < 1 {
+		return nil, fmt.Errorf("invalid number of owners requested: %d", n)
+	}
+	start := time.Now()
+	defer func() { r.scope.RecordHistogramDuration(metrics.HashringLookupLatency, time.Since(start)) }()
+
+	r.members.RLock()
+	defer r.members.RUnlock()
+	ring := r.ring()
+
+	candidates, err := r.ownersLocked(ring, key, len(r.members.keys))
+	if err != nil {
+		return nil, err
+	}
+	owners := make([]HostInfo, 0, n)
+	taken := make([]bool, len(candidates))
+	used := make(map[string]struct{}, n)
+	for i, candidate := range candidates {
+		if len(owners) == n {
+			break
+		}
+		value, _ := candidate.Label(labelKey)
+		if _, ok := used[value]; ok {
+			continue
+		}
+		used[value] = struct{}{}
+		taken[i] = true
+		owners = append(owners, candidate)
+	}
+	for i, candidate := range candidates {
+		if len(owners) == n {
+			break
+		}
+		if !taken[i] {
+			owners = append(owners, candidate)
+		}
+	}
+	r.connections.connect(r.service, owners)
+	return owners, nil
+}
+
+// LookupExcluding finds the host in the ring responsible for serving the given key, skipping the excluded host.
+// If the excluded host owns the key, the next owner in ring order is returned.
+func (r *ring) LookupExcluding(
+	key string,
+	exclude HostInfo,
+) (HostInfo, error) {
+	owners, err := r.LookupN(key, 2)
+	if err != nil {
+		return HostInfo{}, err
+	}
+	for _, owner := range owners {
+		if owner.GetAddress() != exclude.GetAddress() {
+			return owner, nil
+		}
+	}
+	return HostInfo{}, ErrOnlyOwnerExcluded
+}
+
+// signalRefresh asks the refresh worker to reload members, without blocking
+func (r *ring) signalRefresh() {
+	select {
+	case r.refreshChan <- &ChangedEvent{}:
+	default:
 	}
-	return host, nil
 }
 
 // Subscribe registers callback watcher.
@@ -183,7 +597,7 @@ func (r *ring) Subscribe(
 		return fmt.Errorf("service %q already subscribed", service)
 	}
 
-	r.subscribers.keys[service] = notifyChannel
+	r.subscribers.keys[service] = newSubscription(notifyChannel)
 	return nil
 }
 
@@ -193,7 +607,10 @@ func (r *ring) Unsubscribe(
 ) error {
 	r.subscribers.Lock()
 	defer r.subscribers.Unlock()
-	delete(r.subscribers.keys, name)
+	if sub, ok := r.subscribers.keys[name]; ok {
+		sub.stop()
+		delete(r.subscribers.keys, name)
+	}
 	return nil
 }
 
@@ -202,12 +619,72 @@ func (r *ring) MemberCount() int {
 	return r.ring().ServerCount()
 }
 
+// LastRebalanceMoved returns the share of the key space in basis points which changed owner on the last membership change
+func (r *ring) LastRebalanceMoved() int {
+	return int(atomic.LoadInt64(&r.lastRebalanceMoved))
+}
+
+// LoadDistribution returns number of virtual nodes owned by each host in a ring, keyed by host address.
+// Hosts get virtual nodes in proportion to their weight, so the counts show the effective share of keys of every host.
+// When replica points of different hosts collide, the host with the lower address owns the point, as in ringpop.
+// Drained hosts don't own any virtual nodes.
+func (r *ring) LoadDistribution() map[string]int {
+	r.members.RLock()
+	defer r.members.RUnlock()
+
+	load := make(map[string]int, len(r.members.keys))
+	for addr := range r.members.keys {
+		load[addr] = 0
+	}
+	for _, addr := range ownedPoints(r.members.keys).owners {
+		load[addr]++
+	}
+	return load
+}
+
+// lookupResolvedAddress returns the member listening on port whose host name resolves to one of ips.
+// Member host names are resolved once per ring version, members which failed to resolve are retried on the next call.
+func (r *ring) lookupResolvedAddress(ips []net.IP, port uint16, resolver AddressResolver) (HostInfo, bool) {
+	members := r.Members()
+
+	r.memberIPs.Lock()
+	defer r.memberIPs.Unlock()
+	if version := r.Version(); r.memberIPs.ips == nil || r.memberIPs.version != version {
+		r.memberIPs.version = version
+		r.memberIPs.ips = make(map[string][]net.IP, len(members))
+	}
+	for _, member := range members {
+		if !member.hasPort(port) {
+			continue
+		}
+		own, ok := r.memberIPs.ips[member.GetAddress()]
+		if !ok {
+			ctx, cancel := context.WithTimeout(context.Background(), defaultLookupTimeout)
+			resolved, err := lookupHost(ctx, resolver, member.host)
+			cancel()
+			if err != nil {
+				continue
+			}
+			own = resolved
+			r.memberIPs.ips[member.GetAddress()] = own
+		}
+		for _, ip := range ips {
+			for _, o := range own {
+				if ip.Equal(o) {
+					return member, true
+				}
+			}
+		}
+	}
+	return HostInfo{}, false
+}
+
 func (r *ring) Members() []HostInfo {
+	r.members.RLock()
+	defer r.members.RUnlock()
 	servers := r.ring().Servers()
 
 	var hosts = make([]HostInfo, 0, len(servers))
-	r.members.RLock()
-	defer r.members.RUnlock()
 	for _, s := range servers {
 		host, ok := r.members.keys[s]
 		if !ok {
@@ -220,16 +697,109 @@ func (r *ring) Members() []HostInfo {
 	return hosts
 }
 
+// hasMember returns true if a member with the address is in the ring
+func (r *ring) hasMember(addr string) bool {
+	r.members.RLock()
+	defer r.members.RUnlock()
+	_, ok := r.members.keys[addr]
+	return ok
+}
+
+// Peers returns members other than self, self is given the ring port overrides so that it matches its own member
+func (r *ring) Peers(self HostInfo) []HostInfo {
+	if len(r.ports) > 0 {
+		self = self.WithPorts(r.ports)
+	}
+	var peers []HostInfo
+	for _, host := range r.Members() {
+		if !host.Equals(self) {
+			peers = append(peers, host)
+		}
+	}
+	return peers
+}
+
+// Leader returns the member with the smallest hash key, ties are broken by address.
+// Drained members are skipped. It only depends on membership, so every host with the same view of the ring
+// elects the same leader, and a new one as soon as the leader leaves the ring.
+func (r *ring) Leader() (HostInfo, error) {
+	members := r.Members()
+	if len(members) == 0 {
+		return HostInfo{}, ErrNoMembers
+	}
+	var leader HostInfo
+	found := false
+	for _, host := range members {
+		if host.IsDrained() {
+			continue
+		}
+		if !found || host.HashKey() < leader.HashKey() ||
+			(host.HashKey() == leader.HashKey() && host.GetAddress() < leader.GetAddress()) {
+			leader = host
+			found = true
+		}
+	}
+	if !found {
+		return HostInfo{}, ErrInsufficientHosts
+	}
+	return leader, nil
+}
+
+// MembersWithLabel returns members of the ring which have the label set to value
+func (r *ring) MembersWithLabel(key, value string) []HostInfo {
+	var hosts []HostInfo
+	for _, host := range r.Members() {
+		if v, ok := host.Label(key); ok && v == value {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
+
 func (r *ring) refresh() error {
-	if r.members.refreshed.After(time.Now().Add(-minRefreshInternal)) {
+	// read under the lock, forced refreshes of Refresh write it concurrently to the refresh worker
+	r.members.RLock()
+	refreshed := r.members.refreshed
+	r.members.RUnlock()
+	if refreshed.After(time.Now().Add(-minRefreshInternal)) {
 		// refreshed too frequently
 		return nil
 	}
+	return r.refreshMembers()
+}
+
+// forceRefresh reloads members bypassing the refresh rate limit and logs the change of member count
+func (r *ring) forceRefresh() error {
+	before := r.MemberCount()
+	if err := r.refreshMembers(); err != nil {
+		return err
+	}
+	after := r.MemberCount()
+	r.logger.Info("forced ring refresh",
+		tag.Service(r.service),
+		tag.Number(int64(before)),
+		tag.NextNumber(int64(after)),
+		tag.Counter(after-before),
+	)
+	return nil
+}
+
+func (r *ring) refreshMembers() error {
+	r.refreshing.Lock()
+	defer r.refreshing.Unlock()
 
 	members, err := r.peerProvider.GetMembers(r.service)
 	if err != nil {
 		return fmt.Errorf("getting members from peer provider: %w", err)
 	}
+	if len(r.ports) > 0 {
+		overridden := make([]HostInfo, 0, len(members))
+		for _, member := range members {
+			overridden = append(overridden, member.WithPorts(r.ports))
+		}
+		members = overridden
+	}
+	members = r.withServiceDrains(members)
 
 	r.members.Lock()
 	defer r.members.Unlock()
@@ -238,18 +808,150 @@ func (r *ring) refresh() error {
 		return nil
 	}
 
-	ring := emptyHashring()
-	for _, member := range members {
-		ring.AddMembers(member)
+	ring := NewHashRing(members, r.replicas, r.ringOptions...)
+	drained := 0
+	for addr, member := range newMembersMap {
+		member.ringPoints = ring.memberPoints(member)
+		newMembersMap[addr] = member
+		if member.IsDrained() {
+			drained++
+		}
+	}
+	event := r.changedEvent(members, newMembersMap)
+	moved := movedKeySpace(r.members.keys, newMembersMap)
+	atomic.StoreInt64(&r.lastRebalanceMoved, int64(math.Round(moved*basisPoints)))
+	r.scope.UpdateGauge(metrics.HashringRebalanceMovedRatio, moved)
+	conflicts := findIdentityConflicts(r.service, newMembersMap)
+	for _, conflict := range conflicts {
+		r.logger.Warn("ring members advertise the same identity, only one of them owns keys",
+			tag.Value(conflict.Identity), tag.Addresses(conflict.Addresses))
 	}
+	r.scope.UpdateGauge(metrics.HashringIdentityConflicts, float64(len(conflicts)))
+	r.scope.UpdateGauge(metrics.HashringMemberCount, float64(ring.ServerCount()))
 	r.members.keys = newMembersMap
+	r.members.drained = drained
+	r.members.conflicts = conflicts
+	r.members.portRings = r.protocolRings(ring, members)
+	initial := r.members.refreshed.IsZero()
 	r.members.refreshed = time.Now()
-	r.value.Store(ring)
+	// disconnected before the ring is stored, so that no lookup on the new ring finds them connected;
+	// updated members may listen on other ports now, they are connected again on their next lookup
+	r.connections.disconnect(r.service, event.HostsRemoved)
+	r.connections.disconnect(r.service, event.HostsUpdated)
+	r.storeRing(ring)
 	r.logger.Info("refreshed ring members", tag.Value(members))
 
+	r.changes.record(r.service, event)
+	r.self.invalidateIfChanged(event)
+	if !initial {
+		// members of the first load are already serving, only hosts joining later need warming up
+		r.warmupHosts(event.HostsAdded)
+	}
+	r.notifySubscribers(event)
+	r.signalShardWatchers()
+	r.signalKeyWatchers()
 	return nil
 }
 
+// withServiceDrains returns members with LabelDrained set on the ones draining from this ring only
+func (r *ring) withServiceDrains(members []HostInfo) []HostInfo {
+	label := DrainedLabel(Service(r.service))
+	var res []HostInfo
+	for i, member := range members {
+		if value, _ := member.Label(label); value != "true" || member.IsDrained() {
+			continue
+		}
+		if res == nil {
+			res = append(make([]HostInfo, 0, len(members)), members...)
+		}
+		res[i] = member.WithLabel(LabelDrained, "true")
+	}
+	if res == nil {
+		return members
+	}
+	return res
+}
+
+// protocolRings returns a ring for each of protocolPorts made of members which advertise the port.
+// When all members do, the ring of all members is shared, so keys have the same owners in both.
+func (r *ring) protocolRings(all *HashRing, members []HostInfo) map[string]*HashRing {
+	rings := make(map[string]*HashRing, len(protocolPorts))
+	for _, port := range protocolPorts {
+		var advertising []HostInfo
+		for _, member := range members {
+			if _, ok := member.GetPort(port); ok {
+				advertising = append(advertising, member)
+			}
+		}
+		if len(advertising) == len(members) {
+			rings[port] = all
+		} else {
+			rings[port] = NewHashRing(advertising, r.replicas, r.ringOptions...)
+		}
+	}
+	return rings
+}
+
+// IdentityConflicts returns groups of members which advertise the same identity
+func (r *ring) IdentityConflicts() []IdentityConflict {
+	r.members.RLock()
+	defer r.members.RUnlock()
+	return append([]IdentityConflict{}, r.members.conflicts...)
+}
+
+// findIdentityConflicts groups members by identity and returns identities advertised by more than one address.
+// Such members get the same ring points, so all keys go to the lowest address and the others stay idle.
+func findIdentityConflicts(service string, members map[string]HostInfo) []IdentityConflict {
+	byIdentity := make(map[string][]string)
+	for addr, member := range members {
+		if member.HasIdentity() {
+			byIdentity[member.Identity()] = append(byIdentity[member.Identity()], addr)
+		}
+	}
+	var conflicts []IdentityConflict
+	for identity, addrs := range byIdentity {
+		if len(addrs) > 1 {
+			sort.Strings(addrs)
+			conflicts = append(conflicts, IdentityConflict{Service: service, Identity: identity, Addresses: addrs})
+		}
+	}
+	sort.Slice(conflicts, func(i, j int) bool { return conflicts[i].Identity < conflicts[j].Identity })
+	return conflicts
+}
+
+// changedEvent describes the difference between current members and newMembers.
+// This function isn't thread-safe, only call it when members are locked.
+func (r *ring) changedEvent(members []HostInfo, newMembers map[string]HostInfo) *ChangedEvent {
+	event := &ChangedEvent{}
+	for _, m := range members {
+		member := newMembers[m.GetAddress()]
+		old, ok := r.members.keys[m.GetAddress()]
+		switch {
+		case !ok:
+			event.HostsAdded = append(event.HostsAdded, member)
+		case memberChanged(old, member):
+			event.HostsUpdated = append(event.HostsUpdated, member)
+		}
+	}
+	for addr, member := range r.members.keys {
+		if _, ok := newMembers[addr]; !ok {
+			event.HostsRemoved = append(event.HostsRemoved, member)
+		}
+	}
+	return event
+}
+
+func (r *ring) notifySubscribers(event *ChangedEvent) {
+	r.subscribers.RLock()
+	defer r.subscribers.RUnlock()
+
+	for name, sub := range r.subscribers.keys {
+		if !sub.push(event) {
+			r.logger.Warn("Subscriber is too slow, dropped its oldest notification", tag.Subscriber(name))
+		}
+	}
+}
+
 func (r *ring) refreshRingWorker() {
 	defer r.shutdownWG.Done()
 
@@ -260,6 +962,9 @@ func (r *ring) refreshRingWorker() {
 		case <-r.shutdownCh:
 			return
 		case <-r.refreshChan: // local signal or signal from provider
+			if !r.debounceRefresh() {
+				return
+			}
 			if err := r.refresh(); err != nil {
 				r.logger.Error("refreshing ring", tag.Error(err))
 			}
@@ -271,8 +976,149 @@ func (r *ring) refreshRingWorker() {
 	}
 }
 
-func (r *ring) ring() *hashring.HashRing {
-	return r.value.Load().(*hashring.HashRing)
+// ringPoints returns hashring positions of the member, following ringpop replica point placement.
+// The member gets replicaPoints positions per unit of weight, so it owns a share of the key space proportional
+// to its weight. Positions of a weight 1 member are the ones ringpop would use.
+func ringPoints(member HostInfo, replicaPoints int, hash func([]byte) uint32) []uint32 {
+	points := make([]uint32, replicaPoints*member.GetWeight())
+	identity := member.HashKey()
+	for i := range points {
+		var replica string
+		if identity == member.GetAddress() {
+			replica = fmt.Sprintf("%s%v", identity, i)
+		} else {
+			replica = fmt.Sprintf("%s#%v", identity, i)
+		}
+		points[i] = hash([]byte(replica))
+	}
+	return points
+}
+
+// debounceRefresh waits for refresh signals to settle, so that membership churn results in a single refresh.
+// It returns false if the ring is shutting down.
+func (r *ring) debounceRefresh() bool {
+	timer := time.NewTimer(refreshDebounce)
+	defer timer.Stop()
+	for {
+		select {
+		case <-r.shutdownCh:
+			return false
+		case <-r.refreshChan:
+		case <-timer.C:
+			return true
+		}
+	}
+}
+
+// movedKeySpace returns the fraction of the hash space which is owned by a different member after the change.
+// Drained members don't own keys, so they are skipped on both sides.
+func movedKeySpace(before, after map[string]HostInfo) float64 {
+	beforePoints, afterPoints := ownedPoints(before), ownedPoints(after)
+	bounds := make([]uint32, 0, len(beforePoints.hashes)+len(afterPoints.hashes))
+	bounds = append(bounds, beforePoints.hashes...)
+	bounds = append(bounds, afterPoints.hashes...)
+	sort.Slice(bounds, func(i, j int) bool { return bounds[i] < bounds[j] })
+	bounds = uniqueSorted(bounds)
+	if len(bounds) == 0 {
+		return 0
+	}
+
+	// every arc (prev, bound] between two neighbouring bounds has a single owner on both rings,
+	// the first arc wraps around the end of the hash space
+	var moved uint64
+	prev := bounds[len(bounds)-1]
+	for _, bound := range bounds {
+		if beforePoints.owner(bound) != afterPoints.owner(bound) {
+			if len(bounds) == 1 {
+				return 1
+			}
+			moved += uint64(bound - prev)
+		}
+		prev = bound
+	}
+	return float64(moved) / (1 << 32)
+}
+
+func uniqueSorted(values []uint32) []uint32 {
+	res := values[:0]
+	for _, v := range values {
+		if len(res) == 0 || v != res[len(res)-1] {
+			res = append(res, v)
+		}
+	}
+	return res
+}
+
+// ringOwners is a sorted list of hashring points with their owner addresses
+type ringOwners struct {
+	hashes []uint32
+	owners map[uint32]string
+}
+
+func ownedPoints(members map[string]HostInfo) ringOwners {
+	res := ringOwners{owners: make(map[uint32]string, len(members)*defaultReplicaPoints)}
+	for addr, member := range members {
+		if member.IsDrained() {
+			continue
+		}
+		for _, point := range member.ringPoints {
+			if owner, ok := res.owners[point]; !ok || addr < owner {
+				res.owners[point] = addr
+			}
+		}
+	}
+	res.hashes = make([]uint32, 0, len(res.owners))
+	for point := range res.owners {
+		res.hashes = append(res.hashes, point)
+	}
+	sort.Slice(res.hashes, func(i, j int) bool { return res.hashes[i] < res.hashes[j] })
+	return res
+}
+
+// owner returns the address of the member owning the hash, the same way ringpop resolves keys
+func (o ringOwners) owner(hash uint32) string {
+	if len(o.hashes) == 0 {
+		return ""
+	}
+	i := sort.Search(len(o.hashes), func(i int) bool { return o.hashes[i] >= hash })
+	if i == len(o.hashes) {
+		i = 0
+	}
+	return o.owners[o.hashes[i]]
+}
+
+func (r *ring) ring() *HashRing {
+	return r.value.Load().(*HashRing)
+}
+
+// storeRing replaces the ring snapshot, stamping it with the next version.
+// Cached lookups are dropped along with the ring they were computed on.
+func (r *ring) storeRing(ring *HashRing) {
+	ring.version = r.ring().version + 1
+	r.value.Store(ring)
+	r.cache.reset()
+}
+
+// memberChanged tells if a member re-advertised itself under the same address with a different identity,
+// port map, weight, labels or status, so that it is reported in HostsUpdated.
+// Labels include the drained state and the zone, both of which change the owners of keys.
+func memberChanged(old, member HostInfo) bool {
+	return !old.Equals(member) ||
+		old.GetWeight() != member.GetWeight() ||
+		old.GetStatus() != member.GetStatus() ||
+		!labelsEqual(old.labels, member.labels)
+}
+
+func labelsEqual(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if other, ok := b[k]; !ok || other != v {
+			return false
+		}
+	}
+	return true
 }
 
 func (r *ring) compareMembers(members []HostInfo) (map[string]HostInfo, bool) {
@@ -280,7 +1126,7 @@ func (r *ring) compareMembers(members []HostInfo) (map[string]HostInfo, bool) {
 	newMembersMap := make(map[string]HostInfo, len(members))
 	for _, member := range members {
 		newMembersMap[member.GetAddress()] = member
-		if _, ok := r.members.keys[member.GetAddress()]; !ok {
+		if old, ok := r.members.keys[member.GetAddress()]; !ok || memberChanged(old, member) {
 			changed = true
 		}
 	}
@@ -24,16 +24,23 @@ package membership
 
 import (
 	"errors"
+	"fmt"
+	"math"
 	"math/rand"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/dgryski/go-farm"
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+	"github.com/uber/ringpop-go/hashring"
 
 	"github.com/uber/cadence/common"
 	"github.com/uber/cadence/common/log"
+	"github.com/uber/cadence/common/metrics"
 )
 
 var letters = []rune("abcdefghijklmnopqrstuvwxyz")
@@ -88,6 +95,14 @@ func Test_ring_compareMembers(t *testing.T) {
 		{curr: []HostInfo{NewHostInfo("a"), NewHostInfo("b"), NewHostInfo("c")}, new: []HostInfo{NewHostInfo("b"), NewHostInfo("a")}, hasDiff: true},
 		// ring becomes empty
 		{curr: []HostInfo{NewHostInfo("a"), NewHostInfo("b"), NewHostInfo("c")}, new: []HostInfo{}, hasDiff: true},
+		// member is drained
+		{curr: []HostInfo{NewHostInfo("a")}, new: []HostInfo{NewHostInfo("a").WithLabel(LabelDrained, "true")}, hasDiff: true},
+		// member changed its weight
+		{curr: []HostInfo{NewHostInfo("a")}, new: []HostInfo{NewDetailedHostInfo("a", "", nil, WithWeight(2))}, hasDiff: true},
+		// member moved to another zone
+		{curr: []HostInfo{NewHostInfo("a").WithLabel(LabelZone, "z1")}, new: []HostInfo{NewHostInfo("a").WithLabel(LabelZone, "z2")}, hasDiff: true},
+		// member became suspect
+		{curr: []HostInfo{NewHostInfo("a").WithStatus(StatusAlive)}, new: []HostInfo{NewHostInfo("a").WithStatus(StatusSuspect)}, hasDiff: true},
 	}
 
 	for _, tt := range tests {
@@ -96,6 +111,520 @@ func Test_ring_compareMembers(t *testing.T) {
 
 }
 
+func TestRingIsPlacedByHashKey(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	pp := NewMockPeerProvider(ctrl)
+
+	members := []HostInfo{
+		NewDetailedHostInfo("127.0.0.1:7933", "host-a", nil),
+		NewDetailedHostInfo("127.0.0.2:7933", "host-b", nil),
+		NewDetailedHostInfo("127.0.0.3:7933", "host-c", nil),
+	}
+	pp.EXPECT().GetMembers("test-service").Return(members, nil).Times(1)
+	hr := newHashring("test-service", pp, metrics.NewNoopMetricsClient(), log.NewNoop())
+	assert.NoError(t, hr.refresh())
+
+	// only hash keys matter for placement, so a ring of other addresses with the same hash keys
+	// must place keys the same way
+	owners := make(map[string]string)
+	var renamed []HostInfo
+	for i, m := range members {
+		addr := fmt.Sprintf("10.0.0.%d:1", i)
+		owners[addr] = m.GetAddress()
+		renamed = append(renamed, NewDetailedHostInfo(addr, m.HashKey(), nil))
+	}
+	expected := NewHashRing(renamed, defaultReplicaPoints)
+	for i := 0; i < 100; i++ {
+		key := randSeq(10)
+		host, err := hr.Lookup(key)
+		assert.NoError(t, err)
+		addr, ok := expected.Lookup(key)
+		assert.True(t, ok)
+		assert.Equal(t, owners[addr], host.GetAddress())
+	}
+}
+
+func TestNewHashRingReplicaPointsSmoothDistribution(t *testing.T) {
+	members := []HostInfo{
+		NewHostInfo("127.0.0.1:7933"),
+		NewHostInfo("127.0.0.2:7933"),
+		NewHostInfo("127.0.0.3:7933"),
+	}
+	const keys = 30000
+
+	// relative standard deviation of the number of keys owned by each member
+	deviation := func(replicaPoints int) float64 {
+		ring := NewHashRing(members, replicaPoints)
+		counts := make(map[string]int, len(members))
+		for i := 0; i < keys; i++ {
+			addr, ok := ring.Lookup(fmt.Sprintf("key-%d", i))
+			require.True(t, ok)
+			counts[addr]++
+		}
+		mean := float64(keys) / float64(len(members))
+		var variance float64
+		for _, m := range members {
+			diff := float64(counts[m.GetAddress()]) - mean
+			variance += diff * diff / float64(len(members))
+		}
+		return math.Sqrt(variance) / mean
+	}
+
+	few, standard, many := deviation(10), deviation(defaultReplicaPoints), deviation(1000)
+	t.Logf("relative deviation with 10: %.3f, 100: %.3f, 1000: %.3f replica points", few, standard, many)
+	assert.Less(t, many, standard)
+	assert.Less(t, standard, few)
+	assert.Less(t, many, 0.05)
+}
+
+func TestHashRingLookupIsDeterministic(t *testing.T) {
+	members := randomHostInfo(10)
+	// members with colliding replica points are ordered by address
+	members = append(members, NewDetailedHostInfo("127.0.0.2:7933", "twin", nil), NewDetailedHostInfo("127.0.0.1:7933", "twin", nil))
+
+	expected := NewHashRing(members, defaultReplicaPoints)
+	for attempt := 0; attempt < 20; attempt++ {
+		shuffled := append([]HostInfo{}, members...)
+		rand.Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+		ring := NewHashRing(shuffled, defaultReplicaPoints)
+		for i := 0; i < 200; i++ {
+			key := fmt.Sprintf("key-%d", i)
+			owner, ok := ring.Lookup(key)
+			assert.True(t, ok)
+			expectedOwner, _ := expected.Lookup(key)
+			assert.Equal(t, expectedOwner, owner, "lookup of %q differs between identical member sets", key)
+			assert.Equal(t, expected.LookupN(key, 3), ring.LookupN(key, 3))
+		}
+	}
+
+	// twins share all replica points, so the lower address owns all of them
+	for i := 0; i < 2000; i++ {
+		owner, _ := expected.Lookup(fmt.Sprintf("key-%d", i))
+		assert.NotEqual(t, "127.0.0.2:7933", owner)
+	}
+}
+
+func TestHashRingMatchesRingpopPlacement(t *testing.T) {
+	members := randomHostInfo(10)
+	members = append(members, NewDetailedHostInfo("127.0.0.1:7933", "host-a", nil), NewHostInfo("127.0.0.2:7933"))
+
+	ring := NewHashRing(members, defaultReplicaPoints)
+	ringpopRing := hashring.New(farm.Fingerprint32, defaultReplicaPoints)
+	for _, m := range members {
+		ringpopRing.AddMembers(m)
+	}
+	assert.Equal(t, ringpopRing.ServerCount(), ring.ServerCount())
+	assert.ElementsMatch(t, ringpopRing.Servers(), ring.Servers())
+	for i := 0; i < 1000; i++ {
+		key := randSeq(10)
+		assert.Equal(t, ringpopRing.LookupN(key, 3), ring.LookupN(key, 3))
+	}
+	assert.Len(t, ring.LookupN("key", 100), ring.ServerCount())
+	assert.Empty(t, NewHashRing(nil, defaultReplicaPoints).LookupN("key", 1))
+}
+
+func TestHashRingWithHashFunc(t *testing.T) {
+	// positions of replica points and keys are given by the table, unknown inputs go to position 0
+	positions := map[string]uint64{
+		"host-a#0": 100, "host-a#1": 300,
+		"host-b#0": 200, "host-b#1": 1<<32 + 400, // only the lower 32 bits are used
+		"key-150": 150, "key-250": 250, "key-350": 350, "key-450": 450,
+	}
+	hash := func(b []byte) uint64 { return positions[string(b)] }
+	members := []HostInfo{
+		NewDetailedHostInfo("127.0.0.2:7933", "host-b", nil),
+		NewDetailedHostInfo("127.0.0.1:7933", "host-a", nil),
+	}
+	ring := NewHashRing(members, 2, WithHashFunc(hash))
+
+	for key, owners := range map[string][]string{
+		"key-150":   {"127.0.0.2:7933", "127.0.0.1:7933"},
+		"key-250":   {"127.0.0.1:7933", "127.0.0.2:7933"},
+		"key-350":   {"127.0.0.2:7933", "127.0.0.1:7933"},
+		"key-450":   {"127.0.0.1:7933", "127.0.0.2:7933"}, // wraps around to the first point
+		"key-other": {"127.0.0.1:7933", "127.0.0.2:7933"},
+	} {
+		assert.Equal(t, owners, ring.LookupN(key, 2), key)
+	}
+
+	ctrl := gomock.NewController(t)
+	pp := NewMockPeerProvider(ctrl)
+	pp.EXPECT().GetMembers("test-worker").Return(members, nil).Times(1)
+	a := NewMultiringResolver(testServices, pp, metrics.NewNoopMetricsClient(), log.NewNoop(),
+		WithReplicaPoints(map[Service]int{"test-worker": 2}),
+		WithHashRingOptions(WithHashFunc(hash)),
+	)
+	hr, err := a.getRing("test-worker")
+	require.NoError(t, err)
+	require.NoError(t, hr.refresh())
+	owner, err := a.Lookup("test-worker", "key-250")
+	require.NoError(t, err)
+	assert.Equal(t, "127.0.0.1:7933", owner.GetAddress())
+	for _, host := range hr.Members() {
+		assert.Equal(t, ringPoints(host, 2, ring.hash), host.GetRingPoints(), "ring points use the hash function")
+	}
+}
+
+func TestLookupReturnsRingPoints(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	pp := NewMockPeerProvider(ctrl)
+
+	members := []HostInfo{
+		NewDetailedHostInfo("127.0.0.1:7933", "host-a", nil),
+		NewHostInfo("127.0.0.2:7933"),
+	}
+	pp.EXPECT().GetMembers("test-service").Return(members, nil).Times(1)
+	hr := newHashring("test-service", pp, metrics.NewNoopMetricsClient(), log.NewNoop())
+	assert.NoError(t, hr.refresh())
+
+	assert.Equal(t, farm.Fingerprint32([]byte("host-a#0")), ringPoints(members[0], defaultReplicaPoints, farm.Fingerprint32)[0])
+	assert.Equal(t, farm.Fingerprint32([]byte("127.0.0.2:79330")), ringPoints(members[1], defaultReplicaPoints, farm.Fingerprint32)[0])
+
+	for _, host := range hr.Members() {
+		assert.Len(t, host.GetRingPoints(), defaultReplicaPoints)
+		assert.Equal(t, ringPoints(host, defaultReplicaPoints, farm.Fingerprint32), host.GetRingPoints())
+		assert.Nil(t, members[0].GetRingPoints(), "provider members are not modified")
+	}
+
+	host, err := hr.Lookup("key")
+	assert.NoError(t, err)
+	assert.NotEmpty(t, host.GetRingPoints())
+	assert.True(t, host.Equals(members[0]) || host.Equals(members[1]), "ring points are ignored by Equals")
+}
+
+func TestRefreshNotifiesSubscribers(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	pp := NewMockPeerProvider(ctrl)
+
+	a := NewDetailedHostInfo("127.0.0.1:7933", "a", nil)
+	b := NewDetailedHostInfo("127.0.0.2:7933", "b", nil)
+	c := NewDetailedHostInfo("127.0.0.3:7933", "c", nil)
+	renamedB := NewDetailedHostInfo("127.0.0.2:7933", "b2", nil)
+	gomock.InOrder(
+		pp.EXPECT().GetMembers("test-service").Return([]HostInfo{a, b}, nil),
+		pp.EXPECT().GetMembers("test-service").Return([]HostInfo{renamedB, c}, nil),
+	)
+
+	changeCh := make(chan *ChangedEvent, 2)
+	hr := newHashring("test-service", pp, metrics.NewNoopMetricsClient(), log.NewNoop())
+	assert.NoError(t, hr.Subscribe("subscriber", changeCh))
+
+	assert.NoError(t, hr.refresh())
+	event := <-changeCh
+	assert.Equal(t, []string{"a", "b"}, identities(event.HostsAdded))
+	assert.Empty(t, event.HostsUpdated)
+	assert.Empty(t, event.HostsRemoved)
+
+	hr.members.refreshed = time.Time{}
+	assert.NoError(t, hr.refresh())
+	event = <-changeCh
+	assert.Equal(t, []string{"c"}, identities(event.HostsAdded))
+	assert.Equal(t, []string{"b2"}, identities(event.HostsUpdated))
+	assert.Equal(t, []string{"a"}, identities(event.HostsRemoved))
+}
+
+func TestPortMapChangeIsReportedAsUpdate(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	pp := NewMockPeerProvider(ctrl)
+
+	before := NewDetailedHostInfo("127.0.0.1:7933", "a", PortMap{PortTchannel: 7933, PortGRPC: 7833})
+	after := NewDetailedHostInfo("127.0.0.1:7933", "a", PortMap{PortTchannel: 7933, PortGRPC: 7900})
+	gomock.InOrder(
+		pp.EXPECT().GetMembers("test-service").Return([]HostInfo{before}, nil),
+		pp.EXPECT().GetMembers("test-service").Return([]HostInfo{after}, nil),
+		pp.EXPECT().GetMembers("test-service").Return([]HostInfo{after}, nil),
+	)
+
+	changeCh := make(chan *ChangedEvent, 2)
+	hr := newHashring("test-service", pp, metrics.NewNoopMetricsClient(), log.NewNoop())
+	hr.cache = newLookupCache(10)
+	require.NoError(t, hr.Subscribe("subscriber", changeCh))
+
+	require.NoError(t, hr.refreshMembers())
+	<-changeCh
+	host, err := hr.Lookup("key")
+	require.NoError(t, err)
+	addr, err := host.GetNamedAddress(PortGRPC)
+	require.NoError(t, err)
+	assert.Equal(t, "127.0.0.1:7833", addr)
+
+	require.NoError(t, hr.refreshMembers())
+	event := <-changeCh
+	assert.Empty(t, event.HostsAdded)
+	assert.Empty(t, event.HostsRemoved)
+	require.Len(t, event.HostsUpdated, 1)
+	assert.True(t, event.HostsUpdated[0].Equals(after))
+
+	host, err = hr.Lookup("key")
+	require.NoError(t, err)
+	addr, err = host.GetNamedAddress(PortGRPC)
+	require.NoError(t, err)
+	assert.Equal(t, "127.0.0.1:7900", addr, "lookups return the new port map")
+
+	require.NoError(t, hr.refreshMembers())
+	select {
+	case event := <-changeCh:
+		t.Fatalf("unchanged members were notified: %+v", event)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestRefreshSignalsAreDebounced(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	pp := NewMockPeerProvider(ctrl)
+
+	pp.EXPECT().Subscribe(gomock.Any(), gomock.Any()).Times(1)
+	pp.EXPECT().GetMembers("test-service").Return([]HostInfo{NewHostInfo("127.0.0.1:7933")}, nil).Times(1)
+	pp.EXPECT().Stop().Times(1)
+	hr := newHashring("test-service", pp, metrics.NewNoopMetricsClient(), log.NewNoop())
+	hr.Start()
+	defer hr.Stop()
+
+	refreshed := make(chan struct{})
+	pp.EXPECT().GetMembers("test-service").DoAndReturn(func(service string) ([]HostInfo, error) {
+		close(refreshed)
+		return []HostInfo{NewHostInfo("127.0.0.2:7933")}, nil
+	}).Times(1)
+
+	hr.members.Lock()
+	hr.members.refreshed = time.Time{}
+	hr.members.Unlock()
+	for i := 0; i < 5; i++ {
+		hr.refreshChan <- &ChangedEvent{}
+	}
+	select {
+	case <-refreshed:
+	case <-time.After(time.Second):
+		t.Fatal("ring was not refreshed")
+	}
+}
+
+func TestSubscribeShardReportsMovedShards(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	pp := NewMockPeerProvider(ctrl)
+
+	a := NewHostInfo("127.0.0.1:7933")
+	b := NewHostInfo("127.0.0.2:7933")
+	pp.EXPECT().Subscribe(gomock.Any(), gomock.Any()).Times(1)
+	pp.EXPECT().GetMembers("test-service").Return([]HostInfo{a}, nil).Times(1)
+	pp.EXPECT().Stop().Times(1)
+	hr := newHashring("test-service", pp, metrics.NewNoopMetricsClient(), log.NewNoop())
+	hr.Start()
+	defer hr.Stop()
+
+	const numShards = 64
+	moved := make(chan int, numShards)
+	assert.Error(t, hr.SubscribeShard(0, func(int, HostInfo) {}))
+	assert.NoError(t, hr.SubscribeShard(numShards, func(shardID int, owner HostInfo) {
+		assert.Equal(t, b.GetAddress(), owner.GetAddress())
+		moved <- shardID
+	}))
+
+	pp.EXPECT().GetMembers("test-service").Return([]HostInfo{a, b}, nil).Times(1)
+	hr.members.Lock()
+	hr.members.refreshed = time.Time{}
+	hr.members.Unlock()
+	hr.refreshChan <- &ChangedEvent{}
+
+	assert.Eventually(t, func() bool { return len(hr.Members()) == 2 }, time.Second, 10*time.Millisecond)
+	var expected []int
+	for shardID := 0; shardID < numShards; shardID++ {
+		if owner, _ := hr.Lookup(shardKey(shardID)); owner.GetAddress() == b.GetAddress() {
+			expected = append(expected, shardID)
+		}
+	}
+	assert.NotEmpty(t, expected)
+
+	var reported []int
+	for range expected {
+		select {
+		case shardID := <-moved:
+			reported = append(reported, shardID)
+		case <-time.After(time.Second):
+			t.Fatal("moved shards were not reported")
+		}
+	}
+	assert.ElementsMatch(t, expected, reported)
+}
+
+func TestSubscribeShardHasNoLookupSideEffects(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	pp := NewMockPeerProvider(ctrl)
+	a, b := NewHostInfo("127.0.0.1:7933"), NewHostInfo("127.0.0.2:7933")
+	gomock.InOrder(
+		pp.EXPECT().GetMembers("test-worker").Return([]HostInfo{a}, nil),
+		pp.EXPECT().GetMembers("test-worker").Return([]HostInfo{a, b}, nil),
+	)
+	manager := &recordingConnectionManager{}
+	scope := tally.NewTestScope("test", nil)
+	r := NewMultiringResolver(testServices, pp, metrics.NewClient(scope, metrics.History), log.NewNoop(),
+		WithPeerConnectionManager(manager), WithLookupCache(16))
+	hr, err := r.getRing("test-worker")
+	require.NoError(t, err)
+	require.NoError(t, hr.refreshMembers())
+
+	const numShards = 64
+	hr.PinKey(shardKey(0), b)
+	var moved []int
+	require.NoError(t, hr.SubscribeShard(numShards, func(shardID int, owner HostInfo) {
+		assert.Equal(t, b.GetAddress(), owner.GetAddress())
+		moved = append(moved, shardID)
+	}))
+	w := hr.shardWatchers.list[0]
+	for shardID := range w.owners {
+		assert.Equal(t, a.GetAddress(), w.owners[shardID], "pins are not ownership")
+	}
+
+	require.NoError(t, hr.refreshMembers())
+	hr.reportShardOwners(w)
+	var expected []int
+	owners, err := hr.placedShardOwners(numShards)
+	require.NoError(t, err)
+	for shardID, owner := range owners {
+		if owner.GetAddress() == b.GetAddress() {
+			expected = append(expected, shardID)
+		}
+	}
+	assert.NotEmpty(t, expected)
+	assert.ElementsMatch(t, expected, moved)
+
+	assert.Empty(t, manager.drain(), "owners are not connected")
+	assert.Empty(t, hr.cache.entries, "owners are not cached")
+	assert.NotContains(t, scope.Snapshot().Histograms(),
+		"test.hashring_lookup_latency+hashring_service=test-worker,operation=Hashring", "owners are not lookups")
+}
+
+func TestWatchKeyReportsOwnerChangeOnce(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	pp := NewMockPeerProvider(ctrl)
+
+	a := NewHostInfo("127.0.0.1:7933")
+	b := NewHostInfo("127.0.0.2:7933")
+	gomock.InOrder(
+		pp.EXPECT().GetMembers("test-service").Return([]HostInfo{a}, nil),
+		pp.EXPECT().GetMembers("test-service").Return([]HostInfo{b}, nil),
+	)
+	hr := newHashring("test-service", pp, metrics.NewNoopMetricsClient(), log.NewNoop())
+	require.NoError(t, hr.refresh())
+
+	owners, cancel, err := hr.WatchKey("workflow-id")
+	require.NoError(t, err)
+	hr.signalKeyWatchers() // no change of owner, nothing is sent
+
+	hr.members.refreshed = time.Time{}
+	require.NoError(t, hr.refresh())
+	select {
+	case owner := <-owners:
+		assert.Equal(t, b.GetAddress(), owner.GetAddress())
+	case <-time.After(time.Second):
+		t.Fatal("owner change was not reported")
+	}
+	select {
+	case owner := <-owners:
+		t.Fatalf("unexpected owner change to %v", owner)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	cancel()
+	_, ok := <-owners
+	assert.False(t, ok, "channel is closed on cancel")
+	cancel()
+	hr.signalKeyWatchers()
+	assert.Empty(t, hr.keyWatchers.set)
+}
+
+func TestWatchKeyHasNoLookupSideEffects(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	pp := NewMockPeerProvider(ctrl)
+	a, b := NewHostInfo("127.0.0.1:7933"), NewHostInfo("127.0.0.2:7933")
+	gomock.InOrder(
+		pp.EXPECT().GetMembers("test-worker").Return([]HostInfo{a}, nil),
+		pp.EXPECT().GetMembers("test-worker").Return([]HostInfo{b}, nil),
+	)
+	manager := &recordingConnectionManager{}
+	scope := tally.NewTestScope("test", nil)
+	r := NewMultiringResolver(testServices, pp, metrics.NewClient(scope, metrics.History), log.NewNoop(),
+		WithPeerConnectionManager(manager), WithLookupCache(16))
+	hr, err := r.getRing("test-worker")
+	require.NoError(t, err)
+	require.NoError(t, hr.refreshMembers())
+
+	hr.PinKey("workflow-id", b)
+	owners, cancel, err := hr.WatchKey("workflow-id")
+	require.NoError(t, err)
+	defer cancel()
+
+	require.NoError(t, hr.refreshMembers())
+	select {
+	case owner := <-owners:
+		assert.Equal(t, b.GetAddress(), owner.GetAddress(), "the pinned host was not taken for the owner")
+	case <-time.After(time.Second):
+		t.Fatal("owner change was not reported")
+	}
+
+	assert.Empty(t, manager.drain(), "the owner is not connected")
+	assert.Empty(t, hr.cache.entries, "the owner is not cached")
+	assert.NotContains(t, scope.Snapshot().Histograms(),
+		"test.hashring_lookup_latency+hashring_service=test-worker,operation=Hashring", "the owner is not a lookup")
+}
+
+func TestWatchKeyIsClosedOnStop(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	pp := NewMockPeerProvider(ctrl)
+	pp.EXPECT().Subscribe(gomock.Any(), gomock.Any()).Times(1)
+	pp.EXPECT().GetMembers("test-service").Return([]HostInfo{NewHostInfo("127.0.0.1:7933")}, nil).Times(1)
+	pp.EXPECT().Stop().Times(1)
+	hr := newHashring("test-service", pp, metrics.NewNoopMetricsClient(), log.NewNoop())
+	hr.Start()
+
+	owners, cancel, err := hr.WatchKey("workflow-id")
+	require.NoError(t, err)
+	hr.Stop()
+	_, ok := <-owners
+	assert.False(t, ok)
+	cancel()
+
+	_, _, err = hr.WatchKey("workflow-id")
+	assert.Error(t, err)
+}
+
+func TestLookupsAreInstrumented(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	pp := NewMockPeerProvider(ctrl)
+	pp.EXPECT().GetMembers("test-service").Return([]HostInfo{NewHostInfo("127.0.0.1:7933"), NewHostInfo("127.0.0.2:7933")}, nil)
+	scope := tally.NewTestScope("test", nil)
+	hr := newHashring("test-service", pp, metrics.NewClient(scope, metrics.History), log.NewNoop())
+	require.NoError(t, hr.refresh())
+
+	for i := 0; i < 3; i++ {
+		_, err := hr.Lookup(fmt.Sprintf("key-%d", i))
+		require.NoError(t, err)
+	}
+	_, err := hr.LookupN("key", 2)
+	require.NoError(t, err)
+
+	snapshot := scope.Snapshot()
+	histogram, ok := snapshot.Histograms()["test.hashring_lookup_latency+hashring_service=test-service,operation=Hashring"]
+	require.True(t, ok)
+	var observed int64
+	for _, count := range histogram.Durations() {
+		observed += count
+	}
+	assert.Equal(t, int64(4), observed, "every lookup is observed")
+
+	gauge, ok := snapshot.Gauges()["test.hashring_member_count+hashring_service=test-service,operation=Hashring"]
+	require.True(t, ok)
+	assert.Equal(t, float64(2), gauge.Value())
+}
+
+func identities(hosts []HostInfo) []string {
+	res := make([]string, 0, len(hosts))
+	for _, h := range hosts {
+		res = append(res, h.Identity())
+	}
+	return res
+}
+
 func TestFailedLookupWillAskProvider(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	pp := NewMockPeerProvider(ctrl)
@@ -103,13 +632,339 @@ func TestFailedLookupWillAskProvider(t *testing.T) {
 	pp.EXPECT().Subscribe(gomock.Any(), gomock.Any()).Times(1)
 	pp.EXPECT().GetMembers("test-service").Times(1)
 
-	hr := newHashring("test-service", pp, log.NewNoop())
+	hr := newHashring("test-service", pp, metrics.NewNoopMetricsClient(), log.NewNoop())
 	hr.Start()
 	_, err := hr.Lookup("a")
 
 	assert.Error(t, err)
 }
 
+func TestLookupNReturnsDistinctOwnersInRingOrder(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	pp := NewMockPeerProvider(ctrl)
+
+	hosts := []HostInfo{
+		NewHostInfo("127.0.0.1:7933"),
+		NewHostInfo("127.0.0.2:7933"),
+		NewHostInfo("127.0.0.3:7933"),
+	}
+	pp.EXPECT().GetMembers("test-service").Return(hosts, nil).Times(1)
+	hr := newHashring("test-service", pp, metrics.NewNoopMetricsClient(), log.NewNoop())
+	assert.NoError(t, hr.refresh())
+
+	for i := 0; i < 50; i++ {
+		key := randSeq(10)
+		owner, err := hr.Lookup(key)
+		assert.NoError(t, err)
+
+		owners, err := hr.LookupN(key, 2)
+		assert.NoError(t, err)
+		assert.Len(t, owners, 2)
+		assert.Equal(t, owner, owners[0])
+		assert.NotEqual(t, owners[0].GetAddress(), owners[1].GetAddress())
+
+		all, err := hr.LookupN(key, 5)
+		assert.NoError(t, err)
+		assert.Len(t, all, len(hosts))
+		assert.ElementsMatch(t, hosts, stripRingPoints(all))
+		assert.Equal(t, owners, all[:2])
+	}
+
+	_, err := hr.LookupN("key", 0)
+	assert.Error(t, err)
+}
+
+func TestLookupsOnEmptyRingReturnNoMembers(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	pp := NewMockPeerProvider(ctrl)
+
+	hr := newHashring("test-service", pp, metrics.NewNoopMetricsClient(), log.NewNoop())
+	host, err := hr.Lookup("a")
+	assert.Equal(t, ErrNoMembers, err)
+	assert.Equal(t, HostInfo{}, host)
+	hosts, err := hr.LookupN("a", 2)
+	assert.Equal(t, ErrNoMembers, err)
+	assert.Nil(t, hosts)
+	host, err = hr.LookupExcluding("a", NewHostInfo("127.0.0.1:7933"))
+	assert.Equal(t, ErrNoMembers, err)
+	assert.Equal(t, HostInfo{}, host)
+	host, err = hr.LookupZoneAware("a", "zone")
+	assert.Equal(t, ErrNoMembers, err)
+	assert.Equal(t, HostInfo{}, host)
+	_, err = hr.Leader()
+	assert.Equal(t, ErrNoMembers, err)
+	assert.True(t, common.IsServiceTransientError(err), "callers retry until the ring is loaded")
+}
+
+func TestLookupBatchMatchesLookup(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	pp := NewMockPeerProvider(ctrl)
+	hr := newHashring("test-service", pp, metrics.NewNoopMetricsClient(), log.NewNoop())
+
+	hosts, err := hr.LookupBatch([]string{"key"})
+	assert.Equal(t, ErrNoMembers, err)
+	assert.Nil(t, hosts)
+
+	pp.EXPECT().GetMembers("test-service").Return(randomHostInfo(5), nil)
+	require.NoError(t, hr.refresh())
+
+	keys := make([]string, 100)
+	for i := range keys {
+		keys[i] = randSeq(10)
+	}
+	hosts, err = hr.LookupBatch(keys)
+	require.NoError(t, err)
+	require.Len(t, hosts, len(keys))
+	for i, key := range keys {
+		owner, err := hr.Lookup(key)
+		require.NoError(t, err)
+		assert.Equal(t, owner, hosts[i], key)
+	}
+
+	hosts, err = hr.LookupBatch(nil)
+	assert.NoError(t, err)
+	assert.Empty(t, hosts)
+}
+
+func TestLookupNDiverseSpreadsOwnersAcrossZones(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	pp := NewMockPeerProvider(ctrl)
+
+	var hosts []HostInfo
+	for i, zone := range []string{"zone-a", "zone-a", "zone-a", "zone-b", "zone-b", "zone-b", "zone-c", "zone-c", "zone-c"} {
+		hosts = append(hosts, NewHostInfo(fmt.Sprintf("127.0.0.%d:7933", i+1)).WithLabel(LabelZone, zone))
+	}
+	pp.EXPECT().GetMembers("test-service").Return(hosts, nil)
+	hr := newHashring("test-service", pp, metrics.NewNoopMetricsClient(), log.NewNoop())
+	require.NoError(t, hr.refresh())
+
+	var sameZoneInLookupN int
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		primary, err := hr.Lookup(key)
+		require.NoError(t, err)
+
+		owners, err := hr.LookupNDiverse(key, 3, LabelZone)
+		require.NoError(t, err)
+		require.Len(t, owners, 3)
+		assert.Equal(t, primary.GetAddress(), owners[0].GetAddress(), key)
+		zones := make(map[string]struct{})
+		for _, owner := range owners {
+			zone, _ := owner.Label(LabelZone)
+			zones[zone] = struct{}{}
+		}
+		assert.Len(t, zones, 3, "every owner of %v is in a distinct zone", key)
+
+		candidates, err := hr.LookupN(key, 3)
+		require.NoError(t, err)
+		zones = make(map[string]struct{})
+		for _, c := range candidates {
+			zone, _ := c.Label(LabelZone)
+			zones[zone] = struct{}{}
+		}
+		if len(zones) < 3 {
+			sameZoneInLookupN++
+		}
+
+		owners, err = hr.LookupNDiverse(key, 5, LabelZone)
+		require.NoError(t, err)
+		assert.Len(t, owners, 5, "zones are reused once all of them are taken")
+	}
+	assert.NotZero(t, sameZoneInLookupN, "plain LookupN puts some owners in the same zone")
+
+	_, err := hr.LookupNDiverse("key", 0, LabelZone)
+	assert.Error(t, err)
+}
+
+func TestLookupZoneAwarePrefersLocalReplica(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	pp := NewMockPeerProvider(ctrl)
+
+	var hosts []HostInfo
+	for i, zone := range []string{"zone-a", "zone-a", "zone-b", "zone-b", "zone-c"} {
+		hosts = append(hosts, NewHostInfo(fmt.Sprintf("127.0.0.%d:7933", i+1)).WithLabel(LabelZone, zone))
+	}
+	pp.EXPECT().GetMembers("test-service").Return(hosts, nil)
+	hr := newHashring("test-service", pp, metrics.NewNoopMetricsClient(), log.NewNoop())
+	require.NoError(t, hr.refresh())
+
+	var local, fallback int
+	for i := 0; i < 200; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		candidates, err := hr.LookupN(key, zoneAwareCandidates)
+		require.NoError(t, err)
+
+		expected := candidates[0]
+		for _, c := range candidates {
+			if zone, _ := c.Label(LabelZone); zone == "zone-c" {
+				expected = c
+				break
+			}
+		}
+		if zone, _ := expected.Label(LabelZone); zone == "zone-c" {
+			local++
+		} else {
+			fallback++
+		}
+
+		owner, err := hr.LookupZoneAware(key, "zone-c")
+		require.NoError(t, err)
+		assert.Equal(t, expected.GetAddress(), owner.GetAddress(), key)
+
+		owner, err = hr.LookupZoneAware(key, "")
+		require.NoError(t, err)
+		assert.Equal(t, candidates[0].GetAddress(), owner.GetAddress(), "no zone means the primary owner")
+		owner, err = hr.LookupZoneAware(key, "zone-unknown")
+		require.NoError(t, err)
+		assert.Equal(t, candidates[0].GetAddress(), owner.GetAddress(), "no local candidate means the primary owner")
+	}
+	assert.NotZero(t, local, "some keys have a same-zone replica")
+	assert.NotZero(t, fallback, "some keys have no same-zone replica")
+}
+
+func TestLookupExcludingSkipsExcludedHost(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	pp := NewMockPeerProvider(ctrl)
+
+	self := NewHostInfo("127.0.0.1:7933")
+	other := NewHostInfo("127.0.0.2:7933")
+	gomock.InOrder(
+		pp.EXPECT().GetMembers("test-service").Return([]HostInfo{self, other}, nil),
+		pp.EXPECT().GetMembers("test-service").Return([]HostInfo{self}, nil),
+	)
+	hr := newHashring("test-service", pp, metrics.NewNoopMetricsClient(), log.NewNoop())
+	assert.NoError(t, hr.refresh())
+
+	for i := 0; i < 50; i++ {
+		key := randSeq(10)
+		owner, err := hr.Lookup(key)
+		assert.NoError(t, err)
+
+		host, err := hr.LookupExcluding(key, self)
+		assert.NoError(t, err)
+		assert.Equal(t, other.GetAddress(), host.GetAddress())
+
+		host, err = hr.LookupExcluding(key, NewHostInfo("127.0.0.3:7933"))
+		assert.NoError(t, err)
+		assert.Equal(t, owner, host)
+	}
+
+	hr.members.refreshed = time.Time{}
+	assert.NoError(t, hr.refresh())
+	_, err := hr.LookupExcluding("key", self)
+	assert.Equal(t, ErrOnlyOwnerExcluded, err)
+}
+
+func TestLookupSkipsDrainedMembers(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	pp := NewMockPeerProvider(ctrl)
+
+	drained := NewHostInfo("127.0.0.1:7933").WithLabel(LabelDrained, "true")
+	active := NewHostInfo("127.0.0.2:7933")
+	gomock.InOrder(
+		pp.EXPECT().GetMembers("test-service").Return([]HostInfo{drained, active}, nil),
+		pp.EXPECT().GetMembers("test-service").Return([]HostInfo{drained, NewHostInfo("127.0.0.2:7933").WithLabel(LabelDrained, "true")}, nil),
+	)
+	hr := newHashring("test-service", pp, metrics.NewNoopMetricsClient(), log.NewNoop())
+	assert.NoError(t, hr.refresh())
+
+	assert.Len(t, hr.Members(), 2)
+	for i := 0; i < 50; i++ {
+		key := randSeq(10)
+		host, err := hr.Lookup(key)
+		assert.NoError(t, err)
+		assert.Equal(t, active.GetAddress(), host.GetAddress())
+
+		owners, err := hr.LookupN(key, 2)
+		assert.NoError(t, err)
+		assert.Len(t, owners, 1)
+	}
+
+	hr.members.refreshed = time.Time{}
+	assert.NoError(t, hr.refresh())
+	assert.Len(t, hr.Members(), 2)
+	_, err := hr.Lookup("key")
+	assert.Equal(t, ErrInsufficientHosts, err)
+}
+
+func stripRingPoints(hosts []HostInfo) []HostInfo {
+	res := make([]HostInfo, 0, len(hosts))
+	for _, h := range hosts {
+		h.ringPoints = nil
+		res = append(res, h)
+	}
+	return res
+}
+
+func TestMembersWithLabelFiltersByZone(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	pp := NewMockPeerProvider(ctrl)
+
+	members := []HostInfo{
+		NewHostInfo("10.0.0.1:7933").WithLabel(LabelZone, "zone-a"),
+		NewHostInfo("10.0.0.2:7933").WithLabel(LabelZone, "zone-b"),
+		NewHostInfo("10.0.0.3:7933").WithLabel(LabelZone, "zone-a"),
+		NewHostInfo("10.0.0.4:7933"),
+	}
+	pp.EXPECT().GetMembers("test-service").Return(members, nil).Times(1)
+	hr := newHashring("test-service", pp, metrics.NewNoopMetricsClient(), log.NewNoop())
+	assert.NoError(t, hr.refresh())
+
+	zoneA := hr.MembersWithLabel(LabelZone, "zone-a")
+	assert.ElementsMatch(t, []string{"10.0.0.1:7933", "10.0.0.3:7933"}, addresses(zoneA))
+	zoneB := hr.MembersWithLabel(LabelZone, "zone-b")
+	assert.Equal(t, []string{"10.0.0.2:7933"}, addresses(zoneB))
+	assert.Empty(t, hr.MembersWithLabel(LabelZone, "zone-c"))
+	assert.Empty(t, hr.MembersWithLabel("rack", "zone-a"))
+}
+
+func addresses(hosts []HostInfo) []string {
+	res := make([]string, 0, len(hosts))
+	for _, h := range hosts {
+		res = append(res, h.GetAddress())
+	}
+	return res
+}
+
+func TestRefreshDetectsIdentityConflicts(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	pp := NewMockPeerProvider(ctrl)
+
+	members := []HostInfo{
+		NewDetailedHostInfo("10.0.0.2:7933", "cadence-history-0", nil),
+		NewDetailedHostInfo("10.0.0.1:7933", "cadence-history-0", nil),
+		NewDetailedHostInfo("10.0.0.3:7933", "cadence-history-1", nil),
+		NewHostInfo("10.0.0.4:7933"),
+		NewHostInfo("10.0.0.5:7933"),
+	}
+	gomock.InOrder(
+		pp.EXPECT().GetMembers("test-service").Return(members, nil),
+		pp.EXPECT().GetMembers("test-service").Return(members[1:], nil),
+	)
+	scope := tally.NewTestScope("test", nil)
+	hr := newHashring("test-service", pp, metrics.NewClient(scope, metrics.History), log.NewNoop())
+	assert.Empty(t, hr.IdentityConflicts())
+
+	assert.NoError(t, hr.refresh())
+	assert.Equal(t, []IdentityConflict{{
+		Service:   "test-service",
+		Identity:  "cadence-history-0",
+		Addresses: []string{"10.0.0.1:7933", "10.0.0.2:7933"},
+	}}, hr.IdentityConflicts(), "members without identity don't conflict")
+	gauge := scope.Snapshot().Gauges()["test.hashring_identity_conflicts+hashring_service=test-service,operation=Hashring"]
+	assert.Equal(t, 1.0, gauge.Value())
+
+	for i := 0; i < 100; i++ {
+		owner, err := hr.Lookup(randSeq(10))
+		assert.NoError(t, err)
+		assert.NotEqual(t, "10.0.0.2:7933", owner.GetAddress(), "conflicting member with higher address owns nothing")
+	}
+
+	hr.members.refreshed = time.Time{}
+	assert.NoError(t, hr.refresh())
+	assert.Empty(t, hr.IdentityConflicts())
+}
+
 func TestRefreshUpdatesRingOnlyWhenRingHasChanged(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	pp := NewMockPeerProvider(ctrl)
@@ -117,7 +972,7 @@ func TestRefreshUpdatesRingOnlyWhenRingHasChanged(t *testing.T) {
 	pp.EXPECT().Subscribe(gomock.Any(), gomock.Any()).Times(1)
 	pp.EXPECT().GetMembers("test-service").Times(3)
 
-	hr := newHashring("test-service", pp, log.NewNoop())
+	hr := newHashring("test-service", pp, metrics.NewNoopMetricsClient(), log.NewNoop())
 	hr.Start()
 
 	hr.refresh()
@@ -132,18 +987,52 @@ func TestSubscribeIgnoresDuplicates(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	pp := NewMockPeerProvider(ctrl)
 
-	hr := newHashring("test-service", pp, log.NewNoop())
+	hr := newHashring("test-service", pp, metrics.NewNoopMetricsClient(), log.NewNoop())
 
 	assert.NoError(t, hr.Subscribe("test-service", changeCh))
 	assert.Error(t, hr.Subscribe("test-service", changeCh))
 	assert.Equal(t, 1, len(hr.subscribers.keys))
 }
 
+func TestSlowSubscriberIsFlaggedForResync(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	pp := NewMockPeerProvider(ctrl)
+	hr := newHashring("test-service", pp, metrics.NewNoopMetricsClient(), log.NewNoop())
+	changeCh := make(chan *ChangedEvent)
+	require.NoError(t, hr.Subscribe("slow", changeCh))
+	defer hr.Unsubscribe("slow")
+
+	// nobody reads yet, notifications must not block
+	total := subscriberBufferSize + 3
+	for i := 0; i < total; i++ {
+		hr.notifySubscribers(&ChangedEvent{HostsAdded: []HostInfo{NewDetailedHostInfo("127.0.0.1:7933", fmt.Sprint(i), nil)}})
+	}
+
+	var received []string
+	resyncAt := -1
+	for len(received) == 0 || received[len(received)-1] != fmt.Sprint(total-1) {
+		select {
+		case event := <-changeCh:
+			if event.ResyncRequired {
+				assert.Equal(t, -1, resyncAt, "resync is flagged once")
+				resyncAt = len(received)
+			}
+			received = append(received, identities(event.HostsAdded)...)
+		case <-time.After(time.Second):
+			require.FailNow(t, "buffered events were not delivered", "received %v", received)
+		}
+	}
+	// the first event may already be in flight when the buffer overflows, the rest are the newest events
+	assert.Contains(t, []int{subscriberBufferSize, subscriberBufferSize + 1}, len(received))
+	require.NotEqual(t, -1, resyncAt)
+	assert.Equal(t, len(received)-subscriberBufferSize, resyncAt, "the first event after the dropped ones is flagged")
+}
+
 func TestUnsubcribeIgnoresDeletionOnEmpty(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	pp := NewMockPeerProvider(ctrl)
 
-	hr := newHashring("test-service", pp, log.NewNoop())
+	hr := newHashring("test-service", pp, metrics.NewNoopMetricsClient(), log.NewNoop())
 	assert.Equal(t, 0, len(hr.subscribers.keys))
 	assert.NoError(t, hr.Unsubscribe("test-service"))
 	assert.NoError(t, hr.Unsubscribe("test-service"))
@@ -155,7 +1044,7 @@ func TestUnsubcribeDeletes(t *testing.T) {
 	pp := NewMockPeerProvider(ctrl)
 	var changeCh = make(chan *ChangedEvent)
 
-	hr := newHashring("test-service", pp, log.NewNoop())
+	hr := newHashring("test-service", pp, metrics.NewNoopMetricsClient(), log.NewNoop())
 
 	assert.Equal(t, 0, len(hr.subscribers.keys))
 	assert.NoError(t, hr.Subscribe("testservice1", changeCh))
@@ -167,28 +1056,330 @@ func TestUnsubcribeDeletes(t *testing.T) {
 
 }
 
+func TestAllHostsAgreeOnLeader(t *testing.T) {
+	members := []HostInfo{
+		NewDetailedHostInfo("127.0.0.1:7933", "c", nil),
+		NewDetailedHostInfo("127.0.0.2:7933", "a", nil),
+		NewDetailedHostInfo("127.0.0.3:7933", "b", nil),
+	}
+	leaders := func(members []HostInfo) []string {
+		var res []string
+		// every host has its own ring, built from members listed in a different order
+		for i := range members {
+			shuffled := append(append([]HostInfo(nil), members[i:]...), members[:i]...)
+			ctrl := gomock.NewController(t)
+			pp := NewMockPeerProvider(ctrl)
+			pp.EXPECT().GetMembers("test-service").Return(shuffled, nil)
+			hr := newHashring("test-service", pp, metrics.NewNoopMetricsClient(), log.NewNoop())
+			require.NoError(t, hr.refresh())
+			leader, err := hr.Leader()
+			require.NoError(t, err)
+			res = append(res, leader.Identity())
+		}
+		return res
+	}
+
+	assert.Equal(t, []string{"a", "a", "a"}, leaders(members))
+	assert.Equal(t, []string{"b", "b"}, leaders([]HostInfo{members[0], members[2]}), "a new leader is elected when the leader leaves")
+	assert.Equal(t, []string{"c", "c", "c"}, leaders([]HostInfo{members[0], members[1].WithLabel(LabelDrained, "true"), members[2].WithLabel(LabelDrained, "true")}))
+
+	hr := newHashring("test-service", NewMockPeerProvider(gomock.NewController(t)), metrics.NewNoopMetricsClient(), log.NewNoop())
+	_, err := hr.Leader()
+	assert.Equal(t, ErrNoMembers, err)
+}
+
+func TestRingVersionIsIncrementedOnMembershipChange(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	pp := NewMockPeerProvider(ctrl)
+	a, b := NewHostInfo("127.0.0.1:7933"), NewHostInfo("127.0.0.2:7933")
+	gomock.InOrder(
+		pp.EXPECT().GetMembers("test-service").Return([]HostInfo{a}, nil),
+		pp.EXPECT().GetMembers("test-service").Return([]HostInfo{a}, nil),
+		pp.EXPECT().GetMembers("test-service").Return([]HostInfo{b}, nil),
+	)
+	hr := newHashring("test-service", pp, metrics.NewNoopMetricsClient(), log.NewNoop())
+	assert.Zero(t, hr.Version())
+	_, _, err := hr.LookupWithVersion("key")
+	assert.Equal(t, ErrNoMembers, err)
+
+	require.NoError(t, hr.refreshMembers())
+	assert.Equal(t, uint64(1), hr.Version())
+	require.NoError(t, hr.refreshMembers())
+	assert.Equal(t, uint64(1), hr.Version(), "unchanged members keep the version")
+
+	require.NoError(t, hr.refreshMembers())
+	owner, version, err := hr.LookupWithVersion("key")
+	require.NoError(t, err)
+	assert.Equal(t, b.GetAddress(), owner.GetAddress())
+	assert.Equal(t, uint64(2), version)
+	assert.Equal(t, version, hr.Version())
+}
+
+func TestLookupForPortSkipsHostsWithoutThePort(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	pp := NewMockPeerProvider(ctrl)
+	migrated := NewDetailedHostInfo("127.0.0.1:7933", "migrated", PortMap{PortTchannel: 7933, PortGRPC: 7833})
+	legacy := NewDetailedHostInfo("127.0.0.2:7933", "legacy", PortMap{PortTchannel: 7933})
+	pp.EXPECT().GetMembers("test-service").Return([]HostInfo{migrated, legacy}, nil)
+	hr := newHashring("test-service", pp, metrics.NewNoopMetricsClient(), log.NewNoop())
+
+	_, err := hr.LookupForPort("key", PortGRPC)
+	assert.Equal(t, ErrNoMembers, err)
+	require.NoError(t, hr.refreshMembers())
+
+	owners := make(map[string]struct{})
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		host, err := hr.LookupForPort(key, PortGRPC)
+		require.NoError(t, err)
+		assert.Equal(t, "migrated", host.Identity(), "host without grpc port is not in the grpc view")
+
+		host, err = hr.LookupForPort(key, PortTchannel)
+		require.NoError(t, err)
+		expected, err := hr.Lookup(key)
+		require.NoError(t, err)
+		assert.Equal(t, expected.Identity(), host.Identity(), "all hosts advertise tchannel, so its view is the whole ring")
+		owners[host.Identity()] = struct{}{}
+	}
+	assert.Len(t, owners, 2)
+
+	_, err = hr.LookupForPort("key", "http")
+	assert.Error(t, err)
+}
+
 func TestMemberCountReturnsNumber(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	pp := NewMockPeerProvider(ctrl)
 
-	hr := newHashring("test-service", pp, log.NewNoop())
+	hr := newHashring("test-service", pp, metrics.NewNoopMetricsClient(), log.NewNoop())
 	assert.Equal(t, 0, hr.MemberCount())
 
-	ring := emptyHashring()
-	for _, addr := range []string{"127", "128"} {
-		host := NewHostInfo(addr)
-		ring.AddMembers(host)
-	}
-	hr.value.Store(ring)
+	hr.value.Store(NewHashRing([]HostInfo{NewHostInfo("127"), NewHostInfo("128")}, defaultReplicaPoints))
 	assert.Equal(t, 2, hr.MemberCount())
 }
 
+func TestLoadDistributionCountsOwnedVirtualNodes(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	pp := NewMockPeerProvider(ctrl)
+
+	hr := newHashring("test-service", pp, metrics.NewNoopMetricsClient(), log.NewNoop())
+	assert.Empty(t, hr.LoadDistribution())
+
+	hosts := []HostInfo{
+		NewHostInfo("127.0.0.1:7933"),
+		NewHostInfo("127.0.0.2:7933"),
+		NewHostInfo("127.0.0.3:7933"),
+	}
+	pp.EXPECT().GetMembers("test-service").Return(hosts, nil).Times(1)
+	assert.NoError(t, hr.refresh())
+
+	assert.Equal(t, map[string]int{
+		"127.0.0.1:7933": defaultReplicaPoints,
+		"127.0.0.2:7933": defaultReplicaPoints,
+		"127.0.0.3:7933": defaultReplicaPoints,
+	}, hr.LoadDistribution())
+
+	// simulate a collision of replica points between two hosts
+	hr.members.Lock()
+	second := hr.members.keys["127.0.0.2:7933"]
+	second.ringPoints = append([]uint32{hr.members.keys["127.0.0.1:7933"].ringPoints[0]}, second.ringPoints[1:]...)
+	hr.members.keys["127.0.0.2:7933"] = second
+	hr.members.Unlock()
+
+	assert.Equal(t, map[string]int{
+		"127.0.0.1:7933": defaultReplicaPoints,
+		"127.0.0.2:7933": defaultReplicaPoints - 1,
+		"127.0.0.3:7933": defaultReplicaPoints,
+	}, hr.LoadDistribution())
+}
+
+func TestKeysArePlacedProportionallyToWeight(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	pp := NewMockPeerProvider(ctrl)
+	hr := newHashring("test-service", pp, metrics.NewNoopMetricsClient(), log.NewNoop())
+
+	weights := map[string]int{"127.0.0.1:7933": 1, "127.0.0.2:7933": 2, "127.0.0.3:7933": 4}
+	var hosts []HostInfo
+	for addr, weight := range weights {
+		hosts = append(hosts, NewDetailedHostInfo(addr, addr, nil, WithWeight(weight)))
+	}
+	hosts = append(hosts, NewDetailedHostInfo("127.0.0.4:7933", "127.0.0.4:7933", nil, WithWeight(0)))
+	pp.EXPECT().GetMembers("test-service").Return(hosts, nil).Times(1)
+	require.NoError(t, hr.refresh())
+
+	load := hr.LoadDistribution()
+	assert.Equal(t, 0, load["127.0.0.4:7933"], "weight 0 hosts own nothing")
+	for addr, weight := range weights {
+		assert.Equal(t, weight*defaultReplicaPoints, load[addr])
+	}
+
+	const keys = 70000
+	owned := make(map[string]int)
+	for i := 0; i < keys; i++ {
+		host, err := hr.Lookup(fmt.Sprintf("key-%d", i))
+		require.NoError(t, err)
+		owned[host.GetAddress()]++
+	}
+	assert.Len(t, owned, len(weights))
+	for addr, weight := range weights {
+		expected := float64(keys) * float64(weight) / 7
+		assert.InDelta(t, expected, owned[addr], expected*0.2, "host %v with weight %v", addr, weight)
+	}
+}
+
+func TestWeightChangeIsReportedAsUpdate(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	pp := NewMockPeerProvider(ctrl)
+
+	a := NewHostInfo("127.0.0.1:7933")
+	b := NewHostInfo("127.0.0.2:7933")
+	heavierB := NewDetailedHostInfo("127.0.0.2:7933", "", nil, WithWeight(4))
+	gomock.InOrder(
+		pp.EXPECT().GetMembers("test-service").Return([]HostInfo{a, b}, nil),
+		pp.EXPECT().GetMembers("test-service").Return([]HostInfo{a, heavierB}, nil),
+	)
+
+	changeCh := make(chan *ChangedEvent, 2)
+	hr := newHashring("test-service", pp, metrics.NewNoopMetricsClient(), log.NewNoop())
+	hr.cache = newLookupCache(100)
+	require.NoError(t, hr.Subscribe("subscriber", changeCh))
+
+	const keys = 1000
+	ownedBy := func(addr string) int {
+		owned := 0
+		for i := 0; i < keys; i++ {
+			host, err := hr.Lookup(fmt.Sprintf("key-%d", i))
+			require.NoError(t, err)
+			if host.GetAddress() == addr {
+				owned++
+			}
+		}
+		return owned
+	}
+
+	require.NoError(t, hr.refreshMembers())
+	<-changeCh
+	before := ownedBy(b.GetAddress())
+
+	require.NoError(t, hr.refreshMembers())
+	event := <-changeCh
+	assert.Empty(t, event.HostsAdded)
+	assert.Empty(t, event.HostsRemoved)
+	require.Len(t, event.HostsUpdated, 1)
+	assert.Equal(t, 4, event.HostsUpdated[0].GetWeight())
+
+	assert.Equal(t, 4*defaultReplicaPoints, hr.LoadDistribution()[b.GetAddress()])
+	assert.Greater(t, ownedBy(b.GetAddress()), before, "keys move to the heavier member")
+	assert.Positive(t, hr.LastRebalanceMoved())
+}
+
+func TestLookupNSkipsHostsWithWeightZero(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	pp := NewMockPeerProvider(ctrl)
+	hr := newHashring("test-service", pp, metrics.NewNoopMetricsClient(), log.NewNoop())
+
+	hosts := []HostInfo{
+		NewHostInfo("127.0.0.1:7933"),
+		NewDetailedHostInfo("127.0.0.2:7933", "127.0.0.2:7933", nil, WithWeight(0)),
+	}
+	pp.EXPECT().GetMembers("test-service").Return(hosts, nil).Times(1)
+	require.NoError(t, hr.refresh())
+
+	owners, err := hr.LookupN("key", 2)
+	require.NoError(t, err)
+	require.Len(t, owners, 1, "weight 0 hosts own no keys")
+	assert.Equal(t, "127.0.0.1:7933", owners[0].GetAddress())
+}
+
+func TestLookupWhenAllHostsHaveWeightZero(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	pp := NewMockPeerProvider(ctrl)
+	hr := newHashring("test-service", pp, metrics.NewNoopMetricsClient(), log.NewNoop())
+
+	hosts := []HostInfo{
+		NewDetailedHostInfo("127.0.0.1:7933", "127.0.0.1:7933", nil, WithWeight(0)),
+		NewDetailedHostInfo("127.0.0.2:7933", "127.0.0.2:7933", nil, WithWeight(0)),
+	}
+	pp.EXPECT().GetMembers("test-service").Return(hosts, nil).Times(1)
+	require.NoError(t, hr.refresh())
+
+	_, err := hr.LookupN("key", 2)
+	assert.Equal(t, ErrInsufficientHosts, err)
+	_, err = hr.Lookup("key")
+	assert.Equal(t, ErrInsufficientHosts, err)
+}
+
+func TestLastRebalanceMovedMatchesOwnershipChange(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	pp := NewMockPeerProvider(ctrl)
+
+	hosts := []HostInfo{
+		NewHostInfo("127.0.0.1:7933"),
+		NewHostInfo("127.0.0.2:7933"),
+		NewHostInfo("127.0.0.3:7933"),
+	}
+	joined := append(append([]HostInfo{}, hosts...), NewHostInfo("127.0.0.4:7933"))
+	gomock.InOrder(
+		pp.EXPECT().GetMembers("test-service").Return(hosts, nil),
+		pp.EXPECT().GetMembers("test-service").Return(joined, nil),
+	)
+	hr := newHashring("test-service", pp, metrics.NewNoopMetricsClient(), log.NewNoop())
+	assert.NoError(t, hr.refresh())
+	assert.Equal(t, basisPoints, hr.LastRebalanceMoved(), "the whole key space moves to the first members")
+
+	const keys = 10000
+	before := make(map[string]string, keys)
+	for i := 0; i < keys; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		host, err := hr.Lookup(key)
+		assert.NoError(t, err)
+		before[key] = host.GetAddress()
+	}
+
+	hr.members.refreshed = time.Time{}
+	assert.NoError(t, hr.refresh())
+
+	moved := 0
+	for key, owner := range before {
+		host, err := hr.Lookup(key)
+		assert.NoError(t, err)
+		if host.GetAddress() != owner {
+			assert.Equal(t, "127.0.0.4:7933", host.GetAddress(), "keys only move to the new member")
+			moved++
+		}
+	}
+	assert.InDelta(t, moved*basisPoints/keys, hr.LastRebalanceMoved(), 200)
+	assert.InDelta(t, basisPoints/4, hr.LastRebalanceMoved(), 1000, "about a quarter of keys move to the fourth member")
+}
+
+func TestMovedKeySpace(t *testing.T) {
+	withPoints := func(hosts ...HostInfo) map[string]HostInfo {
+		res := make(map[string]HostInfo, len(hosts))
+		for _, h := range hosts {
+			h.ringPoints = ringPoints(h, defaultReplicaPoints, farm.Fingerprint32)
+			res[h.GetAddress()] = h
+		}
+		return res
+	}
+	a, b := NewHostInfo("127.0.0.1:7933"), NewHostInfo("127.0.0.2:7933")
+
+	assert.Equal(t, 0.0, movedKeySpace(nil, nil))
+	assert.Equal(t, 0.0, movedKeySpace(withPoints(a, b), withPoints(b, a)))
+	assert.Equal(t, 1.0, movedKeySpace(nil, withPoints(a)))
+	assert.Equal(t, 1.0, movedKeySpace(withPoints(a), withPoints(b)))
+	assert.Equal(t, 1.0, movedKeySpace(withPoints(a), withPoints(a.WithLabel(LabelDrained, "true"))))
+
+	half := movedKeySpace(withPoints(a), withPoints(a, b))
+	assert.InDelta(t, 0.5, half, 0.15)
+	assert.Equal(t, half, movedKeySpace(withPoints(a, b), withPoints(a)))
+}
+
 func TestErrorIsPropagatedWhenProviderFails(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	pp := NewMockPeerProvider(ctrl)
 	pp.EXPECT().GetMembers(gomock.Any()).Return(nil, errors.New("error"))
 
-	hr := newHashring("test-service", pp, log.NewNoop())
+	hr := newHashring("test-service", pp, metrics.NewNoopMetricsClient(), log.NewNoop())
 	assert.Error(t, hr.refresh())
 }
 
@@ -198,7 +1389,7 @@ func TestStopWillStopProvider(t *testing.T) {
 
 	pp.EXPECT().Stop().Times(1)
 
-	hr := newHashring("test-service", pp, log.NewNoop())
+	hr := newHashring("test-service", pp, metrics.NewNoopMetricsClient(), log.NewNoop())
 	hr.status = common.DaemonStatusStarted
 	hr.Stop()
 
@@ -213,7 +1404,7 @@ func TestLookupAndRefreshRaceCondition(t *testing.T) {
 	pp.EXPECT().GetMembers("test-service").AnyTimes().DoAndReturn(func(service string) ([]HostInfo, error) {
 		return randomHostInfo(5), nil
 	})
-	hr := newHashring("test-service", pp, log.NewNoop())
+	hr := newHashring("test-service", pp, metrics.NewNoopMetricsClient(), log.NewNoop())
 	hr.Start()
 	wg.Add(2)
 	go func() {
@@ -233,3 +1424,197 @@ func TestLookupAndRefreshRaceCondition(t *testing.T) {
 
 	wg.Wait()
 }
+
+func TestForcedAndWorkerRefreshRaceCondition(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	pp := NewMockPeerProvider(ctrl)
+	var wg sync.WaitGroup
+
+	pp.EXPECT().Subscribe(gomock.Any(), gomock.Any()).Times(1)
+	pp.EXPECT().GetMembers("test-service").AnyTimes().DoAndReturn(func(service string) ([]HostInfo, error) {
+		return randomHostInfo(5), nil
+	})
+	hr := newHashring("test-service", pp, metrics.NewNoopMetricsClient(), log.NewNoop())
+	hr.Start()
+	deadline := time.Now().Add(100 * time.Millisecond)
+	wg.Add(2)
+	go func() {
+		// refresh worker, rate limited by the time of the last refresh
+		for time.Now().Before(deadline) {
+			hr.refresh()
+		}
+		wg.Done()
+	}()
+	go func() {
+		// Refresh of the resolver
+		for time.Now().Before(deadline) {
+			hr.forceRefresh()
+		}
+		wg.Done()
+	}()
+
+	wg.Wait()
+}
+
+func TestConcurrentRefreshesStoreMembersInReadOrder(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	pp := NewMockPeerProvider(ctrl)
+
+	stale := []HostInfo{NewHostInfo("10.0.0.1:7933")}
+	fresh := []HostInfo{NewHostInfo("10.0.0.2:7933")}
+	entered, release := make(chan struct{}), make(chan struct{})
+	gomock.InOrder(
+		pp.EXPECT().GetMembers("test-service").DoAndReturn(func(service string) ([]HostInfo, error) {
+			close(entered)
+			<-release
+			return stale, nil
+		}),
+		pp.EXPECT().GetMembers("test-service").Return(fresh, nil),
+	)
+	hr := newHashring("test-service", pp, metrics.NewNoopMetricsClient(), log.NewNoop())
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		assert.NoError(t, hr.refreshMembers())
+	}()
+	<-entered
+	go func() {
+		// e.g. EvictSelf polling while the refresh worker waits for a slow peer provider
+		defer wg.Done()
+		assert.NoError(t, hr.refreshMembers())
+	}()
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, []string{"10.0.0.2:7933"}, addresses(hr.Members()), "members read later are not overwritten")
+}
+
+func BenchmarkLookupBatch(b *testing.B) {
+	members := make([]HostInfo, 0, 100)
+	for i := 0; i < 100; i++ {
+		members = append(members, NewHostInfo(fmt.Sprintf("10.0.0.%d:7933", i)))
+	}
+	keys := make([]string, 64)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("workflow-%d", i)
+	}
+	provider := NewStaticPeerProvider(HostInfo{}, map[string][]HostInfo{"test-worker": members})
+	r := NewMultiringResolver([]string{"test-worker"}, provider, metrics.NewNoopMetricsClient(), log.NewNoop())
+	hr, err := r.getRing("test-worker")
+	if err != nil {
+		b.Fatal(err)
+	}
+	if err := hr.refresh(); err != nil {
+		b.Fatal(err)
+	}
+
+	b.Run("per-key", func(b *testing.B) {
+		b.ReportAllocs()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				for _, key := range keys {
+					if _, err := r.Lookup("test-worker", key); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+	})
+	b.Run("batch", func(b *testing.B) {
+		b.ReportAllocs()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				if _, err := r.LookupBatch("test-worker", keys); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	})
+}
+
+func TestLookupIntoMatchesLookup(t *testing.T) {
+	members := []HostInfo{
+		NewHostInfo("10.0.0.1:7933"),
+		NewHostInfo("10.0.0.2:7933").WithLabel(LabelDrained, "true"),
+		NewHostInfo("10.0.0.3:7933"),
+	}
+	provider := NewStaticPeerProvider(HostInfo{}, map[string][]HostInfo{"test-worker": members})
+	r := NewMultiringResolver([]string{"test-worker"}, provider, metrics.NewNoopMetricsClient(), log.NewNoop())
+	hr, err := r.getRing("test-worker")
+	require.NoError(t, err)
+
+	var out HostInfo
+	assert.Equal(t, ErrNoMembers, r.LookupInto("test-worker", "key", &out))
+	require.NoError(t, hr.refresh())
+
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		owners, err := r.LookupN("test-worker", key, 1)
+		require.NoError(t, err)
+		require.NoError(t, r.LookupInto("test-worker", key, &out))
+		assert.Equal(t, owners[0].GetAddress(), out.GetAddress(), key)
+		assert.False(t, out.IsDrained())
+	}
+	assert.Error(t, r.LookupInto("unknown-service", "key", &out))
+}
+
+func TestLookupIntoDoesNotAllocate(t *testing.T) {
+	members := make([]HostInfo, 0, 100)
+	for i := 0; i < 100; i++ {
+		members = append(members, NewHostInfo(fmt.Sprintf("10.0.0.%d:7933", i)))
+	}
+	provider := NewStaticPeerProvider(HostInfo{}, map[string][]HostInfo{"test-worker": members})
+	r := NewMultiringResolver([]string{"test-worker"}, provider, metrics.NewNoopMetricsClient(), log.NewNoop())
+	hr, err := r.getRing("test-worker")
+	require.NoError(t, err)
+	require.NoError(t, hr.refresh())
+
+	var out HostInfo
+	allocs := testing.AllocsPerRun(1000, func() {
+		if err := r.LookupInto("test-worker", "workflow-id", &out); err != nil {
+			t.Fatal(err)
+		}
+	})
+	assert.Zero(t, allocs)
+}
+
+func BenchmarkLookupInto(b *testing.B) {
+	members := make([]HostInfo, 0, 100)
+	for i := 0; i < 100; i++ {
+		members = append(members, NewHostInfo(fmt.Sprintf("10.0.0.%d:7933", i)))
+	}
+	keys := make([]string, 64)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("workflow-%d", i)
+	}
+	provider := NewStaticPeerProvider(HostInfo{}, map[string][]HostInfo{"test-worker": members})
+	r := NewMultiringResolver([]string{"test-worker"}, provider, metrics.NewNoopMetricsClient(), log.NewNoop())
+	hr, err := r.getRing("test-worker")
+	if err != nil {
+		b.Fatal(err)
+	}
+	if err := hr.refresh(); err != nil {
+		b.Fatal(err)
+	}
+
+	b.Run("lookup", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := r.Lookup("test-worker", keys[i%len(keys)]); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("lookup-into", func(b *testing.B) {
+		b.ReportAllocs()
+		var out HostInfo
+		for i := 0; i < b.N; i++ {
+			if err := r.LookupInto("test-worker", keys[i%len(keys)], &out); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
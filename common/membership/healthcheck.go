@@ -0,0 +1,231 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2017-2020 Uber Technologies Inc.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package membership
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+
+	"github.com/uber/cadence/common/log/tag"
+)
+
+const (
+	defaultHealthCheckInterval         = 5 * time.Second
+	defaultHealthCheckTimeout          = time.Second
+	defaultHealthCheckFailureThreshold = 3
+)
+
+type (
+	// HealthChecker probes a ring member, a nil error means the member is healthy
+	HealthChecker interface {
+		Check(ctx context.Context, host HostInfo) error
+	}
+
+	// HealthCheckConfig describes how ring members are probed
+	HealthCheckConfig struct {
+		// Interval between two probes of the same member
+		Interval time.Duration
+		// Timeout of a single probe
+		Timeout time.Duration
+		// FailureThreshold is the number of consecutive failed probes after which a member is skipped by lookups
+		FailureThreshold int
+	}
+
+	grpcHealthChecker struct {
+		creds credentials.TransportCredentials
+	}
+
+	// healthState tracks consecutive probe failures of ring members by address
+	healthState struct {
+		sync.RWMutex
+		checker   HealthChecker
+		config    HealthCheckConfig
+		failures  map[string]int
+		unhealthy map[string]struct{}
+	}
+)
+
+// NewGRPCHealthChecker returns a checker calling the standard grpc health service on the member grpc port.
+// Members are dialed with tlsConfig, which should be the outbound rpc tls config when rpc tls is enabled,
+// or in plaintext when it is nil.
+func NewGRPCHealthChecker(tlsConfig *tls.Config) HealthChecker {
+	if tlsConfig == nil {
+		return grpcHealthChecker{creds: insecure.NewCredentials()}
+	}
+	return grpcHealthChecker{creds: credentials.NewTLS(tlsConfig)}
+}
+
+// WithHealthCheck returns a setter enabling active health checks of ring members.
+// Members failing config.FailureThreshold consecutive probes are skipped by lookups until a probe succeeds,
+// they are still listed in Members. Zero config values are replaced by defaults.
+func WithHealthCheck(checker HealthChecker, config HealthCheckConfig) ResolverOption {
+	if config.Interval <= 0 {
+		config.Interval = defaultHealthCheckInterval
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = defaultHealthCheckTimeout
+	}
+	if config.FailureThreshold <= 0 {
+		config.FailureThreshold = defaultHealthCheckFailureThreshold
+	}
+	return func(rpo *MultiringResolver) {
+		rpo.healthChecker = checker
+		rpo.healthCheckConfig = config
+	}
+}
+
+func (c grpcHealthChecker) Check(ctx context.Context, host HostInfo) error {
+	addr, err := host.GetNamedAddress(PortGRPC)
+	if err != nil {
+		return err
+	}
+	conn, err := grpc.DialContext(ctx, addr, grpc.WithTransportCredentials(c.creds))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+	if err != nil {
+		return err
+	}
+	if resp.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+		return fmt.Errorf("host %v is %v", host, resp.GetStatus())
+	}
+	return nil
+}
+
+func newHealthState(checker HealthChecker, config HealthCheckConfig) *healthState {
+	return &healthState{
+		checker:   checker,
+		config:    config,
+		failures:  make(map[string]int),
+		unhealthy: make(map[string]struct{}),
+	}
+}
+
+// isUnhealthy returns true if host at the address reached the failure threshold
+func (h *healthState) isUnhealthy(addr string) bool {
+	if h == nil {
+		return false
+	}
+	h.RLock()
+	defer h.RUnlock()
+	_, ok := h.unhealthy[addr]
+	return ok
+}
+
+// unhealthyCount returns the number of members skipped by lookups
+func (h *healthState) unhealthyCount() int {
+	if h == nil {
+		return 0
+	}
+	h.RLock()
+	defer h.RUnlock()
+	return len(h.unhealthy)
+}
+
+// record updates the state with the result of a probe
+func (h *healthState) record(addr string, err error) (changed bool) {
+	h.Lock()
+	defer h.Unlock()
+
+	_, wasUnhealthy := h.unhealthy[addr]
+	if err == nil {
+		delete(h.failures, addr)
+		delete(h.unhealthy, addr)
+		return wasUnhealthy
+	}
+	h.failures[addr]++
+	if h.failures[addr] >= h.config.FailureThreshold {
+		h.unhealthy[addr] = struct{}{}
+	}
+	return !wasUnhealthy && h.failures[addr] >= h.config.FailureThreshold
+}
+
+// forget drops the state of hosts which are not members anymore
+func (h *healthState) forget(members []HostInfo) {
+	current := make(map[string]struct{}, len(members))
+	for _, m := range members {
+		current[m.GetAddress()] = struct{}{}
+	}
+	h.Lock()
+	defer h.Unlock()
+	for addr := range h.failures {
+		if _, ok := current[addr]; !ok {
+			delete(h.failures, addr)
+			delete(h.unhealthy, addr)
+		}
+	}
+}
+
+// checkMembers probes all members of the ring concurrently
+func (r *ring) checkMembers() {
+	members := r.Members()
+	r.health.forget(members)
+
+	var wg sync.WaitGroup
+	for _, member := range members {
+		wg.Add(1)
+		go func(member HostInfo) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), r.health.config.Timeout)
+			defer cancel()
+			err := r.health.checker.Check(ctx, member)
+			if r.health.record(member.GetAddress(), err) {
+				if err != nil {
+					r.logger.Warn("ring member failed health checks, skipping it in lookups", tag.Address(member.GetAddress()), tag.Error(err))
+				} else {
+					r.logger.Info("ring member is healthy again", tag.Address(member.GetAddress()))
+				}
+				r.cache.reset()
+				r.signalShardWatchers()
+				r.signalKeyWatchers()
+			}
+		}(member)
+	}
+	wg.Wait()
+}
+
+func (r *ring) healthCheckWorker() {
+	defer r.shutdownWG.Done()
+
+	ticker := time.NewTicker(r.health.config.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.shutdownCh:
+			return
+		case <-ticker.C:
+			r.checkMembers()
+		}
+	}
+}
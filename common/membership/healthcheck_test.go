@@ -0,0 +1,165 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2017-2020 Uber Technologies Inc.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package membership
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+
+	"github.com/uber/cadence/common/log"
+	"github.com/uber/cadence/common/metrics"
+)
+
+type fakeHealthChecker struct {
+	sync.Mutex
+	unhealthy map[string]bool
+}
+
+func (c *fakeHealthChecker) Check(ctx context.Context, host HostInfo) error {
+	c.Lock()
+	defer c.Unlock()
+	if c.unhealthy[host.GetAddress()] {
+		return errors.New("unhealthy")
+	}
+	return nil
+}
+
+func (c *fakeHealthChecker) set(addr string, unhealthy bool) {
+	c.Lock()
+	defer c.Unlock()
+	c.unhealthy[addr] = unhealthy
+}
+
+func TestUnhealthyMembersAreSkippedByLookup(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	pp := NewMockPeerProvider(ctrl)
+
+	crashed := NewHostInfo("127.0.0.1:7933")
+	healthy := NewHostInfo("127.0.0.2:7933")
+	pp.EXPECT().GetMembers("test-worker").Return([]HostInfo{crashed, healthy}, nil).Times(1)
+
+	checker := &fakeHealthChecker{unhealthy: map[string]bool{}}
+	a := NewMultiringResolver(testServices, pp, metrics.NewNoopMetricsClient(), log.NewNoop(),
+		WithHealthCheck(checker, HealthCheckConfig{FailureThreshold: 2}))
+	r, err := a.getRing("test-worker")
+	require.NoError(t, err)
+	require.NoError(t, r.refresh())
+
+	keys := make([]string, 0, 50)
+	for i := 0; i < 50; i++ {
+		keys = append(keys, randSeq(10))
+	}
+	owners := func() map[string]struct{} {
+		res := map[string]struct{}{}
+		for _, key := range keys {
+			host, err := a.Lookup("test-worker", key)
+			assert.NoError(t, err)
+			res[host.GetAddress()] = struct{}{}
+		}
+		return res
+	}
+
+	checker.set(crashed.GetAddress(), true)
+	r.checkMembers()
+	assert.Len(t, owners(), 2, "a single failure is below threshold")
+
+	r.checkMembers()
+	assert.Equal(t, map[string]struct{}{healthy.GetAddress(): {}}, owners())
+	members, err := a.Members("test-worker")
+	assert.NoError(t, err)
+	assert.Len(t, members, 2, "unhealthy members are still listed")
+
+	checker.set(crashed.GetAddress(), false)
+	r.checkMembers()
+	assert.Len(t, owners(), 2, "member is back after a successful probe")
+}
+
+func TestHealthStateForgetsLeftMembers(t *testing.T) {
+	h := newHealthState(&fakeHealthChecker{}, HealthCheckConfig{FailureThreshold: 1})
+	assert.True(t, h.record("a", errors.New("down")))
+	assert.False(t, h.record("a", errors.New("down")), "already unhealthy")
+	assert.True(t, h.isUnhealthy("a"))
+
+	h.forget([]HostInfo{NewHostInfo("b")})
+	assert.False(t, h.isUnhealthy("a"))
+	assert.Equal(t, 0, h.unhealthyCount())
+
+	var disabled *healthState
+	assert.False(t, disabled.isUnhealthy("a"))
+	assert.Equal(t, 0, disabled.unhealthyCount())
+}
+
+func TestGRPCHealthChecker(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := grpc.NewServer()
+	healthServer := health.NewServer()
+	healthpb.RegisterHealthServer(server, healthServer)
+	go server.Serve(listener)
+	defer server.Stop()
+
+	port := listener.Addr().(*net.TCPAddr).Port
+	host := NewDetailedHostInfo("127.0.0.1:7933", "host", PortMap{PortGRPC: uint16(port)})
+	checker := NewGRPCHealthChecker(nil)
+
+	assert.NoError(t, checker.Check(context.Background(), host))
+
+	healthServer.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	assert.Error(t, checker.Check(context.Background(), host))
+
+	assert.Error(t, checker.Check(context.Background(), NewHostInfo("127.0.0.1:7933")), "grpc port is required")
+}
+
+func TestGRPCHealthCheckerDialsWithTLS(t *testing.T) {
+	certificate, roots := selfSignedCertificate(t)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := grpc.NewServer(grpc.Creds(credentials.NewServerTLSFromCert(&certificate)))
+	healthpb.RegisterHealthServer(server, health.NewServer())
+	go server.Serve(listener)
+	defer server.Stop()
+
+	port := listener.Addr().(*net.TCPAddr).Port
+	host := NewDetailedHostInfo("127.0.0.1:7933", "host", PortMap{PortGRPC: uint16(port)})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	assert.NoError(t, NewGRPCHealthChecker(&tls.Config{RootCAs: roots}).Check(ctx, host))
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.Error(t, NewGRPCHealthChecker(nil).Check(ctx, host), "plaintext probes of a tls member fail")
+}
@@ -23,10 +23,21 @@
 package membership
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
+	"sort"
 	"strconv"
 	"strings"
+	"sync/atomic"
+
+	"go.uber.org/multierr"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/uber/cadence/common/log"
+	"github.com/uber/cadence/common/log/tag"
 )
 
 const (
@@ -34,84 +45,889 @@ const (
 	PortGRPC     = "grpc"
 )
 
+const (
+	// LabelDrained is set to "true" on hosts which are draining and should not be assigned new work,
+	// see DrainedLabel for draining from a single service ring
+	LabelDrained = "drained"
+	// LabelZone is set to the availability zone of the host
+	LabelZone = "zone"
+)
+
+const defaultWeight = 1
+
+const (
+	// StatusUnknown is used when host health is not known, it is the default
+	StatusUnknown Status = iota
+	// StatusAlive is used for hosts known to be healthy
+	StatusAlive
+	// StatusSuspect is used for hosts that are suspected to be unhealthy
+	StatusSuspect
+	// StatusFaulty is used for hosts known to be unhealthy
+	StatusFaulty
+)
+
+// Status describes host health as reported by the membership provider.
+// It is carried along with HostInfo only, acting upon it is up to the ring and its users.
+type Status int
+
+// String returns a human-readable status name
+func (s Status) String() string {
+	switch s {
+	case StatusAlive:
+		return "alive"
+	case StatusSuspect:
+		return "suspect"
+	case StatusFaulty:
+		return "faulty"
+	default:
+		return "unknown"
+	}
+}
+
+// parseStatus is the reverse of Status.String, an empty name is StatusUnknown
+func parseStatus(name string) (Status, error) {
+	for _, status := range []Status{StatusUnknown, StatusAlive, StatusSuspect, StatusFaulty} {
+		if name == status.String() {
+			return status, nil
+		}
+	}
+	if name == "" {
+		return StatusUnknown, nil
+	}
+	return StatusUnknown, fmt.Errorf("unknown host status %q", name)
+}
+
+// ErrPortNotSet is returned when a named port is not present in the host port map.
+// Use errors.Is to check for it, or errors.As with *PortNotSetError to get the details.
+var ErrPortNotSet = errors.New("port is not set")
+
+// InvalidAddressError is returned when the host address cannot be parsed
+type InvalidAddressError struct {
+	Addr string
+	Err  error
+}
+
+// Error returns a human-readable description of the invalid address
+func (e *InvalidAddressError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the parse error
+func (e *InvalidAddressError) Unwrap() error {
+	return e.Err
+}
+
+// ErrPortLookupTimeout is returned by GetNamedAddressCtx when the port could not be resolved before the context deadline
+var ErrPortLookupTimeout = errors.New("named port lookup timed out")
+
+// PortResolver resolves named ports that are not known in advance, e.g. by asking a sidecar
+type PortResolver interface {
+	LookupPort(ctx context.Context, host HostInfo, port string) (uint16, error)
+}
+
+// PortNotSetError is returned when a named port is not present in the host port map
+type PortNotSetError struct {
+	Port     string
+	Identity string
+	host     string
+}
+
+// Error returns a human-readable description of the missing port
+func (e *PortNotSetError) Error() string {
+	return fmt.Sprintf("port %q is not set for %s", e.Port, e.host)
+}
+
+// Is allows PortNotSetError to match ErrPortNotSet
+func (e *PortNotSetError) Is(target error) bool {
+	return target == ErrPortNotSet
+}
+
+// portLookupMissHook holds func(port string) called when a named port is not set
+var portLookupMissHook atomic.Value
+
+// SetPortLookupMissHook registers a function called with the port name every time GetNamedAddress
+// is asked for a port that is not set, e.g. to count misses per port name. Pass nil to remove the hook.
+// The hook is called synchronously, so it should be cheap.
+func SetPortLookupMissHook(hook func(port string)) {
+	portLookupMissHook.Store(hook)
+}
+
 // PortMap is a map of port names to port numbers.
 type PortMap map[string]uint16
 
+// PortEntry describes a named port along with hints how to connect to it
+type PortEntry struct {
+	Number uint16
+	TLS    bool
+	// Scheme is used to build URLs for the port, defaults to the port name (with "s" appended for TLS)
+	Scheme string
+}
+
+// DetailedPortMap is a map of port names to port entries
+type DetailedPortMap map[string]PortEntry
+
+// HostInfoOption sets optional HostInfo details
+type HostInfoOption func(*HostInfo)
+
+// hostInfoJSON is the serialized form of HostInfo
+type hostInfoJSON struct {
+	Address  string            `json:"address"`
+	Identity string            `json:"identity,omitempty"`
+	Ports    PortMap           `json:"ports,omitempty"`
+	Labels   map[string]string `json:"labels,omitempty"`
+	Weight   *int              `json:"weight,omitempty"`
+	Status   string            `json:"status,omitempty"`
+}
+
 // HostInfo is a type that contains the info about a cadence host
 type HostInfo struct {
 	addr     string // ip:port returned by peer provider
-	ip       string // @todo should we set this to net.IP ?
+	host     string // host part of addr as it was provided
+	ip       net.IP // canonical form of host, nil if host is not an IP literal
+	port     uint16 // port part of addr, 0 if addr is invalid
 	identity string
 	portMap  PortMap // ports host is listening to
+	entries  DetailedPortMap
+	labels   map[string]string
+	weight   *int // nil means defaultWeight
+	status   Status
+
+	portResolver PortResolver
+	bind         *bindAddress // nil if host binds to the advertised address
+	ringPoints   []uint32     // positions on the hashring, diagnostic only
+}
+
+// bindAddress is the local address host listens on, if different from the advertised one
+type bindAddress struct {
+	addr string
+	host string
+	ip   net.IP
+	port uint16
 }
 
 // NewHostInfo creates a new HostInfo instance
 func NewHostInfo(addr string) HostInfo {
-	ip, _, _ := net.SplitHostPort(addr)
+	host, ip, port, _ := parseAddr(addr)
 	return HostInfo{
 		addr: addr,
+		host: host,
 		ip:   ip,
+		port: port,
 	}
 }
 
-// String formats a PortMap into a string of name:port pairs
+// NewHostInfoFromAddr creates a new HostInfo instance from a network address.
+// TCP and UDP addresses are used directly without formatting and parsing them again.
+func NewHostInfoFromAddr(addr net.Addr) HostInfo {
+	var (
+		ip   net.IP
+		port int
+		zone string
+	)
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		ip, port, zone = a.IP, a.Port, a.Zone
+	case *net.UDPAddr:
+		ip, port, zone = a.IP, a.Port, a.Zone
+	default:
+		return NewHostInfo(addr.String())
+	}
+	if ip == nil {
+		// not an IP literal, e.g. an unspecified address, parsed from its string form instead of dropping the host
+		return NewHostInfo(addr.String())
+	}
+
+	host := ip.String()
+	if zone != "" {
+		host += "%" + zone
+	}
+	return HostInfo{
+		addr: net.JoinHostPort(host, strconv.Itoa(port)),
+		host: host,
+		ip:   ip,
+		port: uint16(port),
+	}
+}
+
+// NewHostInfoValidated creates a new HostInfo instance and returns an error if addr is not a valid host:port pair
+func NewHostInfoValidated(addr string) (HostInfo, error) {
+	if _, _, _, err := parseAddr(addr); err != nil {
+		return HostInfo{}, err
+	}
+	return NewHostInfo(addr), nil
+}
+
+// NewHostInfos creates HostInfo instances for all addresses.
+// Every invalid address is reported in the returned error, not only the first one.
+func NewHostInfos(addrs []string) ([]HostInfo, error) {
+	res := make([]HostInfo, 0, len(addrs))
+	var errs error
+	for _, addr := range addrs {
+		host, err := NewHostInfoValidated(addr)
+		if err != nil {
+			errs = multierr.Append(errs, err)
+			continue
+		}
+		res = append(res, host)
+	}
+	if errs != nil {
+		return nil, errs
+	}
+	return res, nil
+}
+
+// String formats a PortMap into a string of name:port pairs sorted by name.
+// Ports set to zero are included as well.
 func (m PortMap) String() string {
 	res := make([]string, 0, len(m))
-	for name, port := range m {
-		res = append(res, fmt.Sprintf("%s:%d", name, port))
+	for _, name := range m.names() {
+		res = append(res, fmt.Sprintf("%s:%d", name, m[name]))
 	}
 	return strings.Join(res, ", ")
 }
 
-// NewDetailedHostInfo creates a new HostInfo instance with identity and portmap information
-func NewDetailedHostInfo(addr string, identity string, portMap PortMap) HostInfo {
-	ip, _, _ := net.SplitHostPort(addr)
-	return HostInfo{
+// Clone returns a copy of the PortMap that does not share storage with the original
+func (m PortMap) Clone() PortMap {
+	if m == nil {
+		return nil
+	}
+	res := make(PortMap, len(m))
+	for name, port := range m {
+		res[name] = port
+	}
+	return res
+}
+
+// Diff compares the port map with other, which is usually the expected one.
+// It returns ports present only in other (added), ports present only in m (removed),
+// and ports present in both with different numbers, as set in other (changed).
+func (m PortMap) Diff(other PortMap) (added, removed, changed map[string]uint16) {
+	added = make(map[string]uint16)
+	removed = make(map[string]uint16)
+	changed = make(map[string]uint16)
+	for name, port := range other {
+		current, ok := m[name]
+		switch {
+		case !ok:
+			added[name] = port
+		case current != port:
+			changed[name] = port
+		}
+	}
+	for name, port := range m {
+		if _, ok := other[name]; !ok {
+			removed[name] = port
+		}
+	}
+	return added, removed, changed
+}
+
+// NewPortMap returns the standard ports a cadence service listens to, ports which are not set (0) are left out.
+// The local host should be advertised with it wherever it is registered, so that all members see the same ports.
+func NewPortMap(tchannelPort, grpcPort uint16) PortMap {
+	ports := make(PortMap, 2)
+	if tchannelPort != 0 {
+		ports[PortTchannel] = tchannelPort
+	}
+	if grpcPort != 0 {
+		ports[PortGRPC] = grpcPort
+	}
+	return ports
+}
+
+// Get returns the port number for the name and whether it is set
+func (m PortMap) Get(name string) (uint16, bool) {
+	port, ok := m[name]
+	return port, ok
+}
+
+// Validate returns an error if any port is zero or if the same port number is assigned to several names
+func (m PortMap) Validate() error {
+	names := make(map[uint16]string, len(m))
+	for _, name := range m.names() {
+		port := m[name]
+		if port == 0 {
+			return fmt.Errorf("port %q is set to 0", name)
+		}
+		if other, ok := names[port]; ok {
+			return fmt.Errorf("ports %q and %q are both set to %d", other, name, port)
+		}
+		names[port] = name
+	}
+	return nil
+}
+
+// names returns port names in sorted order
+func (m PortMap) names() []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewDetailedHostInfoValidated creates a new HostInfo instance with identity and portmap information
+// and returns an error if either the address or the port map is invalid
+func NewDetailedHostInfoValidated(addr string, identity string, portMap PortMap) (HostInfo, error) {
+	if _, err := NewHostInfoValidated(addr); err != nil {
+		return HostInfo{}, err
+	}
+	if err := portMap.Validate(); err != nil {
+		return HostInfo{}, fmt.Errorf("invalid port map for %q: %w", addr, err)
+	}
+	return NewDetailedHostInfo(addr, identity, portMap), nil
+}
+
+// NewDetailedHostInfo creates a new HostInfo instance with identity and portmap information.
+// The portMap is copied, so later changes to it made by the caller are not visible in HostInfo.
+func NewDetailedHostInfo(addr string, identity string, portMap PortMap, opts ...HostInfoOption) HostInfo {
+	host, ip, port, _ := parseAddr(addr)
+	hi := HostInfo{
 		addr:     addr,
+		host:     host,
 		ip:       ip,
+		port:     port,
 		identity: identity,
-		portMap:  portMap,
+		portMap:  portMap.Clone(),
+	}
+	for _, opt := range opts {
+		opt(&hi)
+	}
+	return hi
+}
+
+// WithDetailedPorts adds ports along with their connection hints to the host port map
+func WithDetailedPorts(ports DetailedPortMap) HostInfoOption {
+	return func(hi *HostInfo) {
+		if len(ports) == 0 {
+			return
+		}
+		hi.portMap = hi.portMap.Clone()
+		if hi.portMap == nil {
+			hi.portMap = make(PortMap, len(ports))
+		}
+		entries := make(DetailedPortMap, len(hi.entries)+len(ports))
+		for name, entry := range hi.entries {
+			entries[name] = entry
+		}
+		for name, entry := range ports {
+			hi.portMap[name] = entry.Number
+			entries[name] = entry
+		}
+		hi.entries = entries
 	}
 }
 
+// Clone returns a deep copy of HostInfo that does not share any maps with the original,
+// so it can be safely handed to other goroutines
+func (hi HostInfo) Clone() HostInfo {
+	hi.portMap = hi.portMap.Clone()
+	if hi.ringPoints != nil {
+		hi.ringPoints = append([]uint32(nil), hi.ringPoints...)
+	}
+	if hi.ip != nil {
+		hi.ip = append(net.IP(nil), hi.ip...)
+	}
+	if hi.entries != nil {
+		entries := make(DetailedPortMap, len(hi.entries))
+		for name, entry := range hi.entries {
+			entries[name] = entry
+		}
+		hi.entries = entries
+	}
+	if hi.bind != nil {
+		bind := *hi.bind
+		bind.ip = append(net.IP(nil), bind.ip...)
+		hi.bind = &bind
+	}
+	if hi.labels != nil {
+		labels := make(map[string]string, len(hi.labels))
+		for k, v := range hi.labels {
+			labels[k] = v
+		}
+		hi.labels = labels
+	}
+	return hi
+}
+
 // GetAddress returns the ip:port address
 func (hi HostInfo) GetAddress() string {
 	return hi.addr
 }
 
+// GetIP returns the canonical form of the host IP, or an empty string if the host address is not an IP
+func (hi HostInfo) GetIP() string {
+	if hi.ip == nil {
+		return ""
+	}
+	return hi.ip.String()
+}
+
+// GetIPZone returns the zone of a link-local IPv6 host address, e.g. eth0 for fe80::1%eth0, or an empty string.
+// It is the network interface of the address, not to be confused with the availability zone label.
+func (hi HostInfo) GetIPZone() string {
+	if hi.ip == nil {
+		return ""
+	}
+	_, zone := splitZone(hi.host)
+	return zone
+}
+
+// IsLoopback tells if the host IP is a loopback address
+func (hi HostInfo) IsLoopback() bool {
+	return hi.ip != nil && hi.ip.IsLoopback()
+}
+
+// IsPrivate tells if the host IP is a private network address, according to RFC 1918 (IPv4) and RFC 4193 (IPv6)
+func (hi HostInfo) IsPrivate() bool {
+	return hi.ip != nil && hi.ip.IsPrivate()
+}
+
+// InSubnet tells if the host IP belongs to the cidr network, e.g. "10.0.0.0/8".
+// An error is returned if cidr is invalid or if the host address is not an IP.
+func (hi HostInfo) InSubnet(cidr string) (bool, error) {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return false, err
+	}
+	if hi.ip == nil {
+		return false, fmt.Errorf("host %q has no IP address", hi.addr)
+	}
+	return network.Contains(hi.ip), nil
+}
+
+// Ports returns a copy of ports host is listening to
+func (hi HostInfo) Ports() PortMap {
+	return hi.portMap.Clone()
+}
+
+// GetPort returns the number of the named port and whether it is set
+func (hi HostInfo) GetPort(name string) (uint16, bool) {
+	return hi.portMap.Get(name)
+}
+
+// PortCount returns the number of named ports host is listening to
+func (hi HostInfo) PortCount() int {
+	return len(hi.portMap)
+}
+
+// WithPorts returns a copy of HostInfo with extra ports merged into its port map.
+// Ports from extra take precedence over existing ports with the same name.
+func (hi HostInfo) WithPorts(extra PortMap) HostInfo {
+	merged := make(PortMap, len(hi.portMap)+len(extra))
+	for name, port := range hi.portMap {
+		merged[name] = port
+	}
+	for name, port := range extra {
+		merged[name] = port
+	}
+	hi.portMap = merged
+	return hi
+}
+
 // GetNamedAddress returns the ip:port address
 func (hi HostInfo) GetNamedAddress(port string) (string, error) {
 	if port, set := hi.portMap[port]; set {
-		return net.JoinHostPort(hi.ip, strconv.Itoa(int(port))), nil
+		return net.JoinHostPort(hi.host, strconv.Itoa(int(port))), nil
+	}
+
+	if hook, _ := portLookupMissHook.Load().(func(string)); hook != nil {
+		hook(port)
+	}
+	return "", &PortNotSetError{Port: port, Identity: hi.Identity(), host: hi.String()}
+}
+
+// WithWeight sets the relative share of load the host should receive.
+// Weight 0 means the host is drained and nothing should be placed on it.
+// Negative weights are treated as 0.
+func WithWeight(weight int) HostInfoOption {
+	return func(hi *HostInfo) {
+		if weight < 0 {
+			weight = 0
+		}
+		hi.weight = &weight
+	}
+}
+
+// GetWeight returns the relative share of load the host should receive, 1 unless set with WithWeight
+func (hi HostInfo) GetWeight() int {
+	if hi.weight == nil {
+		return defaultWeight
+	}
+	return *hi.weight
+}
+
+// WithPortResolver sets resolver used by GetNamedAddressCtx for ports missing in the port map
+func WithPortResolver(resolver PortResolver) HostInfoOption {
+	return func(hi *HostInfo) {
+		hi.portResolver = resolver
+	}
+}
+
+// WithBindAddress sets the local ip:port the host listens on when it differs from
+// the advertised address, e.g. for hosts behind NAT
+func WithBindAddress(addr string) HostInfoOption {
+	return func(hi *HostInfo) {
+		if addr == "" || addr == hi.addr {
+			hi.bind = nil
+			return
+		}
+		host, ip, port, _ := parseAddr(addr)
+		hi.bind = &bindAddress{addr: addr, host: host, ip: ip, port: port}
+	}
+}
+
+// GetBindAddress returns the local ip:port the host listens on, which is the advertised address unless set with WithBindAddress
+func (hi HostInfo) GetBindAddress() string {
+	if hi.bind == nil {
+		return hi.addr
 	}
+	return hi.bind.addr
+}
+
+// GetRingPoints returns positions assigned to the host on the hashring it was looked up from.
+// It is only set for hosts returned by the ring and is meant for debugging placement.
+func (hi HostInfo) GetRingPoints() []uint32 {
+	return hi.ringPoints
+}
+
+// GetStatus returns host health, StatusUnknown unless set with WithStatus
+func (hi HostInfo) GetStatus() Status {
+	return hi.status
+}
 
-	return "", fmt.Errorf("port %q is not set for %+v", port, hi)
+// WithStatus returns a copy of HostInfo with host health set to status
+func (hi HostInfo) WithStatus(status Status) HostInfo {
+	hi.status = status
+	return hi
 }
 
-// Belongs tells if ip:port is assigned to this member
+// GetNamedURL returns scheme://ip:port URL for the named port.
+// The scheme is taken from the port entry hints if present, otherwise the port name is used.
+func (hi HostInfo) GetNamedURL(port string) (string, error) {
+	addr, err := hi.GetNamedAddress(port)
+	if err != nil {
+		return "", err
+	}
+
+	scheme := port
+	if entry, ok := hi.entries[port]; ok && entry.Number == hi.portMap[port] {
+		switch {
+		case entry.Scheme != "":
+			scheme = entry.Scheme
+		case entry.TLS:
+			scheme += "s"
+		}
+	}
+	return scheme + "://" + addr, nil
+}
+
+// GetNamedAddressCtx returns the ip:port address for the named port. If the port is not in the port map,
+// the port resolver set with WithPortResolver is asked for it. If the context is done before the resolver
+// replies, an error wrapping ErrPortLookupTimeout is returned. Without a resolver it behaves like GetNamedAddress.
+func (hi HostInfo) GetNamedAddressCtx(ctx context.Context, port string) (string, error) {
+	if _, set := hi.portMap[port]; set || hi.portResolver == nil {
+		return hi.GetNamedAddress(port)
+	}
+
+	type result struct {
+		number uint16
+		err    error
+	}
+	resCh := make(chan result, 1)
+	go func() {
+		number, err := hi.portResolver.LookupPort(ctx, hi, port)
+		resCh <- result{number: number, err: err}
+	}()
+
+	select {
+	case <-ctx.Done():
+		return "", fmt.Errorf("%w: port %q for %s: %v", ErrPortLookupTimeout, port, hi.addr, ctx.Err())
+	case res := <-resCh:
+		if res.err != nil {
+			return "", fmt.Errorf("resolving port %q for %s: %w", port, hi.addr, res.err)
+		}
+		return net.JoinHostPort(hi.host, strconv.Itoa(int(res.number))), nil
+	}
+}
+
+// EndpointFor returns host and port number to dial for the named port, falling back to the host address
+// if the named port is not set. An *InvalidAddressError is returned if the host address cannot be parsed;
+// if the named port is not set either, it is combined with a *PortNotSetError.
+func (hi HostInfo) EndpointFor(port string) (host string, portNum uint16, err error) {
+	if number, ok := hi.portMap[port]; ok {
+		if hi.host == "" {
+			_, _, _, err := parseAddr(hi.addr)
+			return "", 0, &InvalidAddressError{Addr: hi.addr, Err: err}
+		}
+		return hi.host, number, nil
+	}
+
+	host, _, portNum, err = parseAddr(hi.addr)
+	if err != nil {
+		return "", 0, multierr.Combine(
+			&PortNotSetError{Port: port, Identity: hi.Identity(), host: hi.String()},
+			&InvalidAddressError{Addr: hi.addr, Err: err},
+		)
+	}
+	return host, portNum, nil
+}
+
+// GetNamedAddresses returns ip:port addresses for all requested named ports.
+// If some of the ports are not set, the returned error combines a PortNotSetError for each of them.
+func (hi HostInfo) GetNamedAddresses(ports ...string) (map[string]string, error) {
+	res := make(map[string]string, len(ports))
+	var errs error
+	for _, port := range ports {
+		addr, err := hi.GetNamedAddress(port)
+		if err != nil {
+			errs = multierr.Append(errs, err)
+			continue
+		}
+		res[port] = addr
+	}
+	if errs != nil {
+		return nil, errs
+	}
+	return res, nil
+}
+
+// LogSelf logs the address of every named port of the host in a single line.
+// It is meant to be called at startup with the result of WhoAmI, so that advertised ports can be checked against configuration.
+func (hi HostInfo) LogSelf(logger log.Logger) {
+	endpoints, _ := hi.GetNamedAddresses(hi.portMap.names()...)
+	logger.Info("local host endpoints",
+		tag.Address(hi.GetAddress()),
+		tag.Name(hi.Identity()),
+		tag.Value(endpoints),
+	)
+}
+
+// GetNamedAddressOrDefault returns the ip:port address for the named port,
+// or the host address if the named port is not set.
+// The fallback may point to a different service than the named port would,
+// so it should only be used while migrating hosts to a new named port.
+func (hi HostInfo) GetNamedAddressOrDefault(port string) string {
+	if addr, err := hi.GetNamedAddress(port); err == nil {
+		return addr
+	}
+	return hi.GetAddress()
+}
+
+// Belongs tells if ip:port is assigned to this member.
+// Both the advertised and the bind address of the member are matched.
 func (hi HostInfo) Belongs(address string) (bool, error) {
 
-	if hi.addr == address {
+	if hi.addr == address || (hi.bind != nil && hi.bind.addr == address) {
 		return true, nil
 	}
 
-	ip, port, err := net.SplitHostPort(address)
+	host, ip, port, err := parseAddr(address)
 	if err != nil {
 		return false, err
 	}
 
-	if ip != hi.ip {
+	if !hi.sameHost(host, ip) {
 		return false, nil
 	}
 
-	for _, number := range hi.portMap {
-		if port == strconv.Itoa(int(number)) {
-			return true, nil
+	return hi.hasPort(port), nil
+}
+
+// BelongsWithResolver tells if ip:port is assigned to this member, resolving host names
+// of both the address and the member with the given resolver when they don't match literally.
+// If resolver is nil, DefaultAddressResolver is used.
+func (hi HostInfo) BelongsWithResolver(address string, resolver AddressResolver) (bool, error) {
+	if belongs, err := hi.Belongs(address); err != nil || belongs {
+		return belongs, err
+	}
+
+	host, _, port, _ := parseAddr(address)
+	if !hi.hasPort(port) {
+		return false, nil
+	}
+
+	if resolver == nil {
+		resolver = DefaultAddressResolver
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultLookupTimeout)
+	defer cancel()
+
+	addressIPs, err := lookupHost(ctx, resolver, host)
+	if err != nil {
+		return false, err
+	}
+	ownIPs, err := lookupHost(ctx, resolver, hi.host)
+	if err != nil {
+		return false, err
+	}
+
+	for _, ip := range addressIPs {
+		for _, own := range ownIPs {
+			if ip.Equal(own) {
+				return true, nil
+			}
 		}
 	}
 	return false, nil
 }
 
+// BelongsAny tells if any of ip:port addresses is assigned to this member.
+// Invalid addresses are skipped and the first parse error is returned along with the result.
+func (hi HostInfo) BelongsAny(addresses []string) (bool, error) {
+	ports := hi.portSet()
+	var (
+		belongs  bool
+		firstErr error
+	)
+	for _, address := range addresses {
+		ok, err := hi.belongsToPorts(address, ports)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		if ok {
+			belongs = true
+			break
+		}
+	}
+	return belongs, firstErr
+}
+
+// BelongsAll tells if all ip:port addresses are assigned to this member.
+// It stops and returns an error on the first invalid address.
+func (hi HostInfo) BelongsAll(addresses []string) (bool, error) {
+	ports := hi.portSet()
+	for _, address := range addresses {
+		ok, err := hi.belongsToPorts(address, ports)
+		if err != nil {
+			return false, err
+		}
+		if !ok {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func (hi HostInfo) belongsToPorts(address string, ports map[uint16]struct{}) (bool, error) {
+	if hi.addr == address || (hi.bind != nil && hi.bind.addr == address) {
+		return true, nil
+	}
+
+	host, ip, port, err := parseAddr(address)
+	if err != nil {
+		return false, err
+	}
+
+	if !hi.sameHost(host, ip) {
+		return false, nil
+	}
+
+	_, ok := ports[port]
+	return ok, nil
+}
+
+// portSet returns all ports of the member, including the address port
+func (hi HostInfo) portSet() map[uint16]struct{} {
+	ports := make(map[uint16]struct{}, len(hi.portMap)+1)
+	if hi.port != 0 {
+		ports[hi.port] = struct{}{}
+	}
+	if hi.bind != nil && hi.bind.port != 0 {
+		ports[hi.bind.port] = struct{}{}
+	}
+	for _, number := range hi.portMap {
+		ports[number] = struct{}{}
+	}
+	return ports
+}
+
+// hasPort tells if port is either the port of the member address or one of its named ports
+func (hi HostInfo) hasPort(port uint16) bool {
+	if hi.port != 0 && hi.port == port {
+		return true
+	}
+	if hi.bind != nil && hi.bind.port != 0 && hi.bind.port == port {
+		return true
+	}
+
+	for _, number := range hi.portMap {
+		if port == number {
+			return true
+		}
+	}
+	return false
+}
+
+// sameHost compares host against this member's advertised and bind hosts. IP literals
+// are compared in their canonical form, so different representations of the same IPv6
+// address are considered equal, as long as their zones are the same.
+func (hi HostInfo) sameHost(host string, ip net.IP) bool {
+	if hostsEqual(host, ip, hi.host, hi.ip) {
+		return true
+	}
+	return hi.bind != nil && hostsEqual(host, ip, hi.bind.host, hi.bind.ip)
+}
+
+// hostsEqual compares IP literals in canonical form, including their zones which tell apart
+// the same link-local address on different interfaces. Other hosts are compared literally.
+func hostsEqual(host string, ip net.IP, otherHost string, otherIP net.IP) bool {
+	if ip != nil && otherIP != nil {
+		_, zone := splitZone(host)
+		_, otherZone := splitZone(otherHost)
+		return ip.Equal(otherIP) && zone == otherZone
+	}
+	return host == otherHost
+}
+
+// Equals tells if other describes the same member: address, identity and all named ports must match.
+// A nil port map is considered equal to an empty one.
+func (hi HostInfo) Equals(other HostInfo) bool {
+	if hi.addr != other.addr || hi.identity != other.identity {
+		return false
+	}
+	if len(hi.portMap) != len(other.portMap) {
+		return false
+	}
+	for name, port := range hi.portMap {
+		if otherPort, ok := other.portMap[name]; !ok || otherPort != port {
+			return false
+		}
+	}
+	return true
+}
+
+// SameHost tells if other is running on the same host, only the IP (or hostname) is compared
+func (hi HostInfo) SameHost(other HostInfo) bool {
+	return hostsEqual(hi.host, hi.ip, other.host, other.ip)
+}
+
+// Key returns a string uniquely describing the member's address, identity and named ports,
+// to be used as a map key since HostInfo itself is not comparable.
+// The key only depends on these values, so it is stable across process restarts.
+func (hi HostInfo) Key() string {
+	var b strings.Builder
+	b.WriteString(strconv.Quote(hi.addr))
+	b.WriteString(strconv.Quote(hi.identity))
+	for _, name := range hi.portMap.names() {
+		b.WriteString(strconv.Quote(name))
+		b.WriteString(strconv.Itoa(int(hi.portMap[name])))
+	}
+	return b.String()
+}
+
+// HashKey returns the value used to place the member on the consistent hash ring.
+// It is the member identity, falling back to the address when identity is not set.
+// Changing it changes the placement of every key owned by the member.
+func (hi HostInfo) HashKey() string {
+	return hi.Identity()
+}
+
 // Identity implements ringpop's Membership interface
 func (hi HostInfo) Identity() string {
 	// if identity is not set, return address
@@ -122,16 +938,213 @@ func (hi HostInfo) Identity() string {
 	return hi.identity
 }
 
-// Label is a noop function to conform to ringpop hashring member interface
+// HasIdentity tells if identity was explicitly set for the member
+func (hi HostInfo) HasIdentity() bool {
+	return hi.identity != ""
+}
+
+// WithIdentity returns a copy of HostInfo with identity set
+func (hi HostInfo) WithIdentity(identity string) HostInfo {
+	hi.identity = identity
+	return hi
+}
+
+// IdentityOrEmpty returns the identity of the member, or an empty string if not set.
+// Unlike Identity, it never falls back to the address.
+func (hi HostInfo) IdentityOrEmpty() string {
+	return hi.identity
+}
+
+// TagValue returns the identity (or address if identity is not set) usable as a metrics tag value.
+// Following metrics tag sanitization rules, every character that is not an ASCII letter, digit
+// or underscore is replaced with an underscore, e.g. "127.0.0.1:7933" becomes "127_0_0_1_7933".
+func (hi HostInfo) TagValue() string {
+	return strings.Map(func(r rune) rune {
+		if r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, hi.Identity())
+}
+
+// Label returns the value of the label set with WithLabel
 func (hi HostInfo) Label(key string) (value string, has bool) {
-	return "", false
+	value, has = hi.labels[key]
+	return value, has
+}
+
+// DrainedLabel returns the label which is set to "true" on hosts draining from the ring of the service only.
+// Rings of the service report such members drained, as if they had LabelDrained set.
+func DrainedLabel(service Service) string {
+	return LabelDrained + "_" + string(service)
 }
 
-// SetLabel is a noop function to conform to ringpop hashring member interface
+// IsDrained returns true if host is draining and should not own keys on the ring
+func (hi HostInfo) IsDrained() bool {
+	value, _ := hi.Label(LabelDrained)
+	return value == "true"
+}
+
+// SetLabel is a noop function to conform to ringpop hashring member interface.
+// HostInfo is a value type, use WithLabel to get a copy with the label set.
 func (hi HostInfo) SetLabel(key string, value string) {
 }
 
+// WithLabel returns a copy of HostInfo with the label set to value
+func (hi HostInfo) WithLabel(key string, value string) HostInfo {
+	labels := make(map[string]string, len(hi.labels)+1)
+	for k, v := range hi.labels {
+		labels[k] = v
+	}
+	labels[key] = value
+	hi.labels = labels
+	return hi
+}
+
 // String will return a human-readable host details
 func (hi HostInfo) String() string {
 	return fmt.Sprintf("addr: %s, identity: %s, portMap: %s", hi.addr, hi.identity, hi.portMap)
 }
+
+// Format implements fmt.Formatter to control verbosity:
+// %v prints the address only, %+v adds the identity, %#v and %s print full details as String does.
+func (hi HostInfo) Format(f fmt.State, verb rune) {
+	switch verb {
+	case 'v':
+		switch {
+		case f.Flag('#'):
+			fmt.Fprint(f, hi.String())
+		case f.Flag('+'):
+			fmt.Fprintf(f, "addr: %s, identity: %s", hi.addr, hi.identity)
+		default:
+			fmt.Fprint(f, hi.addr)
+		}
+	case 's':
+		fmt.Fprint(f, hi.String())
+	case 'q':
+		fmt.Fprint(f, strconv.Quote(hi.String()))
+	default:
+		fmt.Fprintf(f, "%%!%c(membership.HostInfo=%s)", verb, hi.String())
+	}
+}
+
+// MarshalJSON implements json.Marshaler
+func (hi HostInfo) MarshalJSON() ([]byte, error) {
+	var status string
+	if hi.status != StatusUnknown {
+		status = hi.status.String()
+	}
+	return json.Marshal(hostInfoJSON{
+		Address:  hi.addr,
+		Identity: hi.identity,
+		Ports:    hi.portMap,
+		Labels:   hi.labels,
+		Weight:   hi.weight,
+		Status:   status,
+	})
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (hi *HostInfo) UnmarshalJSON(data []byte) error {
+	var v hostInfoJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	status, err := parseStatus(v.Status)
+	if err != nil {
+		return err
+	}
+	var opts []HostInfoOption
+	if v.Weight != nil {
+		opts = append(opts, WithWeight(*v.Weight))
+	}
+	*hi = NewDetailedHostInfo(v.Address, v.Identity, v.Ports, opts...).WithStatus(status)
+	for key, value := range v.Labels {
+		*hi = hi.WithLabel(key, value)
+	}
+	return nil
+}
+
+// MarshalLogObject implements zapcore.ObjectMarshaler
+func (m PortMap) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	for name, port := range m {
+		enc.AddUint16(name, port)
+	}
+	return nil
+}
+
+// MarshalLogObject implements zapcore.ObjectMarshaler
+func (hi HostInfo) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddString("addr", hi.addr)
+	enc.AddString("identity", hi.identity)
+	return enc.AddObject("ports", hi.portMap)
+}
+
+// DiffHosts returns hosts present in new but not in old, and hosts present in old but not in new.
+// Hosts are compared by Key, so a host that changed its identity or ports is reported both as
+// removed and added. Hosts are returned in the order they appear in the input.
+func DiffHosts(old, new []HostInfo) (added, removed []HostInfo) {
+	oldKeys := make(map[string]struct{}, len(old))
+	for _, host := range old {
+		oldKeys[host.Key()] = struct{}{}
+	}
+	newKeys := make(map[string]struct{}, len(new))
+	for _, host := range new {
+		key := host.Key()
+		newKeys[key] = struct{}{}
+		if _, ok := oldKeys[key]; !ok {
+			added = append(added, host)
+		}
+	}
+	for _, host := range old {
+		if _, ok := newKeys[host.Key()]; !ok {
+			removed = append(removed, host)
+		}
+	}
+	return added, removed
+}
+
+// MarshalText implements encoding.TextMarshaler.
+// The text form is "addr|identity|name:port,name:port" with ports sorted by name.
+func (hi HostInfo) MarshalText() ([]byte, error) {
+	if strings.Contains(hi.addr, "|") || strings.Contains(hi.identity, "|") {
+		return nil, fmt.Errorf("host %q cannot be encoded as text: address and identity must not contain '|'", hi.addr)
+	}
+
+	ports := make([]string, 0, len(hi.portMap))
+	for _, name := range hi.portMap.names() {
+		if strings.ContainsAny(name, "|:,") {
+			return nil, fmt.Errorf("host %q cannot be encoded as text: port name %q must not contain '|', ':' or ','", hi.addr, name)
+		}
+		ports = append(ports, fmt.Sprintf("%s:%d", name, hi.portMap[name]))
+	}
+	return []byte(hi.addr + "|" + hi.identity + "|" + strings.Join(ports, ",")), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler, see MarshalText for the format
+func (hi *HostInfo) UnmarshalText(text []byte) error {
+	parts := strings.Split(string(text), "|")
+	if len(parts) != 3 {
+		return fmt.Errorf("invalid host %q: expected addr|identity|ports", text)
+	}
+
+	var portMap PortMap
+	if parts[2] != "" {
+		pairs := strings.Split(parts[2], ",")
+		portMap = make(PortMap, len(pairs))
+		for _, pair := range pairs {
+			i := strings.LastIndex(pair, ":")
+			if i <= 0 {
+				return fmt.Errorf("invalid host %q: expected name:port, got %q", text, pair)
+			}
+			port, err := strconv.ParseUint(pair[i+1:], 10, 16)
+			if err != nil {
+				return fmt.Errorf("invalid host %q: port %q: %w", text, pair, err)
+			}
+			portMap[pair[:i]] = uint16(port)
+		}
+	}
+
+	*hi = NewDetailedHostInfo(parts[0], parts[1], portMap)
+	return nil
+}
@@ -23,9 +23,24 @@
 package membership
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/multierr"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	"github.com/uber/cadence/common/log"
+	"github.com/uber/cadence/common/log/tag"
 )
 
 func TestBelongs(t *testing.T) {
@@ -56,3 +71,849 @@ func TestBelongs(t *testing.T) {
 	assert.False(t, belongs, "portmap has no such port, should return empty without an error")
 	assert.NoError(t, err)
 }
+
+func TestBelongsIPv6(t *testing.T) {
+	host := NewDetailedHostInfo("[::1]:7933", "dummy", PortMap{PortGRPC: 7833})
+
+	belongs, err := host.Belongs("[0:0:0:0:0:0:0:1]:7933")
+	assert.True(t, belongs, "expanded form should match compressed one")
+	assert.NoError(t, err)
+
+	belongs, err = host.Belongs("[0:0:0:0:0:0:0:1]:7833")
+	assert.True(t, belongs, "portmap should be checked for expanded form")
+	assert.NoError(t, err)
+
+	belongs, err = host.Belongs("[::2]:7933")
+	assert.False(t, belongs, "different IP, will result in false")
+	assert.NoError(t, err)
+
+	addr, err := host.GetNamedAddress(PortGRPC)
+	assert.NoError(t, err)
+	assert.Equal(t, "[::1]:7833", addr)
+	assert.Equal(t, "[::1]:7933", host.GetAddress(), "original address is preserved")
+}
+
+func TestGetNamedAddressPortNotSet(t *testing.T) {
+	host := NewDetailedHostInfo("127.0.0.1:1234", "dummy", PortMap{PortTchannel: 1234})
+
+	_, err := host.GetNamedAddress(PortGRPC)
+	assert.True(t, errors.Is(err, ErrPortNotSet))
+
+	var portErr *PortNotSetError
+	assert.True(t, errors.As(err, &portErr))
+	assert.Equal(t, PortGRPC, portErr.Port)
+	assert.Equal(t, "dummy", portErr.Identity)
+	assert.Equal(t, `port "grpc" is not set for addr: 127.0.0.1:1234, identity: dummy, portMap: tchannel:1234`, err.Error())
+}
+
+func TestNewPortMap(t *testing.T) {
+	ports := NewPortMap(7933, 7833)
+	assert.Equal(t, PortMap{PortTchannel: 7933, PortGRPC: 7833}, ports)
+	assert.NoError(t, ports.Validate())
+	assert.Equal(t, PortMap{PortTchannel: 7933}, NewPortMap(7933, 0), "unset ports are not advertised")
+}
+
+func TestPortMapClone(t *testing.T) {
+	assert.Nil(t, PortMap(nil).Clone())
+
+	ports := PortMap{PortTchannel: 1234, PortGRPC: 1235}
+	clone := ports.Clone()
+	assert.Equal(t, ports, clone)
+
+	clone[PortGRPC] = 4321
+	assert.Equal(t, uint16(1235), ports[PortGRPC])
+}
+
+func TestNewDetailedHostInfoCopiesPortMap(t *testing.T) {
+	ports := PortMap{PortTchannel: 1234}
+	host := NewDetailedHostInfo("127.0.0.1:1234", "dummy", ports)
+
+	ports[PortTchannel] = 4321
+	ports[PortGRPC] = 1235
+	assert.Equal(t, PortMap{PortTchannel: 1234}, host.Ports())
+	assert.Equal(t, 1, host.PortCount())
+
+	host.Ports()[PortTchannel] = 1
+	assert.Equal(t, PortMap{PortTchannel: 1234}, host.Ports(), "returned ports are a copy")
+}
+
+func TestEquals(t *testing.T) {
+	tests := []struct {
+		name   string
+		a, b   HostInfo
+		equals bool
+	}{
+		{
+			name:   "empty hosts",
+			a:      HostInfo{},
+			b:      HostInfo{},
+			equals: true,
+		},
+		{
+			name:   "nil and empty port maps",
+			a:      NewDetailedHostInfo("127.0.0.1:1234", "dummy", nil),
+			b:      NewDetailedHostInfo("127.0.0.1:1234", "dummy", PortMap{}),
+			equals: true,
+		},
+		{
+			name:   "same port maps",
+			a:      NewDetailedHostInfo("127.0.0.1:1234", "dummy", PortMap{PortTchannel: 1234, PortGRPC: 1235}),
+			b:      NewDetailedHostInfo("127.0.0.1:1234", "dummy", PortMap{PortGRPC: 1235, PortTchannel: 1234}),
+			equals: true,
+		},
+		{
+			name:   "mismatched identities",
+			a:      NewDetailedHostInfo("127.0.0.1:1234", "dummy", nil),
+			b:      NewDetailedHostInfo("127.0.0.1:1234", "other", nil),
+			equals: false,
+		},
+		{
+			name:   "mismatched port numbers",
+			a:      NewDetailedHostInfo("127.0.0.1:1234", "dummy", PortMap{PortGRPC: 1235}),
+			b:      NewDetailedHostInfo("127.0.0.1:1234", "dummy", PortMap{PortGRPC: 1236}),
+			equals: false,
+		},
+		{
+			name:   "mismatched port names",
+			a:      NewDetailedHostInfo("127.0.0.1:1234", "dummy", PortMap{PortGRPC: 1235}),
+			b:      NewDetailedHostInfo("127.0.0.1:1234", "dummy", PortMap{PortTchannel: 1235}),
+			equals: false,
+		},
+		{
+			name:   "empty and non-empty port maps",
+			a:      NewDetailedHostInfo("127.0.0.1:1234", "dummy", nil),
+			b:      NewDetailedHostInfo("127.0.0.1:1234", "dummy", PortMap{PortGRPC: 1235}),
+			equals: false,
+		},
+		{
+			name:   "mismatched addresses",
+			a:      NewHostInfo("127.0.0.1:1234"),
+			b:      NewHostInfo("127.0.0.1:1235"),
+			equals: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.equals, tt.a.Equals(tt.b))
+			assert.Equal(t, tt.equals, tt.b.Equals(tt.a))
+		})
+	}
+}
+
+func TestSameHost(t *testing.T) {
+	assert.True(t, NewHostInfo("127.0.0.1:1234").SameHost(NewHostInfo("127.0.0.1:4321")))
+	assert.True(t, NewHostInfo("[::1]:1234").SameHost(NewHostInfo("[0:0:0:0:0:0:0:1]:4321")))
+	assert.True(t, NewHostInfo("worker-1:1234").SameHost(NewHostInfo("worker-1:4321")))
+	assert.False(t, NewHostInfo("127.0.0.1:1234").SameHost(NewHostInfo("127.0.0.2:1234")))
+	assert.False(t, NewHostInfo("worker-1:1234").SameHost(NewHostInfo("worker-2:1234")))
+}
+
+func TestNewHostInfoValidated(t *testing.T) {
+	host, err := NewHostInfoValidated("127.0.0.1:1234")
+	assert.NoError(t, err)
+	assert.Equal(t, NewHostInfo("127.0.0.1:1234"), host)
+
+	for _, addr := range []string{"", "localhost", "127.0.0.1", ":1234", "127.0.0.1:", "[::1"} {
+		_, err := NewHostInfoValidated(addr)
+		assert.Error(t, err, "address %q should be rejected", addr)
+	}
+}
+
+func TestHostInfoJSON(t *testing.T) {
+	host := NewDetailedHostInfo("[::1]:1234", "dummy", PortMap{PortTchannel: 1234, PortGRPC: 1235})
+
+	data, err := json.Marshal(host)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"address":"[::1]:1234","identity":"dummy","ports":{"grpc":1235,"tchannel":1234}}`, string(data))
+
+	var decoded HostInfo
+	assert.NoError(t, json.Unmarshal(data, &decoded))
+	assert.True(t, host.Equals(decoded))
+	assert.True(t, host.SameHost(decoded), "ip should be recomputed")
+
+	var hosts []HostInfo
+	assert.NoError(t, json.Unmarshal([]byte(`[{"address":"127.0.0.1:1234"}]`), &hosts))
+	assert.Equal(t, []HostInfo{NewHostInfo("127.0.0.1:1234")}, hosts)
+
+	assert.Error(t, json.Unmarshal([]byte(`{"address":1}`), &decoded))
+
+	drained := NewHostInfo("127.0.0.1:1234").WithLabel(LabelDrained, "true")
+	data, err = json.Marshal(drained)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"address":"127.0.0.1:1234","labels":{"drained":"true"}}`, string(data))
+	assert.NoError(t, json.Unmarshal(data, &decoded))
+	assert.True(t, decoded.IsDrained(), "labels are preserved")
+
+	weighted := NewDetailedHostInfo("127.0.0.1:1234", "dummy", nil, WithWeight(0)).WithStatus(StatusSuspect)
+	data, err = json.Marshal(weighted)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"address":"127.0.0.1:1234","identity":"dummy","weight":0,"status":"suspect"}`, string(data))
+	assert.NoError(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, 0, decoded.GetWeight(), "weight is preserved")
+	assert.Equal(t, StatusSuspect, decoded.GetStatus(), "status is preserved")
+
+	assert.NoError(t, json.Unmarshal([]byte(`{"address":"127.0.0.1:1234"}`), &decoded))
+	assert.Equal(t, defaultWeight, decoded.GetWeight())
+	assert.Equal(t, StatusUnknown, decoded.GetStatus())
+	assert.Error(t, json.Unmarshal([]byte(`{"address":"127.0.0.1:1234","status":"sleepy"}`), &decoded))
+}
+
+func TestGetNamedAddressOrDefault(t *testing.T) {
+	host := NewDetailedHostInfo("127.0.0.1:1234", "dummy", PortMap{PortGRPC: 1235})
+	assert.Equal(t, "127.0.0.1:1235", host.GetNamedAddressOrDefault(PortGRPC))
+	assert.Equal(t, "127.0.0.1:1234", host.GetNamedAddressOrDefault(PortTchannel))
+}
+
+func TestPortMapString(t *testing.T) {
+	ports := PortMap{"c": 3, PortTchannel: 1234, "a": 0, PortGRPC: 1235, "b": 2}
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, "a:0, b:2, c:3, grpc:1235, tchannel:1234", ports.String())
+	}
+	assert.Equal(t, "", PortMap{}.String())
+}
+
+func TestWithPorts(t *testing.T) {
+	host := NewDetailedHostInfo("127.0.0.1:1234", "dummy", PortMap{PortTchannel: 1234, PortGRPC: 1235})
+	merged := host.WithPorts(PortMap{PortGRPC: 4321, "http": 8080})
+	assert.Equal(t, PortMap{PortTchannel: 1234, PortGRPC: 4321, "http": 8080}, merged.Ports())
+	assert.Equal(t, PortMap{PortTchannel: 1234, PortGRPC: 1235}, host.Ports(), "original host is not modified")
+	assert.Equal(t, host.GetAddress(), merged.GetAddress())
+	assert.Equal(t, host.Identity(), merged.Identity())
+
+	empty := NewHostInfo("127.0.0.1:1234").WithPorts(PortMap{PortGRPC: 1235})
+	assert.Equal(t, PortMap{PortGRPC: 1235}, empty.Ports())
+}
+
+func TestGetIP(t *testing.T) {
+	assert.Equal(t, "127.0.0.1", NewHostInfo("127.0.0.1:1234").GetIP())
+	assert.Equal(t, "::1", NewHostInfo("[0:0:0:0:0:0:0:1]:1234").GetIP())
+	assert.Equal(t, "", NewHostInfo("worker-1:1234").GetIP())
+	assert.Equal(t, "", NewHostInfo("127.0.0.1").GetIP())
+}
+
+func TestHostInfoMarshalLogObject(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	host := NewDetailedHostInfo("127.0.0.1:1234", "dummy", PortMap{PortTchannel: 1234, PortGRPC: 1235})
+
+	zap.New(core).Info("routing", zap.Object("host", host))
+
+	assert.Equal(t, 1, logs.Len())
+	assert.Equal(t, map[string]interface{}{
+		"host": map[string]interface{}{
+			"addr":     "127.0.0.1:1234",
+			"identity": "dummy",
+			"ports": map[string]interface{}{
+				PortTchannel: uint16(1234),
+				PortGRPC:     uint16(1235),
+			},
+		},
+	}, logs.All()[0].ContextMap())
+}
+
+func TestLogSelfPrintsAllEndpoints(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	logger := &log.MockLogger{}
+	logger.On("Info", "local host endpoints", mock.Anything).Run(func(args mock.Arguments) {
+		var fields []zap.Field
+		for _, t := range args.Get(1).([]tag.Tag) {
+			fields = append(fields, t.Field())
+		}
+		zap.New(core).Info(args.String(0), fields...)
+	}).Once()
+	host := NewDetailedHostInfo("127.0.0.1:1234", "dummy", PortMap{PortTchannel: 1234, PortGRPC: 1235})
+
+	host.LogSelf(logger)
+
+	logger.AssertExpectations(t)
+	require.Equal(t, 1, logs.Len())
+	fields := logs.All()[0].ContextMap()
+	assert.Equal(t, "127.0.0.1:1234", fields["address"])
+	assert.Equal(t, "dummy", fields["name"])
+	assert.Equal(t, "map[grpc:127.0.0.1:1235 tchannel:127.0.0.1:1234]", fields["value"])
+}
+
+type fakeAddressResolver struct {
+	hosts map[string][]net.IP
+	calls int
+}
+
+func (r *fakeAddressResolver) LookupIP(ctx context.Context, host string) ([]net.IP, error) {
+	r.calls++
+	if ips, ok := r.hosts[host]; ok {
+		return ips, nil
+	}
+	return nil, fmt.Errorf("host %q not found", host)
+}
+
+func TestBelongsWithResolver(t *testing.T) {
+	resolver := &fakeAddressResolver{hosts: map[string][]net.IP{
+		"worker-1.svc": {net.ParseIP("10.0.0.1"), net.ParseIP("fd00::1")},
+		"worker-2.svc": {net.ParseIP("10.0.0.2")},
+	}}
+	host := NewDetailedHostInfo("worker-1.svc:7933", "worker-1", PortMap{PortGRPC: 7833})
+
+	belongs, err := host.BelongsWithResolver("worker-1.svc:7933", resolver)
+	assert.True(t, belongs)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, resolver.calls, "literal match should not resolve")
+
+	belongs, err = host.BelongsWithResolver("10.0.0.1:7933", resolver)
+	assert.True(t, belongs, "resolved IP should match")
+	assert.NoError(t, err)
+
+	belongs, err = host.BelongsWithResolver("[fd00::1]:7833", resolver)
+	assert.True(t, belongs, "any of resolved IPs should match on a named port")
+	assert.NoError(t, err)
+
+	belongs, err = host.BelongsWithResolver("10.0.0.2:7933", resolver)
+	assert.False(t, belongs)
+	assert.NoError(t, err)
+
+	calls := resolver.calls
+	belongs, err = host.BelongsWithResolver("10.0.0.1:1111", resolver)
+	assert.False(t, belongs, "port mismatch")
+	assert.NoError(t, err)
+	assert.Equal(t, calls, resolver.calls, "port mismatch should not resolve")
+
+	belongs, err = host.BelongsWithResolver("unknown.svc:7933", resolver)
+	assert.False(t, belongs)
+	assert.Error(t, err)
+
+	_, err = host.BelongsWithResolver("invalid", resolver)
+	assert.Error(t, err)
+}
+
+func TestPortMapValidate(t *testing.T) {
+	assert.NoError(t, PortMap(nil).Validate())
+	assert.NoError(t, PortMap{PortTchannel: 7933, PortGRPC: 7833}.Validate())
+	assert.EqualError(t, PortMap{PortTchannel: 7933, PortGRPC: 7933}.Validate(), `ports "grpc" and "tchannel" are both set to 7933`)
+	assert.EqualError(t, PortMap{PortTchannel: 7933, PortGRPC: 0}.Validate(), `port "grpc" is set to 0`)
+}
+
+func TestNewDetailedHostInfoValidated(t *testing.T) {
+	host, err := NewDetailedHostInfoValidated("127.0.0.1:7933", "dummy", PortMap{PortTchannel: 7933, PortGRPC: 7833})
+	assert.NoError(t, err)
+	assert.True(t, NewDetailedHostInfo("127.0.0.1:7933", "dummy", PortMap{PortTchannel: 7933, PortGRPC: 7833}).Equals(host))
+
+	_, err = NewDetailedHostInfoValidated("127.0.0.1", "dummy", PortMap{PortTchannel: 7933})
+	assert.Error(t, err)
+	_, err = NewDetailedHostInfoValidated("127.0.0.1:7933", "dummy", PortMap{PortTchannel: 7933, PortGRPC: 7933})
+	assert.Error(t, err)
+}
+
+func TestNewHostInfoFromAddr(t *testing.T) {
+	host := NewHostInfoFromAddr(&net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 7933})
+	assert.Equal(t, "127.0.0.1:7933", host.GetAddress())
+	assert.Equal(t, "127.0.0.1", host.GetIP())
+
+	host = NewHostInfoFromAddr(&net.UDPAddr{IP: net.ParseIP("::1"), Port: 7933})
+	assert.Equal(t, "[::1]:7933", host.GetAddress())
+	assert.Equal(t, "::1", host.GetIP())
+
+	host = NewHostInfoFromAddr(&net.TCPAddr{IP: net.ParseIP("fe80::1"), Port: 7933, Zone: "eth0"})
+	assert.Equal(t, "[fe80::1%eth0]:7933", host.GetAddress())
+	assert.Equal(t, "fe80::1", host.GetIP())
+	belongs, err := host.Belongs("[fe80::1%eth0]:7933")
+	assert.True(t, belongs)
+	assert.NoError(t, err)
+
+	assert.Equal(t, "eth0", host.GetIPZone())
+
+	host = NewHostInfoFromAddr(&net.UnixAddr{Name: "/tmp/cadence.sock", Net: "unix"})
+	assert.Equal(t, "/tmp/cadence.sock", host.GetAddress())
+
+	host = NewHostInfoFromAddr(&net.TCPAddr{Port: 7933})
+	assert.Equal(t, ":7933", host.GetAddress())
+	assert.NotContains(t, host.String(), "<nil>")
+}
+
+func TestIPv6ZonesAreMatched(t *testing.T) {
+	zoned := NewHostInfo("[fe80::1%eth0]:7933")
+	assert.Equal(t, "fe80::1", zoned.GetIP())
+	assert.Equal(t, "eth0", zoned.GetIPZone())
+	assert.Equal(t, "", NewHostInfo("[fe80::1]:7933").GetIPZone())
+	assert.Equal(t, "", NewHostInfo("worker-1%eth0:7933").GetIPZone())
+
+	for addr, expected := range map[string]bool{
+		"[fe80::1%eth0]:7933":   true,
+		"[fe80:0::1%eth0]:7933": true,
+		"[fe80::1]:7933":        false,
+		"[fe80::1%eth1]:7933":   false,
+	} {
+		belongs, err := zoned.Belongs(addr)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, belongs, addr)
+	}
+	belongs, err := NewHostInfo("[fe80::1]:7933").Belongs("[fe80::1%eth0]:7933")
+	assert.NoError(t, err)
+	assert.False(t, belongs, "unzoned host doesn't match a zoned address")
+
+	assert.True(t, zoned.SameHost(NewHostInfoFromAddr(&net.TCPAddr{IP: net.ParseIP("fe80::1"), Port: 7934, Zone: "eth0"})))
+	assert.False(t, zoned.SameHost(NewHostInfoFromAddr(&net.TCPAddr{IP: net.ParseIP("fe80::1"), Port: 7933, Zone: "eth1"})))
+	assert.False(t, zoned.SameHost(NewHostInfo("[fe80::1]:7933")))
+}
+
+func TestGetNamedAddresses(t *testing.T) {
+	host := NewDetailedHostInfo("127.0.0.1:7933", "dummy", PortMap{PortTchannel: 7933, PortGRPC: 7833})
+	addrs, err := host.GetNamedAddresses(PortTchannel, PortGRPC)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{PortTchannel: "127.0.0.1:7933", PortGRPC: "127.0.0.1:7833"}, addrs)
+
+	addrs, err = NewHostInfo("127.0.0.1:7933").GetNamedAddresses(PortTchannel, PortGRPC)
+	assert.Nil(t, addrs)
+	assert.True(t, errors.Is(err, ErrPortNotSet))
+	var missing []string
+	for _, e := range multierr.Errors(err) {
+		var portErr *PortNotSetError
+		assert.True(t, errors.As(e, &portErr))
+		missing = append(missing, portErr.Port)
+	}
+	assert.Equal(t, []string{PortTchannel, PortGRPC}, missing)
+}
+
+func TestKey(t *testing.T) {
+	host := NewDetailedHostInfo("127.0.0.1:7933", "dummy", PortMap{PortTchannel: 7933, PortGRPC: 7833})
+	assert.Equal(t, `"127.0.0.1:7933""dummy""grpc"7833"tchannel"7933`, host.Key())
+	assert.Equal(t, host.Key(), NewDetailedHostInfo("127.0.0.1:7933", "dummy", PortMap{PortGRPC: 7833, PortTchannel: 7933}).Key())
+	assert.Equal(t, NewHostInfo("127.0.0.1:7933").Key(), NewDetailedHostInfo("127.0.0.1:7933", "", PortMap{}).Key())
+
+	differentPorts := NewDetailedHostInfo("127.0.0.1:7933", "dummy", PortMap{PortTchannel: 7933, PortGRPC: 7834})
+	assert.NotEqual(t, host.Key(), differentPorts.Key())
+
+	// values with separators in them should not collide
+	assert.NotEqual(t,
+		NewDetailedHostInfo("a", `b""c`, nil).Key(),
+		NewDetailedHostInfo(`a""b`, "c", nil).Key(),
+	)
+}
+
+func TestDiffHosts(t *testing.T) {
+	a := NewDetailedHostInfo("127.0.0.1:7933", "a", nil)
+	b := NewDetailedHostInfo("127.0.0.2:7933", "b", nil)
+	c := NewDetailedHostInfo("127.0.0.3:7933", "c", nil)
+	renamedA := NewDetailedHostInfo("127.0.0.1:7933", "a2", nil)
+
+	tests := []struct {
+		name           string
+		old, new       []HostInfo
+		added, removed []HostInfo
+	}{
+		{name: "empty"},
+		{name: "no changes", old: []HostInfo{a, b}, new: []HostInfo{b, a}},
+		{name: "host added", old: []HostInfo{a}, new: []HostInfo{a, b, c}, added: []HostInfo{b, c}},
+		{name: "host removed", old: []HostInfo{a, b, c}, new: []HostInfo{b}, removed: []HostInfo{a, c}},
+		{name: "identity changed", old: []HostInfo{a, b}, new: []HostInfo{renamedA, b}, added: []HostInfo{renamedA}, removed: []HostInfo{a}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			added, removed := DiffHosts(tt.old, tt.new)
+			assert.Equal(t, tt.added, added)
+			assert.Equal(t, tt.removed, removed)
+		})
+	}
+}
+
+func TestGetNamedURL(t *testing.T) {
+	host := NewDetailedHostInfo("127.0.0.1:7933", "dummy", PortMap{PortTchannel: 7933}, WithDetailedPorts(DetailedPortMap{
+		PortGRPC: {Number: 7833, TLS: true},
+		"http":   {Number: 8080, Scheme: "https", TLS: true},
+	}))
+	assert.Equal(t, PortMap{PortTchannel: 7933, PortGRPC: 7833, "http": 8080}, host.Ports())
+
+	url, err := host.GetNamedURL(PortGRPC)
+	assert.NoError(t, err)
+	assert.Equal(t, "grpcs://127.0.0.1:7833", url)
+
+	url, err = host.GetNamedURL("http")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://127.0.0.1:8080", url)
+
+	url, err = host.GetNamedURL(PortTchannel)
+	assert.NoError(t, err)
+	assert.Equal(t, "tchannel://127.0.0.1:7933", url)
+
+	addr, err := host.GetNamedAddress(PortGRPC)
+	assert.NoError(t, err)
+	assert.Equal(t, "127.0.0.1:7833", addr)
+
+	_, err = NewHostInfo("127.0.0.1:7933").GetNamedURL(PortGRPC)
+	assert.True(t, errors.Is(err, ErrPortNotSet))
+}
+
+func TestLabels(t *testing.T) {
+	host := NewHostInfo("127.0.0.1:7933")
+	_, has := host.Label("zone")
+	assert.False(t, has)
+
+	labeled := host.WithLabel("zone", "us-east-1a")
+	value, has := labeled.Label("zone")
+	assert.True(t, has)
+	assert.Equal(t, "us-east-1a", value)
+	_, has = host.Label("zone")
+	assert.False(t, has, "original host is not modified")
+
+	overwritten := labeled.WithLabel("zone", "us-east-1b")
+	value, _ = overwritten.Label("zone")
+	assert.Equal(t, "us-east-1b", value)
+	value, _ = labeled.Label("zone")
+	assert.Equal(t, "us-east-1a", value, "labels are not shared between copies")
+
+	labeled.SetLabel("zone", "us-west-1a")
+	value, _ = labeled.Label("zone")
+	assert.Equal(t, "us-east-1a", value, "SetLabel is a noop")
+}
+
+func TestIsDrained(t *testing.T) {
+	host := NewHostInfo("127.0.0.1:7933")
+	assert.False(t, host.IsDrained())
+	assert.True(t, host.WithLabel(LabelDrained, "true").IsDrained())
+	assert.False(t, host.WithLabel(LabelDrained, "false").IsDrained())
+}
+
+func TestTagValue(t *testing.T) {
+	assert.Equal(t, "127_0_0_1_7933", NewHostInfo("127.0.0.1:7933").TagValue())
+	assert.Equal(t, "___1__7933", NewHostInfo("[::1]:7933").TagValue())
+	assert.Equal(t, "worker_3_svc", NewDetailedHostInfo("127.0.0.1:7933", "worker-3.svc", nil).TagValue())
+	assert.Equal(t, "a_b_c_D_9", NewDetailedHostInfo("127.0.0.1:7933", "a b,c D_9", nil).TagValue())
+}
+
+func TestBelongsAnyAll(t *testing.T) {
+	host := NewDetailedHostInfo("127.0.0.1:7933", "dummy", PortMap{PortGRPC: 7833})
+
+	belongs, err := host.BelongsAny([]string{"127.0.0.2:7933", "127.0.0.1:7833"})
+	assert.True(t, belongs)
+	assert.NoError(t, err)
+
+	belongs, err = host.BelongsAny([]string{"127.0.0.2:7933", "127.0.0.1:1111"})
+	assert.False(t, belongs)
+	assert.NoError(t, err)
+
+	belongs, err = host.BelongsAny([]string{"invalid", "127.0.0.1:7833", "invalid2"})
+	assert.True(t, belongs, "valid addresses are checked after an invalid one")
+	assert.Error(t, err)
+
+	belongs, err = host.BelongsAny(nil)
+	assert.False(t, belongs)
+	assert.NoError(t, err)
+
+	belongs, err = host.BelongsAll([]string{"127.0.0.1:7933", "127.0.0.1:7833"})
+	assert.True(t, belongs)
+	assert.NoError(t, err)
+
+	belongs, err = host.BelongsAll([]string{"127.0.0.1:7933", "127.0.0.2:7833"})
+	assert.False(t, belongs)
+	assert.NoError(t, err)
+
+	belongs, err = host.BelongsAll([]string{"127.0.0.1:7933", "invalid"})
+	assert.False(t, belongs)
+	assert.Error(t, err)
+}
+
+func TestIdentity(t *testing.T) {
+	host := NewHostInfo("127.0.0.1:7933")
+	assert.False(t, host.HasIdentity())
+	assert.Equal(t, "", host.IdentityOrEmpty())
+	assert.Equal(t, "127.0.0.1:7933", host.Identity())
+
+	host = NewDetailedHostInfo("127.0.0.1:7933", "dummy", nil)
+	assert.True(t, host.HasIdentity())
+	assert.Equal(t, "dummy", host.IdentityOrEmpty())
+	assert.Equal(t, "dummy", host.Identity())
+}
+
+func TestWeight(t *testing.T) {
+	assert.Equal(t, 1, NewHostInfo("127.0.0.1:7933").GetWeight())
+	assert.Equal(t, 1, NewDetailedHostInfo("127.0.0.1:7933", "dummy", nil).GetWeight())
+	assert.Equal(t, 4, NewDetailedHostInfo("127.0.0.1:7933", "dummy", nil, WithWeight(4)).GetWeight())
+	assert.Equal(t, 0, NewDetailedHostInfo("127.0.0.1:7933", "dummy", nil, WithWeight(0)).GetWeight())
+	assert.Equal(t, 0, NewDetailedHostInfo("127.0.0.1:7933", "dummy", nil, WithWeight(-1)).GetWeight())
+}
+
+func TestIsLoopbackIsPrivate(t *testing.T) {
+	tests := []struct {
+		addr     string
+		loopback bool
+		private  bool
+	}{
+		{addr: "127.0.0.1:7933", loopback: true},
+		{addr: "[::1]:7933", loopback: true},
+		{addr: "10.0.0.1:7933", private: true},
+		{addr: "[fd00::1]:7933", private: true},
+		{addr: "8.8.8.8:7933"},
+		{addr: "localhost:7933"},
+	}
+	for _, tt := range tests {
+		host := NewHostInfo(tt.addr)
+		assert.Equal(t, tt.loopback, host.IsLoopback(), tt.addr)
+		assert.Equal(t, tt.private, host.IsPrivate(), tt.addr)
+	}
+}
+
+func TestNewHostInfos(t *testing.T) {
+	hosts, err := NewHostInfos([]string{"127.0.0.1:7933", "[::1]:7933"})
+	assert.NoError(t, err)
+	assert.Equal(t, []HostInfo{NewHostInfo("127.0.0.1:7933"), NewHostInfo("[::1]:7933")}, hosts)
+
+	hosts, err = NewHostInfos([]string{"127.0.0.1:7933", "localhost", "127.0.0.2:7933", ":7933"})
+	assert.Nil(t, hosts)
+	assert.Len(t, multierr.Errors(err), 2)
+	assert.Contains(t, err.Error(), `"localhost"`)
+	assert.Contains(t, err.Error(), `":7933"`)
+
+	hosts, err = NewHostInfos(nil)
+	assert.NoError(t, err)
+	assert.Empty(t, hosts)
+}
+
+func TestGetPort(t *testing.T) {
+	host := NewDetailedHostInfo("127.0.0.1:7933", "dummy", PortMap{PortGRPC: 7833})
+
+	port, ok := host.GetPort(PortGRPC)
+	assert.True(t, ok)
+	assert.Equal(t, uint16(7833), port)
+
+	port, ok = host.GetPort(PortTchannel)
+	assert.False(t, ok)
+	assert.Equal(t, uint16(0), port)
+
+	_, ok = NewHostInfo("127.0.0.1:7933").GetPort(PortGRPC)
+	assert.False(t, ok, "nil port map")
+}
+
+func TestInSubnet(t *testing.T) {
+	in, err := NewHostInfo("10.1.2.3:7933").InSubnet("10.1.0.0/16")
+	assert.NoError(t, err)
+	assert.True(t, in)
+
+	in, err = NewHostInfo("10.2.2.3:7933").InSubnet("10.1.0.0/16")
+	assert.NoError(t, err)
+	assert.False(t, in)
+
+	in, err = NewHostInfo("[fd00::1]:7933").InSubnet("fd00::/8")
+	assert.NoError(t, err)
+	assert.True(t, in)
+
+	_, err = NewHostInfo("10.1.2.3:7933").InSubnet("10.1.0.0")
+	assert.Error(t, err, "invalid cidr")
+
+	_, err = NewHostInfo("worker-1:7933").InSubnet("10.1.0.0/16")
+	assert.Error(t, err, "host name instead of IP")
+
+	_, err = HostInfo{}.InSubnet("10.1.0.0/16")
+	assert.Error(t, err, "empty host")
+}
+
+func TestHostInfoText(t *testing.T) {
+	tests := []struct {
+		host HostInfo
+		text string
+	}{
+		{host: NewDetailedHostInfo("127.0.0.1:7933", "dummy", PortMap{PortTchannel: 7933, PortGRPC: 7833}), text: "127.0.0.1:7933|dummy|grpc:7833,tchannel:7933"},
+		{host: NewDetailedHostInfo("[::1]:7933", "", PortMap{PortGRPC: 7833}), text: "[::1]:7933||grpc:7833"},
+		{host: NewHostInfo("127.0.0.1:7933"), text: "127.0.0.1:7933||"},
+	}
+	for _, tt := range tests {
+		text, err := tt.host.MarshalText()
+		assert.NoError(t, err)
+		assert.Equal(t, tt.text, string(text))
+
+		var decoded HostInfo
+		assert.NoError(t, decoded.UnmarshalText(text))
+		assert.True(t, tt.host.Equals(decoded), tt.text)
+		assert.True(t, tt.host.SameHost(decoded), tt.text)
+	}
+
+	for _, text := range []string{"", "127.0.0.1:7933", "127.0.0.1:7933|dummy", "a|b|c|d", "127.0.0.1:7933||grpc", "127.0.0.1:7933||grpc:abc", "127.0.0.1:7933||grpc:70000", "127.0.0.1:7933||:7833"} {
+		var decoded HostInfo
+		assert.Error(t, decoded.UnmarshalText([]byte(text)), text)
+	}
+
+	_, err := NewDetailedHostInfo("127.0.0.1:7933", "a|b", nil).MarshalText()
+	assert.Error(t, err)
+	_, err = NewDetailedHostInfo("127.0.0.1:7933", "dummy", PortMap{"a,b": 1}).MarshalText()
+	assert.Error(t, err)
+}
+
+func TestPortLookupMissHook(t *testing.T) {
+	var misses []string
+	SetPortLookupMissHook(func(port string) {
+		misses = append(misses, port)
+	})
+	defer SetPortLookupMissHook(nil)
+
+	host := NewDetailedHostInfo("127.0.0.1:7933", "dummy", PortMap{PortTchannel: 7933})
+	_, err := host.GetNamedAddress(PortTchannel)
+	assert.NoError(t, err)
+	_, err = host.GetNamedAddress(PortGRPC)
+	assert.Error(t, err)
+	assert.Equal(t, []string{PortGRPC}, misses)
+
+	SetPortLookupMissHook(nil)
+	_, err = host.GetNamedAddress(PortGRPC)
+	assert.Error(t, err)
+	assert.Equal(t, []string{PortGRPC}, misses, "hook is removed")
+}
+
+func TestClone(t *testing.T) {
+	host := NewDetailedHostInfo("127.0.0.1:7933", "dummy", PortMap{PortTchannel: 7933}).WithLabel("zone", "a")
+	clone := host.Clone()
+	assert.Equal(t, host, clone)
+
+	clone.portMap[PortGRPC] = 7833
+	clone.labels["zone"] = "b"
+	clone.ip[0] = 10
+	assert.Equal(t, PortMap{PortTchannel: 7933}, host.Ports())
+	zone, _ := host.Label("zone")
+	assert.Equal(t, "a", zone)
+	assert.Equal(t, "127.0.0.1", host.GetIP())
+
+	assert.Equal(t, HostInfo{}, HostInfo{}.Clone())
+}
+
+func TestStatus(t *testing.T) {
+	host := NewHostInfo("127.0.0.1:7933")
+	assert.Equal(t, StatusUnknown, host.GetStatus())
+
+	suspect := host.WithStatus(StatusSuspect)
+	assert.Equal(t, StatusSuspect, suspect.GetStatus())
+	assert.Equal(t, StatusUnknown, host.GetStatus(), "original host is not modified")
+
+	assert.Equal(t, "unknown", StatusUnknown.String())
+	assert.Equal(t, "alive", StatusAlive.String())
+	assert.Equal(t, "suspect", StatusSuspect.String())
+	assert.Equal(t, "faulty", StatusFaulty.String())
+	assert.Equal(t, "unknown", Status(42).String())
+}
+
+func TestHashKey(t *testing.T) {
+	assert.Equal(t, "dummy", NewDetailedHostInfo("127.0.0.1:7933", "dummy", PortMap{PortGRPC: 7833}).HashKey())
+	assert.Equal(t, "127.0.0.1:7933", NewHostInfo("127.0.0.1:7933").HashKey())
+	assert.Equal(t,
+		NewDetailedHostInfo("127.0.0.1:7933", "dummy", nil).HashKey(),
+		NewDetailedHostInfo("127.0.0.2:7933", "dummy", PortMap{PortGRPC: 7833}).WithLabel("zone", "a").HashKey(),
+		"only identity affects hash key",
+	)
+}
+
+type fakePortResolver struct {
+	ports map[string]uint16
+	delay time.Duration
+}
+
+func (r *fakePortResolver) LookupPort(ctx context.Context, host HostInfo, port string) (uint16, error) {
+	time.Sleep(r.delay)
+	if number, ok := r.ports[port]; ok {
+		return number, nil
+	}
+	return 0, fmt.Errorf("port %q not found", port)
+}
+
+func TestGetNamedAddressCtx(t *testing.T) {
+	resolver := &fakePortResolver{ports: map[string]uint16{PortGRPC: 7833}}
+	host := NewDetailedHostInfo("127.0.0.1:7933", "dummy", PortMap{PortTchannel: 7933}, WithPortResolver(resolver))
+
+	addr, err := host.GetNamedAddressCtx(context.Background(), PortTchannel)
+	assert.NoError(t, err)
+	assert.Equal(t, "127.0.0.1:7933", addr)
+
+	addr, err = host.GetNamedAddressCtx(context.Background(), PortGRPC)
+	assert.NoError(t, err)
+	assert.Equal(t, "127.0.0.1:7833", addr)
+
+	_, err = host.GetNamedAddressCtx(context.Background(), "http")
+	assert.Error(t, err)
+	assert.False(t, errors.Is(err, ErrPortLookupTimeout))
+
+	resolver.delay = time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = host.GetNamedAddressCtx(ctx, PortGRPC)
+	assert.True(t, errors.Is(err, ErrPortLookupTimeout))
+
+	_, err = NewHostInfo("127.0.0.1:7933").GetNamedAddressCtx(context.Background(), PortGRPC)
+	assert.True(t, errors.Is(err, ErrPortNotSet), "without resolver behaves as GetNamedAddress")
+}
+
+func TestBindAddress(t *testing.T) {
+	host := NewDetailedHostInfo("1.2.3.4:7933", "dummy", PortMap{PortGRPC: 7833})
+	assert.Equal(t, "1.2.3.4:7933", host.GetBindAddress(), "defaults to advertised address")
+
+	host = NewDetailedHostInfo("1.2.3.4:7933", "dummy", PortMap{PortGRPC: 7833}, WithBindAddress("10.0.0.1:17933"))
+	assert.Equal(t, "10.0.0.1:17933", host.GetBindAddress())
+	assert.Equal(t, "1.2.3.4:7933", host.GetAddress())
+
+	for _, addr := range []string{"1.2.3.4:7933", "1.2.3.4:7833", "10.0.0.1:17933", "10.0.0.1:7833"} {
+		belongs, err := host.Belongs(addr)
+		assert.NoError(t, err)
+		assert.True(t, belongs, addr)
+	}
+	for _, addr := range []string{"10.0.0.2:17933", "10.0.0.1:1111"} {
+		belongs, err := host.Belongs(addr)
+		assert.NoError(t, err)
+		assert.False(t, belongs, addr)
+	}
+
+	belongs, err := host.BelongsAll([]string{"1.2.3.4:7933", "10.0.0.1:17933"})
+	assert.NoError(t, err)
+	assert.True(t, belongs)
+
+	clone := host.Clone()
+	assert.Equal(t, host, clone)
+	assert.False(t, host.bind == clone.bind, "clone does not share bind address")
+}
+
+func TestPortMapDiff(t *testing.T) {
+	actual := PortMap{PortTchannel: 7933, PortGRPC: 7833, "debug": 6060}
+	expected := PortMap{PortTchannel: 7933, PortGRPC: 7834, "http": 8080}
+
+	added, removed, changed := actual.Diff(expected)
+	assert.Equal(t, map[string]uint16{"http": 8080}, added)
+	assert.Equal(t, map[string]uint16{"debug": 6060}, removed)
+	assert.Equal(t, map[string]uint16{PortGRPC: 7834}, changed)
+
+	added, removed, changed = actual.Diff(actual.Clone())
+	assert.Empty(t, added)
+	assert.Empty(t, removed)
+	assert.Empty(t, changed)
+
+	added, removed, changed = PortMap(nil).Diff(PortMap{PortGRPC: 7833})
+	assert.Equal(t, map[string]uint16{PortGRPC: 7833}, added)
+	assert.Empty(t, removed)
+	assert.Empty(t, changed)
+}
+
+func TestFormat(t *testing.T) {
+	host := NewDetailedHostInfo("127.0.0.1:7933", "dummy", PortMap{PortTchannel: 7933, PortGRPC: 7833})
+	assert.Equal(t, "127.0.0.1:7933", fmt.Sprintf("%v", host))
+	assert.Equal(t, "addr: 127.0.0.1:7933, identity: dummy", fmt.Sprintf("%+v", host))
+	assert.Equal(t, "addr: 127.0.0.1:7933, identity: dummy, portMap: grpc:7833, tchannel:7933", fmt.Sprintf("%#v", host))
+	assert.Equal(t, "addr: 127.0.0.1:7933, identity: dummy, portMap: grpc:7833, tchannel:7933", fmt.Sprintf("%s", host))
+	assert.Equal(t, `"addr: 127.0.0.1:7933, identity: dummy, portMap: grpc:7833, tchannel:7933"`, fmt.Sprintf("%q", host))
+	assert.Equal(t, "[127.0.0.1:7933]", fmt.Sprintf("%v", []HostInfo{host}))
+	assert.Equal(t, "%!d(membership.HostInfo=addr: 127.0.0.1:7933, identity: dummy, portMap: grpc:7833, tchannel:7933)", fmt.Sprintf("%d", host))
+}
+
+func TestEndpointFor(t *testing.T) {
+	host := NewDetailedHostInfo("127.0.0.1:7933", "dummy", PortMap{PortGRPC: 7833})
+
+	h, port, err := host.EndpointFor(PortGRPC)
+	assert.NoError(t, err)
+	assert.Equal(t, "127.0.0.1", h)
+	assert.Equal(t, uint16(7833), port)
+
+	h, port, err = host.EndpointFor(PortTchannel)
+	assert.NoError(t, err, "falls back to host address")
+	assert.Equal(t, "127.0.0.1", h)
+	assert.Equal(t, uint16(7933), port)
+
+	var addrErr *InvalidAddressError
+	_, _, err = NewDetailedHostInfo("", "dummy", PortMap{PortGRPC: 7833}).EndpointFor(PortGRPC)
+	assert.True(t, errors.As(err, &addrErr))
+	assert.False(t, errors.Is(err, ErrPortNotSet))
+
+	_, _, err = NewDetailedHostInfo("127.0.0.1", "dummy", nil).EndpointFor(PortGRPC)
+	assert.True(t, errors.As(err, &addrErr))
+	assert.Equal(t, "127.0.0.1", addrErr.Addr)
+	assert.True(t, errors.Is(err, ErrPortNotSet))
+}
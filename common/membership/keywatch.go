@@ -0,0 +1,131 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2017-2020 Uber Technologies Inc.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package membership
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	"github.com/uber/cadence/common"
+)
+
+// keyWatcher tracks the owner of a single key and sends it on ch whenever it changes
+type keyWatcher struct {
+	key    string
+	ch     chan HostInfo
+	signal chan struct{} // coalesces ring changes while the watcher is busy
+	done   chan struct{} // closed by cancel
+	exited chan struct{} // closed once the worker returned and ch is closed
+	owner  string        // address of the last known owner
+}
+
+// WatchKey returns a channel receiving the new owner of the key every time it changes, and a function cancelling the watch.
+// The current owner is not sent, if the key has no owner yet the first owner is sent once there is one.
+// The channel is closed when the watch is cancelled or the ring is stopped.
+func (r *ring) WatchKey(key string) (<-chan HostInfo, func(), error) {
+	if atomic.LoadInt32(&r.status) == common.DaemonStatusStopped {
+		return nil, nil, errors.New("ring is stopped")
+	}
+
+	w := &keyWatcher{
+		key:    key,
+		ch:     make(chan HostInfo),
+		signal: make(chan struct{}, 1),
+		done:   make(chan struct{}),
+		exited: make(chan struct{}),
+	}
+	if owner, err := r.placedOwner(key); err == nil {
+		w.owner = owner.GetAddress()
+	}
+
+	r.keyWatchers.Lock()
+	r.keyWatchers.set[w] = struct{}{}
+	r.keyWatchers.Unlock()
+
+	r.shutdownWG.Add(1)
+	go r.keyWatchWorker(w)
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			r.keyWatchers.Lock()
+			delete(r.keyWatchers.set, w)
+			r.keyWatchers.Unlock()
+			close(w.done)
+		})
+		<-w.exited
+	}
+	return w.ch, cancel, nil
+}
+
+// signalKeyWatchers notifies key watchers that owners might have changed, without blocking
+func (r *ring) signalKeyWatchers() {
+	r.keyWatchers.Lock()
+	defer r.keyWatchers.Unlock()
+	for w := range r.keyWatchers.set {
+		select {
+		case w.signal <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// placedOwner returns the owner of the key as the ring places it.
+// Unlike Lookup, it doesn't follow pins, use the lookup cache, record lookup latency or connect to the owner.
+func (r *ring) placedOwner(key string) (HostInfo, error) {
+	r.members.RLock()
+	defer r.members.RUnlock()
+	var owner HostInfo
+	err := r.ownerLocked(r.ring(), key, nil, &owner)
+	return owner, err
+}
+
+func (r *ring) keyWatchWorker(w *keyWatcher) {
+	defer r.shutdownWG.Done()
+	defer close(w.exited)
+	defer close(w.ch)
+
+	for {
+		select {
+		case <-r.shutdownCh:
+			return
+		case <-w.done:
+			return
+		case <-w.signal:
+			owner, err := r.placedOwner(w.key)
+			if err != nil || owner.GetAddress() == w.owner {
+				// keep the last known owner until the ring has members again
+				continue
+			}
+			w.owner = owner.GetAddress()
+			select {
+			case w.ch <- owner:
+			case <-w.done:
+				return
+			case <-r.shutdownCh:
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,181 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2017-2020 Uber Technologies Inc.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package membership
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/uber/cadence/common/log/tag"
+)
+
+const (
+	defaultLoadReportInterval = 10 * time.Second
+	defaultLoadHalfLife       = time.Minute
+
+	// loads not reported for this many half-lives have decayed below 0.1% and are dropped
+	loadMaxAgeHalfLives = 10
+)
+
+type (
+	// LoadReporter returns the latest load scores of ring members of a service, keyed by member address.
+	// Scores are relative, e.g. requests per second, the membership layer only tracks and exposes them.
+	LoadReporter interface {
+		ReportLoad(ctx context.Context, service Service) (map[string]float64, error)
+	}
+
+	// LoadTrackingConfig describes how member loads are collected
+	LoadTrackingConfig struct {
+		// Interval between two polls of the reporter
+		Interval time.Duration
+		// HalfLife is the time after which the weight of a report drops by half
+		HalfLife time.Duration
+	}
+
+	loadSample struct {
+		score float64
+		at    time.Time
+	}
+
+	// loadState tracks exponentially decayed load scores of ring members by address
+	loadState struct {
+		sync.Mutex
+		reporter LoadReporter
+		config   LoadTrackingConfig
+		samples  map[string]loadSample
+	}
+)
+
+// WithLoadTracking returns a setter enabling collection of member load scores from the reporter, see Resolver.HostLoad.
+// Zero config values are replaced by defaults.
+func WithLoadTracking(reporter LoadReporter, config LoadTrackingConfig) ResolverOption {
+	if config.Interval <= 0 {
+		config.Interval = defaultLoadReportInterval
+	}
+	if config.HalfLife <= 0 {
+		config.HalfLife = defaultLoadHalfLife
+	}
+	return func(rpo *MultiringResolver) {
+		rpo.loadReporter = reporter
+		rpo.loadTrackingConfig = config
+	}
+}
+
+func newLoadState(reporter LoadReporter, config LoadTrackingConfig) *loadState {
+	return &loadState{
+		reporter: reporter,
+		config:   config,
+		samples:  make(map[string]loadSample),
+	}
+}
+
+// decay returns score reduced by half for every halfLife elapsed
+func decay(score float64, elapsed, halfLife time.Duration) float64 {
+	if elapsed <= 0 {
+		return score
+	}
+	return score * math.Exp2(-float64(elapsed)/float64(halfLife))
+}
+
+// record merges the reported score with the decayed previous one.
+// The weight of the new report is the weight the previous one lost since it was recorded,
+// so reports sent at any rate converge to a steady load.
+func (l *loadState) record(addr string, score float64, now time.Time) {
+	l.Lock()
+	defer l.Unlock()
+
+	prev, ok := l.samples[addr]
+	if !ok {
+		l.samples[addr] = loadSample{score: score, at: now}
+		return
+	}
+	elapsed := now.Sub(prev.at)
+	retained := decay(1, elapsed, l.config.HalfLife)
+	l.samples[addr] = loadSample{
+		score: prev.score*retained + score*(1-retained),
+		at:    now,
+	}
+}
+
+// loads returns scores decayed to now, hosts which stopped reporting age out
+func (l *loadState) loads(now time.Time) map[string]float64 {
+	res := make(map[string]float64)
+	if l == nil {
+		return res
+	}
+	l.Lock()
+	defer l.Unlock()
+
+	maxAge := loadMaxAgeHalfLives * l.config.HalfLife
+	for addr, sample := range l.samples {
+		elapsed := now.Sub(sample.at)
+		if elapsed > maxAge {
+			delete(l.samples, addr)
+			continue
+		}
+		res[addr] = decay(sample.score, elapsed, l.config.HalfLife)
+	}
+	return res
+}
+
+// HostLoad returns decayed load scores of members by address, empty if load tracking is not enabled
+func (r *ring) HostLoad() map[string]float64 {
+	return r.load.loads(time.Now())
+}
+
+// collectLoad polls the reporter and records scores of current members
+func (r *ring) collectLoad() {
+	ctx, cancel := context.WithTimeout(context.Background(), r.load.config.Interval)
+	defer cancel()
+	scores, err := r.load.reporter.ReportLoad(ctx, Service(r.service))
+	if err != nil {
+		r.logger.Warn("failed to collect ring member loads", tag.Error(err))
+		return
+	}
+
+	now := time.Now()
+	r.members.RLock()
+	defer r.members.RUnlock()
+	for addr, score := range scores {
+		if _, ok := r.members.keys[addr]; ok {
+			r.load.record(addr, score, now)
+		}
+	}
+}
+
+func (r *ring) loadTrackingWorker() {
+	defer r.shutdownWG.Done()
+
+	ticker := time.NewTicker(r.load.config.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.shutdownCh:
+			return
+		case <-ticker.C:
+			r.collectLoad()
+		}
+	}
+}
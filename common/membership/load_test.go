@@ -0,0 +1,124 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2017-2020 Uber Technologies Inc.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package membership
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/uber/cadence/common/log"
+	"github.com/uber/cadence/common/metrics"
+)
+
+type fakeLoadReporter struct {
+	scores map[string]float64
+	err    error
+}
+
+func (f fakeLoadReporter) ReportLoad(ctx context.Context, service Service) (map[string]float64, error) {
+	return f.scores, f.err
+}
+
+func TestDecay(t *testing.T) {
+	halfLife := time.Minute
+	assert.Equal(t, 80.0, decay(80, 0, halfLife))
+	assert.Equal(t, 80.0, decay(80, -time.Second, halfLife), "clock skew does not increase load")
+	assert.InDelta(t, 40.0, decay(80, halfLife, halfLife), 1e-9)
+	assert.InDelta(t, 10.0, decay(80, 3*halfLife, halfLife), 1e-9)
+	assert.InDelta(t, 80/1.4142135623730951, decay(80, halfLife/2, halfLife), 1e-9)
+}
+
+func TestLoadStateRecordsDecayedAverage(t *testing.T) {
+	halfLife := time.Minute
+	l := newLoadState(nil, LoadTrackingConfig{HalfLife: halfLife})
+	start := time.Unix(1000, 0)
+
+	l.record("a", 100, start)
+	assert.Equal(t, map[string]float64{"a": 100}, l.loads(start))
+
+	// a steady report keeps the load steady
+	l.record("a", 100, start.Add(halfLife))
+	assert.InDelta(t, 100, l.loads(start.Add(halfLife))["a"], 1e-9)
+
+	// after a half-life, the new report and the history weigh the same
+	l.record("a", 0, start.Add(2*halfLife))
+	assert.InDelta(t, 50, l.loads(start.Add(2 * halfLife))["a"], 1e-9)
+
+	// frequent reports move the average slowly
+	l.record("b", 100, start)
+	l.record("b", 200, start.Add(time.Second))
+	assert.InDelta(t, 100+100*(1-decay(1, time.Second, halfLife)), l.loads(start.Add(time.Second))["b"], 1e-9)
+}
+
+func TestLoadStateAgesOutSilentHosts(t *testing.T) {
+	halfLife := time.Minute
+	l := newLoadState(nil, LoadTrackingConfig{HalfLife: halfLife})
+	start := time.Unix(1000, 0)
+
+	l.record("a", 100, start)
+	l.record("b", 100, start.Add(5*halfLife))
+
+	loads := l.loads(start.Add(5 * halfLife))
+	assert.InDelta(t, 100.0/32, loads["a"], 1e-9, "silent host decays")
+	assert.Equal(t, 100.0, loads["b"])
+
+	loads = l.loads(start.Add(11 * halfLife))
+	assert.NotContains(t, loads, "a", "host silent for too long is dropped")
+	assert.Contains(t, loads, "b")
+
+	var disabled *loadState
+	assert.Empty(t, disabled.loads(start))
+}
+
+func TestCollectLoadRecordsRingMembers(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	pp := NewMockPeerProvider(ctrl)
+	reporter := &fakeLoadReporter{scores: map[string]float64{"10.0.0.1:7933": 3, "10.0.0.9:7933": 7}}
+	a := NewMultiringResolver(testServices, pp, metrics.NewNoopMetricsClient(), log.NewNoop(), WithLoadTracking(reporter, LoadTrackingConfig{}))
+
+	pp.EXPECT().GetMembers("test-worker").Return([]HostInfo{NewHostInfo("10.0.0.1:7933")}, nil).Times(1)
+	r, err := a.getRing("test-worker")
+	assert.NoError(t, err)
+	assert.NoError(t, r.refresh())
+	assert.Equal(t, defaultLoadHalfLife, r.load.config.HalfLife)
+
+	r.collectLoad()
+	load, err := a.HostLoad("test-worker")
+	assert.NoError(t, err)
+	assert.Len(t, load, 1, "only ring members are tracked")
+	assert.InDelta(t, 3, load["10.0.0.1:7933"], 0.01)
+
+	reporter.err = errors.New("reporter failed")
+	r.collectLoad()
+	load, err = a.HostLoad("test-worker")
+	assert.NoError(t, err)
+	assert.Len(t, load, 1, "failed reports keep the previous loads")
+
+	_, err = a.HostLoad("WRONG-RING-NAME")
+	assert.Error(t, err)
+}
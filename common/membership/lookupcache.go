@@ -0,0 +1,108 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2017-2020 Uber Technologies Inc.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package membership
+
+import (
+	"container/list"
+	"sync"
+)
+
+type (
+	// lookupCache is a bounded LRU of lookup results by key, it is reset whenever ring lookups can change
+	lookupCache struct {
+		sync.Mutex
+		capacity   int
+		generation uint64 // incremented on reset, so that results computed before it are not cached
+		entries    map[string]*list.Element
+		order      *list.List // most recently used entries first
+	}
+
+	lookupCacheEntry struct {
+		key  string
+		host HostInfo
+	}
+)
+
+// WithLookupCache returns a setter enabling an LRU cache of up to size Lookup results per ring.
+// Cached results are dropped on every membership, drain or health change of the ring, and the least recently
+// used one is evicted when the cache is full, so memory stays bounded however many distinct keys are looked up.
+func WithLookupCache(size int) ResolverOption {
+	return func(rpo *MultiringResolver) {
+		rpo.lookupCacheSize = size
+	}
+}
+
+func newLookupCache(capacity int) *lookupCache {
+	return &lookupCache{
+		capacity: capacity,
+		entries:  make(map[string]*list.Element, capacity),
+		order:    list.New(),
+	}
+}
+
+// get returns the cached owner of the key, along with the generation to put a computed owner with
+func (c *lookupCache) get(key string) (HostInfo, uint64, bool) {
+	c.Lock()
+	defer c.Unlock()
+	element, ok := c.entries[key]
+	if !ok {
+		return HostInfo{}, c.generation, false
+	}
+	c.order.MoveToFront(element)
+	return element.Value.(*lookupCacheEntry).host, c.generation, true
+}
+
+// put caches the owner of the key unless the cache was reset since generation was read.
+// It returns true if the least recently used entry was evicted to stay within capacity.
+func (c *lookupCache) put(key string, host HostInfo, generation uint64) (evicted bool) {
+	c.Lock()
+	defer c.Unlock()
+	if generation != c.generation {
+		return false
+	}
+	if element, ok := c.entries[key]; ok {
+		element.Value.(*lookupCacheEntry).host = host
+		c.order.MoveToFront(element)
+		return false
+	}
+	c.entries[key] = c.order.PushFront(&lookupCacheEntry{key: key, host: host})
+	if c.order.Len() <= c.capacity {
+		return false
+	}
+	oldest := c.order.Back()
+	c.order.Remove(oldest)
+	delete(c.entries, oldest.Value.(*lookupCacheEntry).key)
+	return true
+}
+
+// reset drops all cached results
+func (c *lookupCache) reset() {
+	if c == nil {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+	c.generation++
+	c.entries = make(map[string]*list.Element, c.capacity)
+	c.order.Init()
+}
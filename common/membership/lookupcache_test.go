@@ -0,0 +1,184 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2017-2020 Uber Technologies Inc.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package membership
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/uber-go/tally"
+
+	"github.com/uber/cadence/common/log"
+	"github.com/uber/cadence/common/metrics"
+)
+
+func TestLookupCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := newLookupCache(2)
+	a, b, d := NewHostInfo("a:1"), NewHostInfo("b:1"), NewHostInfo("d:1")
+
+	_, gen, ok := c.get("key-a")
+	assert.False(t, ok)
+	assert.False(t, c.put("key-a", a, gen))
+	assert.False(t, c.put("key-b", b, gen))
+
+	_, _, ok = c.get("key-a") // key-b becomes the least recently used
+	assert.True(t, ok)
+	assert.True(t, c.put("key-d", d, gen))
+
+	_, _, ok = c.get("key-b")
+	assert.False(t, ok)
+	host, _, ok := c.get("key-a")
+	assert.True(t, ok)
+	assert.Equal(t, a, host)
+	host, _, ok = c.get("key-d")
+	assert.True(t, ok)
+	assert.Equal(t, d, host)
+}
+
+func TestLookupCacheIgnoresResultsComputedBeforeReset(t *testing.T) {
+	c := newLookupCache(10)
+	_, gen, _ := c.get("key")
+	c.reset()
+	c.put("key", NewHostInfo("a:1"), gen)
+	_, _, ok := c.get("key")
+	assert.False(t, ok, "owner computed on the previous ring is not cached")
+
+	var disabled *lookupCache
+	disabled.reset()
+}
+
+func TestLookupCacheIsInvalidatedOnMembershipChange(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	pp := NewMockPeerProvider(ctrl)
+	scope := tally.NewTestScope("test", nil)
+	a := NewMultiringResolver(testServices, pp, metrics.NewClient(scope, metrics.History), log.NewNoop(), WithLookupCache(100))
+
+	first, second := NewHostInfo("10.0.0.1:7933"), NewHostInfo("10.0.0.2:7933")
+	gomock.InOrder(
+		pp.EXPECT().GetMembers("test-worker").Return([]HostInfo{first}, nil),
+		pp.EXPECT().GetMembers("test-worker").Return([]HostInfo{second}, nil),
+	)
+	r, err := a.getRing("test-worker")
+	assert.NoError(t, err)
+	assert.NoError(t, r.refresh())
+
+	for i := 0; i < 3; i++ {
+		owner, err := a.Lookup("test-worker", "hot-key")
+		assert.NoError(t, err)
+		assert.Equal(t, first.GetAddress(), owner.GetAddress())
+	}
+
+	r.members.refreshed = time.Time{}
+	assert.NoError(t, r.refresh())
+	owner, err := a.Lookup("test-worker", "hot-key")
+	assert.NoError(t, err)
+	assert.Equal(t, second.GetAddress(), owner.GetAddress(), "cached owner is dropped when membership changes")
+
+	counters := scope.Snapshot().Counters()
+	assert.Equal(t, int64(2), counters["test.hashring_lookup_cache_hits+hashring_service=test-worker,operation=Hashring"].Value())
+	assert.Equal(t, int64(2), counters["test.hashring_lookup_cache_misses+hashring_service=test-worker,operation=Hashring"].Value())
+}
+
+func TestLookupCacheIsDroppedWhenRingStops(t *testing.T) {
+	members := []HostInfo{NewHostInfo("10.0.0.1:7933")}
+	provider := NewStaticPeerProvider(HostInfo{}, map[string][]HostInfo{"test-worker": members})
+	a := NewMultiringResolver(testServices, provider, metrics.NewNoopMetricsClient(), log.NewNoop(), WithLookupCache(100))
+	r, err := a.getRing("test-worker")
+	assert.NoError(t, err)
+	r.Start()
+
+	_, err = a.Lookup("test-worker", "hot-key")
+	assert.NoError(t, err)
+	r.Stop()
+	_, err = a.Lookup("test-worker", "hot-key")
+	assert.Equal(t, ErrNoMembers, err, "owners cached before stop are not returned")
+}
+
+func TestLookupCacheEvictionsAreCounted(t *testing.T) {
+	members := []HostInfo{NewHostInfo("10.0.0.1:7933"), NewHostInfo("10.0.0.2:7933")}
+	provider := NewStaticPeerProvider(HostInfo{}, map[string][]HostInfo{"test-worker": members})
+	scope := tally.NewTestScope("test", nil)
+	a := NewMultiringResolver(testServices, provider, metrics.NewClient(scope, metrics.History), log.NewNoop(), WithLookupCache(2))
+	r, err := a.getRing("test-worker")
+	assert.NoError(t, err)
+	assert.NoError(t, r.refresh())
+
+	evictions := func() int64 {
+		counter, ok := scope.Snapshot().Counters()["test.hashring_lookup_cache_evictions+hashring_service=test-worker,operation=Hashring"]
+		if !ok {
+			return 0
+		}
+		return counter.Value()
+	}
+	for _, key := range []string{"key-0", "key-1", "key-0"} {
+		_, err := a.Lookup("test-worker", key)
+		assert.NoError(t, err)
+	}
+	assert.Zero(t, evictions(), "cache is within capacity")
+
+	_, err = a.Lookup("test-worker", "key-2")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), evictions())
+	_, err = a.Lookup("test-worker", "key-0")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), evictions(), "recently used key-0 was kept")
+	_, err = a.Lookup("test-worker", "key-1")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), evictions(), "least recently used key-1 was evicted")
+}
+
+func BenchmarkLookupCache(b *testing.B) {
+	members := make([]HostInfo, 0, 100)
+	for i := 0; i < 100; i++ {
+		members = append(members, NewHostInfo(fmt.Sprintf("10.0.0.%d:7933", i)))
+	}
+	keys := make([]string, 64)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("hot-workflow-%d", i)
+	}
+
+	for _, size := range []int{0, 1024} {
+		b.Run(fmt.Sprintf("cache-size-%d", size), func(b *testing.B) {
+			provider := NewStaticPeerProvider(HostInfo{}, map[string][]HostInfo{"test-worker": members})
+			r := NewMultiringResolver([]string{"test-worker"}, provider, metrics.NewNoopMetricsClient(), log.NewNoop(), WithLookupCache(size))
+			ring, err := r.getRing("test-worker")
+			if err != nil {
+				b.Fatal(err)
+			}
+			if err := ring.refresh(); err != nil {
+				b.Fatal(err)
+			}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := r.Lookup("test-worker", keys[i%len(keys)]); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -55,6 +55,20 @@ func (m *MockPeerProvider) EXPECT() *MockPeerProviderMockRecorder {
 	return m.recorder
 }
 
+// Drain mocks base method.
+func (m *MockPeerProvider) Drain(service string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Drain", service)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Drain indicates an expected call of Drain.
+func (mr *MockPeerProviderMockRecorder) Drain(service interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Drain", reflect.TypeOf((*MockPeerProvider)(nil).Drain), service)
+}
+
 // GetMembers mocks base method.
 func (m *MockPeerProvider) GetMembers(service string) ([]HostInfo, error) {
 	m.ctrl.T.Helper()
@@ -122,6 +136,20 @@ func (mr *MockPeerProviderMockRecorder) Subscribe(name, notifyChannel interface{
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Subscribe", reflect.TypeOf((*MockPeerProvider)(nil).Subscribe), name, notifyChannel)
 }
 
+// Undrain mocks base method.
+func (m *MockPeerProvider) Undrain(service string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Undrain", service)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Undrain indicates an expected call of Undrain.
+func (mr *MockPeerProviderMockRecorder) Undrain(service interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Undrain", reflect.TypeOf((*MockPeerProvider)(nil).Undrain), service)
+}
+
 // WhoAmI mocks base method.
 func (m *MockPeerProvider) WhoAmI() (HostInfo, error) {
 	m.ctrl.T.Helper()
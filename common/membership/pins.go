@@ -0,0 +1,74 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2017-2020 Uber Technologies Inc.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package membership
+
+import (
+	"sync"
+
+	"github.com/uber/cadence/common/log/tag"
+	"github.com/uber/cadence/common/metrics"
+)
+
+// keyPins routes keys to hosts chosen by an operator instead of the hashring
+type keyPins struct {
+	sync.RWMutex
+	hosts map[string]HostInfo
+}
+
+// get returns the host the key is pinned to
+func (p *keyPins) get(key string) (HostInfo, bool) {
+	p.RLock()
+	defer p.RUnlock()
+	host, ok := p.hosts[key]
+	return host, ok
+}
+
+// PinKey makes Lookup return host for the key until UnpinKey is called or the process restarts.
+// Pins override the hash regardless of membership, so the host should be a member of the ring.
+// A connection manager only connects to the host while it is one.
+func (r *ring) PinKey(key string, host HostInfo) {
+	r.pins.Lock()
+	if r.pins.hosts == nil {
+		r.pins.hosts = make(map[string]HostInfo)
+	}
+	r.pins.hosts[key] = host
+	count := len(r.pins.hosts)
+	r.pins.Unlock()
+
+	r.scope.UpdateGauge(metrics.HashringPinnedKeys, float64(count))
+	r.logger.Warn("key is pinned to a host, overriding the hashring", tag.Key(key), tag.Address(host.GetAddress()))
+}
+
+// UnpinKey reverts PinKey, the key is routed by the hashring again
+func (r *ring) UnpinKey(key string) {
+	r.pins.Lock()
+	_, ok := r.pins.hosts[key]
+	delete(r.pins.hosts, key)
+	count := len(r.pins.hosts)
+	r.pins.Unlock()
+
+	if ok {
+		r.scope.UpdateGauge(metrics.HashringPinnedKeys, float64(count))
+		r.logger.Info("key is unpinned", tag.Key(key))
+	}
+}
@@ -0,0 +1,75 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2017-2020 Uber Technologies Inc.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package membership
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+
+	"github.com/uber/cadence/common/log"
+	"github.com/uber/cadence/common/metrics"
+)
+
+func TestPinnedKeyIgnoresRingPlacementUntilUnpinned(t *testing.T) {
+	members := []HostInfo{NewHostInfo("10.0.0.1:7933"), NewHostInfo("10.0.0.2:7933"), NewHostInfo("10.0.0.3:7933")}
+	provider := NewStaticPeerProvider(HostInfo{}, map[string][]HostInfo{"test-worker": members})
+	scope := tally.NewTestScope("test", nil)
+	r := NewMultiringResolver(testServices, provider, metrics.NewClient(scope, metrics.History), log.NewNoop(), WithLookupCache(10))
+	hr, err := r.getRing("test-worker")
+	require.NoError(t, err)
+	require.NoError(t, hr.refresh())
+
+	owner, err := r.Lookup("test-worker", "key")
+	require.NoError(t, err)
+	var canary HostInfo
+	for _, m := range members {
+		if m.GetAddress() != owner.GetAddress() {
+			canary = m
+		}
+	}
+
+	require.NoError(t, r.PinKey("test-worker", "key", canary))
+	for i := 0; i < 3; i++ {
+		host, err := r.Lookup("test-worker", "key")
+		require.NoError(t, err)
+		assert.Equal(t, canary.GetAddress(), host.GetAddress(), "pins take precedence over cached owners")
+	}
+	owners, err := r.LookupN("test-worker", "key", 1)
+	require.NoError(t, err)
+	assert.Equal(t, owner.GetAddress(), owners[0].GetAddress(), "only Lookup honors pins")
+
+	snapshot := scope.Snapshot()
+	assert.Equal(t, float64(1), snapshot.Gauges()["test.hashring_pinned_keys+hashring_service=test-worker,operation=Hashring"].Value())
+	assert.Equal(t, int64(3), snapshot.Counters()["test.hashring_pinned_lookups+hashring_service=test-worker,operation=Hashring"].Value())
+
+	require.NoError(t, r.UnpinKey("test-worker", "key"))
+	host, err := r.Lookup("test-worker", "key")
+	require.NoError(t, err)
+	assert.Equal(t, owner.GetAddress(), host.GetAddress())
+	assert.Zero(t, scope.Snapshot().Gauges()["test.hashring_pinned_keys+hashring_service=test-worker,operation=Hashring"].Value())
+
+	assert.Error(t, r.PinKey("unknown-service", "key", canary))
+}
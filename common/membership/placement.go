@@ -0,0 +1,58 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2017-2020 Uber Technologies Inc.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package membership
+
+// SimulatePlacement returns the owner of every shard 0..numShards-1 if the add hosts joined a ring of current ones,
+// as shard IDs keyed by owner address. It places members like a service ring with default replica points and hash,
+// drained members own no shards and hosts listed twice are only added once. Neither input is modified.
+// It is meant for capacity planning, comparing the result with the placement of current alone shows which
+// shards would move.
+func SimulatePlacement(current []HostInfo, add []HostInfo, numShards int) map[string][]int {
+	members := make([]HostInfo, 0, len(current)+len(add))
+	members = append(members, current...)
+	members = append(members, add...)
+
+	byAddress := make(map[string]HostInfo, len(members))
+	drained := 0
+	for _, member := range members {
+		if _, ok := byAddress[member.GetAddress()]; ok {
+			continue
+		}
+		byAddress[member.GetAddress()] = member
+		if member.IsDrained() {
+			drained++
+		}
+	}
+
+	ring := NewHashRing(members, defaultReplicaPoints)
+	placement := make(map[string][]int)
+	for shardID := 0; shardID < numShards; shardID++ {
+		for _, addr := range ring.LookupN(shardKey(shardID), drained+1) {
+			if !byAddress[addr].IsDrained() {
+				placement[addr] = append(placement[addr], shardID)
+				break
+			}
+		}
+	}
+	return placement
+}
@@ -0,0 +1,88 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2017-2020 Uber Technologies Inc.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package membership
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/uber/cadence/common/log"
+	"github.com/uber/cadence/common/metrics"
+)
+
+func TestSimulatePlacementPreservesShards(t *testing.T) {
+	var current, add []HostInfo
+	for i := 0; i < 5; i++ {
+		current = append(current, NewHostInfo(fmt.Sprintf("10.0.0.%d:7933", i)))
+	}
+	for i := 5; i < 8; i++ {
+		add = append(add, NewHostInfo(fmt.Sprintf("10.0.0.%d:7933", i)))
+	}
+	const numShards = 1024
+
+	before := SimulatePlacement(current, nil, numShards)
+	after := SimulatePlacement(current, add, numShards)
+	for _, placement := range []map[string][]int{before, after} {
+		seen := make(map[int]struct{}, numShards)
+		for _, shards := range placement {
+			for _, shardID := range shards {
+				seen[shardID] = struct{}{}
+			}
+		}
+		assert.Len(t, seen, numShards, "every shard has exactly one owner")
+	}
+	assert.Len(t, before, 5)
+	assert.Len(t, after, 8)
+
+	// shards only move to the added hosts
+	for addr, shards := range after {
+		if _, existing := before[addr]; existing {
+			assert.Subset(t, before[addr], shards)
+		}
+	}
+
+	drained := append([]HostInfo{current[0].WithLabel(LabelDrained, "true")}, current[1:]...)
+	assert.NotContains(t, SimulatePlacement(drained, add, numShards), current[0].GetAddress())
+	assert.Empty(t, SimulatePlacement(nil, nil, numShards))
+}
+
+func TestSimulatePlacementMatchesServiceRing(t *testing.T) {
+	members := []HostInfo{NewHostInfo("10.0.0.1:7933"), NewHostInfo("10.0.0.2:7933"), NewHostInfo("10.0.0.3:7933")}
+	ctrl := gomock.NewController(t)
+	pp := NewMockPeerProvider(ctrl)
+	pp.EXPECT().GetMembers("test-service").Return(members, nil)
+	hr := newHashring("test-service", pp, metrics.NewNoopMetricsClient(), log.NewNoop())
+	require.NoError(t, hr.refreshMembers())
+
+	for addr, shards := range SimulatePlacement(members[:1], members[1:], 64) {
+		for _, shardID := range shards {
+			owner, err := hr.Lookup(shardKey(shardID))
+			require.NoError(t, err)
+			assert.Equal(t, addr, owner.GetAddress())
+		}
+	}
+}
@@ -0,0 +1,79 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2017-2020 Uber Technologies Inc.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package membership
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+)
+
+const readinessPollInterval = time.Millisecond * 100
+
+// WithReadiness returns a setter for the minimum number of members the given services rings need to have
+// before WaitReady returns. Services which are not listed are not waited for.
+func WithReadiness(minMembers map[Service]int) ResolverOption {
+	return func(rpo *MultiringResolver) {
+		rpo.minMembers = make(map[string]int, len(minMembers))
+		for service, count := range minMembers {
+			rpo.minMembers[string(service)] = count
+		}
+	}
+}
+
+// WaitReady blocks until every ring configured with WithReadiness has the required number of members
+func (rpo *MultiringResolver) WaitReady(ctx context.Context) error {
+	services := make([]string, 0, len(rpo.minMembers))
+	for service := range rpo.minMembers {
+		if _, err := rpo.getRing(Service(service)); err != nil {
+			return err
+		}
+		services = append(services, service)
+	}
+	sort.Strings(services)
+
+	ticker := time.NewTicker(readinessPollInterval)
+	defer ticker.Stop()
+	for {
+		service, count := rpo.firstUnready(services)
+		if service == "" {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%v ring has %d of %d required members: %w", service, count, rpo.minMembers[service], ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// firstUnready returns the first of services whose ring has less members than required along with its member count
+func (rpo *MultiringResolver) firstUnready(services []string) (string, int) {
+	for _, service := range services {
+		if count := rpo.rings[service].MemberCount(); count < rpo.minMembers[service] {
+			return service, count
+		}
+	}
+	return "", 0
+}
@@ -0,0 +1,69 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2017-2020 Uber Technologies Inc.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package membership
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/uber/cadence/common/log"
+	"github.com/uber/cadence/common/metrics"
+)
+
+func TestWaitReadyReturnsOnceRingHasMinimumMembers(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	pp := NewMockPeerProvider(ctrl)
+	pp.EXPECT().GetMembers("test-worker").Return([]HostInfo{NewHostInfo("127.0.0.1:7933"), NewHostInfo("127.0.0.2:7933")}, nil)
+	a := NewMultiringResolver(testServices, pp, metrics.NewNoopMetricsClient(), log.NewNoop(),
+		WithReadiness(map[Service]int{"test-worker": 2}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := a.WaitReady(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Contains(t, err.Error(), "test-worker ring has 0 of 2 required members")
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		assert.NoError(t, a.rings["test-worker"].refreshMembers())
+	}()
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	start := time.Now()
+	require.NoError(t, a.WaitReady(ctx))
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestWaitReadyChecksConfiguredServices(t *testing.T) {
+	a := NewMultiringResolver(testServices, NewMockPeerProvider(gomock.NewController(t)), metrics.NewNoopMetricsClient(), log.NewNoop())
+	assert.NoError(t, a.WaitReady(context.Background()), "no ring is required by default")
+
+	a = NewMultiringResolver(testServices, NewMockPeerProvider(gomock.NewController(t)), metrics.NewNoopMetricsClient(), log.NewNoop(),
+		WithReadiness(map[Service]int{"unknown": 1}))
+	assert.Error(t, a.WaitReady(context.Background()))
+}
@@ -0,0 +1,129 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2017-2020 Uber Technologies Inc.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package membership
+
+import (
+	"context"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/uber/cadence/common/log/tag"
+	"github.com/uber/cadence/common/metrics"
+)
+
+// WithReconciliation returns a setter enabling periodic comparison of ring members with members reported by
+// the peer provider. Members listed by only one of them are counted as drift, see DriftCount.
+// Rings are expected to follow the provider within a refresh, so a member is only logged when it drifted
+// on two consecutive reconciliations. Reconciliation is disabled when interval is not positive.
+func WithReconciliation(interval time.Duration) ResolverOption {
+	return func(rpo *MultiringResolver) {
+		rpo.reconcileInterval = interval
+	}
+}
+
+// reconcileLoop reconciles all rings every interval until ctx is done
+func (rpo *MultiringResolver) reconcileLoop(ctx context.Context, interval time.Duration) {
+	defer rpo.reconcileWG.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, r := range rpo.rings {
+				r.reconcileDrift()
+			}
+		}
+	}
+}
+
+// driftedMembers returns sorted addresses of members which are either reported by the provider or in the ring, but not both
+func (r *ring) driftedMembers() ([]string, error) {
+	members, err := r.peerProvider.GetMembers(r.service)
+	if err != nil {
+		return nil, err
+	}
+	provided := make(map[string]struct{}, len(members))
+	for _, member := range members {
+		provided[member.GetAddress()] = struct{}{}
+	}
+
+	var drifted []string
+	r.members.RLock()
+	for addr := range provided {
+		if _, ok := r.members.keys[addr]; !ok {
+			drifted = append(drifted, addr)
+		}
+	}
+	for addr := range r.members.keys {
+		if _, ok := provided[addr]; !ok {
+			drifted = append(drifted, addr)
+		}
+	}
+	r.members.RUnlock()
+
+	sort.Strings(drifted)
+	return drifted, nil
+}
+
+// reconcileDrift updates the drift count of the ring and logs the members which drifted on the previous reconciliation as well
+func (r *ring) reconcileDrift() {
+	drifted, err := r.driftedMembers()
+	if err != nil {
+		r.logger.Warn("failed to get members from peer provider for reconciliation", tag.Error(err))
+		return
+	}
+	atomic.StoreInt64(&r.drift, int64(len(drifted)))
+	r.scope.UpdateGauge(metrics.HashringMembershipDrift, float64(len(drifted)))
+	persisting := intersectSorted(r.drifted, drifted)
+	r.drifted = drifted
+	if len(persisting) > 0 {
+		r.logger.Warn("ring members drifted from peer provider", tag.Service(r.service), tag.Addresses(persisting))
+	}
+}
+
+// intersectSorted returns the strings which are in both sorted slices
+func intersectSorted(a, b []string) []string {
+	var res []string
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			res = append(res, a[i])
+			i++
+			j++
+		}
+	}
+	return res
+}
+
+// DriftCount returns the number of members which differed between the ring and the peer provider on the last reconciliation
+func (r *ring) DriftCount() int {
+	return int(atomic.LoadInt64(&r.drift))
+}
@@ -0,0 +1,130 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2017-2020 Uber Technologies Inc.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package membership
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	"github.com/uber/cadence/common/log"
+	"github.com/uber/cadence/common/log/tag"
+	"github.com/uber/cadence/common/metrics"
+)
+
+func TestReconcileDriftCountsMembersMissingOnEitherSide(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	pp := NewMockPeerProvider(ctrl)
+	scope := tally.NewTestScope("test", nil)
+	hr := newHashring("test-service", pp, metrics.NewClient(scope, metrics.History), log.NewNoop())
+
+	a, b, c := NewHostInfo("127.0.0.1:7933"), NewHostInfo("127.0.0.2:7933"), NewHostInfo("127.0.0.3:7933")
+	gomock.InOrder(
+		pp.EXPECT().GetMembers("test-service").Return([]HostInfo{a, b}, nil),
+		pp.EXPECT().GetMembers("test-service").Return([]HostInfo{b, c}, nil).Times(2),
+		pp.EXPECT().GetMembers("test-service").Return(nil, errors.New("provider failure")),
+		pp.EXPECT().GetMembers("test-service").Return([]HostInfo{b, a}, nil),
+	)
+	require.NoError(t, hr.refresh())
+
+	drifted, err := hr.driftedMembers()
+	require.NoError(t, err)
+	assert.Equal(t, []string{a.GetAddress(), c.GetAddress()}, drifted)
+
+	hr.reconcileDrift()
+	assert.Equal(t, 2, hr.DriftCount())
+	hr.reconcileDrift()
+	assert.Equal(t, 2, hr.DriftCount(), "failed reconciliation keeps the last count")
+	hr.reconcileDrift()
+	assert.Equal(t, 0, hr.DriftCount(), "member order doesn't matter")
+	assert.Equal(t, float64(0), scope.Snapshot().Gauges()["test.hashring_membership_drift+hashring_service=test-service,operation=Hashring"].Value())
+}
+
+func TestReconcileDriftLogsMembersDriftingTwiceInARow(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	pp := NewMockPeerProvider(ctrl)
+	core, logs := observer.New(zapcore.WarnLevel)
+	logger := &log.MockLogger{}
+	logger.On("Info", mock.Anything, mock.Anything)
+	logger.On("Warn", "ring members drifted from peer provider", mock.Anything).Run(func(args mock.Arguments) {
+		var fields []zap.Field
+		for _, t := range args.Get(1).([]tag.Tag) {
+			fields = append(fields, t.Field())
+		}
+		zap.New(core).Warn(args.String(0), fields...)
+	})
+	hr := newHashring("test-service", pp, metrics.NewNoopMetricsClient(), logger)
+
+	a, b, c, d := NewHostInfo("127.0.0.1:7933"), NewHostInfo("127.0.0.2:7933"), NewHostInfo("127.0.0.3:7933"), NewHostInfo("127.0.0.4:7933")
+	gomock.InOrder(
+		pp.EXPECT().GetMembers("test-service").Return([]HostInfo{a}, nil),
+		pp.EXPECT().GetMembers("test-service").Return([]HostInfo{a, b}, nil),
+		pp.EXPECT().GetMembers("test-service").Return([]HostInfo{a, c}, nil),
+		pp.EXPECT().GetMembers("test-service").Return([]HostInfo{a, c, d}, nil),
+	)
+	require.NoError(t, hr.refresh())
+
+	hr.reconcileDrift()
+	assert.Equal(t, 1, hr.DriftCount())
+	hr.reconcileDrift()
+	assert.Equal(t, 1, hr.DriftCount())
+	assert.Zero(t, logs.Len(), "a different member drifted each time")
+
+	hr.reconcileDrift()
+	assert.Equal(t, 2, hr.DriftCount())
+	require.Equal(t, 1, logs.Len())
+	assert.Equal(t, "["+c.GetAddress()+"]", logs.All()[0].ContextMap()["addresses"], "only the member which drifted twice is logged")
+}
+
+func TestReconcileLoopDetectsDroppedRingUpdates(t *testing.T) {
+	self := NewDetailedHostInfo("127.0.0.1:7933", "self", nil)
+	other := NewDetailedHostInfo("127.0.0.2:7933", "other", nil)
+	provider := NewStaticPeerProvider(self, map[string][]HostInfo{"test-worker": {self, other}})
+	a := NewMultiringResolver(testServices, provider, metrics.NewNoopMetricsClient(), log.NewNoop(),
+		WithReconciliation(10*time.Millisecond))
+	a.Start()
+
+	// the ring was just refreshed on start, so the update caused by eviction is rate limited
+	require.NoError(t, provider.SelfEvict())
+	assert.Eventually(t, func() bool {
+		drift, err := a.DriftCount("test-worker")
+		return err == nil && drift == 1
+	}, time.Second, 10*time.Millisecond)
+
+	drift, err := a.DriftCount("test-services")
+	assert.NoError(t, err)
+	assert.Zero(t, drift)
+	_, err = a.DriftCount("unknown")
+	assert.Error(t, err)
+
+	a.Stop()
+}
@@ -24,13 +24,20 @@
 package membership
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"sort"
+	"sync"
 	"sync/atomic"
+	"time"
+
+	"go.uber.org/multierr"
 
 	"github.com/uber/cadence/common"
 	"github.com/uber/cadence/common/log"
 	"github.com/uber/cadence/common/log/tag"
+	"github.com/uber/cadence/common/metrics"
 	"github.com/uber/cadence/common/service"
 )
 
@@ -38,9 +45,19 @@ type (
 
 	// ChangedEvent describes a change in a membership ring
 	ChangedEvent struct {
-		HostsAdded   []string
-		HostsUpdated []string
-		HostsRemoved []string
+		HostsAdded   []HostInfo
+		HostsUpdated []HostInfo
+		HostsRemoved []HostInfo
+		// ResyncRequired is set when earlier events were dropped because the subscriber didn't keep up,
+		// the subscriber should then read the full list of members instead of applying the changes
+		ResyncRequired bool
+	}
+
+	// IdentityConflict describes members of a service ring which advertise the same identity from different addresses
+	IdentityConflict struct {
+		Service   string   `json:"service"`
+		Identity  string   `json:"identity"`
+		Addresses []string `json:"addresses"`
 	}
 
 	// Resolver provides membership information for all cadence services.
@@ -51,39 +68,281 @@ type (
 		// to return this information
 		WhoAmI() (HostInfo, error)
 
+		// InvalidateSelf drops the cached self host details, so that the next WhoAmI asks peer provider again.
+		// Rings drop them as well when they see this host updated or removed, so it is only needed when this host
+		// is rebound before its rings are refreshed.
+		InvalidateSelf()
+
 		// EvictSelf evicts this member from the membership ring. After this method is
 		// called, other members should discover that this node is no longer part of the
-		// ring.
+		// ring. It returns once this host left every ring it was a member of, or ctx is done.
 		//This primitive is useful to carry out graceful host shutdown during deployments.
-		EvictSelf() error
+		EvictSelf(ctx context.Context) error
+
+		// WaitReady blocks until the service rings required by this host have converged to a minimum member count,
+		// or ctx is done. Serving traffic before that misroutes requests to the few hosts discovered so far.
+		WaitReady(ctx context.Context) error
+
+		// Drain marks this member as drained in the ring of the given service. Drained members remain
+		// listed in Members, but lookups skip them, so no new work is routed to the host.
+		// This primitive is useful to stop taking new work before leaving the ring during deployments.
+		Drain(service Service) error
+
+		// Undrain reverts Drain, e.g. when a deployment is aborted.
+		Undrain(service Service) error
 
 		// Lookup will return host which is an owner for provided key.
-		Lookup(service, key string) (HostInfo, error)
+		// ErrNoMembers is returned if the ring is empty, and ErrInsufficientHosts if all members are drained or unhealthy.
+		Lookup(service Service, key string) (HostInfo, error)
+
+		// LookupInto is Lookup writing the owner into out. It doesn't allocate when the lookup cache and
+		// the connection manager are not set, which matters for routing every request at high rates.
+		LookupInto(service Service, key string, out *HostInfo) error
+
+		// LookupForDomain is Lookup for a key of the domain, which some domains may restrict to a subset of members,
+		// see WithDomainRoutingPolicy. Keys of other domains are routed as Lookup does.
+		LookupForDomain(service Service, domain, key string) (HostInfo, error)
+
+		// LookupWithVersion is Lookup which also returns the version of the ring the owner was found on,
+		// see RingVersion. Forwarded requests can be stamped with it, so that the receiver detects stale routing.
+		LookupWithVersion(service Service, key string) (HostInfo, uint64, error)
+
+		// RingVersion returns the version of a service specific hashring. It is 0 until the ring is first loaded
+		// and incremented on every membership change. Versions are counted by each host, so they only
+		// compare across hosts which observed the same sequence of changes.
+		RingVersion(service Service) (uint64, error)
+
+		// LookupForPort will return host which is an owner for provided key among hosts advertising the port,
+		// PortGRPC or PortTchannel. A host which didn't enable a protocol yet is left out of its view, so while
+		// services migrate between protocols the same key can have different owners in different views.
+		LookupForPort(service Service, key, port string) (HostInfo, error)
+
+		// PinKey makes Lookup of the key in the given service ring return host, overriding the hash,
+		// e.g. to route a workflow to a canary host while debugging. Pins are kept in memory of this host only,
+		// until UnpinKey or a restart. Other lookup methods ignore them.
+		PinKey(service Service, key string, host HostInfo) error
+
+		// UnpinKey reverts PinKey
+		UnpinKey(service Service, key string) error
+
+		// ShardFor returns the shard of numShards which owns the key, e.g. the history shard of a workflow ID.
+		// numShards must be positive.
+		ShardFor(key string, numShards int) int
+
+		// LookupShard will return host which is an owner for the shard, as the history shard controller resolves it.
+		LookupShard(service Service, shardID int) (HostInfo, error)
+
+		// LookupShardRange returns the owner of every shard from lo up to but excluding hi, keyed by shard ID,
+		// as LookupShard would return them. It walks the ring once for all shards, e.g. to reconcile all history shards.
+		LookupShardRange(service Service, lo, hi int) (map[int]HostInfo, error)
+
+		// LookupN will return up to n distinct hosts which own the provided key, in ring order.
+		// The first host is the same one Lookup returns.
+		LookupN(service Service, key string, n int) ([]HostInfo, error)
+
+		// LookupNDiverse is LookupN which spreads owners across failure domains, e.g. zones with LabelZone.
+		// It walks the ring skipping hosts whose labelKey value is already used, and only repeats a value once
+		// all values are used. The first host is the same one Lookup returns.
+		LookupNDiverse(service Service, key string, n int, labelKey string) ([]HostInfo, error)
+
+		// LookupExcluding will return host which is an owner for provided key, skipping the excluded host.
+		// ErrOnlyOwnerExcluded is returned if there is no other host in the ring.
+		LookupExcluding(service Service, key string, exclude HostInfo) (HostInfo, error)
+
+		// LookupBatch will return the owner of every key, in the order of keys. It is cheaper than calling Lookup
+		// for every key, as the ring is locked once for the whole batch.
+		LookupBatch(service Service, keys []string) ([]HostInfo, error)
+
+		// LookupZoneAware will return an owner of the key in localZone if one of the first few hosts in ring order
+		// is labeled with it, otherwise the same host as Lookup. It trades consistency of routing for less
+		// cross-zone traffic, so unlike Lookup different zones may route the same key to different hosts.
+		LookupZoneAware(service Service, key, localZone string) (HostInfo, error)
 
 		// Subscribe adds a subscriber which will get detailed change data on the given
-		// channel, whenever membership changes.
-		Subscribe(service, name string, notifyChannel chan<- *ChangedEvent) error
+		// channel, whenever membership changes. Rapid changes are coalesced into a single event.
+		// Notifications are not blocking, up to 16 events are buffered for a subscriber which doesn't keep up.
+		// Past that the oldest buffered event is dropped and the next delivered one has ResyncRequired set.
+		Subscribe(service Service, name string, notifyChannel chan<- *ChangedEvent) error
+
+		// SetWarmupHook sets a function called in the background for every host added to any service ring,
+		// e.g. to connect to a host before requests are routed to it. A nil hook disables warmup.
+		SetWarmupHook(hook func(HostInfo))
 
 		// Unsubscribe removes a subscriber for this service.
-		Unsubscribe(service, name string) error
+		Unsubscribe(service Service, name string) error
+
+		// SubscribeShard calls handler whenever one of numShards shards changes owner in the given
+		// service ring. Membership storms are coalesced, so a shard is reported once it settles.
+		SubscribeShard(service Service, numShards int, handler func(shardID int, owner HostInfo)) error
+
+		// WatchKey returns a channel receiving the new owner of the key in the given service ring whenever it changes,
+		// along with a function which stops the watch and closes the channel.
+		// It is finer grained than SubscribeShard, e.g. for holders of a lock on a single workflow.
+		WatchKey(service Service, key string) (<-chan HostInfo, func(), error)
+
+		// RecentChanges returns up to limit most recent membership changes of all service rings, oldest first,
+		// all the kept ones when limit is not positive. The number of changes kept is set with WithChangeHistory.
+		RecentChanges(limit int) []TimestampedChange
 
 		// MemberCount returns host count in a service specific hashring
-		MemberCount(service string) (int, error)
+		MemberCount(service Service) (int, error)
+
+		// LastRebalanceMoved returns the share of the key space which changed owner on the last
+		// membership change of a service specific hashring, in basis points (10000 means all keys moved)
+		LastRebalanceMoved(service Service) (int, error)
+
+		// DriftCount returns the number of members which differed between a service specific hashring and
+		// the peer provider on the last reconciliation, see WithReconciliation
+		DriftCount(service Service) (int, error)
+
+		// LoadDistribution returns number of virtual nodes owned by each host address in a service specific hashring,
+		// hosts get virtual nodes in proportion to their weight
+		LoadDistribution(service Service) (map[string]int, error)
 
 		// Members returns all host addresses in a service specific hashring
-		Members(service string) ([]HostInfo, error)
+		Members(service Service) ([]HostInfo, error)
+
+		// Peers returns all hosts in a service specific hashring except this host, as returned by WhoAmI.
+		// Members are matched with Equals, so a host which restarted with another identity is a peer.
+		Peers(service Service) ([]HostInfo, error)
+
+		// Leader returns the member of a service specific hashring elected to run singleton work, e.g. a scanner.
+		// All hosts agree on the leader as long as they agree on the members, and elect a new one when it leaves.
+		Leader(service Service) (HostInfo, error)
+
+		// MembersWithLabel returns hosts in a service specific hashring which have the label set to value,
+		// e.g. members of an availability zone with LabelZone
+		MembersWithLabel(service Service, key, value string) ([]HostInfo, error)
+
+		// HostLoad returns load scores of hosts in a service specific hashring keyed by host address,
+		// as collected by the LoadReporter set with WithLoadTracking. Old reports decay exponentially,
+		// so hosts which stopped reporting age out.
+		HostLoad(service Service) (map[string]float64, error)
 
 		// LookupByAddress returns Host which owns IP:port tuple
-		LookupByAddress(service, address string) (HostInfo, error)
+		LookupByAddress(service Service, address string) (HostInfo, error)
+
+		// Refresh forces all service rings to reload members from the peer provider right away,
+		// it returns once all rings are refreshed or an error if that takes too long.
+		// This primitive is useful to recover from stuck membership without restarting the host.
+		Refresh() error
+
+		// IdentityConflicts returns members of all service rings which advertise the same identity.
+		// Only the lowest address of such members owns keys, so conflicts usually mean a misconfigured deployment.
+		IdentityConflicts() []IdentityConflict
+
+		// ConsistencyDigest returns a hash of the members of the service ring which doesn't depend on their order.
+		// Hosts with the same view of the ring return the same digest, so comparing digests across hosts
+		// detects diverged membership, e.g. during a network partition.
+		ConsistencyDigest(service Service) (uint64, error)
+
+		// ActivePeerConnections returns the number of members of all service rings connected to
+		// by the PeerConnectionManager set with WithPeerConnectionManager
+		ActivePeerConnections() int
+
+		// DescribeRings returns a point in time view of all service rings sorted by service,
+		// with the hash ranges owned by each member, and the shards it owns in rings set with WithShardCounts
+		DescribeRings() []RingDescription
+
+		// ExportSnapshot serializes members of all rings, see NewResolverFromSnapshot
+		ExportSnapshot() ([]byte, error)
 	}
 )
 
+// Service identifies a service ring in membership lookups
+type Service string
+
+const (
+	// ServiceFrontend is the frontend service ring
+	ServiceFrontend Service = service.Frontend
+	// ServiceHistory is the history service ring
+	ServiceHistory Service = service.History
+	// ServiceMatching is the matching service ring
+	ServiceMatching Service = service.Matching
+	// ServiceWorker is the worker service ring
+	ServiceWorker Service = service.Worker
+)
+
+// ParseService converts a bare service name into a Service, returning an error for unknown services.
+// It is meant for callers which still get service names as strings, e.g. from configuration.
+func ParseService(name string) (Service, error) {
+	for _, s := range service.List {
+		if s == name {
+			return Service(name), nil
+		}
+	}
+	return "", fmt.Errorf("unknown service %q", name)
+}
+
+// String returns the service name
+func (s Service) String() string {
+	return string(s)
+}
+
 // MultiringResolver uses ring-per-service for membership information
 type MultiringResolver struct {
 	status int32
 
-	provider PeerProvider
-	rings    map[string]*ring
+	provider           PeerProvider
+	addressResolver    AddressResolver
+	servicePorts       map[string]PortMap
+	replicaPoints      map[string]int
+	healthChecker      HealthChecker
+	healthCheckConfig  HealthCheckConfig
+	loadReporter       LoadReporter
+	loadTrackingConfig LoadTrackingConfig
+	lookupCacheSize    int
+	connectionManager  PeerConnectionManager
+	domainRouting      DomainRoutingPolicy
+	hashRingOptions    []HashRingOption
+	reconcileInterval  time.Duration
+	reconcileCancel    context.CancelFunc
+	reconcileWG        sync.WaitGroup
+	minMembers         map[string]int
+	shardCounts        map[string]int
+	changeHistorySize  int
+	changes            *changeHistory
+	warmup             warmupHook
+	rings              map[string]*ring
+	self               selfCache // shared by the rings
+}
+
+// selfCache holds the host details cached by WhoAmI
+type selfCache struct {
+	value atomic.Value // *HostInfo, nil when not resolved yet
+}
+
+// ResolverOption sets the options of MultiringResolver
+type ResolverOption func(*MultiringResolver)
+
+// WithServicePorts returns a setter overriding ports of members in the given services rings.
+// It is needed when a host runs multiple services which listen to different ports,
+// so that GetNamedAddress of a member returns the address of the service it was looked up for.
+func WithServicePorts(servicePorts map[Service]PortMap) ResolverOption {
+	return func(rpo *MultiringResolver) {
+		rpo.servicePorts = make(map[string]PortMap, len(servicePorts))
+		for service, ports := range servicePorts {
+			rpo.servicePorts[string(service)] = ports.Clone()
+		}
+	}
+}
+
+// WithReplicaPoints returns a setter for the number of virtual nodes per member in the given services rings,
+// services which are not listed keep the default of 100. See NewHashRing for the tradeoffs.
+func WithReplicaPoints(replicaPoints map[Service]int) ResolverOption {
+	return func(rpo *MultiringResolver) {
+		rpo.replicaPoints = make(map[string]int, len(replicaPoints))
+		for service, points := range replicaPoints {
+			rpo.replicaPoints[string(service)] = points
+		}
+	}
+}
+
+// WithHashRingOptions returns a setter for options of the hashrings of all services, e.g. WithHashFunc
+func WithHashRingOptions(opts ...HashRingOption) ResolverOption {
+	return func(rpo *MultiringResolver) {
+		rpo.hashRingOptions = append([]HashRingOption(nil), opts...)
+	}
 }
 
 var _ Resolver = (*MultiringResolver)(nil)
@@ -91,26 +350,59 @@ var _ Resolver = (*MultiringResolver)(nil)
 // NewResolver builds hashrings for all services
 func NewResolver(
 	provider PeerProvider,
+	metricsClient metrics.Client,
 	logger log.Logger,
+	opts ...ResolverOption,
 ) (*MultiringResolver, error) {
-	return NewMultiringResolver(service.List, provider, logger.WithTags(tag.ComponentServiceResolver)), nil
+	return NewMultiringResolver(service.List, provider, metricsClient, logger.WithTags(tag.ComponentServiceResolver), opts...), nil
 }
 
 // NewMultiringResolver creates hashrings for all services
 func NewMultiringResolver(
 	services []string,
 	provider PeerProvider,
+	metricsClient metrics.Client,
 	logger log.Logger,
+	opts ...ResolverOption,
 ) *MultiringResolver {
 	rpo := &MultiringResolver{
-		status:   common.DaemonStatusInitialized,
-		provider: provider,
-		rings:    make(map[string]*ring),
+		status:            common.DaemonStatusInitialized,
+		provider:          provider,
+		addressResolver:   DefaultAddressResolver,
+		rings:             make(map[string]*ring),
+		changeHistorySize: defaultChangeHistorySize,
+	}
+
+	for _, opt := range opts {
+		opt(rpo)
 	}
+	rpo.changes = newChangeHistory(rpo.changeHistorySize)
 
 	for _, s := range services {
-		rpo.rings[s] = newHashring(s, provider, logger)
+		rpo.rings[s] = newHashring(s, provider, metricsClient, logger)
+		rpo.rings[s].ports = rpo.servicePorts[s]
+		rpo.rings[s].ringOptions = rpo.hashRingOptions
+		rpo.rings[s].changes = rpo.changes
+		rpo.rings[s].warmup = &rpo.warmup
+		rpo.rings[s].self = &rpo.self
+		rpo.rings[s].numShards = rpo.shardCounts[s]
+		if points := rpo.replicaPoints[s]; points > 0 {
+			rpo.rings[s].replicas = points
+		}
+		if rpo.healthChecker != nil {
+			rpo.rings[s].health = newHealthState(rpo.healthChecker, rpo.healthCheckConfig)
+		}
+		if rpo.lookupCacheSize > 0 {
+			rpo.rings[s].cache = newLookupCache(rpo.lookupCacheSize)
+		}
+		if rpo.loadReporter != nil {
+			rpo.rings[s].load = newLoadState(rpo.loadReporter, rpo.loadTrackingConfig)
+		}
+		if rpo.connectionManager != nil {
+			rpo.rings[s].connections = newPeerConnections(rpo.connectionManager)
+		}
 	}
+	rpo.InvalidateSelf()
 	return rpo
 }
 
@@ -129,6 +421,13 @@ func (rpo *MultiringResolver) Start() {
 	for _, ring := range rpo.rings {
 		ring.Start()
 	}
+
+	if rpo.reconcileInterval > 0 {
+		var ctx context.Context
+		ctx, rpo.reconcileCancel = context.WithCancel(context.Background())
+		rpo.reconcileWG.Add(1)
+		go rpo.reconcileLoop(ctx, rpo.reconcileInterval)
+	}
 }
 
 // Stop stops all rings and membership provider
@@ -141,6 +440,11 @@ func (rpo *MultiringResolver) Stop() {
 		return
 	}
 
+	if rpo.reconcileCancel != nil {
+		rpo.reconcileCancel()
+		rpo.reconcileWG.Wait()
+	}
+
 	for _, ring := range rpo.rings {
 		ring.Stop()
 	}
@@ -148,25 +452,136 @@ func (rpo *MultiringResolver) Stop() {
 	rpo.provider.Stop()
 }
 
-// WhoAmI asks to provide current instance address
+// WhoAmI asks to provide current instance address, the result is cached until InvalidateSelf is called
+// or a ring sees this host updated or removed
 func (rpo *MultiringResolver) WhoAmI() (HostInfo, error) {
-	return rpo.provider.WhoAmI()
+	if self := rpo.self.load(); self != nil {
+		return *self, nil
+	}
+	self, err := rpo.provider.WhoAmI()
+	if err != nil {
+		return HostInfo{}, err
+	}
+	rpo.self.value.Store(&self)
+	return self, nil
+}
+
+// InvalidateSelf drops the host details cached by WhoAmI
+func (rpo *MultiringResolver) InvalidateSelf() {
+	rpo.self.invalidate()
+}
+
+func (c *selfCache) load() *HostInfo {
+	self, _ := c.value.Load().(*HostInfo)
+	return self
+}
+
+func (c *selfCache) invalidate() {
+	c.value.Store((*HostInfo)(nil))
+}
+
+// invalidateIfChanged drops the cached self if the ring change updated or removed it,
+// e.g. because the peer provider now advertises other ports or another address for this host
+func (c *selfCache) invalidateIfChanged(event *ChangedEvent) {
+	if c == nil {
+		return
+	}
+	self := c.load()
+	if self == nil {
+		return
+	}
+	for _, hosts := range [][]HostInfo{event.HostsUpdated, event.HostsRemoved} {
+		for _, host := range hosts {
+			if host.GetAddress() == self.GetAddress() {
+				c.invalidate()
+				return
+			}
+		}
+	}
+}
+
+// EvictSelf is used to remove this host from membership ring.
+// The departure is announced through the peer provider, then rings are refreshed until this host is gone
+// from all of them. The time it took per ring is emitted as eviction propagation latency.
+func (rpo *MultiringResolver) EvictSelf(ctx context.Context) error {
+	self, err := rpo.WhoAmI()
+	if err != nil {
+		return err
+	}
+	pending := make(map[string]*ring)
+	for service, r := range rpo.rings {
+		if r.hasMember(self.GetAddress()) {
+			pending[service] = r
+		}
+	}
+
+	start := time.Now()
+	if err := rpo.provider.SelfEvict(); err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(evictionPollInterval)
+	defer ticker.Stop()
+	for {
+		for service, r := range pending {
+			// refreshed directly as the refresh worker is rate limited, the ring serializes it with the worker
+			if err := r.refreshMembers(); err != nil {
+				r.logger.Warn("failed to refresh ring while evicting self", tag.Error(err))
+				continue
+			}
+			if !r.hasMember(self.GetAddress()) {
+				r.scope.RecordTimer(metrics.HashringEvictionPropagationLatency, time.Since(start))
+				delete(pending, service)
+			}
+		}
+		if len(pending) == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("host is still a member of %d rings: %w", len(pending), ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// Drain is used to stop routing new work of the service to this host
+func (rpo *MultiringResolver) Drain(service Service) error {
+	ring, err := rpo.getRing(service)
+	if err != nil {
+		return err
+	}
+	if err := rpo.provider.Drain(string(service)); err != nil {
+		return err
+	}
+	rpo.InvalidateSelf()
+	ring.signalRefresh()
+	return nil
 }
 
-// EvictSelf is used to remove this host from membership ring
-func (rpo *MultiringResolver) EvictSelf() error {
-	return rpo.provider.SelfEvict()
+// Undrain is used to route work of the service to this host again after Drain
+func (rpo *MultiringResolver) Undrain(service Service) error {
+	ring, err := rpo.getRing(service)
+	if err != nil {
+		return err
+	}
+	if err := rpo.provider.Undrain(string(service)); err != nil {
+		return err
+	}
+	rpo.InvalidateSelf()
+	ring.signalRefresh()
+	return nil
 }
 
-func (rpo *MultiringResolver) getRing(service string) (*ring, error) {
-	ring, found := rpo.rings[service]
+func (rpo *MultiringResolver) getRing(service Service) (*ring, error) {
+	ring, found := rpo.rings[string(service)]
 	if !found {
 		return nil, fmt.Errorf("service %q is not tracked by Resolver", service)
 	}
 	return ring, nil
 }
 
-func (rpo *MultiringResolver) Lookup(service string, key string) (HostInfo, error) {
+func (rpo *MultiringResolver) Lookup(service Service, key string) (HostInfo, error) {
 	ring, err := rpo.getRing(service)
 	if err != nil {
 		return HostInfo{}, err
@@ -174,7 +589,114 @@ func (rpo *MultiringResolver) Lookup(service string, key string) (HostInfo, erro
 	return ring.Lookup(key)
 }
 
-func (rpo *MultiringResolver) Subscribe(service string, name string, notifyChannel chan<- *ChangedEvent) error {
+func (rpo *MultiringResolver) LookupInto(service Service, key string, out *HostInfo) error {
+	ring, err := rpo.getRing(service)
+	if err != nil {
+		return err
+	}
+	return ring.LookupInto(key, out)
+}
+
+func (rpo *MultiringResolver) LookupWithVersion(service Service, key string) (HostInfo, uint64, error) {
+	ring, err := rpo.getRing(service)
+	if err != nil {
+		return HostInfo{}, 0, err
+	}
+	return ring.LookupWithVersion(key)
+}
+
+func (rpo *MultiringResolver) RingVersion(service Service) (uint64, error) {
+	ring, err := rpo.getRing(service)
+	if err != nil {
+		return 0, err
+	}
+	return ring.Version(), nil
+}
+
+func (rpo *MultiringResolver) PinKey(service Service, key string, host HostInfo) error {
+	ring, err := rpo.getRing(service)
+	if err != nil {
+		return err
+	}
+	ring.PinKey(key, host)
+	return nil
+}
+
+func (rpo *MultiringResolver) UnpinKey(service Service, key string) error {
+	ring, err := rpo.getRing(service)
+	if err != nil {
+		return err
+	}
+	ring.UnpinKey(key)
+	return nil
+}
+
+func (rpo *MultiringResolver) LookupForPort(service Service, key, port string) (HostInfo, error) {
+	ring, err := rpo.getRing(service)
+	if err != nil {
+		return HostInfo{}, err
+	}
+	return ring.LookupForPort(key, port)
+}
+
+func (rpo *MultiringResolver) LookupN(service Service, key string, n int) ([]HostInfo, error) {
+	ring, err := rpo.getRing(service)
+	if err != nil {
+		return nil, err
+	}
+	return ring.LookupN(key, n)
+}
+
+func (rpo *MultiringResolver) LookupBatch(service Service, keys []string) ([]HostInfo, error) {
+	ring, err := rpo.getRing(service)
+	if err != nil {
+		return nil, err
+	}
+	return ring.LookupBatch(keys)
+}
+
+func (rpo *MultiringResolver) LookupZoneAware(service Service, key, localZone string) (HostInfo, error) {
+	ring, err := rpo.getRing(service)
+	if err != nil {
+		return HostInfo{}, err
+	}
+	return ring.LookupZoneAware(key, localZone)
+}
+
+func (rpo *MultiringResolver) LookupNDiverse(service Service, key string, n int, labelKey string) ([]HostInfo, error) {
+	ring, err := rpo.getRing(service)
+	if err != nil {
+		return nil, err
+	}
+	return ring.LookupNDiverse(key, n, labelKey)
+}
+
+func (rpo *MultiringResolver) LookupExcluding(service Service, key string, exclude HostInfo) (HostInfo, error) {
+	ring, err := rpo.getRing(service)
+	if err != nil {
+		return HostInfo{}, err
+	}
+	return ring.LookupExcluding(key, exclude)
+}
+
+// ShardFor uses the same hash as common.WorkflowIDToHistoryShard, so that all services agree on key placement
+func (rpo *MultiringResolver) ShardFor(key string, numShards int) int {
+	return common.WorkflowIDToHistoryShard(key, numShards)
+}
+
+func (rpo *MultiringResolver) LookupShard(service Service, shardID int) (HostInfo, error) {
+	return rpo.Lookup(service, shardKey(shardID))
+}
+
+func (rpo *MultiringResolver) LookupShardRange(service Service, lo, hi int) (map[int]HostInfo, error) {
+	ring, err := rpo.getRing(service)
+	if err != nil {
+		return nil, err
+	}
+	return ring.LookupShardRange(lo, hi)
+}
+
+func (rpo *MultiringResolver) Subscribe(service Service, name string, notifyChannel chan<- *ChangedEvent) error {
 	ring, err := rpo.getRing(service)
 	if err != nil {
 		return err
@@ -182,7 +704,7 @@ func (rpo *MultiringResolver) Subscribe(service string, name string, notifyChann
 	return ring.Subscribe(name, notifyChannel)
 }
 
-func (rpo *MultiringResolver) Unsubscribe(service string, name string) error {
+func (rpo *MultiringResolver) Unsubscribe(service Service, name string) error {
 	ring, err := rpo.getRing(service)
 	if err != nil {
 		return err
@@ -190,7 +712,23 @@ func (rpo *MultiringResolver) Unsubscribe(service string, name string) error {
 	return ring.Unsubscribe(name)
 }
 
-func (rpo *MultiringResolver) Members(service string) ([]HostInfo, error) {
+func (rpo *MultiringResolver) SubscribeShard(service Service, numShards int, handler func(shardID int, owner HostInfo)) error {
+	ring, err := rpo.getRing(service)
+	if err != nil {
+		return err
+	}
+	return ring.SubscribeShard(numShards, handler)
+}
+
+func (rpo *MultiringResolver) WatchKey(service Service, key string) (<-chan HostInfo, func(), error) {
+	ring, err := rpo.getRing(service)
+	if err != nil {
+		return nil, nil, err
+	}
+	return ring.WatchKey(key)
+}
+
+func (rpo *MultiringResolver) Members(service Service) ([]HostInfo, error) {
 	ring, err := rpo.getRing(service)
 	if err != nil {
 		return nil, err
@@ -198,23 +736,162 @@ func (rpo *MultiringResolver) Members(service string) ([]HostInfo, error) {
 	return ring.Members(), nil
 }
 
-func (rpo *MultiringResolver) LookupByAddress(service, address string) (HostInfo, error) {
-	members, err := rpo.Members(service)
+func (rpo *MultiringResolver) Peers(service Service) ([]HostInfo, error) {
+	ring, err := rpo.getRing(service)
+	if err != nil {
+		return nil, err
+	}
+	self, err := rpo.WhoAmI()
+	if err != nil {
+		return nil, err
+	}
+	return ring.Peers(self), nil
+}
+
+func (rpo *MultiringResolver) Leader(service Service) (HostInfo, error) {
+	ring, err := rpo.getRing(service)
+	if err != nil {
+		return HostInfo{}, err
+	}
+	return ring.Leader()
+}
+
+func (rpo *MultiringResolver) IdentityConflicts() []IdentityConflict {
+	var res []IdentityConflict
+	for _, ring := range rpo.rings {
+		res = append(res, ring.IdentityConflicts()...)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Service != res[j].Service {
+			return res[i].Service < res[j].Service
+		}
+		return res[i].Identity < res[j].Identity
+	})
+	return res
+}
+
+func (rpo *MultiringResolver) ConsistencyDigest(service Service) (uint64, error) {
+	ring, err := rpo.getRing(service)
+	if err != nil {
+		return 0, err
+	}
+	return ring.digest(), nil
+}
+
+func (rpo *MultiringResolver) ActivePeerConnections() int {
+	count := 0
+	for _, ring := range rpo.rings {
+		count += ring.ActivePeerConnections()
+	}
+	return count
+}
+
+func (rpo *MultiringResolver) DescribeRings() []RingDescription {
+	res := make([]RingDescription, 0, len(rpo.rings))
+	for _, ring := range rpo.rings {
+		res = append(res, ring.describe())
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Service < res[j].Service })
+	return res
+}
+
+func (rpo *MultiringResolver) Refresh() error {
+	errCh := make(chan error, len(rpo.rings))
+	for name, r := range rpo.rings {
+		go func(name string, r *ring) {
+			if err := r.forceRefresh(); err != nil {
+				errCh <- fmt.Errorf("refreshing %q ring: %w", name, err)
+				return
+			}
+			errCh <- nil
+		}(name, r)
+	}
+
+	timer := time.NewTimer(forceRefreshTimeout)
+	defer timer.Stop()
+	var errs error
+	for range rpo.rings {
+		select {
+		case err := <-errCh:
+			errs = multierr.Append(errs, err)
+		case <-timer.C:
+			return multierr.Append(errs, fmt.Errorf("rings were not refreshed within %v", forceRefreshTimeout))
+		}
+	}
+	return errs
+}
+
+func (rpo *MultiringResolver) MembersWithLabel(service Service, key, value string) ([]HostInfo, error) {
+	ring, err := rpo.getRing(service)
+	if err != nil {
+		return nil, err
+	}
+	return ring.MembersWithLabel(key, value), nil
+}
+
+func (rpo *MultiringResolver) HostLoad(service Service) (map[string]float64, error) {
+	ring, err := rpo.getRing(service)
+	if err != nil {
+		return nil, err
+	}
+	return ring.HostLoad(), nil
+}
+
+func (rpo *MultiringResolver) LookupByAddress(service Service, address string) (HostInfo, error) {
+	ring, err := rpo.getRing(service)
 	if err != nil {
 		return HostInfo{}, err
 	}
-	for _, m := range members {
+	for _, m := range ring.Members() {
 		if belongs, err := m.Belongs(address); err == nil && belongs {
 			return m, nil
 		}
 	}
+	// no literal match, members might be known by host names rather than IPs
+	host, _, port, err := parseAddr(address)
+	if err != nil {
+		return HostInfo{}, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), defaultLookupTimeout)
+	defer cancel()
+	addressIPs, err := lookupHost(ctx, rpo.addressResolver, host)
+	if err != nil {
+		return HostInfo{}, fmt.Errorf("host not found: %w", err)
+	}
+	if m, ok := ring.lookupResolvedAddress(addressIPs, port, rpo.addressResolver); ok {
+		return m, nil
+	}
 	return HostInfo{}, errors.New("host not found")
 }
 
-func (rpo *MultiringResolver) MemberCount(service string) (int, error) {
+func (rpo *MultiringResolver) MemberCount(service Service) (int, error) {
 	ring, err := rpo.getRing(service)
 	if err != nil {
 		return 0, err
 	}
 	return ring.MemberCount(), nil
 }
+
+func (rpo *MultiringResolver) DriftCount(service Service) (int, error) {
+	ring, err := rpo.getRing(service)
+	if err != nil {
+		return 0, err
+	}
+	return ring.DriftCount(), nil
+}
+
+func (rpo *MultiringResolver) LoadDistribution(service Service) (map[string]int, error) {
+	ring, err := rpo.getRing(service)
+	if err != nil {
+		return nil, err
+	}
+	return ring.LoadDistribution(), nil
+}
+
+func (rpo *MultiringResolver) LastRebalanceMoved(service Service) (int, error) {
+	ring, err := rpo.getRing(service)
+	if err != nil {
+		return 0, err
+	}
+	return ring.LastRebalanceMoved(), nil
+}
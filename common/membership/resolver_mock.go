@@ -27,6 +27,7 @@
 package membership
 
 import (
+	context "context"
 	reflect "reflect"
 
 	gomock "github.com/golang/mock/gomock"
@@ -55,22 +56,195 @@ func (m *MockResolver) EXPECT() *MockResolverMockRecorder {
 	return m.recorder
 }
 
+// ActivePeerConnections mocks base method.
+func (m *MockResolver) ActivePeerConnections() int {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ActivePeerConnections")
+	ret0, _ := ret[0].(int)
+	return ret0
+}
+
+// ActivePeerConnections indicates an expected call of ActivePeerConnections.
+func (mr *MockResolverMockRecorder) ActivePeerConnections() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ActivePeerConnections", reflect.TypeOf((*MockResolver)(nil).ActivePeerConnections))
+}
+
+// ConsistencyDigest mocks base method.
+func (m *MockResolver) ConsistencyDigest(service Service) (uint64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ConsistencyDigest", service)
+	ret0, _ := ret[0].(uint64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ConsistencyDigest indicates an expected call of ConsistencyDigest.
+func (mr *MockResolverMockRecorder) ConsistencyDigest(service interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ConsistencyDigest", reflect.TypeOf((*MockResolver)(nil).ConsistencyDigest), service)
+}
+
+// DescribeRings mocks base method.
+func (m *MockResolver) DescribeRings() []RingDescription {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DescribeRings")
+	ret0, _ := ret[0].([]RingDescription)
+	return ret0
+}
+
+// DescribeRings indicates an expected call of DescribeRings.
+func (mr *MockResolverMockRecorder) DescribeRings() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DescribeRings", reflect.TypeOf((*MockResolver)(nil).DescribeRings))
+}
+
+// Drain mocks base method.
+func (m *MockResolver) Drain(service Service) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Drain", service)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Drain indicates an expected call of Drain.
+func (mr *MockResolverMockRecorder) Drain(service interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Drain", reflect.TypeOf((*MockResolver)(nil).Drain), service)
+}
+
+// DriftCount mocks base method.
+func (m *MockResolver) DriftCount(service Service) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DriftCount", service)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DriftCount indicates an expected call of DriftCount.
+func (mr *MockResolverMockRecorder) DriftCount(service interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DriftCount", reflect.TypeOf((*MockResolver)(nil).DriftCount), service)
+}
+
 // EvictSelf mocks base method.
-func (m *MockResolver) EvictSelf() error {
+func (m *MockResolver) EvictSelf(ctx context.Context) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "EvictSelf")
+	ret := m.ctrl.Call(m, "EvictSelf", ctx)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // EvictSelf indicates an expected call of EvictSelf.
-func (mr *MockResolverMockRecorder) EvictSelf() *gomock.Call {
+func (mr *MockResolverMockRecorder) EvictSelf(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "EvictSelf", reflect.TypeOf((*MockResolver)(nil).EvictSelf), ctx)
+}
+
+// ExportSnapshot mocks base method.
+func (m *MockResolver) ExportSnapshot() ([]byte, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ExportSnapshot")
+	ret0, _ := ret[0].([]byte)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ExportSnapshot indicates an expected call of ExportSnapshot.
+func (mr *MockResolverMockRecorder) ExportSnapshot() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ExportSnapshot", reflect.TypeOf((*MockResolver)(nil).ExportSnapshot))
+}
+
+// HostLoad mocks base method.
+func (m *MockResolver) HostLoad(service Service) (map[string]float64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "HostLoad", service)
+	ret0, _ := ret[0].(map[string]float64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// HostLoad indicates an expected call of HostLoad.
+func (mr *MockResolverMockRecorder) HostLoad(service interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "HostLoad", reflect.TypeOf((*MockResolver)(nil).HostLoad), service)
+}
+
+// IdentityConflicts mocks base method.
+func (m *MockResolver) IdentityConflicts() []IdentityConflict {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "IdentityConflicts")
+	ret0, _ := ret[0].([]IdentityConflict)
+	return ret0
+}
+
+// IdentityConflicts indicates an expected call of IdentityConflicts.
+func (mr *MockResolverMockRecorder) IdentityConflicts() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IdentityConflicts", reflect.TypeOf((*MockResolver)(nil).IdentityConflicts))
+}
+
+// InvalidateSelf mocks base method.
+func (m *MockResolver) InvalidateSelf() {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "InvalidateSelf")
+}
+
+// InvalidateSelf indicates an expected call of InvalidateSelf.
+func (mr *MockResolverMockRecorder) InvalidateSelf() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "InvalidateSelf", reflect.TypeOf((*MockResolver)(nil).InvalidateSelf))
+}
+
+// LastRebalanceMoved mocks base method.
+func (m *MockResolver) LastRebalanceMoved(service Service) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "LastRebalanceMoved", service)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// LastRebalanceMoved indicates an expected call of LastRebalanceMoved.
+func (mr *MockResolverMockRecorder) LastRebalanceMoved(service interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "EvictSelf", reflect.TypeOf((*MockResolver)(nil).EvictSelf))
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LastRebalanceMoved", reflect.TypeOf((*MockResolver)(nil).LastRebalanceMoved), service)
+}
+
+// Leader mocks base method.
+func (m *MockResolver) Leader(service Service) (HostInfo, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Leader", service)
+	ret0, _ := ret[0].(HostInfo)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Leader indicates an expected call of Leader.
+func (mr *MockResolverMockRecorder) Leader(service interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Leader", reflect.TypeOf((*MockResolver)(nil).Leader), service)
+}
+
+// LoadDistribution mocks base method.
+func (m *MockResolver) LoadDistribution(service Service) (map[string]int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "LoadDistribution", service)
+	ret0, _ := ret[0].(map[string]int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// LoadDistribution indicates an expected call of LoadDistribution.
+func (mr *MockResolverMockRecorder) LoadDistribution(service interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LoadDistribution", reflect.TypeOf((*MockResolver)(nil).LoadDistribution), service)
 }
 
 // Lookup mocks base method.
-func (m *MockResolver) Lookup(service, key string) (HostInfo, error) {
+func (m *MockResolver) Lookup(service Service, key string) (HostInfo, error) {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "Lookup", service, key)
 	ret0, _ := ret[0].(HostInfo)
@@ -84,8 +258,23 @@ func (mr *MockResolverMockRecorder) Lookup(service, key interface{}) *gomock.Cal
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Lookup", reflect.TypeOf((*MockResolver)(nil).Lookup), service, key)
 }
 
+// LookupBatch mocks base method.
+func (m *MockResolver) LookupBatch(service Service, keys []string) ([]HostInfo, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "LookupBatch", service, keys)
+	ret0, _ := ret[0].([]HostInfo)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// LookupBatch indicates an expected call of LookupBatch.
+func (mr *MockResolverMockRecorder) LookupBatch(service, keys interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LookupBatch", reflect.TypeOf((*MockResolver)(nil).LookupBatch), service, keys)
+}
+
 // LookupByAddress mocks base method.
-func (m *MockResolver) LookupByAddress(service, address string) (HostInfo, error) {
+func (m *MockResolver) LookupByAddress(service Service, address string) (HostInfo, error) {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "LookupByAddress", service, address)
 	ret0, _ := ret[0].(HostInfo)
@@ -99,8 +288,158 @@ func (mr *MockResolverMockRecorder) LookupByAddress(service, address interface{}
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LookupByAddress", reflect.TypeOf((*MockResolver)(nil).LookupByAddress), service, address)
 }
 
+// LookupExcluding mocks base method.
+func (m *MockResolver) LookupExcluding(service Service, key string, exclude HostInfo) (HostInfo, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "LookupExcluding", service, key, exclude)
+	ret0, _ := ret[0].(HostInfo)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// LookupExcluding indicates an expected call of LookupExcluding.
+func (mr *MockResolverMockRecorder) LookupExcluding(service, key, exclude interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LookupExcluding", reflect.TypeOf((*MockResolver)(nil).LookupExcluding), service, key, exclude)
+}
+
+// LookupForDomain mocks base method.
+func (m *MockResolver) LookupForDomain(service Service, domain, key string) (HostInfo, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "LookupForDomain", service, domain, key)
+	ret0, _ := ret[0].(HostInfo)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// LookupForDomain indicates an expected call of LookupForDomain.
+func (mr *MockResolverMockRecorder) LookupForDomain(service, domain, key interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LookupForDomain", reflect.TypeOf((*MockResolver)(nil).LookupForDomain), service, domain, key)
+}
+
+// LookupForPort mocks base method.
+func (m *MockResolver) LookupForPort(service Service, key, port string) (HostInfo, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "LookupForPort", service, key, port)
+	ret0, _ := ret[0].(HostInfo)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// LookupForPort indicates an expected call of LookupForPort.
+func (mr *MockResolverMockRecorder) LookupForPort(service, key, port interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LookupForPort", reflect.TypeOf((*MockResolver)(nil).LookupForPort), service, key, port)
+}
+
+// LookupInto mocks base method.
+func (m *MockResolver) LookupInto(service Service, key string, out *HostInfo) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "LookupInto", service, key, out)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// LookupInto indicates an expected call of LookupInto.
+func (mr *MockResolverMockRecorder) LookupInto(service, key, out interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LookupInto", reflect.TypeOf((*MockResolver)(nil).LookupInto), service, key, out)
+}
+
+// LookupN mocks base method.
+func (m *MockResolver) LookupN(service Service, key string, n int) ([]HostInfo, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "LookupN", service, key, n)
+	ret0, _ := ret[0].([]HostInfo)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// LookupN indicates an expected call of LookupN.
+func (mr *MockResolverMockRecorder) LookupN(service, key, n interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LookupN", reflect.TypeOf((*MockResolver)(nil).LookupN), service, key, n)
+}
+
+// LookupNDiverse mocks base method.
+func (m *MockResolver) LookupNDiverse(service Service, key string, n int, labelKey string) ([]HostInfo, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "LookupNDiverse", service, key, n, labelKey)
+	ret0, _ := ret[0].([]HostInfo)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// LookupNDiverse indicates an expected call of LookupNDiverse.
+func (mr *MockResolverMockRecorder) LookupNDiverse(service, key, n, labelKey interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LookupNDiverse", reflect.TypeOf((*MockResolver)(nil).LookupNDiverse), service, key, n, labelKey)
+}
+
+// LookupShard mocks base method.
+func (m *MockResolver) LookupShard(service Service, shardID int) (HostInfo, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "LookupShard", service, shardID)
+	ret0, _ := ret[0].(HostInfo)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// LookupShard indicates an expected call of LookupShard.
+func (mr *MockResolverMockRecorder) LookupShard(service, shardID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LookupShard", reflect.TypeOf((*MockResolver)(nil).LookupShard), service, shardID)
+}
+
+// LookupShardRange mocks base method.
+func (m *MockResolver) LookupShardRange(service Service, lo, hi int) (map[int]HostInfo, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "LookupShardRange", service, lo, hi)
+	ret0, _ := ret[0].(map[int]HostInfo)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// LookupShardRange indicates an expected call of LookupShardRange.
+func (mr *MockResolverMockRecorder) LookupShardRange(service, lo, hi interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LookupShardRange", reflect.TypeOf((*MockResolver)(nil).LookupShardRange), service, lo, hi)
+}
+
+// LookupWithVersion mocks base method.
+func (m *MockResolver) LookupWithVersion(service Service, key string) (HostInfo, uint64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "LookupWithVersion", service, key)
+	ret0, _ := ret[0].(HostInfo)
+	ret1, _ := ret[1].(uint64)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// LookupWithVersion indicates an expected call of LookupWithVersion.
+func (mr *MockResolverMockRecorder) LookupWithVersion(service, key interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LookupWithVersion", reflect.TypeOf((*MockResolver)(nil).LookupWithVersion), service, key)
+}
+
+// LookupZoneAware mocks base method.
+func (m *MockResolver) LookupZoneAware(service Service, key, localZone string) (HostInfo, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "LookupZoneAware", service, key, localZone)
+	ret0, _ := ret[0].(HostInfo)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// LookupZoneAware indicates an expected call of LookupZoneAware.
+func (mr *MockResolverMockRecorder) LookupZoneAware(service, key, localZone interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LookupZoneAware", reflect.TypeOf((*MockResolver)(nil).LookupZoneAware), service, key, localZone)
+}
+
 // MemberCount mocks base method.
-func (m *MockResolver) MemberCount(service string) (int, error) {
+func (m *MockResolver) MemberCount(service Service) (int, error) {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "MemberCount", service)
 	ret0, _ := ret[0].(int)
@@ -115,7 +454,7 @@ func (mr *MockResolverMockRecorder) MemberCount(service interface{}) *gomock.Cal
 }
 
 // Members mocks base method.
-func (m *MockResolver) Members(service string) ([]HostInfo, error) {
+func (m *MockResolver) Members(service Service) ([]HostInfo, error) {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "Members", service)
 	ret0, _ := ret[0].([]HostInfo)
@@ -129,6 +468,119 @@ func (mr *MockResolverMockRecorder) Members(service interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Members", reflect.TypeOf((*MockResolver)(nil).Members), service)
 }
 
+// MembersWithLabel mocks base method.
+func (m *MockResolver) MembersWithLabel(service Service, key, value string) ([]HostInfo, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "MembersWithLabel", service, key, value)
+	ret0, _ := ret[0].([]HostInfo)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// MembersWithLabel indicates an expected call of MembersWithLabel.
+func (mr *MockResolverMockRecorder) MembersWithLabel(service, key, value interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MembersWithLabel", reflect.TypeOf((*MockResolver)(nil).MembersWithLabel), service, key, value)
+}
+
+// Peers mocks base method.
+func (m *MockResolver) Peers(service Service) ([]HostInfo, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Peers", service)
+	ret0, _ := ret[0].([]HostInfo)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Peers indicates an expected call of Peers.
+func (mr *MockResolverMockRecorder) Peers(service interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Peers", reflect.TypeOf((*MockResolver)(nil).Peers), service)
+}
+
+// PinKey mocks base method.
+func (m *MockResolver) PinKey(service Service, key string, host HostInfo) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PinKey", service, key, host)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// PinKey indicates an expected call of PinKey.
+func (mr *MockResolverMockRecorder) PinKey(service, key, host interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PinKey", reflect.TypeOf((*MockResolver)(nil).PinKey), service, key, host)
+}
+
+// RecentChanges mocks base method.
+func (m *MockResolver) RecentChanges(limit int) []TimestampedChange {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RecentChanges", limit)
+	ret0, _ := ret[0].([]TimestampedChange)
+	return ret0
+}
+
+// RecentChanges indicates an expected call of RecentChanges.
+func (mr *MockResolverMockRecorder) RecentChanges(limit interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RecentChanges", reflect.TypeOf((*MockResolver)(nil).RecentChanges), limit)
+}
+
+// Refresh mocks base method.
+func (m *MockResolver) Refresh() error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Refresh")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Refresh indicates an expected call of Refresh.
+func (mr *MockResolverMockRecorder) Refresh() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Refresh", reflect.TypeOf((*MockResolver)(nil).Refresh))
+}
+
+// RingVersion mocks base method.
+func (m *MockResolver) RingVersion(service Service) (uint64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RingVersion", service)
+	ret0, _ := ret[0].(uint64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RingVersion indicates an expected call of RingVersion.
+func (mr *MockResolverMockRecorder) RingVersion(service interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RingVersion", reflect.TypeOf((*MockResolver)(nil).RingVersion), service)
+}
+
+// SetWarmupHook mocks base method.
+func (m *MockResolver) SetWarmupHook(hook func(HostInfo)) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "SetWarmupHook", hook)
+}
+
+// SetWarmupHook indicates an expected call of SetWarmupHook.
+func (mr *MockResolverMockRecorder) SetWarmupHook(hook interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetWarmupHook", reflect.TypeOf((*MockResolver)(nil).SetWarmupHook), hook)
+}
+
+// ShardFor mocks base method.
+func (m *MockResolver) ShardFor(key string, numShards int) int {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ShardFor", key, numShards)
+	ret0, _ := ret[0].(int)
+	return ret0
+}
+
+// ShardFor indicates an expected call of ShardFor.
+func (mr *MockResolverMockRecorder) ShardFor(key, numShards interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ShardFor", reflect.TypeOf((*MockResolver)(nil).ShardFor), key, numShards)
+}
+
 // Start mocks base method.
 func (m *MockResolver) Start() {
 	m.ctrl.T.Helper()
@@ -154,7 +606,7 @@ func (mr *MockResolverMockRecorder) Stop() *gomock.Call {
 }
 
 // Subscribe mocks base method.
-func (m *MockResolver) Subscribe(service, name string, notifyChannel chan<- *ChangedEvent) error {
+func (m *MockResolver) Subscribe(service Service, name string, notifyChannel chan<- *ChangedEvent) error {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "Subscribe", service, name, notifyChannel)
 	ret0, _ := ret[0].(error)
@@ -167,8 +619,50 @@ func (mr *MockResolverMockRecorder) Subscribe(service, name, notifyChannel inter
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Subscribe", reflect.TypeOf((*MockResolver)(nil).Subscribe), service, name, notifyChannel)
 }
 
+// SubscribeShard mocks base method.
+func (m *MockResolver) SubscribeShard(service Service, numShards int, handler func(int, HostInfo)) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SubscribeShard", service, numShards, handler)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SubscribeShard indicates an expected call of SubscribeShard.
+func (mr *MockResolverMockRecorder) SubscribeShard(service, numShards, handler interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SubscribeShard", reflect.TypeOf((*MockResolver)(nil).SubscribeShard), service, numShards, handler)
+}
+
+// Undrain mocks base method.
+func (m *MockResolver) Undrain(service Service) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Undrain", service)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Undrain indicates an expected call of Undrain.
+func (mr *MockResolverMockRecorder) Undrain(service interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Undrain", reflect.TypeOf((*MockResolver)(nil).Undrain), service)
+}
+
+// UnpinKey mocks base method.
+func (m *MockResolver) UnpinKey(service Service, key string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UnpinKey", service, key)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UnpinKey indicates an expected call of UnpinKey.
+func (mr *MockResolverMockRecorder) UnpinKey(service, key interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UnpinKey", reflect.TypeOf((*MockResolver)(nil).UnpinKey), service, key)
+}
+
 // Unsubscribe mocks base method.
-func (m *MockResolver) Unsubscribe(service, name string) error {
+func (m *MockResolver) Unsubscribe(service Service, name string) error {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "Unsubscribe", service, name)
 	ret0, _ := ret[0].(error)
@@ -181,6 +675,36 @@ func (mr *MockResolverMockRecorder) Unsubscribe(service, name interface{}) *gomo
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Unsubscribe", reflect.TypeOf((*MockResolver)(nil).Unsubscribe), service, name)
 }
 
+// WaitReady mocks base method.
+func (m *MockResolver) WaitReady(ctx context.Context) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "WaitReady", ctx)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// WaitReady indicates an expected call of WaitReady.
+func (mr *MockResolverMockRecorder) WaitReady(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "WaitReady", reflect.TypeOf((*MockResolver)(nil).WaitReady), ctx)
+}
+
+// WatchKey mocks base method.
+func (m *MockResolver) WatchKey(service Service, key string) (<-chan HostInfo, func(), error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "WatchKey", service, key)
+	ret0, _ := ret[0].(<-chan HostInfo)
+	ret1, _ := ret[1].(func())
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// WatchKey indicates an expected call of WatchKey.
+func (mr *MockResolverMockRecorder) WatchKey(service, key interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "WatchKey", reflect.TypeOf((*MockResolver)(nil).WatchKey), service, key)
+}
+
 // WhoAmI mocks base method.
 func (m *MockResolver) WhoAmI() (HostInfo, error) {
 	m.ctrl.T.Helper()
@@ -23,13 +23,22 @@
 package membership
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
 	"testing"
+	"time"
 
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
 
 	"github.com/uber/cadence/common"
 	"github.com/uber/cadence/common/log"
+	"github.com/uber/cadence/common/metrics"
+	"github.com/uber/cadence/common/service"
 )
 
 var testServices = []string{"test-worker", "test-services"}
@@ -78,6 +87,21 @@ func TestMethodsAreRoutedToARing(t *testing.T) {
 	_, err = a.Lookup("WRONG-RING-NAME", "key")
 	assert.Error(t, err)
 
+	owners, err := a.LookupN("test-worker", "key", 2)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(owners))
+	assert.Equal(t, hi, owners[0])
+
+	_, err = a.LookupN("WRONG-RING-NAME", "key", 2)
+	assert.Error(t, err)
+
+	next, err := a.LookupExcluding("test-worker", "key", hi)
+	assert.NoError(t, err)
+	assert.Equal(t, owners[1], next)
+
+	_, err = a.LookupExcluding("WRONG-RING-NAME", "key", hi)
+	assert.Error(t, err)
+
 	members, err := a.Members("test-worker")
 	assert.NoError(t, err)
 	assert.Equal(t, 2, len(members))
@@ -86,6 +110,9 @@ func TestMethodsAreRoutedToARing(t *testing.T) {
 	assert.Error(t, err)
 	assert.Equal(t, 0, len(nomembers))
 
+	_, err = a.MembersWithLabel("WRONG-RING-NAME", LabelZone, "zone-a")
+	assert.Error(t, err)
+
 	memcount, err := a.MemberCount("test-worker")
 	assert.NoError(t, err)
 	assert.Equal(t, 2, memcount)
@@ -94,6 +121,13 @@ func TestMethodsAreRoutedToARing(t *testing.T) {
 	assert.Error(t, err)
 	assert.Equal(t, 0, nomemcount)
 
+	load, err := a.LoadDistribution("test-worker")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{"127": defaultReplicaPoints, "128": defaultReplicaPoints}, load)
+
+	_, err = a.LoadDistribution("WRONG-RING-NAME")
+	assert.Error(t, err)
+
 	serr := a.Subscribe("test-worker", "sub1", changeCh)
 	assert.NoError(t, serr)
 
@@ -108,6 +142,272 @@ func TestMethodsAreRoutedToARing(t *testing.T) {
 
 }
 
+func TestLookupByAddressResolvesHostNames(t *testing.T) {
+	a, pp := newTestResolver(t)
+	ctrl := gomock.NewController(t)
+	addressResolver := NewMockAddressResolver(ctrl)
+	a.addressResolver = addressResolver
+
+	hosts := []HostInfo{
+		NewDetailedHostInfo("worker-1.svc:7933", "worker-1", PortMap{PortGRPC: 7833}),
+		NewDetailedHostInfo("worker-2.svc:7933", "worker-2", PortMap{PortGRPC: 7833}),
+	}
+	pp.EXPECT().GetMembers("test-worker").Return(hosts, nil).Times(1)
+	r, err := a.getRing("test-worker")
+	assert.NoError(t, err)
+	assert.NoError(t, r.refresh())
+
+	host, err := a.LookupByAddress("test-worker", "worker-2.svc:7833")
+	assert.NoError(t, err)
+	assert.Equal(t, "worker-2", host.Identity(), "literal match does not resolve host names")
+
+	addressResolver.EXPECT().LookupIP(gomock.Any(), "worker-1.svc").Return([]net.IP{net.ParseIP("10.0.0.1")}, nil).Times(2)
+	addressResolver.EXPECT().LookupIP(gomock.Any(), "worker-2.svc").Return([]net.IP{net.ParseIP("10.0.0.2")}, nil).Times(1)
+
+	host, err = a.LookupByAddress("test-worker", "10.0.0.2:7833")
+	assert.NoError(t, err)
+	assert.Equal(t, "worker-2", host.Identity())
+	host, err = a.LookupByAddress("test-worker", "10.0.0.1:7833")
+	assert.NoError(t, err)
+	assert.Equal(t, "worker-1", host.Identity(), "member host names are resolved once per ring change")
+
+	_, err = a.LookupByAddress("test-worker", "10.0.0.3:7833")
+	assert.Error(t, err)
+
+	pp.EXPECT().GetMembers("test-worker").Return(hosts[:1], nil).Times(1)
+	assert.NoError(t, r.refreshMembers())
+	host, err = a.LookupByAddress("test-worker", "10.0.0.1:7833")
+	assert.NoError(t, err)
+	assert.Equal(t, "worker-1", host.Identity(), "members are resolved again after the ring changed")
+}
+
+func TestDrainIsForwardedToProvider(t *testing.T) {
+	a, pp := newTestResolver(t)
+
+	pp.EXPECT().Drain("test-worker").Return(nil).Times(1)
+	pp.EXPECT().Undrain("test-worker").Return(errors.New("undrain failed")).Times(1)
+
+	assert.NoError(t, a.Drain("test-worker"))
+	assert.EqualError(t, a.Undrain("test-worker"), "undrain failed")
+
+	assert.Error(t, a.Drain("WRONG-RING-NAME"))
+	assert.Error(t, a.Undrain("WRONG-RING-NAME"))
+}
+
+func TestDrainOnlyAffectsTheGivenService(t *testing.T) {
+	self := NewHostInfo("127.0.0.1:7933")
+	other := NewHostInfo("127.0.0.2:7933")
+	provider := NewStaticPeerProvider(self, map[string][]HostInfo{
+		"test-worker":   {self, other},
+		"test-services": {self, other},
+	})
+	r := NewMultiringResolver(testServices, provider, metrics.NewNoopMetricsClient(), log.NewNoop())
+	for _, service := range testServices {
+		ring, err := r.getRing(Service(service))
+		require.NoError(t, err)
+		require.NoError(t, ring.refreshMembers())
+	}
+
+	require.NoError(t, r.Drain("test-worker"))
+	for _, service := range testServices {
+		ring, err := r.getRing(Service(service))
+		require.NoError(t, err)
+		require.NoError(t, ring.refreshMembers())
+	}
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		host, err := r.Lookup("test-worker", key)
+		require.NoError(t, err)
+		assert.Equal(t, other.GetAddress(), host.GetAddress(), "drained members own no keys of the service")
+	}
+	members, err := r.Members("test-worker")
+	require.NoError(t, err)
+	assert.Len(t, members, 2, "drained members are still listed")
+
+	owners := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		host, err := r.Lookup("test-services", fmt.Sprintf("key-%d", i))
+		require.NoError(t, err)
+		owners[host.GetAddress()] = true
+	}
+	assert.True(t, owners[self.GetAddress()], "other services still route to the host")
+
+	require.NoError(t, r.Undrain("test-worker"))
+	ring, err := r.getRing("test-worker")
+	require.NoError(t, err)
+	require.NoError(t, ring.refreshMembers())
+	assert.Zero(t, ring.members.drained)
+}
+
+func TestServicePortsOverrideMemberPorts(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	pp := NewMockPeerProvider(ctrl)
+	a := NewMultiringResolver(testServices, pp, metrics.NewNoopMetricsClient(), log.NewNoop(), WithServicePorts(map[Service]PortMap{
+		"test-worker":   {PortGRPC: 7833},
+		"test-services": {PortGRPC: 7834},
+	}))
+
+	// the same physical host runs both services
+	host := NewDetailedHostInfo("10.0.0.1:7933", "multi-role", PortMap{PortTchannel: 7933, PortGRPC: 7800})
+	pp.EXPECT().GetMembers(gomock.Any()).Return([]HostInfo{host}, nil).Times(2)
+	for _, service := range testServices {
+		r, err := a.getRing(Service(service))
+		assert.NoError(t, err)
+		assert.NoError(t, r.refresh())
+	}
+
+	worker, err := a.Lookup("test-worker", "key")
+	assert.NoError(t, err)
+	addr, err := worker.GetNamedAddress(PortGRPC)
+	assert.NoError(t, err)
+	assert.Equal(t, "10.0.0.1:7833", addr)
+
+	services, err := a.Lookup("test-services", "key")
+	assert.NoError(t, err)
+	addr, err = services.GetNamedAddress(PortGRPC)
+	assert.NoError(t, err)
+	assert.Equal(t, "10.0.0.1:7834", addr)
+
+	addr, err = services.GetNamedAddress(PortTchannel)
+	assert.NoError(t, err)
+	assert.Equal(t, "10.0.0.1:7933", addr, "ports without overrides are kept")
+
+	owner, err := a.LookupByAddress("test-services", "10.0.0.1:7834")
+	assert.NoError(t, err)
+	assert.Equal(t, "multi-role", owner.Identity())
+	_, err = a.LookupByAddress("test-services", "10.0.0.1:7833")
+	assert.Error(t, err, "grpc port of the other service does not belong to this ring")
+}
+
+func TestPeersExcludeSelf(t *testing.T) {
+	self := NewDetailedHostInfo("127.0.0.1:7933", "self", PortMap{PortGRPC: 7833})
+	other := NewDetailedHostInfo("127.0.0.2:7933", "other", PortMap{PortGRPC: 7833})
+	provider := NewStaticPeerProvider(self, map[string][]HostInfo{
+		"test-worker":   {self, other},
+		"test-services": {other},
+	})
+	a := NewMultiringResolver(testServices, provider, metrics.NewNoopMetricsClient(), log.NewNoop(),
+		WithServicePorts(map[Service]PortMap{"test-worker": {PortGRPC: 7834}}))
+	a.Start()
+	defer a.Stop()
+
+	peers, err := a.Peers("test-worker")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"other"}, identities(peers), "self is matched despite port overrides")
+
+	peers, err = a.Peers("test-services")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"other"}, identities(peers))
+
+	_, err = a.Peers("unknown")
+	assert.Error(t, err)
+}
+
+func TestReplicaPointsAreConfiguredPerService(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	pp := NewMockPeerProvider(ctrl)
+	a := NewMultiringResolver(testServices, pp, metrics.NewNoopMetricsClient(), log.NewNoop(), WithReplicaPoints(map[Service]int{
+		"test-worker": 400,
+	}))
+
+	pp.EXPECT().GetMembers(gomock.Any()).Return([]HostInfo{NewHostInfo("127.0.0.1:7933")}, nil).Times(2)
+	for _, service := range testServices {
+		r, err := a.getRing(Service(service))
+		assert.NoError(t, err)
+		assert.NoError(t, r.refresh())
+	}
+
+	load, err := a.LoadDistribution("test-worker")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{"127.0.0.1:7933": 400}, load)
+
+	load, err = a.LoadDistribution("test-services")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{"127.0.0.1:7933": defaultReplicaPoints}, load, "services without override keep the default")
+}
+
+func TestRefreshBypassesRateLimit(t *testing.T) {
+	a, pp := newTestResolver(t)
+
+	hosts := []HostInfo{NewHostInfo("127.0.0.1:7933")}
+	pp.EXPECT().GetMembers("test-worker").Return(hosts, nil).Times(2)
+	pp.EXPECT().GetMembers("test-services").Return(hosts, nil).Times(1)
+	pp.EXPECT().GetMembers("test-services").Return(nil, errors.New("provider failed")).Times(1)
+
+	assert.NoError(t, a.Refresh())
+	count, err := a.MemberCount("test-worker")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	err = a.Refresh()
+	assert.ErrorContains(t, err, "provider failed")
+	assert.ErrorContains(t, err, "test-services")
+}
+
+func TestIdentityConflictsOfAllRings(t *testing.T) {
+	a, pp := newTestResolver(t)
+
+	twins := []HostInfo{
+		NewDetailedHostInfo("10.0.0.1:7933", "twin", nil),
+		NewDetailedHostInfo("10.0.0.2:7933", "twin", nil),
+	}
+	pp.EXPECT().GetMembers(gomock.Any()).Return(twins, nil).Times(2)
+	for _, service := range testServices {
+		r, err := a.getRing(Service(service))
+		assert.NoError(t, err)
+		assert.NoError(t, r.refresh())
+	}
+
+	conflicts := a.IdentityConflicts()
+	assert.Len(t, conflicts, 2)
+	assert.Equal(t, "test-services", conflicts[0].Service)
+	assert.Equal(t, "test-worker", conflicts[1].Service)
+}
+
+func TestShardForIsStable(t *testing.T) {
+	a, _ := newTestResolver(t)
+
+	// changing these values reassigns workflows to different shards on upgrade
+	assert.Equal(t, 7504, a.ShardFor("workflow-id", 16384))
+	assert.Equal(t, 472, a.ShardFor("workflow-id", 1000))
+	assert.Equal(t, 0, a.ShardFor("workflow-id", 1))
+	assert.Equal(t, common.WorkflowIDToHistoryShard("another-id", 4096), a.ShardFor("another-id", 4096))
+}
+
+func TestLookupShardUsesShardKey(t *testing.T) {
+	a, pp := newTestResolver(t)
+
+	pp.EXPECT().GetMembers("test-worker").Return([]HostInfo{
+		NewHostInfo("127.0.0.1:7933"),
+		NewHostInfo("127.0.0.2:7933"),
+		NewHostInfo("127.0.0.3:7933"),
+	}, nil).Times(1)
+	r, err := a.getRing("test-worker")
+	assert.NoError(t, err)
+	assert.NoError(t, r.refresh())
+
+	for shardID := 0; shardID < 32; shardID++ {
+		owner, err := a.LookupShard("test-worker", shardID)
+		assert.NoError(t, err)
+		expected, err := a.Lookup("test-worker", string(rune(shardID)))
+		assert.NoError(t, err)
+		assert.Equal(t, expected, owner)
+	}
+
+	_, err = a.LookupShard("WRONG-RING-NAME", 1)
+	assert.Error(t, err)
+}
+
+func TestParseService(t *testing.T) {
+	s, err := ParseService(service.History)
+	assert.NoError(t, err)
+	assert.Equal(t, ServiceHistory, s)
+	assert.Equal(t, "cadence-history", s.String())
+
+	_, err = ParseService("cadence-unknown")
+	assert.Error(t, err)
+}
+
 func TestNonExistingRingReturnsError(t *testing.T) {
 	a, _ := newTestResolver(t)
 	_, err := a.getRing("non-existing")
@@ -123,11 +423,151 @@ func TestCallsAreForwardedToProvider(t *testing.T) {
 
 	a.status = common.DaemonStatusStarted
 	a.WhoAmI()
-	a.EvictSelf()
+	a.EvictSelf(context.Background())
 	a.Stop()
 
 }
 
+func TestEvictSelfWaitsUntilHostLeavesRings(t *testing.T) {
+	self := NewDetailedHostInfo("127.0.0.1:7933", "self", PortMap{PortGRPC: 7833})
+	other := NewDetailedHostInfo("127.0.0.2:7933", "other", PortMap{PortGRPC: 7833})
+	provider := NewStaticPeerProvider(self, map[string][]HostInfo{
+		"test-worker":   {self, other},
+		"test-services": {other},
+	})
+	scope := tally.NewTestScope("test", nil)
+	a := NewMultiringResolver(testServices, provider, metrics.NewClient(scope, metrics.History), log.NewNoop())
+	a.Start()
+	defer a.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, a.EvictSelf(ctx))
+
+	members, err := a.Members("test-worker")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"other"}, identities(members))
+
+	timers := scope.Snapshot().Timers()
+	assert.Contains(t, timers, "test.hashring_eviction_propagation_latency+hashring_service=test-worker,operation=Hashring")
+	assert.NotContains(t, timers, "test.hashring_eviction_propagation_latency+hashring_service=test-services,operation=Hashring",
+		"latency is only emitted for rings the host was a member of")
+}
+
+// stuckPeerProvider accepts self eviction but never removes the host, as if gossip didn't propagate
+type stuckPeerProvider struct {
+	*StaticPeerProvider
+}
+
+func (p stuckPeerProvider) SelfEvict() error {
+	return nil
+}
+
+func TestEvictSelfIsBoundedByContext(t *testing.T) {
+	self := NewDetailedHostInfo("127.0.0.1:7933", "self", PortMap{PortGRPC: 7833})
+	provider := stuckPeerProvider{NewStaticPeerProvider(self, map[string][]HostInfo{"test-worker": {self}})}
+	a := NewMultiringResolver(testServices, provider, metrics.NewNoopMetricsClient(), log.NewNoop())
+	a.Start()
+	defer a.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	err := a.EvictSelf(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestWhoAmIIsCachedUntilInvalidated(t *testing.T) {
+	a, mockedPeer := newTestResolver(t)
+
+	self := NewDetailedHostInfo("127.0.0.1:7933", "self", PortMap{PortGRPC: 7833})
+	rebound := NewDetailedHostInfo("127.0.0.1:7934", "self", PortMap{PortGRPC: 7834})
+	gomock.InOrder(
+		mockedPeer.EXPECT().WhoAmI().Return(HostInfo{}, errors.New("not bootstrapped")),
+		mockedPeer.EXPECT().WhoAmI().Return(self, nil),
+		mockedPeer.EXPECT().WhoAmI().Return(rebound, nil),
+	)
+
+	_, err := a.WhoAmI()
+	assert.Error(t, err, "errors are not cached")
+
+	for i := 0; i < 3; i++ {
+		host, err := a.WhoAmI()
+		assert.NoError(t, err)
+		assert.Equal(t, self, host)
+	}
+
+	a.InvalidateSelf()
+	host, err := a.WhoAmI()
+	assert.NoError(t, err)
+	assert.Equal(t, rebound, host)
+}
+
+func TestWhoAmIIsInvalidatedWhenRingUpdatesSelf(t *testing.T) {
+	a, mockedPeer := newTestResolver(t)
+	ring, err := a.getRing("test-worker")
+	require.NoError(t, err)
+
+	self := NewDetailedHostInfo("127.0.0.1:7933", "self", PortMap{PortGRPC: 7833})
+	rebound := NewDetailedHostInfo("127.0.0.1:7933", "self", PortMap{PortGRPC: 7834})
+	other := NewDetailedHostInfo("127.0.0.2:7933", "other", PortMap{PortGRPC: 7833})
+	gomock.InOrder(
+		mockedPeer.EXPECT().WhoAmI().Return(self, nil),
+		mockedPeer.EXPECT().WhoAmI().Return(rebound, nil),
+	)
+	gomock.InOrder(
+		mockedPeer.EXPECT().GetMembers("test-worker").Return([]HostInfo{self, other}, nil),
+		mockedPeer.EXPECT().GetMembers("test-worker").Return([]HostInfo{self, other.WithLabel(LabelZone, "z1")}, nil),
+		mockedPeer.EXPECT().GetMembers("test-worker").Return([]HostInfo{rebound, other}, nil),
+	)
+
+	require.NoError(t, ring.refreshMembers())
+	host, err := a.WhoAmI()
+	require.NoError(t, err)
+	assert.Equal(t, self, host)
+
+	require.NoError(t, ring.refreshMembers())
+	host, err = a.WhoAmI()
+	require.NoError(t, err)
+	assert.Equal(t, self, host, "changes of other members keep the cached self")
+
+	require.NoError(t, ring.refreshMembers())
+	host, err = a.WhoAmI()
+	require.NoError(t, err)
+	assert.Equal(t, rebound, host, "self re-advertised with other ports is asked for again")
+}
+
+// derivingPeerProvider builds self host details on every call, as ringpop provider does
+type derivingPeerProvider struct {
+	*StaticPeerProvider
+}
+
+func (p derivingPeerProvider) WhoAmI() (HostInfo, error) {
+	return NewDetailedHostInfo("127.0.0.1:7933", "self", PortMap{PortTchannel: 7933, PortGRPC: 7833}), nil
+}
+
+func BenchmarkWhoAmI(b *testing.B) {
+	provider := derivingPeerProvider{NewStaticPeerProvider(HostInfo{}, nil)}
+	r := NewMultiringResolver(nil, provider, metrics.NewNoopMetricsClient(), log.NewNoop())
+
+	b.Run("cached", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := r.WhoAmI(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("uncached", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			r.InvalidateSelf()
+			if _, err := r.WhoAmI(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
 func newTestResolver(t *testing.T) (*MultiringResolver, *MockPeerProvider) {
 
 	ctrl := gomock.NewController(t)
@@ -135,6 +575,7 @@ func newTestResolver(t *testing.T) (*MultiringResolver, *MockPeerProvider) {
 	return NewMultiringResolver(
 		testServices,
 		pp,
+		metrics.NewNoopMetricsClient(),
 		log.NewNoop(),
 	), pp
 }
@@ -0,0 +1,175 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2017-2020 Uber Technologies Inc.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package membership
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/uber/cadence/common/log"
+	"github.com/uber/cadence/common/metrics"
+)
+
+// ringBenchmarkSizes is the member counts ring benchmarks run with, e.g.
+// go test ./common/membership -run none -bench Ring -ring.sizes 10,5000
+var ringBenchmarkSizes = flag.String("ring.sizes", "10,100,1000", "comma separated member counts of ring benchmarks")
+
+const (
+	ringBenchmarkService = "test-worker"
+	ringBenchmarkKeys    = 1024
+)
+
+// ringBenchmark is a seeded service ring, whose members can be changed between iterations
+type ringBenchmark struct {
+	provider *StaticPeerProvider
+	resolver *MultiringResolver
+	ring     *ring
+	members  []HostInfo
+	keys     []string
+}
+
+// benchmarkRing runs fn as a sub-benchmark for every size of ringBenchmarkSizes, on a ring of as many members
+// built with opts. Ring changes are compared by running the same fn with different options.
+func benchmarkRing(b *testing.B, fn func(b *testing.B, rb *ringBenchmark), opts ...ResolverOption) {
+	for _, field := range strings.Split(*ringBenchmarkSizes, ",") {
+		size, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || size < 1 {
+			b.Fatalf("invalid ring size %q", field)
+		}
+		b.Run(fmt.Sprintf("members-%d", size), func(b *testing.B) {
+			fn(b, newRingBenchmark(b, size, opts...))
+		})
+	}
+}
+
+func newRingBenchmark(b *testing.B, size int, opts ...ResolverOption) *ringBenchmark {
+	rb := &ringBenchmark{
+		members: make([]HostInfo, 0, size),
+		keys:    make([]string, ringBenchmarkKeys),
+	}
+	for i := 0; i < size; i++ {
+		rb.members = append(rb.members, benchmarkMember(i))
+	}
+	for i := range rb.keys {
+		rb.keys[i] = fmt.Sprintf("workflow-%d", i)
+	}
+	rb.provider = NewStaticPeerProvider(HostInfo{}, map[string][]HostInfo{ringBenchmarkService: rb.members})
+	rb.resolver = NewMultiringResolver([]string{ringBenchmarkService}, rb.provider, metrics.NewNoopMetricsClient(), log.NewNoop(), opts...)
+	var err error
+	if rb.ring, err = rb.resolver.getRing(ringBenchmarkService); err != nil {
+		b.Fatal(err)
+	}
+	if err := rb.ring.refreshMembers(); err != nil {
+		b.Fatal(err)
+	}
+	return rb
+}
+
+func benchmarkMember(i int) HostInfo {
+	return NewHostInfo(fmt.Sprintf("10.%d.%d.%d:7933", i>>16&0xff, i>>8&0xff, i&0xff))
+}
+
+// setMembers replaces the members of the ring and applies the change
+func (rb *ringBenchmark) setMembers(b *testing.B, members []HostInfo) {
+	rb.provider.mu.Lock()
+	rb.provider.members[ringBenchmarkService] = members
+	rb.provider.mu.Unlock()
+	if err := rb.ring.refreshMembers(); err != nil {
+		b.Fatal(err)
+	}
+}
+
+// reportMovement reports the key space which changed owner on the last membership change as moved-%,
+// averaged over the iterations
+func reportMovement(b *testing.B, movedBasisPoints int64) {
+	b.ReportMetric(float64(movedBasisPoints)/basisPoints*100/float64(b.N), "moved-%")
+}
+
+func BenchmarkRingLookup(b *testing.B) {
+	benchmarkRing(b, func(b *testing.B, rb *ringBenchmark) {
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if _, err := rb.ring.Lookup(rb.keys[i%len(rb.keys)]); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkRingLookupN(b *testing.B) {
+	benchmarkRing(b, func(b *testing.B, rb *ringBenchmark) {
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if _, err := rb.ring.LookupN(rb.keys[i%len(rb.keys)], 3); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// BenchmarkRingRebalance measures applying a membership change, alternately a member joining and leaving,
+// and reports the share of the key space moved by it
+func BenchmarkRingRebalance(b *testing.B) {
+	benchmarkRing(b, func(b *testing.B, rb *ringBenchmark) {
+		grown := append(append([]HostInfo(nil), rb.members...), benchmarkMember(len(rb.members)))
+		var moved int64
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if i%2 == 0 {
+				rb.setMembers(b, grown)
+			} else {
+				rb.setMembers(b, rb.members)
+			}
+			moved += int64(rb.ring.LastRebalanceMoved())
+		}
+		b.StopTimer()
+		reportMovement(b, moved)
+	})
+}
+
+// BenchmarkRingDrain measures alternately draining and undraining a member, which moves only the keys it owns
+func BenchmarkRingDrain(b *testing.B) {
+	benchmarkRing(b, func(b *testing.B, rb *ringBenchmark) {
+		drained := append([]HostInfo(nil), rb.members...)
+		drained[0] = drained[0].WithLabel(LabelDrained, "true")
+		var moved int64
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if i%2 == 0 {
+				rb.setMembers(b, drained)
+			} else {
+				rb.setMembers(b, rb.members)
+			}
+			moved += int64(rb.ring.LastRebalanceMoved())
+		}
+		b.StopTimer()
+		reportMovement(b, moved)
+	})
+}
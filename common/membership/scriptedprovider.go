@@ -0,0 +1,126 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2017-2020 Uber Technologies Inc.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package membership
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/uber/cadence/common"
+)
+
+var errScriptedMembership = errors.New("membership of scripted peer provider only changes with pushed member sets")
+
+// ScriptedPeerProvider is a PeerProvider whose members of a single service are replaced by member sets
+// pushed to a channel, so that tests control every membership change.
+// Pushed sets are applied when members are read, so after a push Resolver.Refresh converges rings right away,
+// while other rings pick it up on their periodic refresh. The channel should be buffered to push without blocking.
+type ScriptedPeerProvider struct {
+	status  int32
+	self    HostInfo
+	service string
+	updates <-chan []HostInfo
+
+	mu          sync.Mutex
+	members     []HostInfo
+	subscribers map[string]chan<- *ChangedEvent
+}
+
+var _ PeerProvider = (*ScriptedPeerProvider)(nil)
+
+// NewScriptedPeerProvider returns a provider with no members until a member set of the service is pushed to updates.
+// Other services never have members. self is the host returned by WhoAmI.
+func NewScriptedPeerProvider(self HostInfo, service string, updates <-chan []HostInfo) *ScriptedPeerProvider {
+	return &ScriptedPeerProvider{
+		status:      common.DaemonStatusInitialized,
+		self:        self,
+		service:     service,
+		updates:     updates,
+		subscribers: make(map[string]chan<- *ChangedEvent),
+	}
+}
+
+// Start is a noop, member sets are read from the channel on demand
+func (p *ScriptedPeerProvider) Start() {
+	atomic.CompareAndSwapInt32(&p.status, common.DaemonStatusInitialized, common.DaemonStatusStarted)
+}
+
+// Stop is a noop, the channel is owned by the caller
+func (p *ScriptedPeerProvider) Stop() {
+	atomic.CompareAndSwapInt32(&p.status, common.DaemonStatusStarted, common.DaemonStatusStopped)
+}
+
+// GetMembers applies member sets pushed so far and returns the last one
+func (p *ScriptedPeerProvider) GetMembers(service string) ([]HostInfo, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if service != p.service {
+		return nil, nil
+	}
+	for {
+		select {
+		case members, ok := <-p.updates:
+			if !ok {
+				return append([]HostInfo(nil), p.members...), nil
+			}
+			p.members = append([]HostInfo(nil), members...)
+		default:
+			return append([]HostInfo(nil), p.members...), nil
+		}
+	}
+}
+
+// WhoAmI returns the host given to the constructor
+func (p *ScriptedPeerProvider) WhoAmI() (HostInfo, error) {
+	return p.self, nil
+}
+
+// SelfEvict is not supported, push a member set without this host instead
+func (p *ScriptedPeerProvider) SelfEvict() error {
+	return errScriptedMembership
+}
+
+// Drain is not supported, push a member set with this host labeled with LabelDrained instead
+func (p *ScriptedPeerProvider) Drain(service string) error {
+	return errScriptedMembership
+}
+
+// Undrain is not supported, see Drain
+func (p *ScriptedPeerProvider) Undrain(service string) error {
+	return errScriptedMembership
+}
+
+// Subscribe registers the subscriber. Pushed member sets are only noticed when members are read,
+// so subscribers are never notified.
+func (p *ScriptedPeerProvider) Subscribe(name string, notifyChannel chan<- *ChangedEvent) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := p.subscribers[name]; ok {
+		return fmt.Errorf("%q already subscribed to scripted provider", name)
+	}
+	p.subscribers[name] = notifyChannel
+	return nil
+}
@@ -0,0 +1,65 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2017-2020 Uber Technologies Inc.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package membership
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/uber/cadence/common/log"
+	"github.com/uber/cadence/common/metrics"
+)
+
+func TestScriptedPeerProviderDrivesMembershipTransitions(t *testing.T) {
+	a := NewDetailedHostInfo("127.0.0.1:7933", "a", nil)
+	b := NewDetailedHostInfo("127.0.0.2:7933", "b", nil)
+	updates := make(chan []HostInfo, 1)
+	provider := NewScriptedPeerProvider(a, "test-worker", updates)
+	r := NewMultiringResolver(testServices, provider, metrics.NewNoopMetricsClient(), log.NewNoop())
+	r.Start()
+	defer r.Stop()
+
+	_, err := r.LookupN("test-worker", "key", 2)
+	assert.Equal(t, ErrNoMembers, err, "nothing was pushed yet")
+
+	// add b next to a
+	updates <- []HostInfo{a, b}
+	require.NoError(t, r.Refresh())
+	owners, err := r.LookupN("test-worker", "key", 2)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"a", "b"}, identities(owners))
+
+	// remove a
+	updates <- []HostInfo{b}
+	require.NoError(t, r.Refresh())
+	owners, err = r.LookupN("test-worker", "key", 2)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b"}, identities(owners))
+
+	members, err := r.Members("test-services")
+	require.NoError(t, err)
+	assert.Empty(t, members, "only the scripted service has members")
+	assert.Error(t, provider.SelfEvict())
+}
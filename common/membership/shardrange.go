@@ -0,0 +1,139 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2017-2020 Uber Technologies Inc.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package membership
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/uber/cadence/common/metrics"
+)
+
+// shardPositions are shard IDs in the lower and the hash of their key in the upper 32 bits,
+// so that sorting them orders shards by position on the ring
+type shardPositions []uint64
+
+func (p shardPositions) Len() int           { return len(p) }
+func (p shardPositions) Less(i, j int) bool { return p[i] < p[j] }
+func (p shardPositions) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+// LookupShardRange returns the owner of every shard from lo up to but excluding hi, keyed by shard ID.
+// Shards are placed as LookupShard places them, but their keys are sorted by hash and matched with the ring points
+// in a single pass, instead of a ring search for every shard. ErrInsufficientHosts is returned if no member can own shards.
+func (r *ring) LookupShardRange(lo, hi int) (map[int]HostInfo, error) {
+	if lo < 0 || hi < lo {
+		return nil, fmt.Errorf("invalid shard range [%d, %d)", lo, hi)
+	}
+	start := time.Now()
+	defer func() { r.scope.RecordHistogramDuration(metrics.HashringLookupLatency, time.Since(start)) }()
+
+	r.members.RLock()
+	defer r.members.RUnlock()
+	owners, served, err := r.shardOwnersLocked(r.ring(), lo, hi)
+	if err != nil {
+		return nil, err
+	}
+	if r.connections != nil {
+		hosts := make([]HostInfo, 0, len(served))
+		for _, h := range served {
+			hosts = append(hosts, h)
+		}
+		r.connections.connect(r.service, hosts)
+	}
+	return owners, nil
+}
+
+// shardOwnersLocked is LookupShardRange on the given ring, members must be locked for reading.
+// Along with the owners, it returns the hosts owning the shards placed by the ring, keyed by address.
+func (r *ring) shardOwnersLocked(ring *HashRing, lo, hi int) (map[int]HostInfo, map[string]HostInfo, error) {
+	owners, served, err := r.placeShardsLocked(ring, lo, hi)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	r.pins.RLock()
+	if len(r.pins.hosts) > 0 {
+		for shardID := range owners {
+			if pinned, ok := r.pins.hosts[shardKey(shardID)]; ok {
+				owners[shardID] = pinned
+			}
+		}
+	}
+	r.pins.RUnlock()
+	return owners, served, nil
+}
+
+// placeShardsLocked is shardOwnersLocked without pins, shards are owned as the ring places them.
+func (r *ring) placeShardsLocked(ring *HashRing, lo, hi int) (map[int]HostInfo, map[string]HostInfo, error) {
+	positions := make(shardPositions, 0, hi-lo)
+	for shardID := lo; shardID < hi; shardID++ {
+		positions = append(positions, uint64(ring.hashKey(shardKey(shardID)))<<32|uint64(uint32(shardID)))
+	}
+	sort.Sort(positions)
+
+	owners := make(map[int]HostInfo, len(positions))
+	if len(positions) == 0 {
+		return owners, nil, nil
+	}
+	points := ring.points
+	if len(points) == 0 {
+		return nil, nil, r.emptyRingError(ring)
+	}
+
+	// point indexes grow past len(points) once the walk wraps around the ring, the owner found for a shard
+	// is reused by the following shards placed before it, as there is no member which can own keys in between
+	var (
+		next   int
+		owner  = -1
+		host   HostInfo
+		served = make(map[string]HostInfo)
+	)
+	for _, position := range positions {
+		hash := uint32(position >> 32)
+		for next < len(points) && points[next].hash < hash {
+			next++
+		}
+		if owner < next {
+			found := false
+			for i := next; i < next+len(points); i++ {
+				addr := points[i%len(points)].address
+				member, ok := r.members.keys[addr]
+				if !ok {
+					return nil, nil, fmt.Errorf("host not found in member keys, host: %q", addr)
+				}
+				if member.IsDrained() || r.health.isUnhealthy(addr) {
+					continue
+				}
+				owner, host, found = i, member, true
+				break
+			}
+			if !found {
+				return nil, nil, ErrInsufficientHosts
+			}
+			served[host.GetAddress()] = host
+		}
+		owners[int(uint32(position))] = host
+	}
+	return owners, served, nil
+}
@@ -0,0 +1,116 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2017-2020 Uber Technologies Inc.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package membership
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/uber/cadence/common/log"
+	"github.com/uber/cadence/common/metrics"
+)
+
+func TestLookupShardRangeMatchesKnownPlacement(t *testing.T) {
+	var members []HostInfo
+	for i := 1; i <= 7; i++ {
+		members = append(members, NewHostInfo(fmt.Sprintf("10.0.0.%d:7934", i)))
+	}
+	drained := members[2].WithLabel(LabelDrained, "true")
+	provider := NewStaticPeerProvider(HostInfo{}, map[string][]HostInfo{
+		"test-worker": append(append(append([]HostInfo(nil), members[:2]...), drained), members[3:]...),
+	})
+	r := NewMultiringResolver(testServices, provider, metrics.NewNoopMetricsClient(), log.NewNoop())
+	hr, err := r.getRing("test-worker")
+	require.NoError(t, err)
+	require.NoError(t, hr.refresh())
+
+	// drained members own no shards, so the placement is the one of a ring without them
+	available := append(append([]HostInfo(nil), members[:2]...), members[3:]...)
+	placement := make(map[int]string)
+	for owner, shards := range SimulatePlacement(available, nil, 1024) {
+		for _, shardID := range shards {
+			placement[shardID] = owner
+		}
+	}
+
+	owners, err := r.LookupShardRange("test-worker", 0, 1024)
+	require.NoError(t, err)
+	require.Len(t, owners, 1024)
+	for shardID, owner := range owners {
+		assert.Equal(t, placement[shardID], owner.GetAddress(), "shard %d", shardID)
+		expected, err := r.LookupShard("test-worker", shardID)
+		require.NoError(t, err)
+		assert.Equal(t, expected.GetAddress(), owner.GetAddress(), "shard %d", shardID)
+	}
+
+	owners, err = r.LookupShardRange("test-worker", 100, 110)
+	require.NoError(t, err)
+	assert.Len(t, owners, 10)
+	for shardID, owner := range owners {
+		assert.Equal(t, placement[shardID], owner.GetAddress(), "shard %d", shardID)
+	}
+
+	owners, err = r.LookupShardRange("test-worker", 5, 5)
+	require.NoError(t, err)
+	assert.Empty(t, owners)
+	_, err = r.LookupShardRange("test-worker", 5, 4)
+	assert.Error(t, err)
+	_, err = r.LookupShardRange("test-worker", -1, 4)
+	assert.Error(t, err)
+}
+
+func TestLookupShardRangeOnEmptyRing(t *testing.T) {
+	provider := NewStaticPeerProvider(HostInfo{}, nil)
+	r := NewMultiringResolver(testServices, provider, metrics.NewNoopMetricsClient(), log.NewNoop())
+	_, err := r.LookupShardRange("test-worker", 0, 16)
+	assert.Equal(t, ErrNoMembers, err)
+}
+
+func BenchmarkLookupShardRange(b *testing.B) {
+	benchmarkRing(b, func(b *testing.B, rb *ringBenchmark) {
+		b.Run("per-shard", func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				owners := make(map[int]HostInfo, 16384)
+				for shardID := 0; shardID < 16384; shardID++ {
+					owner, err := rb.resolver.LookupShard(ringBenchmarkService, shardID)
+					if err != nil {
+						b.Fatal(err)
+					}
+					owners[shardID] = owner
+				}
+			}
+		})
+		b.Run("range", func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := rb.resolver.LookupShardRange(ringBenchmarkService, 0, 16384); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	})
+}
@@ -0,0 +1,146 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2017-2020 Uber Technologies Inc.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package membership
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/uber/cadence/common"
+)
+
+var errInvalidShardSubscription = errors.New("shard subscription requires positive number of shards and a handler")
+
+// shardWatcher tracks owners of shards 0..numShards-1 and reports the ones which moved
+type shardWatcher struct {
+	numShards int
+	handler   func(shardID int, owner HostInfo)
+	signal    chan struct{} // coalesces ring changes while the watcher is busy
+	owners    []string      // address of the last reported owner of every shard
+}
+
+// shardKey is the hashring key of a history shard, it matches shard controller lookups
+func shardKey(shardID int) string {
+	return string(rune(shardID))
+}
+
+// SubscribeShard calls handler for every shard which changes owner after a ring change.
+// Current owners are recorded without notification, shards without an owner are reported once they get one.
+// Handler is called sequentially from a single goroutine, until the ring is stopped.
+func (r *ring) SubscribeShard(numShards int, handler func(shardID int, owner HostInfo)) error {
+	if numShards < 1 || handler == nil {
+		return errInvalidShardSubscription
+	}
+	if atomic.LoadInt32(&r.status) == common.DaemonStatusStopped {
+		return errors.New("ring is stopped")
+	}
+
+	w := &shardWatcher{
+		numShards: numShards,
+		handler:   handler,
+		signal:    make(chan struct{}, 1),
+		owners:    make([]string, numShards),
+	}
+	if owners, err := r.placedShardOwners(numShards); err == nil {
+		for shardID, owner := range owners {
+			w.owners[shardID] = owner.GetAddress()
+		}
+	}
+
+	r.shardWatchers.Lock()
+	r.shardWatchers.list = append(r.shardWatchers.list, w)
+	r.shardWatchers.Unlock()
+
+	r.shutdownWG.Add(1)
+	go r.shardWatchWorker(w)
+	return nil
+}
+
+// signalShardWatchers notifies shard watchers that owners might have changed, without blocking
+func (r *ring) signalShardWatchers() {
+	r.shardWatchers.Lock()
+	defer r.shardWatchers.Unlock()
+	for _, w := range r.shardWatchers.list {
+		select {
+		case w.signal <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (r *ring) shardWatchWorker(w *shardWatcher) {
+	defer r.shutdownWG.Done()
+
+	for {
+		select {
+		case <-r.shutdownCh:
+			return
+		case <-w.signal:
+			if !r.debounceShardSignal(w) {
+				return
+			}
+			r.reportShardOwners(w)
+		}
+	}
+}
+
+// debounceShardSignal waits for ring changes to settle, so that a membership storm is reported once.
+// It returns false if the ring is shutting down.
+func (r *ring) debounceShardSignal(w *shardWatcher) bool {
+	timer := time.NewTimer(refreshDebounce)
+	defer timer.Stop()
+	for {
+		select {
+		case <-r.shutdownCh:
+			return false
+		case <-w.signal:
+		case <-timer.C:
+			return true
+		}
+	}
+}
+
+// placedShardOwners returns the owners of shards 0..numShards-1, all of them placed by the same ring.
+// Unlike Lookup, it doesn't follow pins, use the lookup cache, record lookup latency or connect to the owners.
+func (r *ring) placedShardOwners(numShards int) (map[int]HostInfo, error) {
+	r.members.RLock()
+	defer r.members.RUnlock()
+	owners, _, err := r.placeShardsLocked(r.ring(), 0, numShards)
+	return owners, err
+}
+
+func (r *ring) reportShardOwners(w *shardWatcher) {
+	owners, err := r.placedShardOwners(len(w.owners))
+	if err != nil {
+		// keep the last known owners until the ring has members again
+		return
+	}
+	for shardID := range w.owners {
+		owner := owners[shardID]
+		if owner.GetAddress() != w.owners[shardID] {
+			w.owners[shardID] = owner.GetAddress()
+			w.handler(shardID, owner)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2017-2020 Uber Technologies Inc.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package membership
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/uber/cadence/common/log"
+	"github.com/uber/cadence/common/metrics"
+)
+
+// errReadOnlyResolver is returned by resolvers restored from a snapshot on attempts to change membership
+var errReadOnlyResolver = errors.New("resolver restored from snapshot is read-only")
+
+// snapshot is the serialized form of all rings of a resolver
+type snapshot struct {
+	Self          *HostInfo             `json:"self,omitempty"`
+	Services      map[string][]HostInfo `json:"services"`
+	ReplicaPoints map[Service]int       `json:"replicaPoints,omitempty"`
+}
+
+// ExportSnapshot serializes members of every ring, along with its replica points, and this host to JSON.
+// Members are sorted by address, so that snapshots of the same membership are identical.
+func (rpo *MultiringResolver) ExportSnapshot() ([]byte, error) {
+	snap := snapshot{
+		Services:      make(map[string][]HostInfo, len(rpo.rings)),
+		ReplicaPoints: make(map[Service]int, len(rpo.rings)),
+	}
+	if self, err := rpo.WhoAmI(); err == nil {
+		snap.Self = &self
+	}
+	for service, ring := range rpo.rings {
+		members := ring.Members()
+		sort.Slice(members, func(i, j int) bool { return members[i].GetAddress() < members[j].GetAddress() })
+		snap.Services[service] = members
+		snap.ReplicaPoints[Service(service)] = ring.replicas
+	}
+	return json.Marshal(snap)
+}
+
+// NewResolverFromSnapshot rebuilds a read-only resolver from data returned by ExportSnapshot.
+// The resolver is started with the replica points of the exported rings. Hash functions can't be serialized,
+// so it routes keys the same way the exported one did only if opts include the WithHashRingOptions it was created with.
+// Evicting or draining itself returns an error.
+func NewResolverFromSnapshot(data []byte, opts ...ResolverOption) (*MultiringResolver, error) {
+	var snap snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return nil, fmt.Errorf("decoding resolver snapshot: %w", err)
+	}
+	var self HostInfo
+	if snap.Self != nil {
+		self = *snap.Self
+	}
+	services := make([]string, 0, len(snap.Services))
+	for service := range snap.Services {
+		services = append(services, service)
+	}
+
+	provider := snapshotPeerProvider{NewStaticPeerProvider(self, snap.Services)}
+	opts = append([]ResolverOption{WithReplicaPoints(snap.ReplicaPoints)}, opts...)
+	rpo := NewMultiringResolver(services, provider, metrics.NewNoopMetricsClient(), log.NewNoop(), opts...)
+	rpo.Start()
+	return rpo, nil
+}
+
+// snapshotPeerProvider serves members restored from a snapshot, which can't be changed
+type snapshotPeerProvider struct {
+	*StaticPeerProvider
+}
+
+func (p snapshotPeerProvider) SelfEvict() error {
+	return errReadOnlyResolver
+}
+
+func (p snapshotPeerProvider) Drain(service string) error {
+	return errReadOnlyResolver
+}
+
+func (p snapshotPeerProvider) Undrain(service string) error {
+	return errReadOnlyResolver
+}
@@ -0,0 +1,128 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2017-2020 Uber Technologies Inc.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package membership
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/uber/cadence/common/log"
+	"github.com/uber/cadence/common/metrics"
+)
+
+func TestResolverSnapshotRoundTrip(t *testing.T) {
+	self := NewDetailedHostInfo("127.0.0.1:7933", "self", PortMap{PortGRPC: 7833})
+	provider := NewStaticPeerProvider(self, map[string][]HostInfo{
+		"test-worker": {
+			self,
+			NewDetailedHostInfo("127.0.0.2:7933", "second", PortMap{PortGRPC: 7833}),
+			NewDetailedHostInfo("127.0.0.3:7933", "third", PortMap{PortGRPC: 7833}).WithLabel(LabelDrained, "true"),
+		},
+		"test-services": {NewHostInfo("127.0.0.4:7933")},
+	})
+	original := NewMultiringResolver(testServices, provider, metrics.NewNoopMetricsClient(), log.NewNoop())
+	original.Start()
+	defer original.Stop()
+
+	data, err := original.ExportSnapshot()
+	require.NoError(t, err)
+	again, err := original.ExportSnapshot()
+	require.NoError(t, err)
+	assert.Equal(t, data, again, "snapshots are stable")
+
+	restored, err := NewResolverFromSnapshot(data)
+	require.NoError(t, err)
+	defer restored.Stop()
+
+	host, err := restored.WhoAmI()
+	assert.NoError(t, err)
+	assert.Equal(t, self, host)
+
+	for _, name := range testServices {
+		service := Service(name)
+		members, err := original.Members(service)
+		assert.NoError(t, err)
+		restoredMembers, err := restored.Members(service)
+		assert.NoError(t, err)
+		assert.ElementsMatch(t, identities(members), identities(restoredMembers))
+
+		for i := 0; i < 100; i++ {
+			key := fmt.Sprintf("key-%d", i)
+			expected, err := original.Lookup(service, key)
+			assert.NoError(t, err)
+			actual, err := restored.Lookup(service, key)
+			assert.NoError(t, err)
+			assert.Equal(t, expected.Identity(), actual.Identity())
+		}
+	}
+
+	_, err = restored.Lookup("unknown-service", "key")
+	assert.Error(t, err)
+	assert.Equal(t, errReadOnlyResolver, restored.EvictSelf(context.Background()))
+	assert.Equal(t, errReadOnlyResolver, restored.Drain("test-worker"))
+	assert.Equal(t, errReadOnlyResolver, restored.Undrain("test-worker"))
+}
+
+func TestResolverSnapshotKeepsRingOptions(t *testing.T) {
+	hash := WithHashFunc(func(b []byte) uint64 {
+		h := fnv.New64a()
+		h.Write(b)
+		return h.Sum64()
+	})
+	members := []HostInfo{NewHostInfo("127.0.0.1:7933"), NewHostInfo("127.0.0.2:7933"), NewHostInfo("127.0.0.3:7933")}
+	provider := NewStaticPeerProvider(members[0], map[string][]HostInfo{"test-worker": members})
+	original := NewMultiringResolver(testServices, provider, metrics.NewNoopMetricsClient(), log.NewNoop(),
+		WithReplicaPoints(map[Service]int{"test-worker": 7}), WithHashRingOptions(hash))
+	original.Start()
+	defer original.Stop()
+
+	data, err := original.ExportSnapshot()
+	require.NoError(t, err)
+	restored, err := NewResolverFromSnapshot(data, WithHashRingOptions(hash))
+	require.NoError(t, err)
+	defer restored.Stop()
+
+	expected, err := original.LoadDistribution("test-worker")
+	require.NoError(t, err)
+	actual, err := restored.LoadDistribution("test-worker")
+	require.NoError(t, err)
+	assert.Equal(t, expected, actual, "replica points are restored")
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		owner, err := original.Lookup("test-worker", key)
+		require.NoError(t, err)
+		restoredOwner, err := restored.Lookup("test-worker", key)
+		require.NoError(t, err)
+		assert.Equal(t, owner.GetAddress(), restoredOwner.GetAddress(), key)
+	}
+}
+
+func TestResolverFromInvalidSnapshot(t *testing.T) {
+	_, err := NewResolverFromSnapshot([]byte(`{"services":`))
+	assert.Error(t, err)
+}
@@ -0,0 +1,157 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2017-2020 Uber Technologies Inc.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package membership
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/uber/cadence/common"
+)
+
+// StaticPeerProvider is a PeerProvider with a fixed list of members per service and no gossip.
+// It gives deterministic membership to tests and single process setups.
+type StaticPeerProvider struct {
+	status int32
+	self   HostInfo
+
+	mu          sync.RWMutex
+	members     map[string][]HostInfo
+	subscribers map[string]chan<- *ChangedEvent
+}
+
+var _ PeerProvider = (*StaticPeerProvider)(nil)
+
+// NewStaticPeerProvider returns a provider which always reports given members of each service.
+// self is the host returned by WhoAmI.
+func NewStaticPeerProvider(self HostInfo, members map[string][]HostInfo) *StaticPeerProvider {
+	copied := make(map[string][]HostInfo, len(members))
+	for service, hosts := range members {
+		copied[service] = append([]HostInfo(nil), hosts...)
+	}
+	return &StaticPeerProvider{
+		status:      common.DaemonStatusInitialized,
+		self:        self,
+		members:     copied,
+		subscribers: make(map[string]chan<- *ChangedEvent),
+	}
+}
+
+// Start is a noop, membership is known upfront
+func (p *StaticPeerProvider) Start() {
+	atomic.CompareAndSwapInt32(&p.status, common.DaemonStatusInitialized, common.DaemonStatusStarted)
+}
+
+// Stop is a noop, there is nothing to clean up
+func (p *StaticPeerProvider) Stop() {
+	atomic.CompareAndSwapInt32(&p.status, common.DaemonStatusStarted, common.DaemonStatusStopped)
+}
+
+// GetMembers returns members of the service, unknown services have no members
+func (p *StaticPeerProvider) GetMembers(service string) ([]HostInfo, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return append([]HostInfo(nil), p.members[service]...), nil
+}
+
+// WhoAmI returns the host given to the constructor
+func (p *StaticPeerProvider) WhoAmI() (HostInfo, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.self, nil
+}
+
+// SelfEvict removes this host from all services and notifies subscribers
+func (p *StaticPeerProvider) SelfEvict() error {
+	p.updateSelf(true)
+	return nil
+}
+
+// Drain marks this host as drained in the service and notifies subscribers
+func (p *StaticPeerProvider) Drain(service string) error {
+	p.mu.Lock()
+	p.self = p.self.WithLabel(DrainedLabel(Service(service)), "true")
+	p.mu.Unlock()
+	p.updateSelf(false)
+	return nil
+}
+
+// Undrain reverts Drain
+func (p *StaticPeerProvider) Undrain(service string) error {
+	p.mu.Lock()
+	p.self = p.self.WithLabel(DrainedLabel(Service(service)), "false")
+	p.mu.Unlock()
+	p.updateSelf(false)
+	return nil
+}
+
+// Subscribe allows to be subscribed for membership changes caused by this host
+func (p *StaticPeerProvider) Subscribe(name string, notifyChannel chan<- *ChangedEvent) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := p.subscribers[name]; ok {
+		return fmt.Errorf("%q already subscribed to static provider", name)
+	}
+	p.subscribers[name] = notifyChannel
+	return nil
+}
+
+// updateSelf replaces or removes this host in the members of every service, then notifies subscribers
+func (p *StaticPeerProvider) updateSelf(evict bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	found := false
+	for service, members := range p.members {
+		for i, member := range members {
+			if member.GetAddress() != p.self.GetAddress() {
+				continue
+			}
+			found = true
+			if evict {
+				p.members[service] = append(members[:i:i], members[i+1:]...)
+			} else {
+				members[i] = p.self
+			}
+			break
+		}
+	}
+	if !found {
+		return
+	}
+
+	event := &ChangedEvent{}
+	if evict {
+		event.HostsRemoved = []HostInfo{p.self}
+	} else {
+		event.HostsUpdated = []HostInfo{p.self}
+	}
+	for _, ch := range p.subscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
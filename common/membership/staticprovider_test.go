@@ -0,0 +1,104 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2017-2020 Uber Technologies Inc.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package membership
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/uber/cadence/common/log"
+	"github.com/uber/cadence/common/metrics"
+)
+
+func TestStaticPeerProviderBacksResolver(t *testing.T) {
+	self := NewDetailedHostInfo("127.0.0.1:7933", "self", PortMap{PortGRPC: 7833})
+	other := NewDetailedHostInfo("127.0.0.2:7933", "other", PortMap{PortGRPC: 7833})
+	provider := NewStaticPeerProvider(self, map[string][]HostInfo{
+		"test-worker":   {self, other},
+		"test-services": {other},
+	})
+
+	r := NewMultiringResolver(testServices, provider, metrics.NewNoopMetricsClient(), log.NewNoop())
+	r.Start()
+	defer r.Stop()
+
+	host, err := r.WhoAmI()
+	assert.NoError(t, err)
+	assert.Equal(t, self, host)
+
+	members, err := r.Members("test-worker")
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"self", "other"}, identities(members))
+
+	first, err := r.Lookup("test-worker", "key")
+	assert.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		host, err := r.Lookup("test-worker", "key")
+		assert.NoError(t, err)
+		assert.Equal(t, first, host, "lookups are deterministic")
+	}
+
+	host, err = r.Lookup("test-services", "key")
+	assert.NoError(t, err)
+	assert.Equal(t, "other", host.Identity())
+}
+
+func TestStaticPeerProviderUpdatesSelf(t *testing.T) {
+	self := NewHostInfo("127.0.0.1:7933")
+	other := NewHostInfo("127.0.0.2:7933")
+	members := []HostInfo{self, other}
+	provider := NewStaticPeerProvider(self, map[string][]HostInfo{"test-worker": members})
+
+	changeCh := make(chan *ChangedEvent, 3)
+	assert.NoError(t, provider.Subscribe("sub", changeCh))
+	assert.Error(t, provider.Subscribe("sub", changeCh))
+
+	assert.NoError(t, provider.Drain("test-worker"))
+	event := <-changeCh
+	assert.Len(t, event.HostsUpdated, 1)
+	hosts, err := provider.GetMembers("test-worker")
+	assert.NoError(t, err)
+	drained, _ := hosts[0].Label(DrainedLabel("test-worker"))
+	assert.Equal(t, "true", drained)
+	assert.False(t, members[0].IsDrained(), "given members are not modified")
+
+	assert.NoError(t, provider.Undrain("test-worker"))
+	<-changeCh
+	hosts, _ = provider.GetMembers("test-worker")
+	drained, _ = hosts[0].Label(DrainedLabel("test-worker"))
+	assert.Equal(t, "false", drained)
+
+	assert.NoError(t, provider.SelfEvict())
+	event = <-changeCh
+	assert.Equal(t, self.GetAddress(), event.HostsRemoved[0].GetAddress())
+	hosts, _ = provider.GetMembers("test-worker")
+	assert.Equal(t, []HostInfo{other}, hosts)
+
+	assert.NoError(t, provider.SelfEvict())
+	assert.Len(t, changeCh, 0, "no event when self is not a member")
+
+	hosts, err = provider.GetMembers("unknown")
+	assert.NoError(t, err)
+	assert.Empty(t, hosts)
+}
@@ -0,0 +1,118 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2017-2020 Uber Technologies Inc.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package membership
+
+import (
+	"sync"
+)
+
+// subscriberBufferSize is the number of ring change events buffered for a subscriber which doesn't keep up
+const subscriberBufferSize = 16
+
+// subscription forwards ring change events to a subscriber channel, so that a slow subscriber
+// never blocks ring updates. When the buffer is full the oldest event is dropped and
+// the next delivered event is flagged with ResyncRequired.
+type subscription struct {
+	ch     chan<- *ChangedEvent
+	signal chan struct{}
+	done   chan struct{}
+	exited chan struct{}
+
+	sync.Mutex
+	queue  []*ChangedEvent
+	resync bool
+}
+
+func newSubscription(ch chan<- *ChangedEvent) *subscription {
+	s := &subscription{
+		ch:     ch,
+		signal: make(chan struct{}, 1),
+		done:   make(chan struct{}),
+		exited: make(chan struct{}),
+	}
+	go s.forwardWorker()
+	return s
+}
+
+// push queues the event for delivery, it returns false if the oldest queued event was dropped to make room
+func (s *subscription) push(event *ChangedEvent) bool {
+	s.Lock()
+	dropped := len(s.queue) == subscriberBufferSize
+	if dropped {
+		s.queue[0] = nil
+		s.queue = s.queue[1:]
+		s.resync = true
+	}
+	s.queue = append(s.queue, event)
+	s.Unlock()
+
+	select {
+	case s.signal <- struct{}{}:
+	default:
+	}
+	return !dropped
+}
+
+// pop returns the next event to deliver, or nil if there is none
+func (s *subscription) pop() *ChangedEvent {
+	s.Lock()
+	defer s.Unlock()
+	if len(s.queue) == 0 {
+		return nil
+	}
+	event := s.queue[0]
+	s.queue[0] = nil
+	s.queue = s.queue[1:]
+	if s.resync {
+		// events are shared by all subscribers of the ring
+		flagged := *event
+		flagged.ResyncRequired = true
+		event = &flagged
+		s.resync = false
+	}
+	return event
+}
+
+// stop ends forwarding, events which are not delivered yet are discarded
+func (s *subscription) stop() {
+	close(s.done)
+	<-s.exited
+}
+
+func (s *subscription) forwardWorker() {
+	defer close(s.exited)
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-s.signal:
+		}
+		for event := s.pop(); event != nil; event = s.pop() {
+			select {
+			case <-s.done:
+				return
+			case s.ch <- event:
+			}
+		}
+	}
+}
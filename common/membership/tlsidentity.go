@@ -0,0 +1,391 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2017-2020 Uber Technologies Inc.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package membership
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/uber/cadence/common/backoff"
+	"github.com/uber/cadence/common/clock"
+	"github.com/uber/cadence/common/log"
+	"github.com/uber/cadence/common/log/tag"
+)
+
+const (
+	defaultPeerCertificateTimeout     = 5 * time.Second
+	defaultPeerCertificateConcurrency = 16
+
+	peerCertificateInitialBackoff = time.Second
+	peerCertificateMaximumBackoff = time.Minute
+)
+
+type (
+	// PeerCertificateSource returns the leaf certificate a member presents to its peers
+	PeerCertificateSource interface {
+		PeerCertificate(ctx context.Context, host HostInfo) (*x509.Certificate, error)
+	}
+
+	// TLSIdentityPeerProvider sets the identity of members reported by another provider to a subject alternative
+	// name of their certificate, so that Identity matches the identity peers are authenticated with in a mTLS mesh.
+	// URI names, e.g. SPIFFE IDs, are preferred over DNS names and IP addresses. Since replicas of a service often
+	// share names, a member takes the first of its names no other member of the service has. Members whose
+	// certificate cannot be read or has no such name keep the identity they advertise.
+	//
+	// Names are cached for members as they are advertised, so a certificate is only read when a member joins
+	// or changes its address, identity or ports. Certificates are read in parallel, a certificate which could not be
+	// read is only read again after a backoff growing with every failure.
+	TLSIdentityPeerProvider struct {
+		PeerProvider
+		certs       PeerCertificateSource
+		timeout     time.Duration
+		concurrency int
+		retryPolicy backoff.RetryPolicy
+		timeSource  clock.TimeSource
+		logger      log.Logger
+
+		mu         sync.Mutex
+		names      map[string]map[string][]string           // by service and HostInfo.Key, empty when the certificate has no names
+		failures   map[string]map[string]certificateFailure // by service and HostInfo.Key
+		identities map[string]map[string]string             // as last returned, by service and HostInfo.Key
+		self       selfCertificate                          // read by WhoAmI until self is returned by GetMembers
+	}
+
+	selfCertificate struct {
+		key     string // HostInfo.Key of self when the certificate was read
+		read    bool
+		names   []string
+		failure certificateFailure
+	}
+
+	certificateFailure struct {
+		attempts int
+		retryAt  time.Time
+	}
+
+	tlsCertificateSource struct {
+		config *tls.Config
+		port   string
+	}
+)
+
+var _ PeerProvider = (*TLSIdentityPeerProvider)(nil)
+
+var (
+	errNoPeerCertificate = errors.New("peer presented no certificate")
+	errNoTLSConfig       = errors.New("tls config is required to read peer certificates")
+)
+
+// NewTLSIdentityPeerProvider returns a provider taking identities of members of provider from certificates of certs
+func NewTLSIdentityPeerProvider(provider PeerProvider, certs PeerCertificateSource, logger log.Logger) *TLSIdentityPeerProvider {
+	retryPolicy := backoff.NewExponentialRetryPolicy(peerCertificateInitialBackoff)
+	retryPolicy.SetMaximumInterval(peerCertificateMaximumBackoff)
+	retryPolicy.SetExpirationInterval(backoff.NoInterval)
+	return &TLSIdentityPeerProvider{
+		PeerProvider: provider,
+		certs:        certs,
+		timeout:      defaultPeerCertificateTimeout,
+		concurrency:  defaultPeerCertificateConcurrency,
+		retryPolicy:  retryPolicy,
+		timeSource:   clock.NewRealTimeSource(),
+		logger:       logger,
+		names:        make(map[string]map[string][]string),
+		failures:     make(map[string]map[string]certificateFailure),
+		identities:   make(map[string]map[string]string),
+	}
+}
+
+// NewTLSCertificateSource returns a source reading certificates with a TLS handshake with the named port of members,
+// verified as config requires it. Unless config sets a ServerName, the certificate is verified for the host of the
+// member address.
+func NewTLSCertificateSource(config *tls.Config, port string) (PeerCertificateSource, error) {
+	if config == nil {
+		return nil, errNoTLSConfig
+	}
+	return tlsCertificateSource{config: config.Clone(), port: port}, nil
+}
+
+// GetMembers returns members of the service with identities taken from their certificates
+func (p *TLSIdentityPeerProvider) GetMembers(service string) ([]HostInfo, error) {
+	members, err := p.PeerProvider.GetMembers(service)
+	if err != nil {
+		return nil, err
+	}
+	now := p.timeSource.Now()
+	p.mu.Lock()
+	cachedNames := p.names[service]
+	cachedFailures := p.failures[service]
+	p.mu.Unlock()
+
+	// only current members are kept, so that members which left are forgotten
+	names := make(map[string][]string, len(members))
+	failures := make(map[string]certificateFailure)
+	var unread []HostInfo
+	for _, member := range members {
+		key := member.Key()
+		if memberNames, ok := cachedNames[key]; ok {
+			names[key] = memberNames
+			continue
+		}
+		if failure, ok := cachedFailures[key]; ok && now.Before(failure.retryAt) {
+			failures[key] = failure
+			continue
+		}
+		unread = append(unread, member)
+	}
+	for i, result := range p.readNames(unread) {
+		key := unread[i].Key()
+		if result.err != nil {
+			failure := cachedFailures[key]
+			failure.retryAt = now.Add(p.retryPolicy.ComputeNextDelay(0, failure.attempts))
+			failure.attempts++
+			failures[key] = failure
+			continue
+		}
+		names[key] = result.names
+	}
+
+	// a name is only taken as identity by the single member having it, other members keep their identity
+	owners := make(map[string]int)
+	for _, member := range members {
+		memberNames, ok := names[member.Key()]
+		if !ok || len(memberNames) == 0 {
+			owners[member.Identity()]++
+			continue
+		}
+		countNames(owners, memberNames)
+	}
+	identities := make(map[string]string, len(members))
+	res := make([]HostInfo, 0, len(members))
+	for _, member := range members {
+		key := member.Key()
+		identity := member.Identity()
+		if unique, ok := firstUnique(names[key], owners); ok {
+			identity = unique
+		} else if len(names[key]) > 0 {
+			p.logger.Warn("certificate names are shared with other members, keeping advertised identity", tag.Address(member.GetAddress()))
+		}
+		identities[key] = identity
+		res = append(res, withIdentity(member, identity))
+	}
+	p.mu.Lock()
+	p.names[service] = names
+	p.failures[service] = failures
+	p.identities[service] = identities
+	p.mu.Unlock()
+	return res, nil
+}
+
+// WhoAmI returns this host with the identity it was given in the last GetMembers of a service it is a member of.
+// Before that, its certificate is read and the first of its names no other known member has is taken.
+// The certificate is read once, or after a growing backoff while it can't be read, as GetMembers does.
+func (p *TLSIdentityPeerProvider) WhoAmI() (HostInfo, error) {
+	self, err := p.PeerProvider.WhoAmI()
+	if err != nil {
+		return HostInfo{}, err
+	}
+	key := self.Key()
+	taken := make(map[string]int)
+	p.mu.Lock()
+	for _, identities := range p.identities {
+		if identity, ok := identities[key]; ok {
+			p.mu.Unlock()
+			return withIdentity(self, identity), nil
+		}
+		for _, identity := range identities {
+			taken[identity]++
+		}
+	}
+	p.mu.Unlock()
+
+	names, ok := p.selfNames(self)
+	if !ok {
+		return self, nil
+	}
+	countNames(taken, names)
+	identity, ok := firstUnique(names, taken)
+	if !ok {
+		return self, nil
+	}
+	return withIdentity(self, identity), nil
+}
+
+// selfNames returns the names of the certificate of self, reading it unless it was read already
+// or failed to be read within the backoff
+func (p *TLSIdentityPeerProvider) selfNames(self HostInfo) ([]string, bool) {
+	key := self.Key()
+	now := p.timeSource.Now()
+	p.mu.Lock()
+	if p.self.key != key {
+		p.self = selfCertificate{key: key}
+	}
+	cached := p.self
+	p.mu.Unlock()
+	if cached.read {
+		return cached.names, true
+	}
+	if cached.failure.attempts > 0 && now.Before(cached.failure.retryAt) {
+		return nil, false
+	}
+
+	result := p.readNames([]HostInfo{self})[0]
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.self.key != key {
+		return result.names, result.err == nil
+	}
+	if result.err != nil {
+		p.self.failure.retryAt = now.Add(p.retryPolicy.ComputeNextDelay(0, p.self.failure.attempts))
+		p.self.failure.attempts++
+		return nil, false
+	}
+	p.self.read = true
+	p.self.names = result.names
+	return result.names, true
+}
+
+type namesResult struct {
+	names []string
+	err   error
+}
+
+// readNames reads certificates of hosts, at most concurrency at a time, and returns their names in the order of hosts
+func (p *TLSIdentityPeerProvider) readNames(hosts []HostInfo) []namesResult {
+	results := make([]namesResult, len(hosts))
+	sem := make(chan struct{}, p.concurrency)
+	var wg sync.WaitGroup
+	for i, host := range hosts {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, host HostInfo) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+			defer cancel()
+			cert, err := p.certs.PeerCertificate(ctx, host)
+			if err != nil {
+				p.logger.Warn("failed to read peer certificate, keeping advertised identity", tag.Address(host.GetAddress()), tag.Error(err))
+				results[i].err = err
+				return
+			}
+			results[i].names = certificateNames(cert)
+		}(i, host)
+	}
+	wg.Wait()
+	return results
+}
+
+// countNames counts the names of a member in owners, once each
+func countNames(owners map[string]int, names []string) {
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		if !seen[name] {
+			seen[name] = true
+			owners[name]++
+		}
+	}
+}
+
+// firstUnique returns the first of the names of a member no other member in owners has
+func firstUnique(names []string, owners map[string]int) (string, bool) {
+	for _, name := range names {
+		if owners[name] <= 1 {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+func withIdentity(host HostInfo, identity string) HostInfo {
+	if identity == "" || identity == host.Identity() {
+		return host
+	}
+	return host.WithIdentity(identity)
+}
+
+// InvalidateIdentities drops cached names and failures, so that certificates of all members are read again on the
+// next GetMembers, e.g. after members were issued certificates for other names
+func (p *TLSIdentityPeerProvider) InvalidateIdentities() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.names = make(map[string]map[string][]string)
+	p.failures = make(map[string]map[string]certificateFailure)
+	p.identities = make(map[string]map[string]string)
+	p.self = selfCertificate{}
+}
+
+// certificateNames returns the URI, DNS and IP subject alternative names of the certificate, in this order
+func certificateNames(cert *x509.Certificate) []string {
+	names := make([]string, 0, len(cert.URIs)+len(cert.DNSNames)+len(cert.IPAddresses))
+	for _, uri := range cert.URIs {
+		names = append(names, uri.String())
+	}
+	names = append(names, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		names = append(names, ip.String())
+	}
+	return names
+}
+
+func (s tlsCertificateSource) PeerCertificate(ctx context.Context, host HostInfo) (*x509.Certificate, error) {
+	addr, err := host.GetNamedAddress(s.port)
+	if err != nil {
+		return nil, err
+	}
+	config := s.config
+	if config.ServerName == "" {
+		serverName, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		config = config.Clone()
+		config.ServerName = serverName
+	}
+	var dialer net.Dialer
+	rawConn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	conn := tls.Client(rawConn, config)
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return nil, err
+		}
+	}
+	if err := conn.Handshake(); err != nil {
+		return nil, err
+	}
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return nil, errNoPeerCertificate
+	}
+	return certs[0], nil
+}
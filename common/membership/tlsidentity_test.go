@@ -0,0 +1,283 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2017-2020 Uber Technologies Inc.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package membership
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/uber/cadence/common/clock"
+	"github.com/uber/cadence/common/log"
+)
+
+// fakeCertificateSource returns certificates by member address and counts reads
+type fakeCertificateSource struct {
+	sync.Mutex
+	certs       map[string]*x509.Certificate
+	delay       time.Duration
+	reads       int
+	inFlight    int
+	maxInFlight int
+}
+
+func (s *fakeCertificateSource) PeerCertificate(ctx context.Context, host HostInfo) (*x509.Certificate, error) {
+	s.Lock()
+	s.reads++
+	s.inFlight++
+	if s.inFlight > s.maxInFlight {
+		s.maxInFlight = s.inFlight
+	}
+	s.Unlock()
+	time.Sleep(s.delay)
+
+	s.Lock()
+	defer s.Unlock()
+	s.inFlight--
+	cert, ok := s.certs[host.GetAddress()]
+	if !ok {
+		return nil, errors.New("connection refused")
+	}
+	return cert, nil
+}
+
+func (s *fakeCertificateSource) takeReads() int {
+	s.Lock()
+	defer s.Unlock()
+	reads := s.reads
+	s.reads = 0
+	return reads
+}
+
+func TestTLSIdentityPeerProviderTakesIdentityFromSAN(t *testing.T) {
+	spiffeID, err := url.Parse("spiffe://cadence/history/host-a")
+	require.NoError(t, err)
+	sharedID, err := url.Parse("spiffe://cadence/history")
+	require.NoError(t, err)
+	self := NewDetailedHostInfo("10.0.0.1:7934", "advertised-a", nil)
+	members := []HostInfo{
+		self,
+		NewDetailedHostInfo("10.0.0.2:7934", "advertised-b", nil),
+		NewDetailedHostInfo("10.0.0.3:7934", "advertised-c", nil),
+		NewDetailedHostInfo("10.0.0.4:7934", "advertised-d", nil),
+		NewDetailedHostInfo("10.0.0.5:7934", "advertised-e", nil),
+		NewDetailedHostInfo("10.0.0.6:7934", "advertised-f", nil),
+		NewDetailedHostInfo("10.0.0.7:7934", "advertised-g", nil),
+		NewDetailedHostInfo("10.0.0.8:7934", "advertised-h", nil),
+	}
+	certs := &fakeCertificateSource{certs: map[string]*x509.Certificate{
+		"10.0.0.1:7934": {URIs: []*url.URL{spiffeID}, DNSNames: []string{"host-a.cadence"}},
+		"10.0.0.2:7934": {DNSNames: []string{"host-b.cadence"}},
+		"10.0.0.3:7934": {},
+		"10.0.0.5:7934": {URIs: []*url.URL{sharedID}, DNSNames: []string{"host-e.cadence"}},
+		"10.0.0.6:7934": {URIs: []*url.URL{sharedID}, DNSNames: []string{"host-f.cadence"}},
+		"10.0.0.7:7934": {DNSNames: []string{"cadence-history.svc"}},
+		"10.0.0.8:7934": {DNSNames: []string{"cadence-history.svc"}},
+	}}
+	timeSource := clock.NewEventTimeSource().Update(time.Now())
+	provider := NewTLSIdentityPeerProvider(NewStaticPeerProvider(self, map[string][]HostInfo{"cadence-history": members}), certs, log.NewNoop())
+	provider.timeSource = timeSource
+
+	hosts, err := provider.GetMembers("cadence-history")
+	require.NoError(t, err)
+	require.Len(t, hosts, 8)
+	assert.Equal(t, "spiffe://cadence/history/host-a", hosts[0].Identity(), "URI names are preferred")
+	assert.Equal(t, "host-b.cadence", hosts[1].Identity())
+	assert.Equal(t, "advertised-c", hosts[2].Identity(), "certificates without names keep the advertised identity")
+	assert.Equal(t, "advertised-d", hosts[3].Identity(), "unreadable certificates keep the advertised identity")
+	assert.Equal(t, "host-e.cadence", hosts[4].Identity(), "names shared by replicas are skipped")
+	assert.Equal(t, "host-f.cadence", hosts[5].Identity())
+	assert.Equal(t, "advertised-g", hosts[6].Identity(), "members with only shared names keep the advertised identity")
+	assert.Equal(t, "advertised-h", hosts[7].Identity())
+	assert.Equal(t, 8, certs.takeReads())
+
+	_, err = provider.GetMembers("cadence-history")
+	require.NoError(t, err)
+	assert.Equal(t, 0, certs.takeReads(), "failures are cached until the backoff elapses")
+
+	timeSource.Update(timeSource.Now().Add(peerCertificateInitialBackoff))
+	_, err = provider.GetMembers("cadence-history")
+	require.NoError(t, err)
+	assert.Equal(t, 1, certs.takeReads(), "only the unreadable certificate is read again")
+
+	timeSource.Update(timeSource.Now().Add(peerCertificateInitialBackoff))
+	_, err = provider.GetMembers("cadence-history")
+	require.NoError(t, err)
+	assert.Equal(t, 0, certs.takeReads(), "the backoff grows with every failure")
+
+	me, err := provider.WhoAmI()
+	require.NoError(t, err)
+	assert.Equal(t, "spiffe://cadence/history/host-a", me.Identity())
+	assert.Equal(t, self.GetAddress(), me.GetAddress())
+	assert.Equal(t, 0, certs.takeReads(), "the identity given in GetMembers is reused")
+
+	provider.InvalidateIdentities()
+	_, err = provider.GetMembers("cadence-history")
+	require.NoError(t, err)
+	assert.Equal(t, 8, certs.takeReads())
+}
+
+func TestTLSIdentityPeerProviderWhoAmIBeforeGetMembers(t *testing.T) {
+	self := NewDetailedHostInfo("10.0.0.1:7934", "advertised-a", nil)
+	certs := &fakeCertificateSource{certs: map[string]*x509.Certificate{
+		"10.0.0.1:7934": {DNSNames: []string{"host-a.cadence"}},
+	}}
+	provider := NewTLSIdentityPeerProvider(NewStaticPeerProvider(self, nil), certs, log.NewNoop())
+
+	me, err := provider.WhoAmI()
+	require.NoError(t, err)
+	assert.Equal(t, "host-a.cadence", me.Identity())
+	assert.Equal(t, 1, certs.takeReads())
+
+	me, err = provider.WhoAmI()
+	require.NoError(t, err)
+	assert.Equal(t, "host-a.cadence", me.Identity())
+	assert.Equal(t, 0, certs.takeReads(), "the certificate of self is read once")
+}
+
+func TestTLSIdentityPeerProviderWhoAmIBacksOffOnFailure(t *testing.T) {
+	self := NewDetailedHostInfo("10.0.0.1:7934", "advertised-a", nil)
+	certs := &fakeCertificateSource{certs: map[string]*x509.Certificate{}}
+	timeSource := clock.NewEventTimeSource().Update(time.Now())
+	provider := NewTLSIdentityPeerProvider(NewStaticPeerProvider(self, nil), certs, log.NewNoop())
+	provider.timeSource = timeSource
+
+	for i := 0; i < 3; i++ {
+		me, err := provider.WhoAmI()
+		require.NoError(t, err)
+		assert.Equal(t, "advertised-a", me.Identity())
+	}
+	assert.Equal(t, 1, certs.takeReads(), "failures are cached until the backoff elapses")
+
+	certs.Lock()
+	certs.certs["10.0.0.1:7934"] = &x509.Certificate{DNSNames: []string{"host-a.cadence"}}
+	certs.Unlock()
+	timeSource.Update(timeSource.Now().Add(peerCertificateInitialBackoff))
+	me, err := provider.WhoAmI()
+	require.NoError(t, err)
+	assert.Equal(t, "host-a.cadence", me.Identity())
+	assert.Equal(t, 1, certs.takeReads())
+}
+
+func TestTLSCertificateSourceRequiresConfig(t *testing.T) {
+	_, err := NewTLSCertificateSource(nil, PortGRPC)
+	assert.Error(t, err)
+}
+
+func TestTLSIdentityPeerProviderReadsCertificatesInParallel(t *testing.T) {
+	var members []HostInfo
+	certs := &fakeCertificateSource{certs: make(map[string]*x509.Certificate), delay: 20 * time.Millisecond}
+	for i := 1; i <= 8; i++ {
+		address := fmt.Sprintf("10.0.0.%d:7934", i)
+		members = append(members, NewHostInfo(address))
+		certs.certs[address] = &x509.Certificate{DNSNames: []string{fmt.Sprintf("host-%d.cadence", i)}}
+	}
+	provider := NewTLSIdentityPeerProvider(NewStaticPeerProvider(members[0], map[string][]HostInfo{"cadence-history": members}), certs, log.NewNoop())
+	provider.concurrency = 3
+
+	hosts, err := provider.GetMembers("cadence-history")
+	require.NoError(t, err)
+	for i, host := range hosts {
+		assert.Equal(t, fmt.Sprintf("host-%d.cadence", i+1), host.Identity())
+	}
+	assert.Equal(t, 8, certs.reads)
+	assert.Equal(t, 3, certs.maxInFlight, "reads are bounded by the concurrency")
+}
+
+// selfSignedCertificate returns a certificate for 127.0.0.1 and the given URIs, along with a pool trusting it
+func selfSignedCertificate(t *testing.T, uris ...*url.URL) (tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		URIs:         uris,
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	leaf, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	roots := x509.NewCertPool()
+	roots.AddCert(leaf)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, roots
+}
+
+func TestTLSCertificateSourceReadsPeerCertificate(t *testing.T) {
+	spiffeID, err := url.Parse("spiffe://cadence/frontend/host-a")
+	require.NoError(t, err)
+	certificate, roots := selfSignedCertificate(t, spiffeID)
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+	})
+	require.NoError(t, err)
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			_ = conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+
+	_, port, err := net.SplitHostPort(listener.Addr().String())
+	require.NoError(t, err)
+	portNumber, err := net.LookupPort("tcp", port)
+	require.NoError(t, err)
+	host := NewDetailedHostInfo("127.0.0.1:7933", "", PortMap{PortGRPC: uint16(portNumber)})
+
+	source, err := NewTLSCertificateSource(&tls.Config{RootCAs: roots}, PortGRPC)
+	require.NoError(t, err)
+	cert, err := source.PeerCertificate(context.Background(), host)
+	require.NoError(t, err, "the certificate is verified for the member host")
+	assert.Equal(t, []string{"spiffe://cadence/frontend/host-a", "127.0.0.1"}, certificateNames(cert))
+
+	other, err := NewTLSCertificateSource(&tls.Config{RootCAs: roots, ServerName: "other.cadence"}, PortGRPC)
+	require.NoError(t, err)
+	_, err = other.PeerCertificate(context.Background(), host)
+	assert.Error(t, err, "certificates not issued for the server name are rejected")
+
+	_, err = source.PeerCertificate(context.Background(), NewHostInfo("127.0.0.1:7933"))
+	assert.Error(t, err, "members without the port cannot be dialed")
+}
@@ -0,0 +1,65 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2017-2020 Uber Technologies Inc.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package membership
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/uber/cadence/common/log/tag"
+)
+
+// warmupHook is called in the background for every host joining a ring of a resolver
+type warmupHook struct {
+	hook atomic.Value // func(HostInfo), nil when not set
+}
+
+// SetWarmupHook sets the function called for every host added to any of the service rings, e.g. to open
+// the grpc connection to a new host before the first request is routed to it. Members found by the first load
+// of a ring are not warmed up, they are connected on their first lookup as without a hook. Calls are best effort:
+// each one runs on its own goroutine, membership changes don't wait for it and panics are only logged.
+// A nil hook disables warmup.
+func (rpo *MultiringResolver) SetWarmupHook(hook func(HostInfo)) {
+	rpo.warmup.hook.Store(hook)
+}
+
+// warmupHosts calls the warmup hook for every host, without blocking
+func (r *ring) warmupHosts(hosts []HostInfo) {
+	if r.warmup == nil {
+		return
+	}
+	hook, _ := r.warmup.hook.Load().(func(HostInfo))
+	if hook == nil {
+		return
+	}
+	for _, host := range hosts {
+		go func(host HostInfo) {
+			defer func() {
+				if p := recover(); p != nil {
+					r.logger.Warn("ring member warmup panicked", tag.Address(host.GetAddress()), tag.Value(fmt.Sprint(p)))
+				}
+			}()
+			hook(host)
+		}(host)
+	}
+}
@@ -0,0 +1,111 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2017-2020 Uber Technologies Inc.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package membership
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/uber/cadence/common"
+	"github.com/uber/cadence/common/log"
+	"github.com/uber/cadence/common/metrics"
+)
+
+func TestWarmupHookFiresOncePerAddedHost(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	pp := NewMockPeerProvider(ctrl)
+	a, b, c := NewHostInfo("127.0.0.1:7933"), NewHostInfo("127.0.0.2:7933"), NewHostInfo("127.0.0.3:7933")
+	gomock.InOrder(
+		pp.EXPECT().GetMembers("test-worker").Return([]HostInfo{a, b}, nil),
+		pp.EXPECT().GetMembers("test-worker").Return([]HostInfo{a, b, c}, nil),
+		pp.EXPECT().GetMembers("test-worker").Return([]HostInfo{a, c}, nil),
+		pp.EXPECT().GetMembers("test-worker").Return([]HostInfo{a, c}, nil),
+	)
+	r := NewMultiringResolver(testServices, pp, metrics.NewNoopMetricsClient(), log.NewNoop())
+	hr, err := r.getRing("test-worker")
+	require.NoError(t, err)
+
+	var (
+		mu     sync.Mutex
+		warmed []string
+		wg     sync.WaitGroup
+	)
+	r.SetWarmupHook(func(host HostInfo) {
+		defer wg.Done()
+		mu.Lock()
+		defer mu.Unlock()
+		warmed = append(warmed, host.GetAddress())
+	})
+
+	require.NoError(t, hr.refreshMembers())
+	time.Sleep(50 * time.Millisecond)
+	mu.Lock()
+	assert.Empty(t, warmed, "members of the first load are not warmed up")
+	mu.Unlock()
+
+	wg.Add(1)
+	require.NoError(t, hr.refreshMembers())
+	require.True(t, common.AwaitWaitGroup(&wg, time.Second), "warmup hook was not called")
+	require.NoError(t, hr.refreshMembers())
+	require.NoError(t, hr.refreshMembers())
+	time.Sleep(50 * time.Millisecond)
+	mu.Lock()
+	assert.Equal(t, []string{c.GetAddress()}, warmed, "hosts leaving or staying are not warmed up")
+	mu.Unlock()
+}
+
+func TestPanickingWarmupHookDoesNotBlockRefresh(t *testing.T) {
+	members := []HostInfo{NewHostInfo("127.0.0.1:7933")}
+	provider := NewStaticPeerProvider(HostInfo{}, map[string][]HostInfo{"test-worker": members})
+	r := NewMultiringResolver(testServices, provider, metrics.NewNoopMetricsClient(), log.NewNoop())
+	hr, err := r.getRing("test-worker")
+	require.NoError(t, err)
+
+	called := make(chan struct{})
+	r.SetWarmupHook(func(HostInfo) {
+		close(called)
+		panic("dial failed")
+	})
+	require.NoError(t, hr.refreshMembers())
+	provider.mu.Lock()
+	provider.members["test-worker"] = append(members, NewHostInfo("127.0.0.2:7933"))
+	provider.mu.Unlock()
+	require.NoError(t, hr.refreshMembers())
+	select {
+	case <-called:
+	case <-time.After(time.Second):
+		t.Fatal("warmup hook was not called")
+	}
+	assert.Equal(t, 2, hr.MemberCount())
+
+	r.SetWarmupHook(nil)
+	provider.mu.Lock()
+	provider.members["test-worker"] = append(members, NewHostInfo("127.0.0.3:7933"))
+	provider.mu.Unlock()
+	require.NoError(t, hr.refreshMembers(), "a nil hook disables warmup")
+}
@@ -745,6 +745,12 @@ const (
 	ClusterMetadataScope
 	// GetAvailableIsolationGroupsScope is the metric for the default partitioner's getIsolationGroups operation
 	GetAvailableIsolationGroupsScope
+	// HashringScope is used for membership hashring metrics
+	HashringScope
+	// DNSPeerProviderScope is used for DNS based membership discovery metrics
+	DNSPeerProviderScope
+	// RingpopPeerProviderScope is used for ringpop based membership discovery metrics
+	RingpopPeerProviderScope
 
 	NumCommonScopes
 )
@@ -1568,6 +1574,9 @@ var ScopeDefs = map[ServiceIdx]map[int]scopeDefinition{
 		BlobstoreClientDirectoryExistsScope: {operation: "BlobstoreClientDirectoryExists", tags: map[string]string{CadenceRoleTagName: BlobstoreRoleTagValue}},
 
 		GetAvailableIsolationGroupsScope: {operation: "GetAvailableIsolationGroups"},
+		HashringScope:                    {operation: "Hashring"},
+		DNSPeerProviderScope:             {operation: "DNSPeerProvider"},
+		RingpopPeerProviderScope:         {operation: "RingpopPeerProvider"},
 
 		DomainFailoverScope:         {operation: "DomainFailover"},
 		DomainReplicationQueueScope: {operation: "DomainReplicationQueue"},
@@ -2023,6 +2032,21 @@ const (
 	IsolationGroupStateDrained
 	IsolationGroupStateHealthy
 
+	HashringRebalanceMovedRatio
+	HashringIdentityConflicts
+	HashringLookupCacheHits
+	HashringLookupCacheMisses
+	HashringLookupCacheEvictions
+	HashringEvictionPropagationLatency
+	HashringLookupLatency
+	HashringMemberCount
+	HashringMembershipDrift
+	HashringPinnedKeys
+	HashringPinnedLookups
+	DNSPeerProviderResolutionFailures
+	RingpopBootstrapAttempts
+	RingpopBootstrapFailures
+
 	NumCommonMetrics // Needs to be last on this list for iota numbering
 )
 
@@ -2639,6 +2663,20 @@ var MetricDefs = map[ServiceIdx]map[int]metricDefinition{
 		IsolationGroupStatePollerUnavailable: {metricName: "isolation_group_poller_unavailable", metricType: Counter},
 		IsolationGroupStateDrained:           {metricName: "isolation_group_drained", metricType: Counter},
 		IsolationGroupStateHealthy:           {metricName: "isolation_group_healthy", metricType: Counter},
+		HashringRebalanceMovedRatio:          {metricName: "hashring_rebalance_moved_ratio", metricType: Gauge},
+		HashringIdentityConflicts:            {metricName: "hashring_identity_conflicts", metricType: Gauge},
+		HashringLookupCacheHits:              {metricName: "hashring_lookup_cache_hits", metricType: Counter},
+		HashringLookupCacheMisses:            {metricName: "hashring_lookup_cache_misses", metricType: Counter},
+		HashringLookupCacheEvictions:         {metricName: "hashring_lookup_cache_evictions", metricType: Counter},
+		HashringEvictionPropagationLatency:   {metricName: "hashring_eviction_propagation_latency", metricType: Timer},
+		HashringLookupLatency:                {metricName: "hashring_lookup_latency", metricType: Histogram, buckets: HashringLookupLatencyBuckets},
+		HashringMemberCount:                  {metricName: "hashring_member_count", metricType: Gauge},
+		HashringMembershipDrift:              {metricName: "hashring_membership_drift", metricType: Gauge},
+		HashringPinnedKeys:                   {metricName: "hashring_pinned_keys", metricType: Gauge},
+		HashringPinnedLookups:                {metricName: "hashring_pinned_lookups", metricType: Counter},
+		DNSPeerProviderResolutionFailures:    {metricName: "dns_peer_provider_resolution_failures", metricType: Counter},
+		RingpopBootstrapAttempts:             {metricName: "ringpop_bootstrap_attempts", metricType: Counter},
+		RingpopBootstrapFailures:             {metricName: "ringpop_bootstrap_failures", metricType: Counter},
 	},
 	History: {
 		TaskRequests:             {metricName: "task_requests", metricType: Counter},
@@ -3082,6 +3120,9 @@ var PersistenceLatencyBuckets = tally.DurationBuckets([]time.Duration{
 	60 * time.Second,
 })
 
+// HashringLookupLatencyBuckets contains duration buckets for measuring hashring lookups, which usually take microseconds
+var HashringLookupLatencyBuckets = tally.MustMakeExponentialDurationBuckets(time.Microsecond, 2, 18)
+
 // ErrorClass is an enum to help with classifying SLA vs. non-SLA errors (SLA = "service level agreement")
 type ErrorClass uint8
 
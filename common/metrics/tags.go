@@ -56,6 +56,7 @@ const (
 	shardID                = "shard_id"
 	matchingHost           = "matching_host"
 	pollerIsolationGroup   = "poller_isolation_group"
+	hashringService        = "hashring_service"
 
 	allValue     = "all"
 	unknownValue = "_unknown_"
@@ -224,6 +225,11 @@ func PollerIsolationGroupTag(value string) Tag {
 	return metricWithUnknown(pollerIsolationGroup, value)
 }
 
+// HashringServiceTag returns a new HashringService tag
+func HashringServiceTag(value string) Tag {
+	return metricWithUnknown(hashringService, value)
+}
+
 // PartitionConfigTags returns a list of partition config tags
 func PartitionConfigTags(partitionConfig map[string]string) []Tag {
 	tags := make([]Tag, 0, len(partitionConfig))
@@ -0,0 +1,127 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2017-2020 Uber Technologies Inc.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package dnsprovider
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/uber/cadence/common/membership"
+)
+
+// RecordType is an enum type for the DNS record type used to discover members
+type RecordType int
+
+const (
+	// RecordTypeNone represents a record type set to nothing or invalid
+	RecordTypeNone RecordType = iota
+	// RecordTypeA represents A records, member ports are taken from the configuration
+	RecordTypeA
+	// RecordTypeSRV represents SRV records named after member ports, e.g. _grpc._tcp.<name>,
+	// so that member port maps are discovered along with addresses
+	RecordTypeSRV
+)
+
+const (
+	defaultRefreshInterval = 10 * time.Second
+	defaultRefreshJitter   = 0.2
+)
+
+// Config contains the DNS peer provider config items
+type Config struct {
+	// Services maps a service name to the DNS name its members are registered with, e.g. a Kubernetes headless service
+	Services map[string]string `yaml:"services"`
+	// RecordType is the DNS record type to resolve members with, currently supports: a and srv
+	RecordType RecordType `yaml:"recordType"`
+	// Ports maps a service name to the ports of its members resolved from A records, e.g. tchannel 7933 for frontend
+	// and 7934 for history. Every service needs a tchannel port with A records.
+	Ports map[string]membership.PortMap `yaml:"ports"`
+	// RefreshInterval is how often member DNS names are resolved, defaults to 10s
+	RefreshInterval time.Duration `yaml:"refreshInterval"`
+	// RefreshJitter randomizes every refresh interval by up to this fraction of it, so that hosts don't resolve
+	// members at the same time. Defaults to 0.2, set it to 0 to refresh on a fixed interval.
+	RefreshJitter *float64 `yaml:"refreshJitter"`
+}
+
+func (c *Config) validate() error {
+	if len(c.Services) == 0 {
+		return fmt.Errorf("dns peer provider config missing `services` param")
+	}
+
+	if c.RefreshInterval < 0 {
+		return fmt.Errorf("dns peer provider config with negative refresh interval %v", c.RefreshInterval)
+	}
+	if c.RefreshInterval == 0 {
+		c.RefreshInterval = defaultRefreshInterval
+	}
+	if c.RefreshJitter == nil {
+		jitter := defaultRefreshJitter
+		c.RefreshJitter = &jitter
+	}
+	if *c.RefreshJitter < 0 || *c.RefreshJitter >= 1 {
+		return fmt.Errorf("dns peer provider config with refresh jitter %v out of [0, 1) range", *c.RefreshJitter)
+	}
+
+	switch c.RecordType {
+	case RecordTypeA:
+		for service := range c.Services {
+			if _, ok := c.Ports[service][membership.PortTchannel]; !ok {
+				return fmt.Errorf("dns peer provider config with A records is missing %s port of %s", membership.PortTchannel, service)
+			}
+		}
+	case RecordTypeSRV:
+	default:
+		return fmt.Errorf("dns peer provider config with unknown record type %d", c.RecordType)
+	}
+	return nil
+}
+
+// UnmarshalYAML is called by the yaml package to convert
+// the config YAML into a RecordType.
+func (t *RecordType) UnmarshalYAML(
+	unmarshal func(interface{}) error,
+) error {
+
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	var err error
+	*t, err = parseRecordType(s)
+	return err
+}
+
+// parseRecordType reads a string value and returns a record type.
+func parseRecordType(
+	recordType string,
+) (RecordType, error) {
+
+	switch strings.ToLower(recordType) {
+	case "a":
+		return RecordTypeA, nil
+	case "srv":
+		return RecordTypeSRV, nil
+	}
+	return RecordTypeNone, fmt.Errorf("invalid dns record type %q", recordType)
+}
@@ -0,0 +1,114 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2017-2020 Uber Technologies Inc.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package dnsprovider
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+
+	"github.com/uber/cadence/common/membership"
+)
+
+func TestConfigSRV(t *testing.T) {
+	var cfg Config
+	err := yaml.Unmarshal([]byte(`
+services:
+  cadence-history: cadence-history-headless.cadence.svc.cluster.local
+recordType: srv
+refreshInterval: 30s
+`), &cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, RecordTypeSRV, cfg.RecordType)
+	assert.Equal(t, map[string]string{"cadence-history": "cadence-history-headless.cadence.svc.cluster.local"}, cfg.Services)
+	assert.Equal(t, 30*time.Second, cfg.RefreshInterval)
+	assert.NoError(t, cfg.validate())
+	assert.Equal(t, defaultRefreshJitter, *cfg.RefreshJitter)
+}
+
+func TestConfigA(t *testing.T) {
+	var cfg Config
+	err := yaml.Unmarshal([]byte(`
+services:
+  cadence-frontend: cadence-frontend-headless
+  cadence-history: cadence-history-headless
+recordType: A
+ports:
+  cadence-frontend:
+    tchannel: 7933
+    grpc: 7833
+  cadence-history:
+    tchannel: 7934
+    grpc: 7834
+`), &cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, RecordTypeA, cfg.RecordType)
+	assert.Equal(t, map[string]membership.PortMap{
+		"cadence-frontend": {membership.PortTchannel: 7933, membership.PortGRPC: 7833},
+		"cadence-history":  {membership.PortTchannel: 7934, membership.PortGRPC: 7834},
+	}, cfg.Ports)
+	assert.NoError(t, cfg.validate())
+	assert.Equal(t, defaultRefreshInterval, cfg.RefreshInterval)
+}
+
+func TestConfigRefreshJitter(t *testing.T) {
+	var cfg Config
+	err := yaml.Unmarshal([]byte(`
+services:
+  cadence-history: cadence-history-headless
+recordType: srv
+refreshJitter: 0
+`), &cfg)
+	assert.NoError(t, err)
+	assert.NoError(t, cfg.validate())
+	assert.Equal(t, 0.0, *cfg.RefreshJitter, "jitter can be disabled")
+
+	jitter := 1.5
+	cfg.RefreshJitter = &jitter
+	assert.Error(t, cfg.validate())
+}
+
+func TestConfigValidation(t *testing.T) {
+	var cfg Config
+	assert.Error(t, yaml.Unmarshal([]byte(`recordType: cname`), &cfg))
+
+	assert.Error(t, (&Config{RecordType: RecordTypeSRV}).validate(), "services are required")
+	assert.Error(t, (&Config{Services: map[string]string{"s": "s.local"}}).validate(), "record type is required")
+	assert.Error(t, (&Config{
+		Services:   map[string]string{"s": "s.local"},
+		RecordType: RecordTypeA,
+		Ports:      map[string]membership.PortMap{"s": {membership.PortGRPC: 7833}},
+	}).validate(), "tchannel port is required for A records")
+	assert.Error(t, (&Config{
+		Services:   map[string]string{"s": "s.local", "t": "t.local"},
+		RecordType: RecordTypeA,
+		Ports:      map[string]membership.PortMap{"s": {membership.PortTchannel: 7933}},
+	}).validate(), "tchannel port is required for every service")
+	assert.Error(t, (&Config{
+		Services:        map[string]string{"s": "s.local"},
+		RecordType:      RecordTypeSRV,
+		RefreshInterval: -time.Second,
+	}).validate(), "refresh interval can't be negative")
+}
@@ -0,0 +1,320 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2017-2020 Uber Technologies Inc.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package dnsprovider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/uber/cadence/common"
+	"github.com/uber/cadence/common/backoff"
+	"github.com/uber/cadence/common/log"
+	"github.com/uber/cadence/common/log/tag"
+	"github.com/uber/cadence/common/membership"
+	"github.com/uber/cadence/common/metrics"
+)
+
+type (
+	// Provider discovers members by periodically resolving DNS names of services, without any gossip
+	Provider struct {
+		status        int32
+		config        *Config
+		self          membership.HostInfo
+		resolver      membership.AddressResolver
+		lookupSRV     func(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+		metricsClient metrics.Client
+		logger        log.Logger
+		shutdownCh    chan struct{}
+		shutdownWG    sync.WaitGroup
+
+		mu          sync.RWMutex
+		members     map[string][]membership.HostInfo
+		subscribers map[string]chan<- *membership.ChangedEvent
+	}
+)
+
+const lookupTimeout = 5 * time.Second
+
+var errDrainNotSupported = errors.New("drain is not supported by dns peer provider")
+
+var _ membership.PeerProvider = (*Provider)(nil)
+
+// New creates a DNS based peer provider, self is returned by WhoAmI. Members resolved from A and SRV records are
+// identified by their ip:port, so self should be identified the same way for the ring to recognize this host among them.
+func New(
+	config *Config,
+	self membership.HostInfo,
+	metricsClient metrics.Client,
+	logger log.Logger,
+) (*Provider, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	return newProvider(config, self, membership.DefaultAddressResolver, metricsClient, logger), nil
+}
+
+func newProvider(
+	config *Config,
+	self membership.HostInfo,
+	resolver membership.AddressResolver,
+	metricsClient metrics.Client,
+	logger log.Logger,
+) *Provider {
+	return &Provider{
+		status:        common.DaemonStatusInitialized,
+		config:        config,
+		self:          self,
+		resolver:      resolver,
+		lookupSRV:     net.DefaultResolver.LookupSRV,
+		metricsClient: metricsClient,
+		logger:        logger,
+		shutdownCh:    make(chan struct{}),
+		members:       make(map[string][]membership.HostInfo),
+		subscribers:   make(map[string]chan<- *membership.ChangedEvent),
+	}
+}
+
+// Start resolves members and keeps refreshing them in background
+func (p *Provider) Start() {
+	if !atomic.CompareAndSwapInt32(
+		&p.status,
+		common.DaemonStatusInitialized,
+		common.DaemonStatusStarted,
+	) {
+		return
+	}
+
+	p.refresh()
+
+	p.shutdownWG.Add(1)
+	go p.refreshWorker()
+}
+
+// Stop stops refreshing members
+func (p *Provider) Stop() {
+	if !atomic.CompareAndSwapInt32(
+		&p.status,
+		common.DaemonStatusStarted,
+		common.DaemonStatusStopped,
+	) {
+		return
+	}
+
+	close(p.shutdownCh)
+	if success := common.AwaitWaitGroup(&p.shutdownWG, time.Minute); !success {
+		p.logger.Warn("dns peer provider timed out on shutdown.")
+	}
+}
+
+// GetMembers returns members of the service resolved on the last refresh
+func (p *Provider) GetMembers(service string) ([]membership.HostInfo, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return append([]membership.HostInfo(nil), p.members[service]...), nil
+}
+
+// WhoAmI returns address of this instance
+func (p *Provider) WhoAmI() (membership.HostInfo, error) {
+	return p.self, nil
+}
+
+// SelfEvict is a noop, members leave the ring once they are removed from DNS
+func (p *Provider) SelfEvict() error {
+	return nil
+}
+
+// Drain is not supported, DNS records can't be changed by members
+func (p *Provider) Drain(service string) error {
+	return errDrainNotSupported
+}
+
+// Undrain is not supported, DNS records can't be changed by members
+func (p *Provider) Undrain(service string) error {
+	return errDrainNotSupported
+}
+
+// Subscribe allows to be subscribed for membership changes
+func (p *Provider) Subscribe(name string, notifyChannel chan<- *membership.ChangedEvent) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	_, ok := p.subscribers[name]
+	if ok {
+		return fmt.Errorf("%q already subscribed to dns provider", name)
+	}
+
+	p.subscribers[name] = notifyChannel
+	return nil
+}
+
+func (p *Provider) refreshWorker() {
+	defer p.shutdownWG.Done()
+
+	timer := time.NewTimer(p.nextRefreshInterval())
+	defer timer.Stop()
+	for {
+		select {
+		case <-p.shutdownCh:
+			return
+		case <-timer.C:
+			p.refresh()
+			timer.Reset(p.nextRefreshInterval())
+		}
+	}
+}
+
+// nextRefreshInterval returns the refresh interval randomized by the configured jitter
+func (p *Provider) nextRefreshInterval() time.Duration {
+	if *p.config.RefreshJitter == 0 {
+		return p.config.RefreshInterval
+	}
+	return backoff.JitDuration(p.config.RefreshInterval, *p.config.RefreshJitter)
+}
+
+// refresh resolves members of all services, members of a service are kept as they are if resolution fails
+func (p *Provider) refresh() {
+	change := &membership.ChangedEvent{}
+	for service, name := range p.config.Services {
+		hosts, err := p.resolve(service, name)
+		if err != nil {
+			p.metricsClient.Scope(metrics.DNSPeerProviderScope, metrics.HashringServiceTag(service)).
+				IncCounter(metrics.DNSPeerProviderResolutionFailures)
+			p.logger.Warn("could not resolve service members", tag.Service(service), tag.Address(name), tag.Error(err))
+			continue
+		}
+
+		p.mu.Lock()
+		added, removed := membership.DiffHosts(p.members[service], hosts)
+		p.members[service] = hosts
+		p.mu.Unlock()
+
+		change.HostsAdded = append(change.HostsAdded, added...)
+		change.HostsRemoved = append(change.HostsRemoved, removed...)
+	}
+	if len(change.HostsAdded) == 0 && len(change.HostsRemoved) == 0 {
+		return
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	for name, ch := range p.subscribers {
+		select {
+		case ch <- change:
+		default:
+			p.logger.Error("Failed to send listener notification, channel full", tag.Subscriber(name))
+		}
+	}
+}
+
+func (p *Provider) resolve(service, name string) ([]membership.HostInfo, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), lookupTimeout)
+	defer cancel()
+
+	var hosts []membership.HostInfo
+	var err error
+	switch p.config.RecordType {
+	case RecordTypeSRV:
+		hosts, err = p.resolveSRV(ctx, name)
+	default:
+		hosts, err = p.resolveA(ctx, service, name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(hosts, func(i, j int) bool { return hosts[i].GetAddress() < hosts[j].GetAddress() })
+	return hosts, nil
+}
+
+// resolveA looks up addresses of members of the service, which listen on the ports configured for it
+func (p *Provider) resolveA(ctx context.Context, service, name string) ([]membership.HostInfo, error) {
+	ips, err := p.resolver.LookupIP(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	ports := p.config.Ports[service]
+	port := strconv.Itoa(int(ports[membership.PortTchannel]))
+	hosts := make([]membership.HostInfo, 0, len(ips))
+	for _, ip := range ips {
+		hostPort := net.JoinHostPort(ip.String(), port)
+		hosts = append(hosts, membership.NewDetailedHostInfo(hostPort, hostPort, ports))
+	}
+	return hosts, nil
+}
+
+// resolveSRV looks up SRV records named after each known port and joins them by target,
+// members without a tchannel port are skipped as they can't be addressed.
+// Targets are resolved to their IPs, so members are identified by ip:port as with A records
+// and this host finds itself among them.
+func (p *Provider) resolveSRV(ctx context.Context, name string) ([]membership.HostInfo, error) {
+	portMaps := make(map[string]membership.PortMap)
+	for _, portName := range []string{membership.PortTchannel, membership.PortGRPC} {
+		_, srvs, err := p.lookupSRV(ctx, portName, "tcp", name)
+		if err != nil {
+			var dnsErr *net.DNSError
+			if portName != membership.PortTchannel && errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+				continue
+			}
+			return nil, err
+		}
+		for _, srv := range srvs {
+			target := strings.TrimSuffix(srv.Target, ".")
+			if portMaps[target] == nil {
+				portMaps[target] = make(membership.PortMap)
+			}
+			portMaps[target][portName] = srv.Port
+		}
+	}
+
+	hosts := make([]membership.HostInfo, 0, len(portMaps))
+	for target, portMap := range portMaps {
+		port, ok := portMap[membership.PortTchannel]
+		if !ok {
+			continue
+		}
+		ips, err := p.lookupTarget(ctx, target)
+		if err != nil {
+			return nil, fmt.Errorf("resolving SRV target %q: %w", target, err)
+		}
+		for _, ip := range ips {
+			hostPort := net.JoinHostPort(ip.String(), strconv.Itoa(int(port)))
+			hosts = append(hosts, membership.NewDetailedHostInfo(hostPort, hostPort, portMap))
+		}
+	}
+	return hosts, nil
+}
+
+// lookupTarget returns IPs of an SRV target, without resolving it if it is an IP literal
+func (p *Provider) lookupTarget(ctx context.Context, target string) ([]net.IP, error) {
+	if ip := net.ParseIP(target); ip != nil {
+		return []net.IP{ip}, nil
+	}
+	return p.resolver.LookupIP(ctx, target)
+}
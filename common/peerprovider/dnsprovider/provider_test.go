@@ -0,0 +1,288 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2017-2020 Uber Technologies Inc.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package dnsprovider
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/uber-go/tally"
+
+	"github.com/uber/cadence/common/log"
+	"github.com/uber/cadence/common/membership"
+	"github.com/uber/cadence/common/metrics"
+)
+
+type fakeResolver struct {
+	hosts   map[string][]string
+	srvs    map[string][]*net.SRV
+	err     error
+	hostErr error // fails LookupIP only
+}
+
+func (r *fakeResolver) LookupIP(ctx context.Context, host string) ([]net.IP, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	if r.hostErr != nil {
+		return nil, r.hostErr
+	}
+	var ips []net.IP
+	for _, addr := range r.hosts[host] {
+		ips = append(ips, net.ParseIP(addr))
+	}
+	return ips, nil
+}
+
+func (r *fakeResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	if r.err != nil {
+		return "", nil, r.err
+	}
+	srvs, ok := r.srvs["_"+service+"._"+proto+"."+name]
+	if !ok {
+		return "", nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	return "", srvs, nil
+}
+
+func TestResolveARecords(t *testing.T) {
+	resolver := &fakeResolver{hosts: map[string][]string{
+		"history":  {"10.0.0.2", "10.0.0.1"},
+		"matching": {"10.0.0.3"},
+	}}
+	historyPorts := membership.PortMap{membership.PortTchannel: 7934, membership.PortGRPC: 7834}
+	matchingPorts := membership.PortMap{membership.PortTchannel: 7935, membership.PortGRPC: 7835}
+	p := newTestProvider(&Config{
+		Services:   map[string]string{"cadence-history": "history", "cadence-matching": "matching"},
+		RecordType: RecordTypeA,
+		Ports:      map[string]membership.PortMap{"cadence-history": historyPorts, "cadence-matching": matchingPorts},
+	}, resolver, metrics.NewNoopMetricsClient())
+
+	p.refresh()
+	members, err := p.GetMembers("cadence-history")
+	assert.NoError(t, err)
+	assert.Equal(t, []membership.HostInfo{
+		membership.NewDetailedHostInfo("10.0.0.1:7934", "10.0.0.1:7934", historyPorts),
+		membership.NewDetailedHostInfo("10.0.0.2:7934", "10.0.0.2:7934", historyPorts),
+	}, members)
+
+	members, err = p.GetMembers("cadence-matching")
+	assert.NoError(t, err)
+	assert.Equal(t, []membership.HostInfo{
+		membership.NewDetailedHostInfo("10.0.0.3:7935", "10.0.0.3:7935", matchingPorts),
+	}, members, "every service has its own ports")
+}
+
+func TestResolveSRVRecordsPopulatesPorts(t *testing.T) {
+	resolver := &fakeResolver{
+		hosts: map[string][]string{
+			"history-0.history":   {"10.0.0.1"},
+			"history-1.history":   {"10.0.0.2"},
+			"history-2.history":   {"10.0.0.3"},
+			"matching-0.matching": {"10.0.1.1"},
+		},
+		srvs: map[string][]*net.SRV{
+			"_tchannel._tcp.history": {
+				{Target: "history-0.history.", Port: 7934},
+				{Target: "history-1.history.", Port: 7934},
+			},
+			"_grpc._tcp.history": {
+				{Target: "history-0.history.", Port: 7834},
+				{Target: "history-2.history.", Port: 7834},
+			},
+			"_tchannel._tcp.matching": {
+				{Target: "matching-0.matching.", Port: 7935},
+			},
+		},
+	}
+	p := newTestProvider(&Config{
+		Services:   map[string]string{"cadence-history": "history", "cadence-matching": "matching"},
+		RecordType: RecordTypeSRV,
+	}, resolver, metrics.NewNoopMetricsClient())
+
+	p.refresh()
+	members, err := p.GetMembers("cadence-history")
+	assert.NoError(t, err)
+	assert.Equal(t, []membership.HostInfo{
+		membership.NewDetailedHostInfo("10.0.0.1:7934", "10.0.0.1:7934",
+			membership.PortMap{membership.PortTchannel: 7934, membership.PortGRPC: 7834}),
+		membership.NewDetailedHostInfo("10.0.0.2:7934", "10.0.0.2:7934",
+			membership.PortMap{membership.PortTchannel: 7934}),
+	}, members, "members without tchannel port are skipped")
+
+	members, err = p.GetMembers("cadence-matching")
+	assert.NoError(t, err)
+	assert.Equal(t, []membership.HostInfo{
+		membership.NewDetailedHostInfo("10.0.1.1:7935", "10.0.1.1:7935",
+			membership.PortMap{membership.PortTchannel: 7935}),
+	}, members, "grpc records are optional")
+}
+
+func TestSelfIsFoundAmongSRVMembers(t *testing.T) {
+	resolver := &fakeResolver{
+		hosts: map[string][]string{"history-0.history": {"127.0.0.1"}, "history-1.history": {"127.0.0.2"}},
+		srvs: map[string][]*net.SRV{
+			"_tchannel._tcp.history": {
+				{Target: "history-0.history.", Port: 7934},
+				{Target: "history-1.history.", Port: 7934},
+			},
+		},
+	}
+	p := newTestProvider(&Config{
+		Services:   map[string]string{"cadence-history": "history"},
+		RecordType: RecordTypeSRV,
+	}, resolver, metrics.NewNoopMetricsClient())
+
+	p.refresh()
+	self, err := p.WhoAmI()
+	assert.NoError(t, err)
+	members, err := p.GetMembers("cadence-history")
+	assert.NoError(t, err)
+	found := false
+	for _, member := range members {
+		if member.Equals(self) {
+			found = true
+		}
+	}
+	assert.True(t, found, "self %v is one of %v", self, members)
+}
+
+func TestSRVTargetResolutionFailureKeepsMembers(t *testing.T) {
+	resolver := &fakeResolver{
+		hosts: map[string][]string{"history-0.history": {"10.0.0.1"}},
+		srvs: map[string][]*net.SRV{
+			"_tchannel._tcp.history": {{Target: "history-0.history.", Port: 7934}},
+		},
+	}
+	p := newTestProvider(&Config{
+		Services:   map[string]string{"cadence-history": "history"},
+		RecordType: RecordTypeSRV,
+	}, resolver, metrics.NewNoopMetricsClient())
+
+	p.refresh()
+	before, err := p.GetMembers("cadence-history")
+	assert.NoError(t, err)
+	assert.Len(t, before, 1)
+
+	resolver.hosts = nil
+	resolver.srvs["_tchannel._tcp.history"] = []*net.SRV{{Target: "history-1.history.", Port: 7934}}
+	resolver.hostErr = &net.DNSError{Err: "no such host", Name: "history-1.history", IsNotFound: true}
+	p.refresh()
+	after, err := p.GetMembers("cadence-history")
+	assert.NoError(t, err)
+	assert.Equal(t, before, after)
+}
+
+func TestRefreshNotifiesSubscribersAndKeepsMembersOnFailure(t *testing.T) {
+	resolver := &fakeResolver{hosts: map[string][]string{"history": {"10.0.0.1"}}}
+	scope := tally.NewTestScope("test", nil)
+	p := newTestProvider(&Config{
+		Services:   map[string]string{"cadence-history": "history"},
+		RecordType: RecordTypeA,
+		Ports:      map[string]membership.PortMap{"cadence-history": {membership.PortTchannel: 7934}},
+	}, resolver, metrics.NewClient(scope, metrics.History))
+
+	changeCh := make(chan *membership.ChangedEvent, 1)
+	assert.NoError(t, p.Subscribe("sub", changeCh))
+	assert.Error(t, p.Subscribe("sub", changeCh))
+
+	p.refresh()
+	event := <-changeCh
+	assert.Len(t, event.HostsAdded, 1)
+
+	p.refresh()
+	assert.Len(t, changeCh, 0, "no event without changes")
+
+	resolver.hosts["history"] = []string{"10.0.0.2"}
+	p.refresh()
+	event = <-changeCh
+	assert.Equal(t, "10.0.0.2:7934", event.HostsAdded[0].GetAddress())
+	assert.Equal(t, "10.0.0.1:7934", event.HostsRemoved[0].GetAddress())
+
+	resolver.err = errors.New("dns is down")
+	p.refresh()
+	members, err := p.GetMembers("cadence-history")
+	assert.NoError(t, err)
+	assert.Len(t, members, 1)
+	counter, ok := scope.Snapshot().Counters()["test.dns_peer_provider_resolution_failures+hashring_service=cadence-history,operation=DNSPeerProvider"]
+	assert.True(t, ok)
+	assert.Equal(t, int64(1), counter.Value())
+}
+
+func TestProviderLifecycle(t *testing.T) {
+	resolver := &fakeResolver{hosts: map[string][]string{"history": {"10.0.0.1"}}}
+	p := newTestProvider(&Config{
+		Services:   map[string]string{"cadence-history": "history"},
+		RecordType: RecordTypeA,
+		Ports:      map[string]membership.PortMap{"cadence-history": {membership.PortTchannel: 7934}},
+	}, resolver, metrics.NewNoopMetricsClient())
+
+	p.Start()
+	members, err := p.GetMembers("cadence-history")
+	assert.NoError(t, err)
+	assert.Len(t, members, 1, "members are resolved on start")
+	p.Stop()
+
+	self, err := p.WhoAmI()
+	assert.NoError(t, err)
+	assert.Equal(t, "127.0.0.1:7934", self.GetAddress())
+	assert.Equal(t, membership.PortMap{membership.PortTchannel: 7934}, self.Ports(), "self is the given host")
+	assert.NoError(t, p.SelfEvict())
+	assert.Error(t, p.Drain("cadence-history"))
+	assert.Error(t, p.Undrain("cadence-history"))
+}
+
+func TestNextRefreshIntervalIsJittered(t *testing.T) {
+	p := newTestProvider(&Config{
+		Services:        map[string]string{"cadence-history": "history"},
+		RecordType:      RecordTypeSRV,
+		RefreshInterval: 10 * time.Second,
+	}, &fakeResolver{}, metrics.NewNoopMetricsClient())
+
+	distinct := make(map[time.Duration]struct{})
+	for i := 0; i < 1000; i++ {
+		interval := p.nextRefreshInterval()
+		assert.GreaterOrEqual(t, interval, 8*time.Second)
+		assert.Less(t, interval, 12*time.Second)
+		distinct[interval] = struct{}{}
+	}
+	assert.Greater(t, len(distinct), 1, "intervals are randomized")
+
+	noJitter := 0.0
+	p.config.RefreshJitter = &noJitter
+	assert.Equal(t, 10*time.Second, p.nextRefreshInterval())
+}
+
+func newTestProvider(config *Config, resolver *fakeResolver, metricsClient metrics.Client) *Provider {
+	if err := config.validate(); err != nil {
+		panic(err)
+	}
+	self := membership.NewDetailedHostInfo("127.0.0.1:7934", "127.0.0.1:7934", membership.PortMap{membership.PortTchannel: 7934})
+	p := newProvider(config, self, resolver, metricsClient, log.NewNoop())
+	p.lookupSRV = resolver.LookupSRV
+	return p
+}
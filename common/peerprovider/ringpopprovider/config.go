@@ -28,10 +28,13 @@ import (
 	"strings"
 	"time"
 
+	"github.com/uber/ringpop-go"
 	"github.com/uber/ringpop-go/discovery"
 	"github.com/uber/ringpop-go/discovery/jsonfile"
 	"github.com/uber/ringpop-go/discovery/statichosts"
+	"github.com/uber/ringpop-go/swim"
 
+	"github.com/uber/cadence/common/backoff"
 	"github.com/uber/cadence/common/log"
 	"github.com/uber/cadence/common/log/tag"
 )
@@ -59,6 +62,15 @@ const (
 
 const (
 	defaultMaxJoinDuration = 10 * time.Second
+
+	defaultBootstrapInitialInterval    = time.Second
+	defaultBootstrapBackoffCoefficient = 2.0
+	defaultBootstrapMaxInterval        = 30 * time.Second
+	defaultBootstrapMaxAttempts        = 10
+
+	minSuspectPeriod   = time.Second
+	maxSuspectPeriod   = 5 * time.Minute
+	minTombstonePeriod = 10 * time.Second
 )
 
 // Config contains the ringpop config items
@@ -73,10 +85,112 @@ type Config struct {
 	BootstrapFile string `yaml:"bootstrapFile"`
 	// MaxJoinDuration is the max wait time to join the ring
 	MaxJoinDuration time.Duration `yaml:"maxJoinDuration"`
+	// BootstrapRetry configures retries of a failed bootstrap, e.g. when seed hosts are not up yet on a cluster cold start
+	BootstrapRetry BootstrapRetryConfig `yaml:"bootstrapRetry"`
+	// Suspicion configures how long unreachable members stay in each swim state before moving to the next one
+	Suspicion SuspicionConfig `yaml:"suspicion"`
 	// Custom discovery provider, cannot be specified through yaml
 	DiscoveryProvider discovery.DiscoverProvider `yaml:"-"`
 }
 
+// BootstrapRetryConfig contains exponential backoff parameters of ringpop bootstrap retries
+type BootstrapRetryConfig struct {
+	// InitialInterval is the delay before the first retry, defaults to 1s
+	InitialInterval time.Duration `yaml:"initialInterval"`
+	// BackoffCoefficient multiplies the delay after every retry, defaults to 2
+	BackoffCoefficient float64 `yaml:"backoffCoefficient"`
+	// MaxInterval caps the delay between retries, defaults to 30s
+	MaxInterval time.Duration `yaml:"maxInterval"`
+	// MaxAttempts caps the number of bootstrap attempts including the first one, defaults to 10.
+	// Set it to 1 to fail on the first error.
+	MaxAttempts int `yaml:"maxAttempts"`
+}
+
+func (c *BootstrapRetryConfig) applyDefaults() {
+	if c.InitialInterval <= 0 {
+		c.InitialInterval = defaultBootstrapInitialInterval
+	}
+	if c.BackoffCoefficient < 1 {
+		c.BackoffCoefficient = defaultBootstrapBackoffCoefficient
+	}
+	if c.MaxInterval <= 0 {
+		c.MaxInterval = defaultBootstrapMaxInterval
+	}
+	if c.MaxInterval < c.InitialInterval {
+		c.MaxInterval = c.InitialInterval
+	}
+	if c.MaxAttempts <= 0 {
+		c.MaxAttempts = defaultBootstrapMaxAttempts
+	}
+}
+
+func (c BootstrapRetryConfig) retryPolicy() backoff.RetryPolicy {
+	policy := backoff.NewExponentialRetryPolicy(c.InitialInterval)
+	policy.SetBackoffCoefficient(c.BackoffCoefficient)
+	policy.SetMaximumInterval(c.MaxInterval)
+	// attempts are capped by the provider, so that the policy never gives up on its own
+	policy.SetExpirationInterval(backoff.NoInterval)
+	return policy
+}
+
+// SuspicionConfig contains the swim state timeouts of ringpop, zero values keep the ringpop defaults.
+//
+// SuspectPeriod is the one to tune, as it bounds how fast a crashed host stops owning shards and task lists.
+// It has to cover a few protocol periods (200ms plus ping and indirect ping round trips) or a single
+// lost packet gets a healthy host declared faulty. Recommended values:
+//   - same datacenter, RTT below 5ms: 3s to 5s
+//   - cross zone, RTT up to 50ms: 5s to 10s
+//   - cross region or lossy networks, RTT above 50ms: 15s to 30s
+type SuspicionConfig struct {
+	// SuspectPeriod is the time a member stays suspect before it is declared faulty, defaults to 5s
+	SuspectPeriod time.Duration `yaml:"suspectPeriod"`
+	// FaultyPeriod is the time a member stays faulty before it becomes a tombstone, defaults to 24h
+	FaultyPeriod time.Duration `yaml:"faultyPeriod"`
+	// TombstonePeriod is the time a tombstone is kept before the member is evicted from the list, defaults to 1m
+	TombstonePeriod time.Duration `yaml:"tombstonePeriod"`
+}
+
+func (c SuspicionConfig) validate() error {
+	if c.SuspectPeriod < 0 || c.FaultyPeriod < 0 || c.TombstonePeriod < 0 {
+		return fmt.Errorf("ringpop suspicion periods cannot be negative")
+	}
+	if c.SuspectPeriod != 0 && (c.SuspectPeriod < minSuspectPeriod || c.SuspectPeriod > maxSuspectPeriod) {
+		return fmt.Errorf("ringpop suspectPeriod %v is out of the [%v, %v] range", c.SuspectPeriod, minSuspectPeriod, maxSuspectPeriod)
+	}
+	if c.FaultyPeriod != 0 && c.SuspectPeriod != 0 && c.FaultyPeriod < c.SuspectPeriod {
+		return fmt.Errorf("ringpop faultyPeriod %v is shorter than suspectPeriod %v", c.FaultyPeriod, c.SuspectPeriod)
+	}
+	if c.TombstonePeriod != 0 && c.TombstonePeriod < minTombstonePeriod {
+		return fmt.Errorf("ringpop tombstonePeriod %v is shorter than %v", c.TombstonePeriod, minTombstonePeriod)
+	}
+	return nil
+}
+
+// stateTimeouts returns the swim state timeouts of the periods, zero timeouts keep the ringpop defaults
+func (c SuspicionConfig) stateTimeouts() swim.StateTimeouts {
+	return swim.StateTimeouts{
+		Suspect:   c.SuspectPeriod,
+		Faulty:    c.FaultyPeriod,
+		Tombstone: c.TombstonePeriod,
+	}
+}
+
+// options returns the ringpop options overriding the default state timeouts
+func (c SuspicionConfig) options() []ringpop.Option {
+	timeouts := c.stateTimeouts()
+	var opts []ringpop.Option
+	if timeouts.Suspect > 0 {
+		opts = append(opts, ringpop.SuspectPeriod(timeouts.Suspect))
+	}
+	if timeouts.Faulty > 0 {
+		opts = append(opts, ringpop.FaultyPeriod(timeouts.Faulty))
+	}
+	if timeouts.Tombstone > 0 {
+		opts = append(opts, ringpop.TombstonePeriod(timeouts.Tombstone))
+	}
+	return opts
+}
+
 func (rpConfig *Config) validate() error {
 	if len(rpConfig.Name) == 0 {
 		return fmt.Errorf("ringpop config missing `name` param")
@@ -85,6 +199,10 @@ func (rpConfig *Config) validate() error {
 	if rpConfig.MaxJoinDuration == 0 {
 		rpConfig.MaxJoinDuration = defaultMaxJoinDuration
 	}
+	rpConfig.BootstrapRetry.applyDefaults()
+	if err := rpConfig.Suspicion.validate(); err != nil {
+		return err
+	}
 
 	return validateBootstrapMode(rpConfig)
 }
@@ -30,6 +30,7 @@ import (
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
 	"github.com/uber/ringpop-go/discovery/statichosts"
+	"github.com/uber/ringpop-go/swim"
 	"gopkg.in/yaml.v2"
 
 	"github.com/uber/cadence/common/log/loggerimpl"
@@ -60,6 +61,91 @@ func (s *RingpopSuite) TestHostsMode() {
 	s.Nil(err)
 }
 
+func (s *RingpopSuite) TestBootstrapRetryDefaults() {
+	var cfg Config
+	err := yaml.Unmarshal([]byte(getHostsConfig()), &cfg)
+	s.Nil(err)
+	s.Nil(cfg.validate())
+	s.Equal(BootstrapRetryConfig{
+		InitialInterval:    time.Second,
+		BackoffCoefficient: 2,
+		MaxInterval:        30 * time.Second,
+		MaxAttempts:        10,
+	}, cfg.BootstrapRetry)
+
+	err = yaml.Unmarshal([]byte(`
+name: "test"
+bootstrapMode: "hosts"
+bootstrapHosts: ["127.0.0.1:1111"]
+bootstrapRetry:
+  initialInterval: 2s
+  maxAttempts: 1
+`), &cfg)
+	s.Nil(err)
+	s.Nil(cfg.validate())
+	s.Equal(2*time.Second, cfg.BootstrapRetry.InitialInterval)
+	s.Equal(1, cfg.BootstrapRetry.MaxAttempts)
+}
+
+func (s *RingpopSuite) TestSuspicionPeriods() {
+	var cfg Config
+	err := yaml.Unmarshal([]byte(`
+name: "test"
+bootstrapMode: "hosts"
+bootstrapHosts: ["127.0.0.1:1111"]
+suspicion:
+  suspectPeriod: 10s
+  faultyPeriod: 1h
+`), &cfg)
+	s.Nil(err)
+	s.Nil(cfg.validate())
+	s.Equal(SuspicionConfig{SuspectPeriod: 10 * time.Second, FaultyPeriod: time.Hour}, cfg.Suspicion)
+	s.Len(cfg.Suspicion.options(), 2)
+
+	for _, invalid := range []SuspicionConfig{
+		{SuspectPeriod: 100 * time.Millisecond},
+		{SuspectPeriod: time.Hour},
+		{SuspectPeriod: 10 * time.Second, FaultyPeriod: time.Second},
+		{TombstonePeriod: time.Second},
+		{FaultyPeriod: -time.Second},
+	} {
+		cfg.Suspicion = invalid
+		s.NotNil(cfg.validate(), "%+v should be rejected", invalid)
+	}
+}
+
+func (s *RingpopSuite) TestSuspicionStateTimeouts() {
+	for _, tc := range []struct {
+		config   SuspicionConfig
+		expected swim.StateTimeouts
+		options  int
+	}{
+		{
+			config:   SuspicionConfig{},
+			expected: swim.StateTimeouts{},
+			options:  0,
+		},
+		{
+			config:   SuspicionConfig{SuspectPeriod: 3 * time.Second, TombstonePeriod: 2 * time.Minute},
+			expected: swim.StateTimeouts{Suspect: 3 * time.Second, Tombstone: 2 * time.Minute},
+			options:  2,
+		},
+		{
+			config:   SuspicionConfig{SuspectPeriod: 30 * time.Second, FaultyPeriod: time.Hour, TombstonePeriod: time.Minute},
+			expected: swim.StateTimeouts{Suspect: 30 * time.Second, Faulty: time.Hour, Tombstone: time.Minute},
+			options:  3,
+		},
+	} {
+		s.Nil(tc.config.validate(), "%+v should be accepted", tc.config)
+		s.Equal(tc.expected, tc.config.stateTimeouts(), "zero periods keep the ringpop defaults")
+		s.Len(tc.config.options(), tc.options, "only set periods override ringpop defaults")
+	}
+
+	s.Nil(SuspicionConfig{SuspectPeriod: minSuspectPeriod, TombstonePeriod: minTombstonePeriod}.validate())
+	s.Nil(SuspicionConfig{SuspectPeriod: maxSuspectPeriod, FaultyPeriod: maxSuspectPeriod}.validate())
+	s.Nil(SuspicionConfig{FaultyPeriod: time.Second}.validate(), "faulty period is only compared with a set suspect period")
+}
+
 func (s *RingpopSuite) TestFileMode() {
 	var cfg Config
 	err := yaml.Unmarshal([]byte(getJSONConfig()), &cfg)
@@ -23,11 +23,13 @@
 package ringpopprovider
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"strconv"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"go.uber.org/yarpc/transport/tchannel"
 
@@ -38,22 +40,29 @@ import (
 	tcg "github.com/uber/tchannel-go"
 
 	"github.com/uber/cadence/common"
+	"github.com/uber/cadence/common/backoff"
 	"github.com/uber/cadence/common/log"
 	"github.com/uber/cadence/common/log/tag"
 	"github.com/uber/cadence/common/membership"
+	"github.com/uber/cadence/common/metrics"
 )
 
 type (
 	// Provider use ringpop to announce membership changes
 	Provider struct {
-		status      int32
-		service     string
-		ringpop     *ringpop.Ringpop
-		bootParams  *swim.BootstrapOptions
-		logger      log.Logger
-		portmap     membership.PortMap
-		mu          sync.RWMutex
-		subscribers map[string]chan<- *membership.ChangedEvent
+		status         int32
+		service        string
+		ringpop        *ringpop.Ringpop
+		bootParams     *swim.BootstrapOptions
+		bootstrapRetry BootstrapRetryConfig
+		bootstrapFn    func(*swim.BootstrapOptions) ([]string, error)
+		ctx            context.Context // canceled on Stop, interrupts bootstrap retries
+		cancel         context.CancelFunc
+		scope          metrics.Scope
+		logger         log.Logger
+		portmap        membership.PortMap
+		mu             sync.RWMutex
+		subscribers    map[string]chan<- *membership.ChangedEvent
 	}
 )
 
@@ -70,6 +79,7 @@ func New(
 	config *Config,
 	channel tchannel.Channel,
 	portMap membership.PortMap,
+	metricsClient metrics.Client,
 	logger log.Logger,
 ) (*Provider, error) {
 	if err := config.validate(); err != nil {
@@ -86,12 +96,13 @@ func New(
 		DiscoverProvider: discoveryProvider,
 	}
 
-	rp, err := ringpop.New(config.Name, ringpop.Channel(channel.(*tcg.Channel)))
+	opts := append([]ringpop.Option{ringpop.Channel(channel.(*tcg.Channel))}, config.Suspicion.options()...)
+	rp, err := ringpop.New(config.Name, opts...)
 	if err != nil {
 		return nil, fmt.Errorf("ringpop instance creation: %w", err)
 	}
 
-	return NewRingpopProvider(service, rp, portMap, bootstrapOpts, logger), nil
+	return NewRingpopProvider(service, rp, portMap, bootstrapOpts, config.BootstrapRetry, metricsClient, logger), nil
 }
 
 // NewRingpopProvider sets up ringpop based peer provider
@@ -100,16 +111,25 @@ func NewRingpopProvider(
 	rp *ringpop.Ringpop,
 	portMap membership.PortMap,
 	bootstrapOpts *swim.BootstrapOptions,
+	bootstrapRetry BootstrapRetryConfig,
+	metricsClient metrics.Client,
 	logger log.Logger,
 ) *Provider {
+	bootstrapRetry.applyDefaults()
+	ctx, cancel := context.WithCancel(context.Background())
 	return &Provider{
-		service:     service,
-		status:      common.DaemonStatusInitialized,
-		bootParams:  bootstrapOpts,
-		logger:      logger,
-		portmap:     portMap,
-		ringpop:     rp,
-		subscribers: map[string]chan<- *membership.ChangedEvent{},
+		service:        service,
+		status:         common.DaemonStatusInitialized,
+		bootParams:     bootstrapOpts,
+		bootstrapRetry: bootstrapRetry,
+		bootstrapFn:    rp.Bootstrap,
+		ctx:            ctx,
+		cancel:         cancel,
+		scope:          metricsClient.Scope(metrics.RingpopPeerProviderScope),
+		logger:         logger,
+		portmap:        portMap,
+		ringpop:        rp,
+		subscribers:    map[string]chan<- *membership.ChangedEvent{},
 	}
 }
 
@@ -123,8 +143,11 @@ func (r *Provider) Start() {
 		return
 	}
 
-	_, err := r.ringpop.Bootstrap(r.bootParams)
-	if err != nil {
+	if err := r.bootstrap(); err != nil {
+		if r.ctx.Err() != nil {
+			r.logger.Info("ringpop bootstrap interrupted by shutdown", tag.Error(err))
+			return
+		}
 		r.logger.Fatal("unable to bootstrap ringpop", tag.Error(err))
 	}
 
@@ -148,6 +171,32 @@ func (r *Provider) Start() {
 	}
 }
 
+// bootstrap joins the ring, retrying with exponential backoff until the attempts cap is reached or the provider is stopped
+func (r *Provider) bootstrap() error {
+	retrier := backoff.NewRetrier(r.bootstrapRetry.retryPolicy(), backoff.SystemClock)
+	for attempt := 1; ; attempt++ {
+		r.scope.IncCounter(metrics.RingpopBootstrapAttempts)
+		_, err := r.bootstrapFn(r.bootParams)
+		if err == nil {
+			return nil
+		}
+		r.scope.IncCounter(metrics.RingpopBootstrapFailures)
+		if attempt >= r.bootstrapRetry.MaxAttempts {
+			return fmt.Errorf("ringpop bootstrap failed after %d attempts: %w", attempt, err)
+		}
+
+		delay := retrier.NextBackOff()
+		r.logger.Warn("ringpop bootstrap failed, retrying", tag.Attempt(int32(attempt)), tag.Error(err))
+		timer := time.NewTimer(delay)
+		select {
+		case <-r.ctx.Done():
+			timer.Stop()
+			return r.ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
 // HandleEvent handles updates from ringpop
 func (r *Provider) HandleEvent(
 	event events.Event,
@@ -161,9 +210,9 @@ func (r *Provider) HandleEvent(
 	r.logger.Info("Received a ringpop ring changed event")
 	// Marshall the event object into the required type
 	change := &membership.ChangedEvent{
-		HostsAdded:   e.ServersAdded,
-		HostsUpdated: e.ServersUpdated,
-		HostsRemoved: e.ServersRemoved,
+		HostsAdded:   hostInfos(e.ServersAdded),
+		HostsUpdated: hostInfos(e.ServersUpdated),
+		HostsRemoved: hostInfos(e.ServersRemoved),
 	}
 
 	// Notify subscribers
@@ -184,6 +233,25 @@ func (r *Provider) SelfEvict() error {
 	return r.ringpop.SelfEvict()
 }
 
+// Drain sets the drained label of the service, so that other members stop routing its work to this host
+func (r *Provider) Drain(service string) error {
+	labels, err := r.ringpop.Labels()
+	if err != nil {
+		return fmt.Errorf("getting ringpop labels: %w", err)
+	}
+	return labels.Set(membership.DrainedLabel(membership.Service(service)), "true")
+}
+
+// Undrain removes the drained label set by Drain
+func (r *Provider) Undrain(service string) error {
+	labels, err := r.ringpop.Labels()
+	if err != nil {
+		return fmt.Errorf("getting ringpop labels: %w", err)
+	}
+	_, err = labels.Remove(membership.DrainedLabel(membership.Service(service)))
+	return err
+}
+
 // GetMembers returns all hosts with a specified role value
 func (r *Provider) GetMembers(service string) ([]membership.HostInfo, error) {
 	var res []membership.HostInfo
@@ -232,7 +300,14 @@ func (r *Provider) GetMembers(service string) ([]membership.HostInfo, error) {
 			}
 		}
 
-		res = append(res, membership.NewDetailedHostInfo(member.GetAddress(), member.Identity(), portMap))
+		host := membership.NewDetailedHostInfo(member.GetAddress(), member.Identity(), portMap)
+		for key, value := range member.Labels {
+			if isInternalLabel(key) {
+				continue
+			}
+			host = host.WithLabel(key, value)
+		}
+		res = append(res, host)
 
 		return true
 	}
@@ -244,6 +319,16 @@ func (r *Provider) GetMembers(service string) ([]membership.HostInfo, error) {
 	return res, nil
 }
 
+// isInternalLabel tells if the ringpop label is already part of HostInfo, such as ports and identity,
+// or only used to find members of a service
+func isInternalLabel(key string) bool {
+	switch key {
+	case roleKey, membership.PortTchannel, membership.PortGRPC, rpmembership.IdentityLabelKey:
+		return true
+	}
+	return false
+}
+
 // WhoAmI returns address of this instance
 func (r *Provider) WhoAmI() (membership.HostInfo, error) {
 	address, err := r.ringpop.WhoAmI()
@@ -277,6 +362,7 @@ func (r *Provider) Stop() {
 		return
 	}
 
+	r.cancel()
 	r.ringpop.Destroy()
 }
 
@@ -294,6 +380,18 @@ func (r *Provider) Subscribe(name string, notifyChannel chan<- *membership.Chang
 	return nil
 }
 
+// hostInfos converts ringpop server addresses into hosts, only addresses are known at this point
+func hostInfos(addrs []string) []membership.HostInfo {
+	if len(addrs) == 0 {
+		return nil
+	}
+	res := make([]membership.HostInfo, 0, len(addrs))
+	for _, addr := range addrs {
+		res = append(res, membership.NewHostInfo(addr))
+	}
+	return res
+}
+
 func labelToPort(label string) (uint16, error) {
 	port, err := strconv.ParseInt(label, 0, 16)
 	if err != nil {
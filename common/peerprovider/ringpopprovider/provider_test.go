@@ -23,11 +23,16 @@
 package ringpopprovider
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"strings"
+	"testing"
 	"time"
 
 	"github.com/pborman/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/uber-go/tally"
 	"github.com/uber/ringpop-go"
 	"github.com/uber/ringpop-go/discovery/statichosts"
 	"github.com/uber/ringpop-go/swim"
@@ -36,6 +41,7 @@ import (
 	"github.com/uber/cadence/common/log/loggerimpl"
 	"github.com/uber/cadence/common/log/tag"
 	"github.com/uber/cadence/common/membership"
+	"github.com/uber/cadence/common/metrics"
 )
 
 type HostInfo struct {
@@ -100,7 +106,7 @@ func NewTestRingpopCluster(ringPopApp string, size int, ipAddr string, seed stri
 			return nil
 		}
 
-		NewRingpopProvider(ringPopApp, ringPop, membership.PortMap{}, bOptions, logger)
+		NewRingpopProvider(ringPopApp, ringPop, membership.PortMap{}, bOptions, BootstrapRetryConfig{}, metrics.NewNoopMetricsClient(), logger)
 
 	}
 	return cluster
@@ -131,3 +137,90 @@ func (c *TestRingpopCluster) FindHostByAddr(addr string) (HostInfo, bool) {
 	}
 	return HostInfo{}, false
 }
+
+func TestBootstrapIsRetriedWithBackoff(t *testing.T) {
+	scope := tally.NewTestScope("test", nil)
+	p := NewRingpopProvider("cadence-history", nil, membership.PortMap{}, nil, BootstrapRetryConfig{
+		InitialInterval: time.Millisecond,
+		MaxInterval:     5 * time.Millisecond,
+		MaxAttempts:     5,
+	}, metrics.NewClient(scope, metrics.History), loggerimpl.NewNopLogger())
+
+	attempts := 0
+	p.bootstrapFn = func(*swim.BootstrapOptions) ([]string, error) {
+		attempts++
+		if attempts < 3 {
+			return nil, errors.New("seed hosts are not up")
+		}
+		return []string{"seed"}, nil
+	}
+	assert.NoError(t, p.bootstrap())
+	assert.Equal(t, 3, attempts)
+
+	counters := scope.Snapshot().Counters()
+	assert.Equal(t, int64(3), counters["test.ringpop_bootstrap_attempts+operation=RingpopPeerProvider"].Value())
+	assert.Equal(t, int64(2), counters["test.ringpop_bootstrap_failures+operation=RingpopPeerProvider"].Value())
+}
+
+func TestBootstrapGivesUpAfterMaxAttempts(t *testing.T) {
+	p := NewRingpopProvider("cadence-history", nil, membership.PortMap{}, nil, BootstrapRetryConfig{
+		InitialInterval: time.Millisecond,
+		MaxAttempts:     4,
+	}, metrics.NewNoopMetricsClient(), loggerimpl.NewNopLogger())
+
+	attempts := 0
+	p.bootstrapFn = func(*swim.BootstrapOptions) ([]string, error) {
+		attempts++
+		return nil, errors.New("seed hosts are not up")
+	}
+	assert.EqualError(t, p.bootstrap(), "ringpop bootstrap failed after 4 attempts: seed hosts are not up")
+	assert.Equal(t, 4, attempts)
+}
+
+func TestBootstrapRetriesAreInterruptedByShutdown(t *testing.T) {
+	p := NewRingpopProvider("cadence-history", nil, membership.PortMap{}, nil, BootstrapRetryConfig{
+		InitialInterval: time.Hour,
+	}, metrics.NewNoopMetricsClient(), loggerimpl.NewNopLogger())
+
+	p.bootstrapFn = func(*swim.BootstrapOptions) ([]string, error) {
+		p.cancel()
+		return nil, errors.New("seed hosts are not up")
+	}
+	assert.Equal(t, context.Canceled, p.bootstrap())
+}
+
+func TestGetMembersCopiesLabels(t *testing.T) {
+	ch, err := tchannel.NewChannel("test", nil)
+	assert.NoError(t, err)
+	defer ch.Close()
+	assert.NoError(t, ch.ListenAndServe("127.0.0.1:0"))
+
+	p, err := New("cadence-history", &Config{
+		Name:            "test",
+		BootstrapMode:   BootstrapModeHosts,
+		BootstrapHosts:  []string{ch.PeerInfo().HostPort},
+		MaxJoinDuration: time.Second,
+	}, ch, membership.PortMap{membership.PortGRPC: 7833}, metrics.NewNoopMetricsClient(), loggerimpl.NewNopLogger())
+	assert.NoError(t, err)
+	p.Start()
+	defer p.Stop()
+
+	labels, err := p.ringpop.Labels()
+	assert.NoError(t, err)
+	assert.NoError(t, labels.Set(membership.LabelZone, "zone-a"))
+	assert.NoError(t, p.Drain("cadence-history"))
+
+	members, err := p.GetMembers("cadence-history")
+	assert.NoError(t, err)
+	assert.Len(t, members, 1)
+	zone, ok := members[0].Label(membership.LabelZone)
+	assert.True(t, ok)
+	assert.Equal(t, "zone-a", zone)
+	drained, ok := members[0].Label(membership.DrainedLabel(membership.ServiceHistory))
+	assert.True(t, ok)
+	assert.Equal(t, "true", drained)
+	_, ok = members[0].Label(roleKey)
+	assert.False(t, ok, "labels finding members of a service are not copied")
+	_, ok = members[0].Label(membership.PortGRPC)
+	assert.False(t, ok, "ports are not copied as labels")
+}
@@ -33,7 +33,7 @@ func PerMember(service string, globalRPS, instanceRPS float64, resolver membersh
 		return instanceRPS
 	}
 
-	memberCount, err := resolver.MemberCount(service)
+	memberCount, err := resolver.MemberCount(membership.Service(service))
 	if err != nil || memberCount < 1 {
 		return instanceRPS
 	}
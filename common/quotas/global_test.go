@@ -32,9 +32,9 @@ import (
 func Test_PerMember(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	resolver := membership.NewMockResolver(ctrl)
-	resolver.EXPECT().MemberCount("A").Return(10, nil).AnyTimes()
-	resolver.EXPECT().MemberCount("X").Return(0, assert.AnError).AnyTimes()
-	resolver.EXPECT().MemberCount("Y").Return(0, nil).AnyTimes()
+	resolver.EXPECT().MemberCount(membership.Service("A")).Return(10, nil).AnyTimes()
+	resolver.EXPECT().MemberCount(membership.Service("X")).Return(0, assert.AnError).AnyTimes()
+	resolver.EXPECT().MemberCount(membership.Service("Y")).Return(0, nil).AnyTimes()
 
 	// Invalid service - fallback to instanceRPS
 	assert.Equal(t, 3.0, PerMember("X", 20.0, 3.0, resolver))
@@ -367,6 +367,7 @@ func (h *Impl) Start() {
 		h.logger.WithTags(tag.Error(err)).Fatal("fail to get host info from membership monitor")
 	}
 	h.hostInfo = hostInfo
+	hostInfo.LogSelf(h.logger)
 
 	if h.isolationGroupConfigStore != nil {
 		h.isolationGroupConfigStore.Start()
@@ -41,11 +41,29 @@ func newSimpleHashring(hosts []membership.HostInfo) *simpleHashring {
 }
 
 func (s *simpleHashring) Lookup(key string) (membership.HostInfo, error) {
+	if len(s.hosts) == 0 {
+		return membership.HostInfo{}, membership.ErrNoMembers
+	}
 	hash := int(s.hashfunc([]byte(key)))
 	idx := hash % len(s.hosts)
 	return s.hosts[idx], nil
 }
 
+func (s *simpleHashring) LookupN(key string, n int) ([]membership.HostInfo, error) {
+	if len(s.hosts) == 0 {
+		return nil, membership.ErrNoMembers
+	}
+	if n > len(s.hosts) {
+		n = len(s.hosts)
+	}
+	hash := int(s.hashfunc([]byte(key)))
+	hosts := make([]membership.HostInfo, 0, n)
+	for i := 0; i < n; i++ {
+		hosts = append(hosts, s.hosts[(hash+i)%len(s.hosts)])
+	}
+	return hosts, nil
+}
+
 func (s *simpleHashring) AddListener(name string, notifyChannel chan<- *membership.ChangedEvent) error {
 	return nil
 }
@@ -21,9 +21,12 @@
 package host
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"sync"
 
+	"github.com/uber/cadence/common"
 	"github.com/uber/cadence/common/membership"
 )
 
@@ -50,7 +53,19 @@ func (s *simpleResolver) Start() {
 func (s *simpleResolver) Stop() {
 }
 
-func (s *simpleResolver) EvictSelf() error {
+func (s *simpleResolver) EvictSelf(ctx context.Context) error {
+	return nil
+}
+
+func (s *simpleResolver) WaitReady(ctx context.Context) error {
+	return nil
+}
+
+func (s *simpleResolver) Drain(service membership.Service) error {
+	return nil
+}
+
+func (s *simpleResolver) Undrain(service membership.Service) error {
 	return nil
 }
 
@@ -58,32 +73,208 @@ func (s *simpleResolver) WhoAmI() (membership.HostInfo, error) {
 	return s.hostInfo, nil
 }
 
-func (s *simpleResolver) Subscribe(service string, name string, notifyChannel chan<- *membership.ChangedEvent) error {
+func (s *simpleResolver) InvalidateSelf() {
+}
+
+func (s *simpleResolver) Subscribe(service membership.Service, name string, notifyChannel chan<- *membership.ChangedEvent) error {
+	return nil
+}
+
+func (s *simpleResolver) Unsubscribe(service membership.Service, name string) error {
 	return nil
 }
 
-func (s *simpleResolver) Unsubscribe(service string, name string) error {
+func (s *simpleResolver) SubscribeShard(service membership.Service, numShards int, handler func(shardID int, owner membership.HostInfo)) error {
 	return nil
 }
 
-func (s *simpleResolver) Lookup(service string, key string) (membership.HostInfo, error) {
-	resolver, ok := s.resolvers[service]
+func (s *simpleResolver) Lookup(service membership.Service, key string) (membership.HostInfo, error) {
+	resolver, ok := s.resolvers[string(service)]
 	if !ok {
 		return membership.HostInfo{}, fmt.Errorf("cannot lookup host for service %q", service)
 	}
 	return resolver.Lookup(key)
 }
 
-func (s *simpleResolver) MemberCount(service string) (int, error) {
+func (s *simpleResolver) ShardFor(key string, numShards int) int {
+	return common.WorkflowIDToHistoryShard(key, numShards)
+}
+
+func (s *simpleResolver) LookupShard(service membership.Service, shardID int) (membership.HostInfo, error) {
+	return s.Lookup(service, string(rune(shardID)))
+}
+
+func (s *simpleResolver) LookupShardRange(service membership.Service, lo, hi int) (map[int]membership.HostInfo, error) {
+	if lo < 0 || hi < lo {
+		return nil, fmt.Errorf("invalid shard range [%d, %d)", lo, hi)
+	}
+	owners := make(map[int]membership.HostInfo, hi-lo)
+	for shardID := lo; shardID < hi; shardID++ {
+		owner, err := s.LookupShard(service, shardID)
+		if err != nil {
+			return nil, err
+		}
+		owners[shardID] = owner
+	}
+	return owners, nil
+}
+
+func (s *simpleResolver) LookupN(service membership.Service, key string, n int) ([]membership.HostInfo, error) {
+	resolver, ok := s.resolvers[string(service)]
+	if !ok {
+		return nil, fmt.Errorf("cannot lookup host for service %q", service)
+	}
+	return resolver.LookupN(key, n)
+}
+
+func (s *simpleResolver) LookupExcluding(service membership.Service, key string, exclude membership.HostInfo) (membership.HostInfo, error) {
+	owners, err := s.LookupN(service, key, 2)
+	if err != nil {
+		return membership.HostInfo{}, err
+	}
+	for _, owner := range owners {
+		if owner.GetAddress() != exclude.GetAddress() {
+			return owner, nil
+		}
+	}
+	return membership.HostInfo{}, membership.ErrOnlyOwnerExcluded
+}
+
+// WatchKey never sends on the channel since the hosts of simpleResolver are fixed, it is closed once the watch is cancelled
+func (s *simpleResolver) WatchKey(service membership.Service, key string) (<-chan membership.HostInfo, func(), error) {
+	ch := make(chan membership.HostInfo)
+	var once sync.Once
+	return ch, func() { once.Do(func() { close(ch) }) }, nil
+}
+
+func (s *simpleResolver) LookupBatch(service membership.Service, keys []string) ([]membership.HostInfo, error) {
+	hosts := make([]membership.HostInfo, 0, len(keys))
+	for _, key := range keys {
+		host, err := s.Lookup(service, key)
+		if err != nil {
+			return nil, err
+		}
+		hosts = append(hosts, host)
+	}
+	return hosts, nil
+}
+
+func (s *simpleResolver) LookupNDiverse(service membership.Service, key string, n int, labelKey string) ([]membership.HostInfo, error) {
+	return s.LookupN(service, key, n)
+}
+
+func (s *simpleResolver) LookupZoneAware(service membership.Service, key, localZone string) (membership.HostInfo, error) {
+	owners, err := s.LookupN(service, key, 3)
+	if err != nil {
+		return membership.HostInfo{}, err
+	}
+	for _, owner := range owners {
+		if zone, ok := owner.Label(membership.LabelZone); ok && localZone != "" && zone == localZone {
+			return owner, nil
+		}
+	}
+	return owners[0], nil
+}
+
+func (s *simpleResolver) MemberCount(service membership.Service) (int, error) {
 	return 0, nil
 }
 
-func (s *simpleResolver) Members(service string) ([]membership.HostInfo, error) {
+func (s *simpleResolver) LastRebalanceMoved(service membership.Service) (int, error) {
+	return 0, nil
+}
+
+func (s *simpleResolver) DriftCount(service membership.Service) (int, error) {
+	return 0, nil
+}
+
+func (s *simpleResolver) LoadDistribution(service membership.Service) (map[string]int, error) {
 	return nil, nil
 }
 
-func (s *simpleResolver) LookupByAddress(service string, address string) (membership.HostInfo, error) {
-	resolver, ok := s.resolvers[service]
+func (s *simpleResolver) Members(service membership.Service) ([]membership.HostInfo, error) {
+	return nil, nil
+}
+
+func (s *simpleResolver) Peers(service membership.Service) ([]membership.HostInfo, error) {
+	return nil, nil
+}
+
+func (s *simpleResolver) Leader(service membership.Service) (membership.HostInfo, error) {
+	return membership.HostInfo{}, nil
+}
+
+func (s *simpleResolver) LookupWithVersion(service membership.Service, key string) (membership.HostInfo, uint64, error) {
+	host, err := s.Lookup(service, key)
+	return host, 0, err
+}
+
+func (s *simpleResolver) RingVersion(service membership.Service) (uint64, error) {
+	return 0, nil
+}
+
+func (s *simpleResolver) LookupInto(service membership.Service, key string, out *membership.HostInfo) error {
+	host, err := s.Lookup(service, key)
+	if err != nil {
+		return err
+	}
+	*out = host
+	return nil
+}
+
+func (s *simpleResolver) LookupForDomain(service membership.Service, domain, key string) (membership.HostInfo, error) {
+	return s.Lookup(service, key)
+}
+
+func (s *simpleResolver) LookupForPort(service membership.Service, key, port string) (membership.HostInfo, error) {
+	return s.Lookup(service, key)
+}
+
+func (s *simpleResolver) PinKey(service membership.Service, key string, host membership.HostInfo) error {
+	return nil
+}
+
+func (s *simpleResolver) UnpinKey(service membership.Service, key string) error {
+	return nil
+}
+
+func (s *simpleResolver) RecentChanges(limit int) []membership.TimestampedChange {
+	return nil
+}
+
+func (s *simpleResolver) SetWarmupHook(hook func(membership.HostInfo)) {
+}
+
+func (s *simpleResolver) ActivePeerConnections() int {
+	return 0
+}
+
+func (s *simpleResolver) IdentityConflicts() []membership.IdentityConflict {
+	return nil
+}
+
+func (s *simpleResolver) ConsistencyDigest(service membership.Service) (uint64, error) {
+	return 0, nil
+}
+
+func (s *simpleResolver) DescribeRings() []membership.RingDescription {
+	return nil
+}
+
+func (s *simpleResolver) Refresh() error {
+	return nil
+}
+
+func (s *simpleResolver) MembersWithLabel(service membership.Service, key, value string) ([]membership.HostInfo, error) {
+	return nil, nil
+}
+
+func (s *simpleResolver) HostLoad(service membership.Service) (map[string]float64, error) {
+	return nil, nil
+}
+
+func (s *simpleResolver) LookupByAddress(service membership.Service, address string) (membership.HostInfo, error) {
+	resolver, ok := s.resolvers[string(service)]
 	if !ok {
 		return membership.HostInfo{}, fmt.Errorf("cannot lookup host for service %q", service)
 	}
@@ -95,3 +286,7 @@ func (s *simpleResolver) LookupByAddress(service string, address string) (member
 
 	return membership.HostInfo{}, errors.New("host not found")
 }
+
+func (s *simpleResolver) ExportSnapshot() ([]byte, error) {
+	return nil, errors.New("snapshot is not supported by simple resolver")
+}
@@ -238,10 +238,7 @@ func newHost(tchan uint16) membership.HostInfo {
 	return membership.NewDetailedHostInfo(
 		fmt.Sprintf("%s:%d", address, tchan),
 		fmt.Sprintf("%s_%d", address, tchan),
-		membership.PortMap{
-			membership.PortTchannel: tchan,
-			membership.PortGRPC:     tchan + 10,
-		},
+		membership.NewPortMap(tchan, tchan+10),
 	)
 
 }
@@ -46,6 +46,7 @@ import (
 	"github.com/uber/cadence/common/elasticsearch"
 	"github.com/uber/cadence/common/log"
 	"github.com/uber/cadence/common/log/tag"
+	"github.com/uber/cadence/common/membership"
 	"github.com/uber/cadence/common/metrics"
 	"github.com/uber/cadence/common/ndc"
 	"github.com/uber/cadence/common/persistence"
@@ -924,7 +925,7 @@ func (adh *adminHandlerImpl) DescribeCluster(
 		var rings []*types.RingInfo
 		for _, role := range service.List {
 			var servers []*types.HostInfo
-			members, err := monitor.Members(role)
+			members, err := monitor.Members(membership.Service(role))
 			if err != nil {
 				return nil, adh.error(err, scope)
 			}
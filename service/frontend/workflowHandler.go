@@ -55,6 +55,8 @@ import (
 const (
 	getDomainReplicationMessageBatchSize = 100
 	defaultLastMessageID                 = int64(-1)
+	// ringReadinessTimeout bounds the wait for membership rings after the warmup duration has elapsed
+	ringReadinessTimeout = 5 * time.Minute
 )
 
 const (
@@ -94,6 +96,7 @@ type (
 		visibilityQueryValidator  *validator.VisibilityQueryValidator
 		searchAttributesValidator *validator.SearchAttributesValidator
 		throttleRetry             *backoff.ThrottleRetry
+		stopWarmup                context.CancelFunc
 	}
 
 	getHistoryContinuationToken struct {
@@ -232,22 +235,42 @@ func (wh *WorkflowHandler) Start() {
 	// TODO: Get warmup duration from config. Even better, run proactive checks such as probing downstream connections.
 	const warmUpDuration = 30 * time.Second
 
+	ctx, cancel := context.WithCancel(context.Background())
+	wh.stopWarmup = cancel
 	warmupTimer := time.NewTimer(warmUpDuration)
 	go func() {
 		<-warmupTimer.C
 		wh.GetLogger().Warn("Service warmup duration has elapsed.")
-		if atomic.CompareAndSwapInt32(&wh.healthStatus, int32(HealthStatusWarmingUp), int32(HealthStatusOK)) {
-			wh.GetLogger().Warn("Warmup time has elapsed. Service is healthy.")
-		} else {
-			status := HealthStatus(atomic.LoadInt32(&wh.healthStatus))
-			wh.GetLogger().Warn(fmt.Sprintf("Warmup time has elapsed. Service status is: %v", status.String()))
-		}
+		wh.finishWarmup(ctx, ringReadinessTimeout)
 	}()
 }
 
+// finishWarmup keeps warming up until membership rings converged, otherwise early requests are misrouted.
+// Rings which don't converge within readinessTimeout don't block the service, it becomes healthy with a warning.
+func (wh *WorkflowHandler) finishWarmup(ctx context.Context, readinessTimeout time.Duration) {
+	readyCtx, cancel := context.WithTimeout(ctx, readinessTimeout)
+	defer cancel()
+	if err := wh.GetMembershipResolver().WaitReady(readyCtx); err != nil {
+		if ctx.Err() != nil {
+			wh.GetLogger().Warn("Service warmup was stopped before membership rings are ready.", tag.Error(err))
+			return
+		}
+		wh.GetLogger().Warn("Membership rings are not ready after warmup timeout, serving anyway.", tag.Error(err))
+	}
+	if atomic.CompareAndSwapInt32(&wh.healthStatus, int32(HealthStatusWarmingUp), int32(HealthStatusOK)) {
+		wh.GetLogger().Warn("Warmup time has elapsed. Service is healthy.")
+	} else {
+		status := HealthStatus(atomic.LoadInt32(&wh.healthStatus))
+		wh.GetLogger().Warn(fmt.Sprintf("Warmup time has elapsed. Service status is: %v", status.String()))
+	}
+}
+
 // Stop stops the handler
 func (wh *WorkflowHandler) Stop() {
 	atomic.StoreInt32(&wh.shuttingDown, 1)
+	if wh.stopWarmup != nil {
+		wh.stopWarmup()
+	}
 }
 
 // UpdateHealthStatus sets the health status for this rpc handler.
@@ -42,13 +42,13 @@ import (
 	"github.com/uber/cadence/common/cluster"
 	"github.com/uber/cadence/common/domain"
 	dc "github.com/uber/cadence/common/dynamicconfig"
+	"github.com/uber/cadence/common/membership"
 	"github.com/uber/cadence/common/messaging"
 	"github.com/uber/cadence/common/metrics"
 	"github.com/uber/cadence/common/mocks"
 	"github.com/uber/cadence/common/partition"
 	"github.com/uber/cadence/common/persistence"
 	"github.com/uber/cadence/common/resource"
-	"github.com/uber/cadence/common/service"
 	"github.com/uber/cadence/common/types"
 )
 
@@ -135,7 +135,7 @@ func (s *workflowHandlerSuite) SetupTest() {
 	)
 
 	mockMonitor := s.mockResource.MembershipResolver
-	mockMonitor.EXPECT().MemberCount(service.Frontend).Return(5, nil).AnyTimes()
+	mockMonitor.EXPECT().MemberCount(membership.ServiceFrontend).Return(5, nil).AnyTimes()
 	s.mockVersionChecker.EXPECT().ClientSupported(gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
 }
 
@@ -1694,6 +1694,37 @@ func (s *workflowHandlerSuite) TestSignalMetricHasSignalName() {
 	s.True(expectedMetrics["test.cadence_errors_bad_request"])
 }
 
+func (s *workflowHandlerSuite) TestWarmupFinishesWhenRingsNeverConverge() {
+	wh := s.getWorkflowHandler(s.newConfig(dc.NewInMemoryClient()))
+	s.mockResource.MembershipResolver.EXPECT().WaitReady(gomock.Any()).DoAndReturn(func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	wh.finishWarmup(context.Background(), 10*time.Millisecond)
+
+	health, err := wh.Health(context.Background())
+	s.NoError(err)
+	s.True(health.Ok, "service is healthy after the readiness timeout")
+}
+
+func (s *workflowHandlerSuite) TestWarmupStoppedBeforeRingsConverge() {
+	wh := s.getWorkflowHandler(s.newConfig(dc.NewInMemoryClient()))
+	s.mockResource.MembershipResolver.EXPECT().WaitReady(gomock.Any()).DoAndReturn(func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	wh.finishWarmup(ctx, time.Minute)
+
+	health, err := wh.Health(context.Background())
+	s.NoError(err)
+	s.False(health.Ok)
+	s.Equal("WarmingUp", health.Msg)
+}
+
 func (s *workflowHandlerSuite) newConfig(dynamicClient dc.Client) *Config {
 	config := NewConfig(
 		dc.NewCollection(
@@ -21,6 +21,7 @@
 package history
 
 import (
+	"context"
 	"sync/atomic"
 	"time"
 
@@ -130,10 +131,17 @@ func (s *Service) Stop() {
 	remainingTime := s.config.ShutdownDrainDuration()
 
 	s.GetLogger().Info("ShutdownHandler: Evicting self from membership ring")
-	s.GetMembershipResolver().EvictSelf()
+	evictStart := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), remainingTime)
+	if err := s.GetMembershipResolver().EvictSelf(ctx); err != nil {
+		s.GetLogger().Warn("ShutdownHandler: Failed to evict self from membership ring", tag.Error(err))
+	}
+	cancel()
+	evictDuration := time.Since(evictStart)
+	remainingTime = common.MaxDuration(remainingTime-evictDuration, 0)
 
 	s.GetLogger().Info("ShutdownHandler: Waiting for others to discover I am unhealthy")
-	remainingTime = common.SleepWithMinDuration(gossipPropagationDelay, remainingTime)
+	remainingTime = common.SleepWithMinDuration(common.MaxDuration(gossipPropagationDelay-evictDuration, 0), remainingTime)
 
 	remainingTime = s.handler.PrepareToStop(remainingTime)
 	_ = common.SleepWithMinDuration(gracePeriod, remainingTime)
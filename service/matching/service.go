@@ -21,11 +21,13 @@
 package matching
 
 import (
+	"context"
 	"sync/atomic"
 	"time"
 
 	"github.com/uber/cadence/common"
 	"github.com/uber/cadence/common/dynamicconfig"
+	"github.com/uber/cadence/common/log/tag"
 	"github.com/uber/cadence/common/resource"
 	"github.com/uber/cadence/common/service"
 )
@@ -122,10 +124,17 @@ func (s *Service) Stop() {
 	}
 
 	// remove self from membership ring and wait for traffic to drain
+	drainDuration := s.config.ShutdownDrainDuration()
 	s.GetLogger().Info("ShutdownHandler: Evicting self from membership ring")
-	s.GetMembershipResolver().EvictSelf()
+	evictStart := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), drainDuration)
+	if err := s.GetMembershipResolver().EvictSelf(ctx); err != nil {
+		s.GetLogger().Warn("ShutdownHandler: Failed to evict self from membership ring", tag.Error(err))
+	}
+	cancel()
+	remainingTime := common.MaxDuration(drainDuration-time.Since(evictStart), 0)
 	s.GetLogger().Info("ShutdownHandler: Waiting for others to discover I am unhealthy")
-	time.Sleep(s.config.ShutdownDrainDuration())
+	_ = common.SleepWithMinDuration(drainDuration, remainingTime)
 
 	close(s.stopC)
 
@@ -34,9 +34,9 @@ import (
 	"github.com/uber/cadence/client/admin"
 	"github.com/uber/cadence/common/backoff"
 	"github.com/uber/cadence/common/domain"
+	"github.com/uber/cadence/common/membership"
 	"github.com/uber/cadence/common/metrics"
 	"github.com/uber/cadence/common/resource"
-	"github.com/uber/cadence/common/service"
 	"github.com/uber/cadence/common/types"
 )
 
@@ -69,7 +69,7 @@ func (s *domainReplicationSuite) SetupTest() {
 	s.domainReplicationQueue = domain.NewMockReplicationQueue(s.controller)
 	s.remoteClient = resource.RemoteAdminClient
 	serviceResolver := resource.MembershipResolver
-	serviceResolver.EXPECT().Lookup(service.Worker, s.sourceCluster).Return(resource.GetHostInfo(), nil).AnyTimes()
+	serviceResolver.EXPECT().Lookup(membership.ServiceWorker, s.sourceCluster).Return(resource.GetHostInfo(), nil).AnyTimes()
 	s.replicationProcessor = newDomainReplicationProcessor(
 		s.sourceCluster,
 		s.currentCluster,